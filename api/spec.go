@@ -0,0 +1,27 @@
+// Package api holds the OpenAPI specification of the server's HTTP API,
+// maintained by hand in openapi.yaml alongside the handlers.
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed openapi.yaml
+var specYAML []byte
+
+// SpecJSON returns the specification as JSON
+func SpecJSON() ([]byte, error) {
+	var spec map[string]any
+	if err := yaml.Unmarshal(specYAML, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI specification: %w", err)
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode OpenAPI specification: %w", err)
+	}
+	return b, nil
+}
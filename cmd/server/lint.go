@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+)
+
+// runLint implements the lint subcommand. It checks the policies in
+// -policy-dir, or the embedded policies, and returns the exit status:
+// 0 when clean, 1 when there are warnings, and 2 when the policies
+// cannot be read.
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	policyDir := fs.String("policy-dir", os.Getenv("POLICY_DIR"), "directory of *.cedar files to lint instead of the embedded policies")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	policySet, err := cedar.ParsePolicies(*policyDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lint: %v\n", err)
+		return 2
+	}
+	warnings, err := cedar.Lint(policySet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lint: %v\n", err)
+		return 2
+	}
+
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+	if len(warnings) > 0 {
+		return 1
+	}
+	return 0
+}
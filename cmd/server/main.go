@@ -5,27 +5,99 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo for AUTHZ_TIMEZONE
 
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/verifiedpermissions"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	apispec "github.com/ksakiyama/study-cedar/api"
+	"github.com/ksakiyama/study-cedar/api/documentspb"
 	"github.com/ksakiyama/study-cedar/internal/api"
+	"github.com/ksakiyama/study-cedar/internal/avp"
 	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+	"github.com/ksakiyama/study-cedar/internal/jobs"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
 	_ "github.com/lib/pq"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
+// maxJobInterval bounds how long data is kept past its retention, by
+// running each cleanup job at least this often
+const maxJobInterval = time.Hour
+
 func main() {
+	// Subcommands run without a database or server
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		os.Exit(runLint(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	// Get configuration from environment
 	port := getEnv("PORT", "8080")
+	grpcPort := os.Getenv("GRPC_PORT")
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbPort := getEnv("DB_PORT", "5432")
 	dbUser := getEnv("DB_USER", "postgres")
 	dbPassword := getEnv("DB_PASSWORD", "postgres")
 	dbName := getEnv("DB_NAME", "cedardb")
+	policyDir := os.Getenv("POLICY_DIR")
+	policySource := getEnv("POLICY_SOURCE", "")
+	policyBundleURL := os.Getenv("POLICY_BUNDLE_URL")
+	policyBundleCache := os.Getenv("POLICY_BUNDLE_CACHE")
+	shadowPolicyDir := os.Getenv("SHADOW_POLICY_DIR")
+	policyRefreshInterval := getDurationEnv("POLICY_REFRESH_INTERVAL", 30*time.Second)
+	authzCacheTTL := getDurationEnv("AUTHZ_CACHE_TTL", 0)
+	authzCacheSize := getIntEnv("AUTHZ_CACHE_SIZE", 10000)
+	authzTimeout := getDurationEnv("AUTHZ_TIMEOUT", 2*time.Second)
+	authzBackend := getEnv("AUTHZ_BACKEND", "local")
+	authzAuditBuffer := getIntEnv("AUTHZ_AUDIT_BUFFER", 1000)
+	decisionLogSampleRate := os.Getenv("DECISION_LOG_SAMPLE_RATE")
+	authzTimezone := getEnv("AUTHZ_TIMEZONE", "Asia/Tokyo")
+	businessHoursSpec := getEnv("BUSINESS_HOURS", "09:00-18:00")
+	entityCache := os.Getenv("ENTITY_CACHE") == "true"
+	requestRateWindow := getDurationEnv("REQUEST_RATE_WINDOW", time.Minute)
+	documentUsageTTL := getDurationEnv("DOCUMENT_USAGE_TTL", 5*time.Minute)
+	trashRetention := getDurationEnv("TRASH_RETENTION", 30*24*time.Hour)
+	retentionGrace := getDurationEnv("RETENTION_GRACE_PERIOD", 30*24*time.Hour)
+	idempotencyKeyTTL := getDurationEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+	documentLockTTL := getDurationEnv("DOCUMENT_LOCK_TTL", 15*time.Minute)
+	contentThreshold := getIntEnv("DOCUMENT_CONTENT_THRESHOLD", 64<<10)
+	maxTitleLength := getIntEnv("DOCUMENT_MAX_TITLE_LENGTH", 500)
+	maxContentSize := getIntEnv("DOCUMENT_MAX_CONTENT_SIZE", 1<<20)
+	sanitizeHTML := os.Getenv("SANITIZE_HTML") == "true"
+	renderCacheSize := getIntEnv("RENDER_CACHE_SIZE", 1000)
+	shareLinkSecret := os.Getenv("SHARE_LINK_SECRET")
+	shareLinkMaxTTL := getDurationEnv("SHARE_LINK_MAX_TTL", 7*24*time.Hour)
+	shareLinkRetention := getDurationEnv("SHARE_LINK_RETENTION", 30*24*time.Hour)
+	recentViewsLimit := getIntEnv("RECENT_VIEWS_LIMIT", 50)
+	recentViewsBuffer := getIntEnv("RECENT_VIEWS_BUFFER", 1000)
+	attachmentMaxSize := getIntEnv("ATTACHMENT_MAX_SIZE", 10<<20)
+	attachmentTypes := getEnv("ATTACHMENT_TYPES", "application/pdf,image/png,image/jpeg,image/gif,text/plain")
+	pdpURL := os.Getenv("PDP_URL")
+	pdpActions := os.Getenv("PDP_ACTIONS")
+	pdpToken := os.Getenv("PDP_TOKEN")
+	unknownContext := os.Getenv("UNKNOWN_CONTEXT")
+	recordRequests := os.Getenv("RECORD_REQUESTS")
+	swaggerUI := os.Getenv("SWAGGER_UI") == "true"
+	webhookBuffer := getIntEnv("WEBHOOK_BUFFER", 1000)
+	webhookMaxAttempts := getIntEnv("WEBHOOK_MAX_ATTEMPTS", 5)
+	webhookBackoff := getDurationEnv("WEBHOOK_BACKOFF", time.Second)
 
 	// Connect to database
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
@@ -54,15 +126,309 @@ func main() {
 
 	log.Println("Connected to database successfully")
 
+	// Background workers stop when this context is cancelled
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	clk := clock.Real{}
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	businessHours, err := cedar.ParseBusinessHours(businessHoursSpec, authzTimezone)
+	if err != nil {
+		log.Fatalf("Invalid business hours configuration: %v", err)
+	}
+
+	// Record every authorization decision in the background
+	auditLog := cedar.NewAuditLog(db, authzAuditBuffer)
+	auditDone := make(chan struct{})
+	go func() {
+		auditLog.Run(ctx)
+		close(auditDone)
+	}()
+
+	// Record break-glass decisions separately for incident review, before
+	// they are returned
+	breakGlassLog := cedar.NewBreakGlassAuditLog(db)
+	breakGlass := cedar.NewBreakGlassStore(db, clk)
+
+	// Deliver document events and denials to webhooks in the background
+	webhooks := webhook.NewDispatcher(db, webhookBuffer, webhook.WithClock(clk), webhook.WithRetries(webhookMaxAttempts, webhookBackoff))
+	webhooksDone := make(chan struct{})
+	go func() {
+		webhooks.Run(ctx)
+		close(webhooksDone)
+	}()
+
+	// Keep group associations in memory, refreshed by database notifications
+	var providerOpts []cedar.ProviderOption
+	if entityCache {
+		groupCache := cedar.NewGroupCache(db, dsn)
+		if err := groupCache.Load(ctx); err != nil {
+			log.Fatalf("Failed to load group cache: %v", err)
+		}
+		go func() {
+			if err := groupCache.Run(ctx); err != nil {
+				log.Printf("Group cache stopped: %v", err)
+			}
+		}()
+		providerOpts = append(providerOpts, cedar.WithGroupCache(groupCache))
+		log.Println("Caching group associations in memory")
+	}
+
 	// Initialize Cedar authorizer
-	authorizer, err := cedar.NewAuthorizer()
+	authzOpts := []cedar.Option{
+		cedar.WithClock(clk),
+		cedar.WithDecisionCache(authzCacheTTL, authzCacheSize),
+		cedar.WithTimeout(authzTimeout),
+		cedar.WithTemplateStore(cedar.NewTemplateStore(db)),
+		cedar.WithEntityProvider(cedar.NewPostgresEntityProvider(db, providerOpts...)),
+		cedar.WithContextBuilders(cedar.RequestMethodContext, cedar.TimeContext(clk, businessHours)),
+		cedar.WithAuditSink(auditLog),
+		cedar.WithAuditSink(webhooks),
+		cedar.WithBreakGlass(breakGlass, breakGlassLog),
+	}
+	var requestRate *cedar.RequestRate
+	if requestRateWindow > 0 {
+		requestRate = cedar.NewRequestRate(clk, requestRateWindow)
+		authzOpts = append(authzOpts, cedar.WithContextBuilders(requestRate))
+	}
+	var documentUsage *cedar.DocumentUsage
+	if documentUsageTTL > 0 {
+		documentUsage = cedar.NewDocumentUsage(db, clk, documentUsageTTL)
+		authzOpts = append(authzOpts, cedar.WithContextBuilders(documentUsage))
+	}
+	if recordRequests != "" {
+		f, err := os.OpenFile(recordRequests, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatalf("Failed to open RECORD_REQUESTS file: %v", err)
+		}
+		defer f.Close()
+		authzOpts = append(authzOpts, cedar.WithRequestRecorder(cedar.NewRequestRecorder(f)))
+		log.Printf("Recording authorization requests to %s", recordRequests)
+	}
+	if unknownContext != "" {
+		strategies, err := cedar.ParseUnknownStrategies(unknownContext)
+		if err != nil {
+			log.Fatalf("Invalid UNKNOWN_CONTEXT: %v", err)
+		}
+		authzOpts = append(authzOpts, cedar.WithUnknownStrategies(strategies))
+	}
+	if decisionLogSampleRate != "" {
+		rate, err := strconv.ParseFloat(decisionLogSampleRate, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Fatalf("Invalid DECISION_LOG_SAMPLE_RATE %q: must be between 0 and 1", decisionLogSampleRate)
+		}
+		authzOpts = append(authzOpts, cedar.WithAuditSink(cedar.NewDecisionLogger(os.Stdout, rate)))
+		log.Printf("Logging all denied and %g of allowed decisions", rate)
+	}
+	switch {
+	case authzBackend == "avp":
+		policyStoreID := os.Getenv("AVP_POLICY_STORE_ID")
+		if policyStoreID == "" {
+			log.Fatal("AVP_POLICY_STORE_ID is required when AUTHZ_BACKEND=avp")
+		}
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			log.Fatalf("Failed to load AWS config: %v", err)
+		}
+		client := verifiedpermissions.NewFromConfig(awsCfg)
+		authzOpts = append(authzOpts, cedar.WithEvaluator(avp.NewEvaluator(client, policyStoreID)))
+		log.Printf("Using Amazon Verified Permissions policy store %s", policyStoreID)
+	case policySource == "db":
+		store := cedar.NewPolicyStore(db, clk)
+		if err := store.SeedDefaults(ctx); err != nil {
+			log.Fatalf("Failed to seed policy store: %v", err)
+		}
+		authzOpts = append(authzOpts, cedar.WithPolicyStore(store))
+	case policySource == "bundle":
+		if policyBundleURL == "" {
+			log.Fatal("POLICY_BUNDLE_URL is required when POLICY_SOURCE=bundle")
+		}
+		var bundleOpts []cedar.BundleOption
+		if policyBundleCache != "" {
+			bundleOpts = append(bundleOpts, cedar.WithBundleCacheFile(policyBundleCache))
+		}
+		if strings.HasPrefix(policyBundleURL, "s3://") {
+			awsCfg, err := config.LoadDefaultConfig(ctx)
+			if err != nil {
+				log.Fatalf("Failed to load AWS config: %v", err)
+			}
+			bundleOpts = append(bundleOpts, cedar.WithBundleAWSConfig(awsCfg))
+		}
+		bundle, err := cedar.NewPolicyBundle(policyBundleURL, bundleOpts...)
+		if err != nil {
+			log.Fatalf("Invalid policy bundle configuration: %v", err)
+		}
+		authzOpts = append(authzOpts, cedar.WithPolicyBundle(bundle))
+	case policyDir != "":
+		authzOpts = append(authzOpts, cedar.WithPolicyDir(policyDir))
+	}
+	if shadowPolicyDir != "" {
+		authzOpts = append(authzOpts, cedar.WithShadowPolicyDir(shadowPolicyDir))
+		log.Printf("Evaluating candidate policies from %s in shadow mode", shadowPolicyDir)
+	}
+	if pdpURL != "" {
+		modes, err := cedar.ParsePDPModes(pdpActions)
+		if err != nil {
+			log.Fatalf("Invalid PDP_ACTIONS: %v", err)
+		}
+		var pdpOpts []cedar.PDPOption
+		if pdpToken != "" {
+			pdpOpts = append(pdpOpts, cedar.WithPDPHeader("Authorization", "Bearer "+pdpToken))
+		}
+		pdp := cedar.NewHTTPEvaluator(pdpURL, pdpOpts...)
+		authzOpts = append(authzOpts, cedar.WithExternalPDP(cedar.NewMultiAuthorizer(pdp, modes)))
+		log.Printf("Consulting external PDP %s for %d actions", pdpURL, len(modes))
+	}
+	authorizer, err := cedar.NewAuthorizer(authzOpts...)
 	if err != nil {
 		log.Fatalf("Failed to initialize Cedar authorizer: %v", err)
 	}
 	log.Println("Cedar authorizer initialized successfully")
 
+	// Keep policies up to date with their source
+	switch {
+	case authzBackend == "avp":
+		// Policies are managed in Verified Permissions
+	case policySource == "db":
+		go authorizer.PollPolicies(ctx, policyRefreshInterval)
+		log.Printf("Refreshing policies from database every %s", policyRefreshInterval)
+	case policySource == "bundle":
+		go authorizer.PollPolicies(ctx, policyRefreshInterval)
+		log.Printf("Refreshing policies from %s every %s", policyBundleURL, policyRefreshInterval)
+	case policyDir != "":
+		go func() {
+			if err := authorizer.Watch(ctx); err != nil {
+				log.Printf("Policy watcher stopped: %v", err)
+			}
+		}()
+		log.Printf("Watching %s for policy changes", policyDir)
+	}
+
+	// Reload policies on demand, e.g. after a ConfigMap update
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reloadSignals:
+				changed, err := authorizer.Reload(ctx)
+				switch {
+				case err != nil:
+					log.Printf("Policy reload on SIGHUP failed, keeping previous policies: %v", err)
+				case changed:
+					log.Println("Reloaded policies on SIGHUP")
+				default:
+					log.Println("Policies unchanged on SIGHUP")
+				}
+			}
+		}
+	}()
+
 	// Create handler
-	handler := api.NewHandler(db, authorizer)
+	handler := api.NewHandler(db, authorizer, clk)
+	handler.SetAuditLog(auditLog)
+	handler.SetDirectory(cedar.NewDirectory(db))
+	handler.SetBreakGlass(breakGlass)
+	handler.SetWebhooks(webhooks)
+	handler.SetDocumentLocks(documentLockTTL)
+	handler.SetContentLimits(maxTitleLength, maxContentSize)
+	handler.SetRenderCache(renderCacheSize)
+	handler.SetRetentionGrace(retentionGrace)
+	if shareLinkSecret != "" {
+		handler.SetShareLinks([]byte(shareLinkSecret), shareLinkMaxTTL)
+	}
+	if sanitizeHTML {
+		handler.SetContentSanitizer(bluemonday.UGCPolicy())
+	}
+	if documentUsage != nil {
+		handler.SetDocumentUsage(documentUsage)
+	}
+
+	// Record the documents each user views in the background
+	recentViewsDone := make(chan struct{})
+	if recentViewsLimit > 0 {
+		recentViews := api.NewRecentViews(db, recentViewsBuffer, recentViewsLimit)
+		go func() {
+			recentViews.Run(ctx)
+			close(recentViewsDone)
+		}()
+		handler.SetRecentViews(recentViews)
+	} else {
+		close(recentViewsDone)
+	}
+
+	spec, err := apispec.SpecJSON()
+	if err != nil {
+		log.Fatalf("Failed to load OpenAPI specification: %v", err)
+	}
+	handler.SetOpenAPI(spec)
+
+	// Keep attachments and large document content outside the database
+	objectStorage, storageLocation, err := setupStorage(ctx)
+	if err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+	if objectStorage != nil {
+		handler.SetStorage(objectStorage, int64(contentThreshold))
+		var types []string
+		for _, t := range strings.Split(attachmentTypes, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+		handler.SetAttachments(api.AttachmentConfig{
+			MaxSize: int64(attachmentMaxSize),
+			Types:   types,
+		})
+		log.Printf("Storing attachments of up to %d bytes and document content over %d bytes in %s", attachmentMaxSize, contentThreshold, storageLocation)
+	}
+
+	// Stream document changes notified by the database to clients
+	documentEvents := api.NewDocumentEvents(dsn)
+	go func() {
+		if err := documentEvents.Run(ctx); err != nil {
+			log.Printf("Document events stopped: %v", err)
+		}
+	}()
+	handler.SetDocumentEvents(documentEvents)
+
+	// Run maintenance jobs in the background, on one instance at a time
+	scheduler := jobs.NewScheduler(db)
+	if trashRetention > 0 {
+		// Permanently delete documents that have been in the trash too long
+		scheduler.Add("purge_trash", min(trashRetention, maxJobInterval), func(ctx context.Context) error {
+			return handler.PurgeTrash(ctx, trashRetention)
+		})
+		log.Printf("Purging documents deleted more than %s ago", trashRetention)
+	}
+	// Move expired documents to the trash and purge them after the grace
+	// period
+	scheduler.Add("expire_documents", maxJobInterval, handler.ExpireDocuments)
+	if shareLinkRetention > 0 {
+		// Forget share links once they have expired or been revoked for long
+		scheduler.Add("purge_share_links", min(shareLinkRetention, maxJobInterval), func(ctx context.Context) error {
+			return handler.PurgeShareLinks(ctx, shareLinkRetention)
+		})
+	}
+	if idempotencyKeyTTL > 0 {
+		// Forget idempotency keys once retries with them are no longer
+		// expected
+		scheduler.Add("purge_idempotency_keys", min(idempotencyKeyTTL, maxJobInterval), func(ctx context.Context) error {
+			return handler.PurgeIdempotencyKeys(ctx, idempotencyKeyTTL)
+		})
+	}
+	jobsDone := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(jobsDone)
+	}()
 
 	// Setup router
 	r := chi.NewRouter()
@@ -72,28 +438,161 @@ func main() {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
+	r.Use(api.Metrics)
 
 	// Routes
 	r.Get("/health", handler.HealthCheck)
+	r.Handle("/metrics", promhttp.Handler())
+	if swaggerUI {
+		r.Get("/docs", api.Docs("/api/v1/openapi.json"))
+	}
 
 	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(handler.ResolveUser)
+		if requestRate != nil {
+			// Count each API request once, however many checks it makes
+			r.Use(requestRate.Count)
+		}
 		r.Get("/health", handler.HealthCheck)
+		r.Get("/openapi.json", handler.GetOpenAPI)
+		// Each field is authorized by its resolver
+		r.Post("/graphql", handler.GraphQL)
 
 		r.Route("/documents", func(r chi.Router) {
-			r.Get("/", handler.ListDocuments)
-			r.Post("/", handler.CreateDocument)
-			r.Get("/{documentId}", handler.GetDocument)
-			r.Put("/{documentId}", handler.UpdateDocument)
-			r.Delete("/{documentId}", handler.DeleteDocument)
+			document := cedar.URLParam("documentId")
+
+			r.With(authorizer.Require("ListDocuments", cedar.Collection)).Get("/", handler.ListDocuments)
+			r.With(authorizer.Require("ListDocuments", cedar.Collection)).Get("/mine", handler.ListMyDocuments)
+			r.With(authorizer.Require("ListDocuments", cedar.Collection)).Get("/starred", handler.ListStarredDocuments)
+			r.With(authorizer.Require("ListDocuments", cedar.Collection)).Get("/recent", handler.ListRecentDocuments)
+			r.With(authorizer.Require("ViewDocumentStats", cedar.Collection)).Get("/stats", handler.GetDocumentStats)
+			r.With(authorizer.Require("CreateDocument", cedar.Collection)).Post("/", handler.CreateDocument)
+			r.With(authorizer.Require("CreateDocument", cedar.Collection)).Post("/batch", handler.CreateDocuments)
+			// Each event is authorized by the handler
+			r.With(authorizer.Require("ListDocuments", cedar.Collection)).Get("/events", handler.StreamDocumentEvents)
+			// Each document is authorized by the handler
+			r.Post("/batch-delete", handler.DeleteDocuments)
+			r.Post("/batch-get", handler.GetDocuments)
+			r.With(authorizer.Require("GetDocument", document)).Get("/{documentId}", handler.GetDocument)
+			r.With(authorizer.Require("GetDocument", document)).Head("/{documentId}", handler.HeadDocument)
+			r.With(authorizer.Require("GetDocument", document)).Get("/{documentId}/capabilities", handler.GetCapabilities)
+			r.With(authorizer.Require("GetDocument", document)).Get("/{documentId}/rendered", handler.GetRenderedDocument)
+			r.With(authorizer.Require("GetDocument", document)).Get("/{documentId}/activity", handler.GetDocumentActivity)
+			r.With(authorizer.Require("GetDocument", document)).Get("/{documentId}/retention", handler.GetDocumentRetention)
+			r.With(authorizer.Require("DeleteDocument", document)).Put("/{documentId}/retention", handler.SetDocumentRetention)
+			r.With(authorizer.Require("GetDocument", document)).Get("/{documentId}/legal-hold", handler.GetLegalHold)
+			r.With(authorizer.Require("SetLegalHold", document)).Put("/{documentId}/legal-hold", handler.SetLegalHold)
+			r.With(authorizer.Require("GetDocument", document)).Put("/{documentId}/star", handler.StarDocument)
+			r.With(authorizer.Require("GetDocument", document)).Delete("/{documentId}/star", handler.UnstarDocument)
+			r.With(authorizer.Require("UpdateDocument", document)).Put("/{documentId}", handler.UpdateDocument)
+			r.With(authorizer.Require("UpdateDocument", document)).Patch("/{documentId}", handler.PatchDocument)
+			r.With(authorizer.Require("DeleteDocument", document)).Delete("/{documentId}", handler.DeleteDocument)
+			r.With(authorizer.Require("RestoreDocument", document)).Post("/{documentId}/restore", handler.RestoreDocument)
+			r.With(authorizer.Require("GetDocument", document)).Get("/{documentId}/lock", handler.GetDocumentLock)
+			r.With(authorizer.Require("UpdateDocument", document)).Post("/{documentId}/lock", handler.LockDocument)
+			r.With(authorizer.Require("UpdateDocument", document)).Post("/{documentId}/unlock", handler.UnlockDocument)
+			r.With(authorizer.Require("GetDocument", document)).Post("/{documentId}/duplicate", handler.DuplicateDocument)
+			r.With(authorizer.Require("ShareDocument", document)).Post("/{documentId}/share", handler.ShareDocument)
+			r.With(authorizer.Require("ShareDocument", document)).Get("/{documentId}/permissions", handler.GetDocumentPermissions)
+			r.With(authorizer.Require("ShareDocument", document)).Put("/{documentId}/permissions", handler.PutDocumentPermissions)
+			r.With(authorizer.Require("ShareDocument", document)).Post("/{documentId}/share-links", handler.CreateShareLink)
+			r.With(authorizer.Require("ShareDocument", document)).Get("/{documentId}/share-links", handler.ListShareLinks)
+			r.With(authorizer.Require("ShareDocument", document)).Delete("/{documentId}/share-links/{linkId}", handler.RevokeShareLink)
+			r.With(authorizer.Require("ListDocumentVersions", document)).Get("/{documentId}/versions", handler.ListDocumentVersions)
+			r.With(authorizer.Require("GetDocumentVersion", document)).Get("/{documentId}/versions/{version}", handler.GetDocumentVersion)
+			r.With(authorizer.Require("GetDocumentVersion", document)).Get("/{documentId}/versions/{version}/diff/{other}", handler.DiffDocumentVersions)
+			r.With(authorizer.Require("RestoreDocumentVersion", document)).Post("/{documentId}/versions/{version}/restore", handler.RestoreDocumentVersion)
+			r.With(authorizer.Require("ListAttachments", document)).Get("/{documentId}/attachments", handler.ListAttachments)
+			r.With(authorizer.Require("UploadAttachment", document)).Post("/{documentId}/attachments", handler.UploadAttachment)
+			r.With(authorizer.Require("GetAttachment", document)).Get("/{documentId}/attachments/{attachmentId}", handler.DownloadAttachment)
+			r.With(authorizer.Require("DeleteAttachment", document)).Delete("/{documentId}/attachments/{attachmentId}", handler.DeleteAttachment)
+			r.With(authorizer.Require("AssignDocumentGroup", document)).Put("/{documentId}/group", handler.AssignDocumentGroup)
+			r.With(authorizer.Require("AssignDocumentGroup", document)).Delete("/{documentId}/group", handler.UnassignDocumentGroup)
+		})
+
+		r.With(authorizer.Require("ListDocuments", cedar.Collection)).Get("/trash", handler.ListTrash)
+		// Authorized by the handler on behalf of the link's creator
+		r.Get("/shared/{token}", handler.GetSharedDocument)
+
+		r.Route("/authz", func(r chi.Router) {
+			// Checks only the caller's own permissions
+			r.Post("/batch", handler.AuthorizeBatch)
+			r.With(authorizer.Require("CheckAuthorization", cedar.Collection)).Post("/check", handler.CheckAuthorization)
+			r.Get("/schema", handler.GetSchema)
+			r.Get("/actions", handler.ListActions)
+		})
+
+		r.Route("/admin/policies", func(r chi.Router) {
+			managePolicies := authorizer.Require("ManagePolicies", cedar.Collection)
+			r.With(managePolicies).Get("/versions", handler.ListPolicyVersions)
+			r.With(managePolicies).Post("/versions/{version}/rollback", handler.RollbackPolicies)
+			r.With(authorizer.Require("DiffPolicies", cedar.Collection)).Post("/diff", handler.DiffPolicyVersions)
+			r.With(authorizer.Require("ExportPolicies", cedar.Collection)).Get("/export", handler.ExportPolicies)
+			r.With(authorizer.Require("ImportPolicies", cedar.Collection)).Post("/import", handler.ImportPolicies)
+			r.With(managePolicies).Put("/{policyId}", handler.UpdatePolicy)
+		})
+
+		r.Route("/admin/users", func(r chi.Router) {
+			r.Use(authorizer.Require("ManageUsers", cedar.Collection))
+			r.Get("/", handler.ListUsers)
+			r.Get("/{userId}", handler.GetUser)
+			r.Put("/{userId}", handler.PutUser)
+			r.Delete("/{userId}", handler.DeleteUser)
+			r.Post("/{userId}/erase", handler.EraseUser)
+		})
+
+		r.Route("/admin/user-groups", func(r chi.Router) {
+			r.Use(authorizer.Require("ManageUserGroups", cedar.Collection))
+			r.Get("/", handler.ListUserGroups)
+			r.Post("/", handler.CreateUserGroup)
+			r.Get("/{groupId}", handler.GetUserGroup)
+			r.Put("/{groupId}", handler.UpdateUserGroup)
+			r.Delete("/{groupId}", handler.DeleteUserGroup)
+			r.Get("/{groupId}/members", handler.ListUserGroupMembers)
+			r.Put("/{groupId}/members/{userId}", handler.AddUserGroupMember)
+			r.Delete("/{groupId}/members/{userId}", handler.RemoveUserGroupMember)
 		})
+
+		r.Route("/admin/document-groups", func(r chi.Router) {
+			r.Use(authorizer.Require("ManageDocumentGroups", cedar.Collection))
+			r.Get("/", handler.ListDocumentGroups)
+			r.Post("/", handler.CreateDocumentGroup)
+			r.Get("/{groupId}", handler.GetDocumentGroup)
+			r.Put("/{groupId}", handler.UpdateDocumentGroup)
+			r.Delete("/{groupId}", handler.DeleteDocumentGroup)
+			r.Get("/{groupId}/templates", handler.ListDocumentTemplates)
+			r.Post("/{groupId}/templates", handler.CreateDocumentTemplate)
+			r.Get("/{groupId}/templates/{templateId}", handler.GetDocumentTemplate)
+			r.Put("/{groupId}/templates/{templateId}", handler.UpdateDocumentTemplate)
+			r.Delete("/{groupId}/templates/{templateId}", handler.DeleteDocumentTemplate)
+		})
+
+		r.Route("/admin/webhooks", func(r chi.Router) {
+			r.Use(authorizer.Require("ManageWebhooks", cedar.Collection))
+			r.Get("/", handler.ListWebhooks)
+			r.Post("/", handler.CreateWebhook)
+			r.Get("/{webhookId}", handler.GetWebhook)
+			r.Delete("/{webhookId}", handler.DeleteWebhook)
+		})
+
+		r.With(authorizer.Require("ViewAuditLog", cedar.Collection)).Get("/admin/audit", handler.ListAuditRecords)
+		r.With(authorizer.Require("GrantBreakGlass", cedar.Collection)).Post("/admin/break-glass", handler.GrantBreakGlass)
+		r.With(authorizer.Require("SimulateAuthorization", cedar.Collection)).Post("/admin/authz/simulate", handler.SimulateAuthorization)
+		r.With(authorizer.Require("ReviewDocumentAccess", cedar.URLParam("documentId"))).Get("/admin/documents/{documentId}/access", handler.ReviewDocumentAccess)
 	})
 
 	// Create HTTP server
 	addr := fmt.Sprintf(":%s", port)
 	srv := &http.Server{
-		Addr:    addr,
-		Handler: r,
+		Addr: addr,
+		// Start a server span per request, continuing the caller's trace
+		Handler: otelhttp.NewHandler(r, "http.server", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "HTTP " + r.Method
+		})),
 	}
+	// End event streams on shutdown instead of waiting for clients to
+	// disconnect
+	srv.RegisterOnShutdown(documentEvents.Close)
 
 	// Start server in a goroutine
 	serverErrors := make(chan error, 1)
@@ -102,6 +601,22 @@ func main() {
 		serverErrors <- srv.ListenAndServe()
 	}()
 
+	// Serve the gRPC API on its own port, with the same handler
+	var grpcServer *grpc.Server
+	if grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+		grpcServer = grpc.NewServer(grpc.ChainUnaryInterceptor(api.LogRPC))
+		documentspb.RegisterDocumentServiceServer(grpcServer, api.NewGRPCServer(handler))
+		reflection.Register(grpcServer)
+		go func() {
+			log.Printf("Starting gRPC server on %s", lis.Addr())
+			serverErrors <- grpcServer.Serve(lis)
+		}()
+	}
+
 	// Setup signal handling for graceful shutdown
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
@@ -129,6 +644,28 @@ func main() {
 				log.Printf("Force close failed: %v", err)
 			}
 		}
+		if grpcServer != nil {
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				grpcServer.Stop()
+			}
+		}
+
+		// Stop background workers and write any buffered audit records
+		stop()
+		<-auditDone
+		<-webhooksDone
+		<-recentViewsDone
+		<-jobsDone
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
 
 		log.Println("Server stopped gracefully")
 	}
@@ -141,3 +678,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %q, using default %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s: %q, using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
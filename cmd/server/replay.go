@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+)
+
+// runReplay implements the replay subcommand. It decides the requests in
+// a recording made with RECORD_REQUESTS against the policies in
+// -baseline-dir and -policy-dir, each the embedded policies when empty,
+// and returns the exit status: 0 when every decision is identical, 1 when
+// some differ, and 2 when the recording or policies cannot be read.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	baselineDir := fs.String("baseline-dir", "", "directory of *.cedar files to compare against instead of the embedded policies")
+	policyDir := fs.String("policy-dir", os.Getenv("POLICY_DIR"), "directory of *.cedar files to replay instead of the embedded policies")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: server replay [-baseline-dir dir] [-policy-dir dir] recording.jsonl")
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 2
+	}
+	defer f.Close()
+	requests, err := cedar.ReadRecordedRequests(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %s: %v\n", fs.Arg(0), err)
+		return 2
+	}
+
+	baseline, err := cedar.ParsePolicies(*baselineDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: baseline: %v\n", err)
+		return 2
+	}
+	candidate, err := cedar.ParsePolicies(*policyDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 2
+	}
+
+	mismatches := cedar.Replay(requests, baseline, candidate)
+	for _, m := range mismatches {
+		req := m.Request.Request
+		fmt.Printf("request %d: %s %s on %s: baseline %s %v, candidate %s %v\n",
+			m.Index+1, req.Principal, req.Action, req.Resource,
+			outcome(m.Baseline), m.Baseline.MatchedPolicies, outcome(m.Candidate), m.Candidate.MatchedPolicies)
+	}
+	fmt.Printf("%d requests replayed, %d decided differently\n", len(requests), len(mismatches))
+	if len(mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// outcome describes a decision as "allow" or "deny" with its code
+func outcome(d cedar.AuthzDecision) string {
+	if d.Allowed {
+		return "allow"
+	}
+	return "deny " + d.Code
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/ksakiyama/study-cedar/internal/storage"
+)
+
+// setupStorage creates the storage backend named by STORAGE_BACKEND:
+// "local" stores files under STORAGE_DIR, "s3" and "gcs" store objects in
+// STORAGE_BUCKET. S3 uses the standard AWS configuration and, if set,
+// STORAGE_ENDPOINT for S3-compatible stores; Cloud Storage uses the
+// service account the server runs as. It also returns where objects are
+// kept, for the log, and returns a nil backend if none is configured.
+func setupStorage(ctx context.Context) (storage.Backend, string, error) {
+	bucket := os.Getenv("STORAGE_BUCKET")
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "":
+		return nil, "", nil
+	case "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			return nil, "", fmt.Errorf("STORAGE_DIR is required when STORAGE_BACKEND=local")
+		}
+		l, err := storage.NewLocal(dir)
+		return l, dir, err
+	case "s3":
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		var opts []storage.S3Option
+		if endpoint := os.Getenv("STORAGE_ENDPOINT"); endpoint != "" {
+			opts = append(opts, storage.WithS3Endpoint(endpoint))
+		}
+		s, err := storage.NewS3(bucket, awsCfg, opts...)
+		return s, "s3://" + bucket, err
+	case "gcs":
+		g, err := storage.NewGCS(bucket)
+		return g, "gs://" + bucket, err
+	default:
+		return nil, "", fmt.Errorf("unknown storage backend %q: want local, s3, or gcs", backend)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// AccessReviewer reports who may act on a document. It is implemented by
+// *cedar.Authorizer.
+type AccessReviewer interface {
+	WhoCanAccess(ctx context.Context, resourceID string, principals []cedar.Principal) ([]cedar.Access, error)
+}
+
+// PrincipalDirectory lists the principals an access review considers. It
+// is implemented by *cedar.Directory.
+type PrincipalDirectory interface {
+	Principals(ctx context.Context) ([]cedar.Principal, error)
+}
+
+// SetDirectory enables the access review endpoint
+func (h *Handler) SetDirectory(directory PrincipalDirectory) {
+	h.directory = directory
+}
+
+// ReviewDocumentAccess handles listing who may perform each action on a
+// document under the current policies. The caller has been authorized for
+// ReviewDocumentAccess on the document by the route middleware.
+func (h *Handler) ReviewDocumentAccess(w http.ResponseWriter, r *http.Request) {
+	if h.reviewer == nil || h.directory == nil {
+		respondError(w, http.StatusNotImplemented, "Access review is not available")
+		return
+	}
+	documentID := chi.URLParam(r, "documentId")
+
+	principals, err := h.directory.Principals(r.Context())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list principals: %v", err))
+		return
+	}
+	access, err := h.reviewer.WhoCanAccess(r.Context(), documentID, principals)
+	if errors.Is(err, cedar.ErrResourceNotFound) {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
+		return
+	}
+
+	response := models.AccessReviewResponse{
+		DocumentID: documentID,
+		Access:     make([]models.AccessEntry, 0, len(access)),
+	}
+	for _, a := range access {
+		response.Access = append(response.Access, models.AccessEntry{
+			UserID:  a.Principal.ID,
+			Role:    a.Principal.Role,
+			GroupID: a.Principal.GroupID,
+			Actions: a.Actions,
+		})
+	}
+	respondJSON(w, http.StatusOK, response)
+}
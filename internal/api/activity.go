@@ -0,0 +1,116 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// activityTypes are the kinds of activity of the document actions shown in
+// its feed
+var activityTypes = map[string]string{
+	"GetDocument":            "viewed",
+	"GetDocumentVersion":     "viewed",
+	"GetAttachment":          "viewed",
+	"UpdateDocument":         "edited",
+	"RestoreDocumentVersion": "edited",
+	"UploadAttachment":       "edited",
+	"DeleteAttachment":       "edited",
+	"AssignDocumentGroup":    "edited",
+	"ClassifyDocument":       "edited",
+	"SetLegalHold":           "edited",
+	"ShareDocument":          "shared",
+	"DeleteDocument":         "deleted",
+	"RestoreDocument":        "restored",
+}
+
+// GetDocumentActivity handles listing what has been done to a document,
+// newest first and a page at a time: the requests allowed on it as
+// recorded in the audit log, and its creation on the last page. The caller
+// has been authorized for GetDocument by the route middleware.
+func (h *Handler) GetDocumentActivity(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		respondError(w, http.StatusNotImplemented, "Audit log is not available")
+		return
+	}
+	params := r.URL.Query()
+	limit := defaultPageSize
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = n
+	}
+	var before *cedar.AuditPosition
+	if v := params.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			err = json.Unmarshal(b, &before)
+		}
+		if err != nil || before == nil {
+			respondError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+
+	doc, err := h.fetchDocument(r.Context(), chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// One more than the page holds tells whether there is a next page
+	records, err := h.audit.Query(r.Context(), cedar.AuditQuery{
+		ResourceID:  doc.ID,
+		Actions:     slices.Collect(maps.Keys(activityTypes)),
+		AllowedOnly: true,
+		Before:      before,
+		Limit:       limit + 1,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	response := models.DocumentActivity{DocumentID: doc.ID, Events: []models.ActivityEvent{}}
+	if len(records) > limit {
+		records = records[:limit]
+		last := records[limit-1]
+		b, _ := json.Marshal(cedar.AuditPosition{Time: last.Time, ID: last.ID})
+		response.NextCursor = base64.RawURLEncoding.EncodeToString(b)
+	}
+	for _, rec := range records {
+		response.Events = append(response.Events, models.ActivityEvent{
+			Type:     activityTypes[rec.Action],
+			Action:   rec.Action,
+			UserID:   rec.PrincipalID,
+			UserRole: rec.PrincipalRole,
+			Time:     rec.Time,
+		})
+	}
+	if response.NextCursor == "" {
+		// Creation is authorized on the collection, so it is not recorded
+		// for the document
+		response.Events = append(response.Events, models.ActivityEvent{
+			Type:   "created",
+			UserID: doc.OwnerID,
+			Time:   doc.CreatedAt,
+		})
+	}
+	respondJSON(w, http.StatusOK, response)
+}
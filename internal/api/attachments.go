@@ -0,0 +1,348 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// multipartOverhead is allowed on top of the attachment size for the
+// multipart boundaries and headers
+const multipartOverhead = 64 << 10
+
+// sniffLen is how much of an upload is read to detect its type
+const sniffLen = 512
+
+// AttachmentConfig says which attachments are accepted. They are kept in
+// the storage set with SetStorage.
+type AttachmentConfig struct {
+	// MaxSize is the largest accepted attachment in bytes
+	MaxSize int64
+	// Types are the accepted media types, e.g. "application/pdf". The type
+	// is detected from the content, not taken from the client.
+	Types []string
+}
+
+// SetAttachments enables the attachment endpoints, which also need
+// storage
+func (h *Handler) SetAttachments(cfg AttachmentConfig) {
+	h.attachments = &cfg
+}
+
+// ListAttachments handles listing the attachments of a document
+func (h *Handler) ListAttachments(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentId")
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT a.id, a.document_id, a.filename, a.content_type, a.size, a.uploaded_by, a.created_at
+		FROM attachments a
+		JOIN documents d ON d.id = a.document_id
+		WHERE a.document_id = $1 AND d.deleted_at IS NULL
+		ORDER BY a.created_at, a.id
+	`, documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.AttachmentsResponse{DocumentID: documentID, Attachments: []models.Attachment{}}
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(&a.ID, &a.DocumentID, &a.Filename, &a.ContentType, &a.Size, &a.UploadedBy, &a.CreatedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.Attachments = append(response.Attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// UploadAttachment handles a multipart upload of a file to a document. The
+// "file" part is streamed to a temporary file without being held in
+// memory, rejected if it is too large or of a type that is not accepted,
+// and then put in storage.
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	if h.attachments == nil || h.storage == nil {
+		respondError(w, http.StatusNotImplemented, "Attachments are not available")
+		return
+	}
+	documentID := chi.URLParam(r, "documentId")
+
+	var exists bool
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM documents WHERE id = $1 AND deleted_at IS NULL)
+	`, documentID).Scan(&exists)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !exists {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.attachments.MaxSize+multipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return
+	}
+	var part io.Reader
+	var filename string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			respondError(w, http.StatusBadRequest, "The file part is required")
+			return
+		}
+		if err != nil {
+			respondUploadError(w, err)
+			return
+		}
+		if p.FormName() == "file" {
+			part, filename = p, filepath.Base(p.FileName())
+			break
+		}
+	}
+	if filename == "" || filename == "." || filename == string(filepath.Separator) {
+		respondError(w, http.StatusBadRequest, "The file part needs a filename")
+		return
+	}
+
+	// Detect the type from the first bytes before storing anything
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		respondUploadError(w, err)
+		return
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !slices.Contains(h.attachments.Types, mediaType) {
+		respondError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Attachments of type %s are not accepted", mediaType))
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Storage error: %v", err))
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, io.LimitReader(io.MultiReader(bytes.NewReader(head), part), h.attachments.MaxSize+1))
+	if err != nil {
+		respondUploadError(w, err)
+		return
+	}
+	if size > h.attachments.MaxSize {
+		respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Attachments can be at most %d bytes", h.attachments.MaxSize))
+		return
+	}
+
+	id, err := newAttachmentID()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Storage error: %v", err))
+		return
+	}
+	if err := h.storage.Put(r.Context(), attachmentKey(id), tmp, size); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Storage error: %v", err))
+		return
+	}
+
+	a := models.Attachment{
+		ID:          id,
+		DocumentID:  documentID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		UploadedBy:  r.Header.Get("X-User-ID"),
+		CreatedAt:   h.clock.Now(),
+	}
+	_, err = h.db.ExecContext(r.Context(), `
+		INSERT INTO attachments (id, document_id, filename, content_type, size, uploaded_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, a.ID, a.DocumentID, a.Filename, a.ContentType, a.Size, a.UploadedBy, a.CreatedAt)
+	if err != nil {
+		h.removeAttachmentFiles(context.Background(), []string{id})
+		if isForeignKeyViolation(err) {
+			respondError(w, http.StatusNotFound, "Document not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, a)
+}
+
+// DownloadAttachment handles downloading an attachment. It is always
+// offered as a download under its original name, never shown inline.
+// Range requests are supported if the storage can seek.
+func (h *Handler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	if h.attachments == nil || h.storage == nil {
+		respondError(w, http.StatusNotImplemented, "Attachments are not available")
+		return
+	}
+
+	var a models.Attachment
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT a.id, a.filename, a.content_type, a.size, a.created_at
+		FROM attachments a
+		JOIN documents d ON d.id = a.document_id
+		WHERE a.id = $1 AND a.document_id = $2 AND d.deleted_at IS NULL
+	`, chi.URLParam(r, "attachmentId"), chi.URLParam(r, "documentId")).Scan(&a.ID, &a.Filename, &a.ContentType, &a.Size, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	body, err := h.storage.Get(r.Context(), attachmentKey(a.ID))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Storage error: %v", err))
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if rs, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", a.CreatedAt, rs)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Last-Modified", a.CreatedAt.UTC().Format(http.TimeFormat))
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Failed to send attachment %s: %v", a.ID, err)
+	}
+}
+
+// DeleteAttachment handles deleting an attachment and its file
+func (h *Handler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	var id string
+	err := h.db.QueryRowContext(r.Context(), `
+		DELETE FROM attachments
+		WHERE id = $1 AND document_id = $2
+			AND document_id IN (SELECT id FROM documents WHERE deleted_at IS NULL)
+		RETURNING id
+	`, chi.URLParam(r, "attachmentId"), chi.URLParam(r, "documentId")).Scan(&id)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	h.removeAttachmentFiles(r.Context(), []string{id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeAttachments deletes the attachments of the documents matching
+// where, one of the purge conditions, ahead of purging the documents
+// themselves, so their files go too
+func (h *Handler) purgeAttachments(ctx context.Context, where string, cutoff time.Time) error {
+	ids, err := deleteAttachments(ctx, h.db, where, cutoff)
+	if err != nil {
+		return err
+	}
+	h.removeAttachmentFiles(ctx, ids)
+	return nil
+}
+
+// deleteAttachments deletes, with db, a database or a transaction, the
+// attachments of the documents matching where, given args. It returns
+// their IDs, whose files the caller removes once the deletion is
+// committed.
+func deleteAttachments(ctx context.Context, db interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}, where string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		DELETE FROM attachments
+		WHERE document_id IN (SELECT id FROM documents WHERE `+where+`)
+		RETURNING id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to delete attachments: %w", err)
+	}
+	return ids, nil
+}
+
+// removeAttachmentFiles deletes the files of attachments from storage
+func (h *Handler) removeAttachmentFiles(ctx context.Context, ids []string) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = attachmentKey(id)
+	}
+	h.removeStoredObjects(ctx, keys)
+}
+
+// attachmentKey is the storage key of an attachment's file
+func attachmentKey(id string) string {
+	return "attachments/" + id
+}
+
+// newAttachmentID returns a random attachment ID
+func newAttachmentID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate attachment ID: %w", err)
+	}
+	return "att-" + hex.EncodeToString(b), nil
+}
+
+// respondUploadError answers a failure to read an upload, which is the
+// client's fault if the body was too large or malformed
+func respondUploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	respondError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read upload: %v", err))
+}
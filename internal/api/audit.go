@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditQuerier searches the authorization audit log. It is implemented by
+// *cedar.AuditLog.
+type AuditQuerier interface {
+	Query(ctx context.Context, q cedar.AuditQuery) ([]cedar.AuditRecord, error)
+}
+
+// AuditPseudonymizer replaces an erased user in audit records that are not
+// written yet. It is implemented by *cedar.AuditLog.
+type AuditPseudonymizer interface {
+	Pseudonymize(principalID, pseudonym string)
+}
+
+// SetAuditLog enables the audit log endpoint
+func (h *Handler) SetAuditLog(audit AuditQuerier) {
+	h.audit = audit
+	if ap, ok := audit.(AuditPseudonymizer); ok {
+		h.pseudonymizer = ap
+	}
+}
+
+// ListAuditRecords handles searching the authorization audit log, which
+// can also be exported as CSV or NDJSON. The caller has been authorized
+// for ViewAuditLog by the route middleware.
+func (h *Handler) ListAuditRecords(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		respondError(w, http.StatusNotImplemented, "Audit log is not available")
+		return
+	}
+
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid query: "+err.Error())
+		return
+	}
+
+	records, err := h.audit.Query(r.Context(), q)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	response := models.AuditResponse{
+		Records: make([]models.AuditRecord, 0, len(records)),
+	}
+	for _, rec := range records {
+		response.Records = append(response.Records, models.AuditRecord{
+			Time:            rec.Time,
+			PrincipalID:     rec.PrincipalID,
+			PrincipalRole:   rec.PrincipalRole,
+			PrincipalGroup:  rec.PrincipalGroup,
+			Action:          rec.Action,
+			ResourceID:      rec.ResourceID,
+			Allowed:         rec.Allowed,
+			MatchedPolicies: rec.MatchedPolicies,
+			IPAddress:       rec.IPAddress,
+			IsPrivateIP:     rec.IsPrivateIP,
+			IsJapanIP:       rec.IsJapanIP,
+			MFAVerified:     rec.MFAVerified,
+		})
+	}
+	h.respondList(w, r, response, newList(response.Records, auditColumns, auditRow))
+}
+
+// parseAuditQuery reads the audit search filters from the query string
+func parseAuditQuery(r *http.Request) (cedar.AuditQuery, error) {
+	params := r.URL.Query()
+	q := cedar.AuditQuery{
+		PrincipalID: params.Get("principal"),
+		ResourceID:  params.Get("resource"),
+		Action:      params.Get("action"),
+		Limit:       defaultAuditLimit,
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if v := params.Get(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return cedar.AuditQuery{}, fmt.Errorf("%s must be an RFC 3339 time", bound.name)
+			}
+			*bound.dst = t
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			return cedar.AuditQuery{}, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/iputil"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// maxBatchSize limits the number of entries accepted in a single batch check
+const maxBatchSize = 100
+
+// AuthorizeBatch handles checking several actions for the caller at once.
+// Each entry is reported with its own status so that a bad entry does not
+// fail the whole batch. The principal is always the caller, so that it
+// tells nobody what others may do.
+func (h *Handler) AuthorizeBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-User-ID") == "" || r.Header.Get("X-User-Role") == "" {
+		respondError(w, http.StatusBadRequest, "Missing user headers")
+		return
+	}
+
+	var input models.BatchAuthzInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(input.Requests) == 0 {
+		respondError(w, http.StatusBadRequest, "No requests provided")
+		return
+	}
+	if len(input.Requests) > maxBatchSize {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Too many requests: maximum is %d", maxBatchSize))
+		return
+	}
+
+	results := make([]models.BatchAuthzResult, len(input.Requests))
+	reqs := make([]cedar.AuthzRequest, len(input.Requests))
+	for i, entry := range input.Requests {
+		results[i] = models.BatchAuthzResult{
+			Action:     entry.Action,
+			ResourceID: entry.ResourceID,
+		}
+		reqs[i] = cedar.RequestFromHTTP(r, entry.Action, entry.ResourceID)
+	}
+
+	for i, res := range h.authorizer.AuthorizeBatch(r.Context(), reqs) {
+		switch {
+		case errors.Is(res.Err, cedar.ErrInvalidRequest), errors.Is(res.Err, cedar.ErrUnknownAction):
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = res.Err.Error()
+		case errors.Is(res.Err, cedar.ErrResourceNotFound):
+			results[i].Status = http.StatusNotFound
+			results[i].Error = "Document not found"
+		case errors.Is(res.Err, context.DeadlineExceeded):
+			results[i].Status = http.StatusServiceUnavailable
+			results[i].Error = "Authorization timed out"
+		case res.Err != nil:
+			results[i].Status = http.StatusInternalServerError
+			results[i].Error = fmt.Sprintf("Authorization error: %v", res.Err)
+		case res.Decision.Allowed:
+			results[i].Status = http.StatusOK
+			results[i].Allowed = true
+		default:
+			results[i].Status = http.StatusForbidden
+			results[i].Code = res.Decision.Code
+			results[i].Message = res.Decision.DenyMessage()
+			results[i].Reasons = res.Decision.MatchedPolicies
+		}
+	}
+
+	respondJSON(w, http.StatusMultiStatus, models.BatchAuthzResponse{
+		Results: results,
+	})
+}
+
+// CheckAuthorization handles a standalone authorization request so that
+// other services can use this server as a policy decision point. The
+// principal comes from the body rather than the user headers, and the
+// caller has been authorized for CheckAuthorization by the route
+// middleware. A context "ip_address" is classified like a client address;
+// the remaining context attributes are passed to the policies as they are.
+func (h *Handler) CheckAuthorization(w http.ResponseWriter, r *http.Request) {
+	var input models.AuthzCheckInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req, err := checkRequest(input.Principal, input.Action, input.Resource.ID, input.Context)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	decision, err := h.authorizer.Authorize(r.Context(), req)
+	if err != nil {
+		respondCheckError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, checkResponse(decision))
+}
+
+// checkRequest builds and validates the authorization request for an
+// explicitly described principal. A context "ip_address" is classified
+// like a client address, and "mfa_verified" must be a boolean.
+// "break_glass" cannot be set; it requires a token on a user request.
+// Attributes set to null are unknown.
+func checkRequest(principal models.AuthzPrincipal, action, resourceID string, attrs map[string]any) (cedar.AuthzRequest, error) {
+	req := cedar.AuthzRequest{
+		UserID:      principal.ID,
+		UserRole:    principal.Role,
+		UserGroupID: principal.GroupID,
+		Action:      action,
+		ResourceID:  resourceID,
+		Context:     map[string]any{},
+	}
+	for k, v := range attrs {
+		if k == "break_glass" {
+			return cedar.AuthzRequest{}, errors.New("context break_glass cannot be set")
+		}
+		if v == nil {
+			req.Unknown = append(req.Unknown, k)
+			continue
+		}
+		if k == "mfa_verified" {
+			mfa, ok := v.(bool)
+			if !ok {
+				return cedar.AuthzRequest{}, errors.New("context mfa_verified must be a boolean")
+			}
+			req.MFAVerified = mfa
+			continue
+		}
+		if k != "ip_address" {
+			req.Context[k] = v
+			continue
+		}
+		ip, ok := v.(string)
+		if !ok {
+			return cedar.AuthzRequest{}, errors.New("context ip_address must be a string")
+		}
+		ipInfo := iputil.ClassifyIP(ip)
+		req.IPAddress = ipInfo.IPAddress
+		req.IsPrivateIP = ipInfo.IsPrivateIP
+		req.IsJapanIP = ipInfo.IsJapanIP
+		req.Unknown = append(req.Unknown, cedar.UnknownIPAttributes(ipInfo)...)
+	}
+	slices.Sort(req.Unknown)
+	req.Unknown = slices.Compact(req.Unknown)
+	if err := req.Validate(); err != nil {
+		return cedar.AuthzRequest{}, err
+	}
+	return req, nil
+}
+
+// respondCheckError answers a failed authorization check
+func respondCheckError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cedar.ErrInvalidRequest), errors.Is(err, cedar.ErrUnknownAction):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, cedar.ErrResourceNotFound):
+		respondError(w, http.StatusNotFound, "Document not found")
+	case errors.Is(err, context.DeadlineExceeded):
+		respondError(w, http.StatusServiceUnavailable, "Authorization timed out")
+	default:
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
+	}
+}
+
+// respondDenied answers a request the policies deny with 403 and the deny
+// code, message, and policies of the decision
+func respondDenied(w http.ResponseWriter, decision cedar.AuthzDecision) {
+	respondJSON(w, http.StatusForbidden, models.ErrorResponse{
+		Error:   http.StatusText(http.StatusForbidden),
+		Code:    decision.Code,
+		Message: decision.DenyMessage(),
+		Reasons: decision.MatchedPolicies,
+	})
+}
+
+// decisionName returns "allow" or "deny"
+func decisionName(decision cedar.AuthzDecision) string {
+	if decision.Allowed {
+		return "allow"
+	}
+	return "deny"
+}
+
+// checkResponse describes a decision as returned by the check endpoint
+func checkResponse(decision cedar.AuthzDecision) models.AuthzCheckResponse {
+	return models.AuthzCheckResponse{
+		Decision:    decisionName(decision),
+		Allowed:     decision.Allowed,
+		Diagnostics: diagnostics(decision),
+	}
+}
+
+// diagnostics explains a decision, with empty lists rather than null
+func diagnostics(decision cedar.AuthzDecision) models.AuthzDiagnostics {
+	d := models.AuthzDiagnostics{
+		Code:           decision.Code,
+		Reason:         decision.Reason,
+		Reasons:        decision.MatchedPolicies,
+		ForbidOverride: decision.ForbidOverride,
+		DecidingPolicy: decision.DecidingPolicy,
+		Overridden:     decision.OverriddenPolicies,
+		Errors:         decision.Errors,
+		UnknownContext: decision.UnknownContext,
+	}
+	if d.Reasons == nil {
+		d.Reasons = []string{}
+	}
+	if d.Overridden == nil {
+		d.Overridden = []string{}
+	}
+	if d.Errors == nil {
+		d.Errors = []string{}
+	}
+	if d.UnknownContext == nil {
+		d.UnknownContext = []string{}
+	}
+	return d
+}
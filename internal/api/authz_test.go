@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// TestAuthorizeBatchHandler checks that a batch mixing valid and invalid
+// entries answers 207 with the status of each entry in order
+func TestAuthorizeBatchHandler(t *testing.T) {
+	authorizer, err := cedar.NewAuthorizer(cedar.WithEntityProvider(cedar.StaticEntityProvider{
+		Documents: map[string]cedar.Document{
+			"doc-1": {ID: "doc-1", OwnerID: "user-2", Classification: "public"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(nil, authorizer, clock.Real{})
+
+	tests := []struct {
+		action     string
+		resourceID string
+		status     int
+		allowed    bool
+	}{
+		{"GetDocument", "doc-1", http.StatusOK, true},
+		{"DeleteDocument", "doc-1", http.StatusForbidden, false},
+		{"", "doc-1", http.StatusBadRequest, false},
+		{"PublishDocument", "doc-1", http.StatusBadRequest, false},
+		{"GetDocument", "doc-missing", http.StatusNotFound, false},
+	}
+	var input models.BatchAuthzInput
+	for _, tt := range tests {
+		input.Requests = append(input.Requests, models.BatchAuthzEntry{Action: tt.action, ResourceID: tt.resourceID})
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/authz/batch", bytes.NewReader(body))
+	r.RemoteAddr = "10.0.0.1:40000"
+	r.Header.Set("X-User-ID", "user-1")
+	r.Header.Set("X-User-Role", "viewer")
+	w := httptest.NewRecorder()
+	h.AuthorizeBatch(w, r)
+
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusMultiStatus, w.Body)
+	}
+	var resp models.BatchAuthzResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(resp.Results), len(tests))
+	}
+	for i, tt := range tests {
+		got := resp.Results[i]
+		if got.Action != tt.action || got.ResourceID != tt.resourceID {
+			t.Errorf("result %d is for %s %s, want %s %s", i, got.Action, got.ResourceID, tt.action, tt.resourceID)
+		}
+		if got.Status != tt.status || got.Allowed != tt.allowed {
+			t.Errorf("%s %s: status %d allowed %t, want %d %t", tt.action, tt.resourceID, got.Status, got.Allowed, tt.status, tt.allowed)
+		}
+	}
+	if code := resp.Results[1].Code; code == "" {
+		t.Errorf("denied entry has no deny code")
+	}
+}
+
+// TestMFAVerifiedContext checks that whether the caller completed MFA is
+// read from X-MFA-Verified or else the bearer token's amr claim, and
+// reaches the policies as context.mfa_verified, which deleting requires
+func TestMFAVerifiedContext(t *testing.T) {
+	authorizer, err := cedar.NewAuthorizer(cedar.WithEntityProvider(cedar.StaticEntityProvider{
+		Documents: map[string]cedar.Document{
+			"doc-1": {ID: "doc-1", OwnerID: "user-1", Classification: "internal"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.With(authorizer.Require("DeleteDocument", cedar.URLParam("documentId"))).
+		Delete("/documents/{documentId}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+	token := func(amr ...string) string {
+		claims, err := json.Marshal(map[string]any{"sub": "user-1", "amr": amr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+	}
+	tests := []struct {
+		name          string
+		header        string
+		authorization string
+		status        int
+	}{
+		{"header true", "true", "", http.StatusNoContent},
+		{"header false", "false", "", http.StatusForbidden},
+		{"neither", "", "", http.StatusForbidden},
+		{"token with mfa", "", token("pwd", "mfa"), http.StatusNoContent},
+		{"token without mfa", "", token("pwd"), http.StatusForbidden},
+		{"header false over token with mfa", "false", token("pwd", "mfa"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/documents/doc-1", nil)
+			r.RemoteAddr = "10.0.0.1:40000"
+			r.Header.Set("X-User-ID", "user-1")
+			r.Header.Set("X-User-Role", "editor")
+			if tt.header != "" {
+				r.Header.Set(cedar.MFAHeader, tt.header)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusForbidden {
+				var resp models.ErrorResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Code != "MFA_REQUIRED" {
+					t.Errorf("deny code %q, want MFA_REQUIRED", resp.Code)
+				}
+			}
+		})
+	}
+}
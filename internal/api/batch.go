@@ -0,0 +1,406 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+	"github.com/lib/pq"
+)
+
+// maxDocumentBatchSize limits the number of documents accepted in a single
+// bulk request
+const maxDocumentBatchSize = 500
+
+// maxDocumentBatchGetSize limits the number of documents fetched in a
+// single request, whose content is all loaded at once
+const maxDocumentBatchGetSize = 100
+
+// CreateDocuments handles creating documents in bulk. Each document is
+// validated on its own; the valid ones are created together in a single
+// transaction, and the response holds a result per document in request
+// order. The caller has been authorized for CreateDocument on the
+// collection by the route middleware, and each valid document is
+// authorized again with the valid documents before it counted in the
+// caller's usage, so quotas hold for the batch as a whole.
+func (h *Handler) CreateDocuments(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+
+	var input models.BatchDocumentsInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(input.Documents) == 0 {
+		respondError(w, http.StatusBadRequest, "No documents provided")
+		return
+	}
+	if len(input.Documents) > maxDocumentBatchSize {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Too many documents: maximum is %d", maxDocumentBatchSize))
+		return
+	}
+
+	now := h.clock.Now()
+	results := make([]models.BatchDocumentResult, len(input.Documents))
+	var valid []models.Document
+	var validPositions []int
+	for i, in := range input.Documents {
+		doc, err := h.newDocument(in, userID, now)
+		if fieldErrors(err) != nil {
+			results[i] = models.BatchDocumentResult{Status: validationStatus(err), Error: err.Error(), Errors: fieldErrors(err)}
+			continue
+		} else if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		valid = append(valid, doc)
+		validPositions = append(validPositions, i)
+	}
+
+	collection, _ := cedar.Collection(r)
+	reqs := make([]cedar.AuthzRequest, len(valid))
+	var pendingBytes int64
+	for i, doc := range valid {
+		reqs[i] = cedar.RequestFromHTTP(r, "CreateDocument", collection.ID)
+		reqs[i].PendingDocuments = int64(i)
+		reqs[i].PendingBytes = pendingBytes
+		pendingBytes += int64(len(doc.Content))
+	}
+	var docs []models.Document
+	var positions []int
+	for i, res := range h.authorizer.AuthorizeBatch(r.Context(), reqs) {
+		if setBatchDecision(&results[validPositions[i]], res) {
+			docs = append(docs, valid[i])
+			positions = append(positions, validPositions[i])
+		}
+	}
+
+	// Either all valid documents are created or none
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	stored := make([]storedContent, len(docs))
+	for i, doc := range docs {
+		stored[i], err = h.insertDocument(r.Context(), tx, doc)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		for _, s := range stored {
+			h.removeStoredContent(s)
+		}
+		if isUniqueViolation(err) {
+			respondError(w, http.StatusConflict, "Document IDs are already taken, try again")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	for i, doc := range docs {
+		h.recordUsage(doc.OwnerID, 1, stored[i].bytes(doc.Content))
+		h.publishDocumentEvent(webhook.DocumentCreated, doc.ID, doc.OwnerID)
+		results[positions[i]] = models.BatchDocumentResult{
+			ID:       doc.ID,
+			Status:   http.StatusCreated,
+			Document: &docs[i],
+		}
+	}
+	respondJSON(w, http.StatusMultiStatus, models.BatchDocumentsResponse{Results: results})
+}
+
+// DeleteDocuments handles deleting documents in bulk. Each document is
+// authorized for DeleteDocument on its own and needs an ETag, as in
+// If-Match for a single delete; the permitted ones that have not changed
+// are moved to the trash together, and the response holds a result per ID
+// in request order.
+func (h *Handler) DeleteDocuments(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-User-ID") == "" || r.Header.Get("X-User-Role") == "" {
+		respondError(w, http.StatusBadRequest, "Missing user headers")
+		return
+	}
+
+	var input models.BatchDeleteInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(input.IDs) == 0 {
+		respondError(w, http.StatusBadRequest, "No document IDs provided")
+		return
+	}
+	if len(input.IDs) > maxDocumentBatchSize {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Too many document IDs: maximum is %d", maxDocumentBatchSize))
+		return
+	}
+
+	results := make([]models.BatchDocumentResult, len(input.IDs))
+	permitted, matched := h.authorizeBatchIDs(r, "DeleteDocument", input.IDs, results)
+	var withETag []string
+	for _, id := range permitted {
+		if input.ETags[id] != "" {
+			withETag = append(withETag, id)
+		}
+	}
+
+	deleted := map[string]bool{}
+	changed := map[string]string{}
+	if len(withETag) > 0 {
+		var err error
+		deleted, changed, err = h.trashDocuments(r.Context(), withETag, input.ETags)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+
+	for i, id := range input.IDs {
+		if results[i].Status != 0 {
+			continue
+		}
+		if input.ETags[id] == "" {
+			results[i].Status = http.StatusPreconditionRequired
+			results[i].Error = "ETag is required: send the ETag of the document as you last read it"
+			continue
+		}
+		if etag, ok := changed[id]; ok {
+			results[i].Status = http.StatusPreconditionFailed
+			results[i].Error = "The document has changed since it was read"
+			results[i].ETag = etag
+			continue
+		}
+		if deleted[id] {
+			results[i].Status = http.StatusNoContent
+			log.Printf("Deleted document %s in bulk: user=%s policies=%v", id, r.Header.Get("X-User-ID"), matched[id])
+		} else {
+			// Already in the trash
+			results[i].Status = http.StatusNotFound
+			results[i].Error = "Document not found"
+		}
+	}
+	respondJSON(w, http.StatusMultiStatus, models.BatchDocumentsResponse{Results: results})
+}
+
+// trashDocuments moves the documents ids whose ETags are held by etags,
+// which maps each ID to a list as in If-Match, to the trash in one
+// transaction, so either all of them are deleted or none. It returns the
+// IDs deleted and the current ETags of those that have changed; documents
+// already in the trash are in neither.
+func (h *Handler) trashDocuments(ctx context.Context, ids []string, etags map[string]string) (map[string]bool, map[string]string, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, updated_at FROM documents
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		FOR UPDATE
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, nil, err
+	}
+	var unchanged []string
+	changed := map[string]string{}
+	for rows.Next() {
+		var doc models.Document
+		if err := rows.Scan(&doc.ID, &doc.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if matchETag(etags[doc.ID], doc) {
+			unchanged = append(unchanged, doc.ID)
+		} else {
+			changed[doc.ID] = documentETag(doc)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	type trashed struct {
+		id, ownerID string
+		size        int64
+	}
+	var all []trashed
+	rows, err = tx.QueryContext(ctx, `
+		UPDATE documents SET deleted_at = $1
+		WHERE id = ANY($2)
+		RETURNING id, owner_id, COALESCE(content_size, octet_length(content))
+	`, h.clock.Now(), pq.Array(unchanged))
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var t trashed
+		if err := rows.Scan(&t.id, &t.ownerID, &t.size); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		all = append(all, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	deleted := map[string]bool{}
+	for _, t := range all {
+		deleted[t.id] = true
+		h.recordUsage(t.ownerID, -1, -t.size)
+		h.publishDocumentEvent(webhook.DocumentDeleted, t.id, t.ownerID)
+	}
+	return deleted, changed, nil
+}
+
+// GetDocuments handles fetching documents in bulk. Each document is
+// authorized for GetDocument on its own, the permitted ones are read with
+// one query, and the response holds a result per ID in request order.
+func (h *Handler) GetDocuments(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-User-ID") == "" || r.Header.Get("X-User-Role") == "" {
+		respondError(w, http.StatusBadRequest, "Missing user headers")
+		return
+	}
+
+	var input models.BatchGetInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(input.IDs) == 0 {
+		respondError(w, http.StatusBadRequest, "No document IDs provided")
+		return
+	}
+	if len(input.IDs) > maxDocumentBatchGetSize {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Too many document IDs: maximum is %d", maxDocumentBatchGetSize))
+		return
+	}
+
+	results := make([]models.BatchDocumentResult, len(input.IDs))
+	permitted, _ := h.authorizeBatchIDs(r, "GetDocument", input.IDs, results)
+
+	found := map[string]*models.Document{}
+	if len(permitted) > 0 {
+		rows, err := h.db.QueryContext(r.Context(), `
+			SELECT id, title, content, content_key, content_size, owner_id, document_group_id, classification, tags, metadata, created_at, updated_at
+			FROM documents
+			WHERE id = ANY($1) AND deleted_at IS NULL
+		`, pq.Array(permitted))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var doc models.Document
+			var stored storedContent
+			if err := rows.Scan(&doc.ID, &doc.Title, &doc.Content, &stored.key, &stored.size, &doc.OwnerID, &doc.DocumentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.Metadata, &doc.CreatedAt, &doc.UpdatedAt); err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+				return
+			}
+			if err := h.loadContent(r.Context(), &doc.Content, stored); err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			found[doc.ID] = &doc
+		}
+		if err := rows.Err(); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+
+	for i, id := range input.IDs {
+		if results[i].Status != 0 {
+			continue
+		}
+		if doc, ok := found[id]; ok {
+			results[i].Status = http.StatusOK
+			results[i].Document = doc
+		} else {
+			// In the trash
+			results[i].Status = http.StatusNotFound
+			results[i].Error = "Document not found"
+		}
+	}
+	respondJSON(w, http.StatusMultiStatus, models.BatchDocumentsResponse{Results: results})
+}
+
+// authorizeBatchIDs authorizes action on each of ids with one batch and
+// records in results, which holds a result per ID, those that are empty,
+// repeated, missing, or denied. It returns the permitted IDs with the
+// policies that permitted each.
+func (h *Handler) authorizeBatchIDs(r *http.Request, action string, ids []string, results []models.BatchDocumentResult) ([]string, map[string][]string) {
+	var reqs []cedar.AuthzRequest
+	var positions []int
+	seen := map[string]bool{}
+	for i, id := range ids {
+		results[i].ID = id
+		switch {
+		case id == "":
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = "Document ID is required"
+		case seen[id]:
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = "Duplicate document ID"
+		default:
+			seen[id] = true
+			reqs = append(reqs, cedar.RequestFromHTTP(r, action, id))
+			positions = append(positions, i)
+		}
+	}
+
+	var permitted []string
+	matched := map[string][]string{}
+	for j, res := range h.authorizer.AuthorizeBatch(r.Context(), reqs) {
+		i := positions[j]
+		if setBatchDecision(&results[i], res) {
+			permitted = append(permitted, ids[i])
+			matched[ids[i]] = res.Decision.MatchedPolicies
+		}
+	}
+	return permitted, matched
+}
+
+// setBatchDecision reports whether res permits the document of result,
+// recording in result why not if it does not
+func setBatchDecision(result *models.BatchDocumentResult, res cedar.BatchResult) bool {
+	switch {
+	case errors.Is(res.Err, cedar.ErrResourceNotFound):
+		result.Status = http.StatusNotFound
+		result.Error = "Document not found"
+	case errors.Is(res.Err, context.DeadlineExceeded):
+		result.Status = http.StatusServiceUnavailable
+		result.Error = "Authorization timed out"
+	case res.Err != nil:
+		result.Status = http.StatusInternalServerError
+		result.Error = fmt.Sprintf("Authorization error: %v", res.Err)
+	case res.Decision.Allowed:
+		return true
+	default:
+		result.Status = http.StatusForbidden
+		result.Error = "Access denied"
+		result.Code = res.Decision.Code
+		result.Message = res.Decision.DenyMessage()
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// TestDeleteDocumentsRequiresETags checks that a bulk delete, like a
+// single one, is authorized before the ETag of each document is required
+func TestDeleteDocumentsRequiresETags(t *testing.T) {
+	authorizer, err := cedar.NewAuthorizer(cedar.WithEntityProvider(cedar.StaticEntityProvider{
+		Documents: map[string]cedar.Document{
+			"doc-1": {ID: "doc-1", OwnerID: "user-1", Classification: "internal"},
+			"doc-2": {ID: "doc-2", OwnerID: "user-2", Classification: "internal"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(nil, authorizer, clock.Real{})
+
+	body := `{"ids":["doc-1","doc-2","doc-404"],"etags":{"doc-2":"*"}}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/documents/batch-delete", strings.NewReader(body))
+	r.RemoteAddr = "10.0.0.1:40000"
+	r.Header.Set("X-User-ID", "user-1")
+	r.Header.Set("X-User-Role", "editor")
+	r.Header.Set("X-MFA-Verified", "true")
+	w := httptest.NewRecorder()
+	h.DeleteDocuments(w, r)
+
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusMultiStatus, w.Body)
+	}
+	var resp models.BatchDocumentsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{http.StatusPreconditionRequired, http.StatusForbidden, http.StatusNotFound}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(resp.Results), len(want))
+	}
+	for i, status := range want {
+		if got := resp.Results[i]; got.Status != status {
+			t.Errorf("%s: status %d, want %d: %s", got.ID, got.Status, status, got.Error)
+		}
+	}
+}
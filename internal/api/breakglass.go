@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// BreakGlassGranter issues emergency overrides. It is implemented by
+// *cedar.BreakGlassStore.
+type BreakGlassGranter interface {
+	Grant(ctx context.Context, userID, grantedBy, reason string, d time.Duration) (cedar.BreakGlassGrant, error)
+}
+
+// SetBreakGlass enables the break-glass endpoint
+func (h *Handler) SetBreakGlass(granter BreakGlassGranter) {
+	h.breakGlass = granter
+}
+
+// GrantBreakGlass handles issuing a break-glass token that lets a user
+// bypass the geographic and group restrictions until it expires. The
+// caller has been authorized for GrantBreakGlass by the route middleware.
+func (h *Handler) GrantBreakGlass(w http.ResponseWriter, r *http.Request) {
+	if h.breakGlass == nil {
+		respondError(w, http.StatusNotImplemented, "Break-glass is not available")
+		return
+	}
+
+	var input models.BreakGlassInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	d, err := time.ParseDuration(input.Duration)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid duration: %s", input.Duration))
+		return
+	}
+
+	grant, err := h.breakGlass.Grant(r.Context(), input.UserID, r.Header.Get("X-User-ID"), input.Reason, d)
+	if errors.Is(err, cedar.ErrInvalidBreakGlass) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to grant break-glass: %v", err))
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, models.BreakGlassResponse{
+		UserID:    grant.UserID,
+		GrantedBy: grant.GrantedBy,
+		Reason:    grant.Reason,
+		Token:     grant.Token,
+		ExpiresAt: grant.ExpiresAt,
+	})
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/ksakiyama/study-cedar/internal/storage"
+)
+
+// SetStorage keeps attachments, and document content larger than
+// contentThreshold bytes, in backend instead of the database. With a
+// threshold of 0 all document content stays in the database.
+func (h *Handler) SetStorage(backend storage.Backend, contentThreshold int64) {
+	h.storage = backend
+	h.contentThreshold = contentThreshold
+}
+
+// storedContent says where the content of a document or version is kept.
+// Content in the database is read with the row and has no key; content in
+// storage leaves the content column empty.
+type storedContent struct {
+	key  sql.NullString
+	size sql.NullInt64
+}
+
+// bytes returns the length in bytes of content kept as stored says,
+// inline being the content column
+func (c storedContent) bytes(inline string) int64 {
+	if c.key.Valid {
+		return c.size.Int64
+	}
+	return int64(len(inline))
+}
+
+// storeContent puts content in storage if it is over the threshold. It
+// returns what to write to the content column and where the content is.
+func (h *Handler) storeContent(ctx context.Context, documentID, content string) (string, storedContent, error) {
+	if h.storage == nil || h.contentThreshold <= 0 || int64(len(content)) <= h.contentThreshold {
+		return content, storedContent{}, nil
+	}
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", storedContent{}, fmt.Errorf("failed to generate content key: %w", err)
+	}
+	// Keys are never reused, so versions keep referring to the content
+	// they had
+	key := "documents/" + documentID + "/" + hex.EncodeToString(b)
+	if err := h.storage.Put(ctx, key, strings.NewReader(content), int64(len(content))); err != nil {
+		return "", storedContent{}, fmt.Errorf("failed to store document content: %w", err)
+	}
+	return "", storedContent{
+		key:  sql.NullString{String: key, Valid: true},
+		size: sql.NullInt64{Int64: int64(len(content)), Valid: true},
+	}, nil
+}
+
+// loadContent replaces content, read from the content column, with the
+// content from storage if it is kept there
+func (h *Handler) loadContent(ctx context.Context, content *string, stored storedContent) error {
+	if !stored.key.Valid {
+		return nil
+	}
+	if h.storage == nil {
+		return errors.New("document content is kept in storage, which is not configured")
+	}
+	body, err := h.storage.Get(ctx, stored.key.String)
+	if err != nil {
+		return fmt.Errorf("failed to load document content: %w", err)
+	}
+	defer body.Close()
+	var b strings.Builder
+	b.Grow(int(stored.size.Int64))
+	if _, err := io.Copy(&b, body); err != nil {
+		return fmt.Errorf("failed to load document content: %w", err)
+	}
+	*content = b.String()
+	return nil
+}
+
+// removeStoredContent deletes content put in storage for a write that
+// failed
+func (h *Handler) removeStoredContent(stored storedContent) {
+	if stored.key.Valid {
+		h.removeStoredObjects(context.Background(), []string{stored.key.String})
+	}
+}
+
+// removeStoredObjects deletes objects from storage. Failures are logged,
+// since what referred to them is already gone from the database.
+func (h *Handler) removeStoredObjects(ctx context.Context, keys []string) {
+	if h.storage == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := h.storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to remove %s from storage: %v", key, err)
+		}
+	}
+}
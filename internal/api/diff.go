@@ -0,0 +1,165 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is how many unchanged lines are shown around changed ones
+const diffContext = 3
+
+// DiffDocumentVersions handles showing what changed between two earlier
+// versions of a document: as JSON by default or, with format=unified, as
+// a unified diff of the title and the content. The caller has been
+// authorized for GetDocumentVersion by the route middleware.
+func (h *Handler) DiffDocumentVersions(w http.ResponseWriter, r *http.Request) {
+	from, ok := versionParam(w, r, "version")
+	if !ok {
+		return
+	}
+	to, ok := versionParam(w, r, "other")
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "unified" {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid format: %s", format))
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentId")
+	versions := make([]models.DocumentVersion, 2)
+	for i, version := range []int{from, to} {
+		v, err := h.documentVersion(r.Context(), documentID, version)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, fmt.Sprintf("Version %d not found", version))
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		versions[i] = v
+	}
+
+	if format == "unified" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(unifiedDiff(versions[0], versions[1])))
+		return
+	}
+	respondJSON(w, http.StatusOK, diffVersions(versions[0], versions[1]))
+}
+
+// diffVersions returns the changes from a to b
+func diffVersions(a, b models.DocumentVersion) models.DocumentDiff {
+	diff := models.DocumentDiff{
+		DocumentID:  a.DocumentID,
+		From:        a.Version,
+		To:          b.Version,
+		AddedTags:   []string{},
+		RemovedTags: []string{},
+		Content:     []models.DiffHunk{},
+	}
+	if a.Title != b.Title {
+		diff.Title = &models.ValueChange{From: a.Title, To: b.Title}
+	}
+	if a.Classification != b.Classification {
+		diff.Classification = &models.ValueChange{From: a.Classification, To: b.Classification}
+	}
+	for _, tag := range b.Tags {
+		if !slices.Contains(a.Tags, tag) {
+			diff.AddedTags = append(diff.AddedTags, tag)
+		}
+	}
+	for _, tag := range a.Tags {
+		if !slices.Contains(b.Tags, tag) {
+			diff.RemovedTags = append(diff.RemovedTags, tag)
+		}
+	}
+
+	fromLines, toLines := splitLines(a.Content), splitLines(b.Content)
+	matcher := difflib.NewMatcher(fromLines, toLines)
+	for _, group := range matcher.GetGroupedOpCodes(diffContext) {
+		first, last := group[0], group[len(group)-1]
+		hunk := models.DiffHunk{
+			FromLine:  first.I1 + 1,
+			FromCount: last.I2 - first.I1,
+			ToLine:    first.J1 + 1,
+			ToCount:   last.J2 - first.J1,
+			Lines:     []models.DiffLine{},
+		}
+		for _, op := range group {
+			switch op.Tag {
+			case 'e':
+				hunk.Lines = appendDiffLines(hunk.Lines, "equal", fromLines[op.I1:op.I2])
+			case 'd':
+				hunk.Lines = appendDiffLines(hunk.Lines, "delete", fromLines[op.I1:op.I2])
+			case 'i':
+				hunk.Lines = appendDiffLines(hunk.Lines, "insert", toLines[op.J1:op.J2])
+			case 'r':
+				hunk.Lines = appendDiffLines(hunk.Lines, "delete", fromLines[op.I1:op.I2])
+				hunk.Lines = appendDiffLines(hunk.Lines, "insert", toLines[op.J1:op.J2])
+			}
+		}
+		diff.Content = append(diff.Content, hunk)
+	}
+	return diff
+}
+
+// appendDiffLines appends lines to a hunk with op
+func appendDiffLines(hunk []models.DiffLine, op string, lines []string) []models.DiffLine {
+	for _, line := range lines {
+		hunk = append(hunk, models.DiffLine{Op: op, Text: strings.TrimSuffix(line, "\n")})
+	}
+	return hunk
+}
+
+// unifiedDiff returns the changes from a to b to the title and the content
+// as a unified diff of two files, title and content
+func unifiedDiff(a, b models.DocumentVersion) string {
+	var out strings.Builder
+	for _, file := range []struct {
+		name     string
+		from, to string
+	}{
+		{"title", a.Title, b.Title},
+		{"content", a.Content, b.Content},
+	} {
+		// The diff cannot fail, since it is written to memory
+		text, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(file.from),
+			B:        splitLines(file.to),
+			FromFile: "a/" + file.name,
+			FromDate: fmt.Sprintf("version %d", a.Version),
+			ToFile:   "b/" + file.name,
+			ToDate:   fmt.Sprintf("version %d", b.Version),
+			Context:  diffContext,
+		})
+		out.WriteString(text)
+	}
+	return out.String()
+}
+
+// splitLines splits s into lines that keep their line breaks. A last line
+// without one gets one, so that it compares equal to the same line
+// followed by more.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
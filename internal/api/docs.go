@@ -0,0 +1,57 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// swaggerUIVersion is the version of Swagger UI the docs page loads
+const swaggerUIVersion = "5.17.14"
+
+// docsPage renders Swagger UI for the specification at SpecURL. The UI's
+// scripts and styles are loaded from a CDN by the browser.
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>API documentation</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: {{.SpecURL}}, dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`))
+
+// SetOpenAPI serves spec, the OpenAPI specification as JSON, at
+// GetOpenAPI
+func (h *Handler) SetOpenAPI(spec []byte) {
+	h.openAPI = spec
+}
+
+// GetOpenAPI handles getting the OpenAPI specification of the API
+func (h *Handler) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if h.openAPI == nil {
+		respondError(w, http.StatusNotImplemented, "OpenAPI specification is not available")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.openAPI)
+}
+
+// Docs returns a handler serving Swagger UI for the specification at
+// specURL
+func Docs(specURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		docsPage.Execute(w, struct {
+			Version string
+			SpecURL string
+		}{swaggerUIVersion, specURL})
+	}
+}
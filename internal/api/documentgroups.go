@@ -0,0 +1,228 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/lib/pq"
+)
+
+// foreignKeyViolation is the Postgres error code for a missing or still
+// referenced row
+const foreignKeyViolation = "23503"
+
+// isForeignKeyViolation reports whether err is a foreign key error
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation
+}
+
+// ListDocumentGroups handles listing the document groups. The caller has
+// been authorized for ManageDocumentGroups by the route middleware, as for
+// every document group endpoint.
+func (h *Handler) ListDocumentGroups(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT `+documentGroupColumns+` FROM document_groups ORDER BY id
+	`)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.DocumentGroupsResponse{DocumentGroups: []models.DocumentGroup{}}
+	for rows.Next() {
+		g, err := scanDocumentGroup(rows)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.DocumentGroups = append(response.DocumentGroups, g)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// CreateDocumentGroup handles creating a document group
+func (h *Handler) CreateDocumentGroup(w http.ResponseWriter, r *http.Request) {
+	var input models.DocumentGroupInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.ID == "" || input.Name == "" {
+		respondError(w, http.StatusBadRequest, "id and name are required")
+		return
+	}
+	if input.RetentionDays != nil && *input.RetentionDays < 1 {
+		respondError(w, http.StatusBadRequest, "retention_days must be positive")
+		return
+	}
+	if err := checkMetadataSchema(input.MetadataSchema); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g := models.DocumentGroup{ID: input.ID, Name: input.Name, RetentionDays: input.RetentionDays, MetadataSchema: input.MetadataSchema, CreatedAt: h.clock.Now()}
+	_, err := h.db.ExecContext(r.Context(), `
+		INSERT INTO document_groups (id, name, retention_days, metadata_schema, created_at) VALUES ($1, $2, $3, $4, $5)
+	`, g.ID, g.Name, g.RetentionDays, g.MetadataSchema, g.CreatedAt)
+	if isUniqueViolation(err) {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Document group %s already exists", g.ID))
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusCreated, g)
+}
+
+// GetDocumentGroup handles fetching a document group
+func (h *Handler) GetDocumentGroup(w http.ResponseWriter, r *http.Request) {
+	g, err := scanDocumentGroup(h.db.QueryRowContext(r.Context(), `
+		SELECT `+documentGroupColumns+` FROM document_groups WHERE id = $1
+	`, chi.URLParam(r, "groupId")))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document group not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, g)
+}
+
+// UpdateDocumentGroup handles renaming a document group and replacing its
+// retention and metadata schema. Documents already in the group keep
+// their metadata until it is next changed.
+func (h *Handler) UpdateDocumentGroup(w http.ResponseWriter, r *http.Request) {
+	var input models.DocumentGroupInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.Name == "" {
+		respondError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if input.RetentionDays != nil && *input.RetentionDays < 1 {
+		respondError(w, http.StatusBadRequest, "retention_days must be positive")
+		return
+	}
+	if err := checkMetadataSchema(input.MetadataSchema); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g, err := scanDocumentGroup(h.db.QueryRowContext(r.Context(), `
+		UPDATE document_groups SET name = $1, retention_days = $2, metadata_schema = $3 WHERE id = $4
+		RETURNING `+documentGroupColumns+`
+	`, input.Name, input.RetentionDays, input.MetadataSchema, chi.URLParam(r, "groupId")))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document group not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, g)
+}
+
+// DeleteDocumentGroup handles deleting an empty document group together
+// with its associations with user groups and its templates
+func (h *Handler) DeleteDocumentGroup(w http.ResponseWriter, r *http.Request) {
+	res, err := h.db.ExecContext(r.Context(), `
+		DELETE FROM document_groups WHERE id = $1
+	`, chi.URLParam(r, "groupId"))
+	if isForeignKeyViolation(err) {
+		respondError(w, http.StatusConflict, "The document group still has documents")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "Document group not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AssignDocumentGroup handles moving a document into a document group. The
+// document's metadata is not checked against the group's metadata schema
+// until it is next changed. The caller has been authorized for
+// AssignDocumentGroup on the document by the route middleware.
+func (h *Handler) AssignDocumentGroup(w http.ResponseWriter, r *http.Request) {
+	var input models.DocumentGroupAssignment
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.DocumentGroupID == "" {
+		respondError(w, http.StatusBadRequest, "document_group_id is required")
+		return
+	}
+	h.setDocumentGroup(w, r, sql.NullString{String: input.DocumentGroupID, Valid: true})
+}
+
+// UnassignDocumentGroup handles taking a document out of its document
+// group
+func (h *Handler) UnassignDocumentGroup(w http.ResponseWriter, r *http.Request) {
+	h.setDocumentGroup(w, r, sql.NullString{})
+}
+
+// setDocumentGroup sets the document group of the document in the URL and
+// responds with the updated document
+func (h *Handler) setDocumentGroup(w http.ResponseWriter, r *http.Request, groupID sql.NullString) {
+	var doc models.Document
+	var stored storedContent
+	err := h.db.QueryRowContext(r.Context(), `
+		UPDATE documents SET document_group_id = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NULL
+		RETURNING id, title, content, content_key, content_size, owner_id, document_group_id, classification, tags, metadata, created_at, updated_at
+	`, groupID, h.clock.Now(), chi.URLParam(r, "documentId")).Scan(&doc.ID, &doc.Title, &doc.Content, &stored.key, &stored.size, &doc.OwnerID, &doc.DocumentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.Metadata, &doc.CreatedAt, &doc.UpdatedAt)
+	if isForeignKeyViolation(err) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown document group: %s", groupID.String))
+		return
+	}
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if err := h.loadContent(r.Context(), &doc.Content, stored); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	setDocumentETag(w, doc)
+	respondJSON(w, http.StatusOK, doc)
+}
+
+// documentGroupColumns are the columns read by scanDocumentGroup
+const documentGroupColumns = `id, name, retention_days, metadata_schema, created_at`
+
+// scanDocumentGroup scans a row selected with documentGroupColumns
+func scanDocumentGroup(row interface{ Scan(...any) error }) (models.DocumentGroup, error) {
+	var g models.DocumentGroup
+	var days sql.NullInt64
+	err := row.Scan(&g.ID, &g.Name, &days, &g.MetadataSchema, &g.CreatedAt)
+	if days.Valid {
+		n := int(days.Int64)
+		g.RetentionDays = &n
+	}
+	return g, err
+}
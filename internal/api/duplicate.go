@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+)
+
+// DuplicateDocument handles copying a document into a new one owned by
+// the caller, optionally with its tags and attachments. The copy keeps the
+// original's classification, metadata, and document group, so it is
+// restricted like the original. The caller has been authorized for
+// GetDocument on the original by the route middleware, and is authorized
+// here for CreateDocument on the copy in its document group.
+func (h *Handler) DuplicateDocument(w http.ResponseWriter, r *http.Request) {
+	var input models.DuplicateDocumentInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.IncludeAttachments && (h.attachments == nil || h.storage == nil) {
+		respondError(w, http.StatusNotImplemented, "Attachments are not available")
+		return
+	}
+	if h.newDocuments == nil {
+		respondError(w, http.StatusNotImplemented, "Duplicating documents is not available")
+		return
+	}
+
+	source, err := h.fetchDocument(r.Context(), chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	copied := models.DocumentInput{
+		Title:          source.Title,
+		Content:        source.Content,
+		Classification: source.Classification,
+		Metadata:       source.Metadata,
+	}
+	if input.Title != "" {
+		copied.Title = input.Title
+	}
+	if input.IncludeTags {
+		copied.Tags = source.Tags
+	}
+	now := h.clock.Now()
+	doc, err := h.newDocument(copied, r.Header.Get("X-User-ID"), now)
+	if fieldErrors(err) != nil {
+		respondValidationError(w, err)
+		return
+	} else if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	doc.DocumentGroupID = source.DocumentGroupID
+	if !h.authorizeNewDocument(w, r, doc) {
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	stored, err := h.insertDocument(r.Context(), tx, doc)
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondError(w, http.StatusConflict, "Document ID is already taken, try again")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	var attachmentIDs []string
+	if input.IncludeAttachments {
+		attachmentIDs, err = h.copyAttachments(r.Context(), tx, source.ID, doc.ID, now)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.removeStoredContent(stored)
+		h.removeAttachmentFiles(context.Background(), attachmentIDs)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	h.recordUsage(doc.OwnerID, 1, stored.bytes(doc.Content))
+	h.publishDocumentEvent(webhook.DocumentCreated, doc.ID, doc.OwnerID)
+
+	setDocumentETag(w, doc)
+	respondJSON(w, http.StatusCreated, doc)
+}
+
+// copyAttachments copies the attachments of the document sourceID, files
+// included, to the document targetID with tx. It returns the IDs of the
+// copies whose files were stored, which are to be removed again if the
+// transaction is not committed.
+func (h *Handler) copyAttachments(ctx context.Context, tx *sql.Tx, sourceID, targetID string, now time.Time) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, filename, content_type, size, uploaded_by
+		FROM attachments
+		WHERE document_id = $1
+		ORDER BY created_at, id
+	`, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	var attachments []models.Attachment
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(&a.ID, &a.Filename, &a.ContentType, &a.Size, &a.UploadedBy); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+
+	var copied []string
+	for _, a := range attachments {
+		id, err := newAttachmentID()
+		if err != nil {
+			return copied, err
+		}
+		if err := h.copyAttachmentFile(ctx, a.ID, id, a.Size); err != nil {
+			return copied, err
+		}
+		copied = append(copied, id)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO attachments (id, document_id, filename, content_type, size, uploaded_by, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, id, targetID, a.Filename, a.ContentType, a.Size, a.UploadedBy, now)
+		if err != nil {
+			return copied, fmt.Errorf("failed to copy attachment: %w", err)
+		}
+	}
+	return copied, nil
+}
+
+// copyAttachmentFile stores a copy of the file of the attachment sourceID
+// as that of targetID
+func (h *Handler) copyAttachmentFile(ctx context.Context, sourceID, targetID string, size int64) error {
+	body, err := h.storage.Get(ctx, attachmentKey(sourceID))
+	if err != nil {
+		return fmt.Errorf("failed to read attachment %s: %w", sourceID, err)
+	}
+	defer body.Close()
+	if err := h.storage.Put(ctx, attachmentKey(targetID), body, size); err != nil {
+		return fmt.Errorf("failed to copy attachment %s: %w", sourceID, err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// ListEncoder writes the items of a list response in a media type other
+// than JSON
+type ListEncoder func(w io.Writer, list List) error
+
+// List is a list response as a ListEncoder sees it
+type List struct {
+	// Items are the listed values, which encode to JSON as in the JSON
+	// response
+	Items []any
+	// Columns names the values Row returns for the item at each index,
+	// for tabular media types
+	Columns []string
+	Row     func(i int) []string
+}
+
+// newList returns a List of items whose rows are given by row
+func newList[T any](items []T, columns []string, row func(T) []string) List {
+	list := List{Items: make([]any, len(items)), Columns: columns}
+	for i, item := range items {
+		list.Items[i] = item
+	}
+	list.Row = func(i int) []string { return row(items[i]) }
+	return list
+}
+
+// defaultListEncoders are the media types list responses are available
+// in besides JSON
+func defaultListEncoders() map[string]ListEncoder {
+	return map[string]ListEncoder{
+		"text/csv":             encodeCSV,
+		"application/x-ndjson": encodeNDJSON,
+	}
+}
+
+// SetListEncoder makes list responses available in mediaType, encoded
+// with enc, replacing any encoder for it
+func (h *Handler) SetListEncoder(mediaType string, enc ListEncoder) {
+	h.listEncoders[mediaType] = enc
+}
+
+// respondList writes a list response in the media type the request's
+// Accept header prefers: body as JSON, or list with the matching
+// ListEncoder. A request accepting none of them is answered with 406.
+func (h *Handler) respondList(w http.ResponseWriter, r *http.Request, body any, list List) {
+	w.Header().Add("Vary", "Accept")
+	offers := append([]string{"application/json"}, slices.Sorted(maps.Keys(h.listEncoders))...)
+	mediaType, ok := negotiate(r.Header.Get("Accept"), offers)
+	if !ok {
+		respondError(w, http.StatusNotAcceptable, "Lists are available as "+strings.Join(offers, ", "))
+		return
+	}
+	if mediaType == "application/json" {
+		respondJSON(w, http.StatusOK, body)
+		return
+	}
+
+	contentType := mediaType
+	if strings.HasPrefix(mediaType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	// The status is sent, so a failure can only cut the body short
+	h.listEncoders[mediaType](w, list)
+}
+
+// negotiate returns the offer that accept, an Accept header, prefers, the
+// first offer if accept is empty, or false if it accepts none
+func negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, rng := range ranges {
+		for _, offer := range offers {
+			typ, _, _ := strings.Cut(offer, "/")
+			if rng.mediaType == offer || rng.mediaType == typ+"/*" || rng.mediaType == "*/*" {
+				return offer, true
+			}
+		}
+	}
+	return "", false
+}
+
+// encodeCSV writes the columns of list as a header row followed by a row
+// per item. Values a spreadsheet would take for a formula are prefixed
+// with a quote.
+func encodeCSV(w io.Writer, list List) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(list.Columns); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		row := list.Row(i)
+		for j, value := range row {
+			if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+				row[j] = "'" + value
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// encodeNDJSON writes each item of list as JSON on a line of its own
+func encodeNDJSON(w io.Writer, list List) error {
+	enc := json.NewEncoder(w)
+	for _, item := range list.Items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// documentColumns are the CSV columns of a document list, which leaves out
+// the content
+var documentColumns = []string{"id", "title", "owner_id", "document_group_id", "classification", "tags", "metadata", "created_at", "updated_at", "deleted_at"}
+
+// documentRow returns the values of documentColumns for doc
+func documentRow(doc models.Document) []string {
+	metadata, _ := json.Marshal(doc.Metadata)
+	return []string{
+		doc.ID,
+		doc.Title,
+		doc.OwnerID,
+		doc.DocumentGroupID.String,
+		doc.Classification,
+		strings.Join(doc.Tags, ","),
+		string(metadata),
+		formatTime(&doc.CreatedAt),
+		formatTime(&doc.UpdatedAt),
+		formatTime(doc.DeletedAt),
+	}
+}
+
+// auditColumns are the CSV columns of the audit log
+var auditColumns = []string{"time", "principal_id", "principal_role", "principal_group", "action", "resource_id", "allowed", "matched_policies", "ip_address", "is_private_ip", "is_japan_ip", "mfa_verified"}
+
+// auditRow returns the values of auditColumns for rec
+func auditRow(rec models.AuditRecord) []string {
+	return []string{
+		formatTime(&rec.Time),
+		rec.PrincipalID,
+		rec.PrincipalRole,
+		rec.PrincipalGroup,
+		rec.Action,
+		rec.ResourceID,
+		strconv.FormatBool(rec.Allowed),
+		strings.Join(rec.MatchedPolicies, ","),
+		rec.IPAddress,
+		strconv.FormatBool(rec.IsPrivateIP),
+		strconv.FormatBool(rec.IsJapanIP),
+		strconv.FormatBool(rec.MFAVerified),
+	}
+}
+
+// formatTime formats t in RFC 3339, or as empty if it is nil
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+	"github.com/lib/pq"
+)
+
+// PolicyReloader re-reads the active policies, e.g. after template links
+// were removed from the database. It is implemented by *cedar.Authorizer.
+type PolicyReloader interface {
+	Reload(ctx context.Context) (bool, error)
+}
+
+// erasedRows are the rows that only concern the user $1 and are deleted
+// with them, by the name they are reported under
+var erasedRows = []struct{ name, query string }{
+	{"users", `DELETE FROM users WHERE id = $1`},
+	{"user_group_members", `DELETE FROM user_group_members WHERE user_id = $1`},
+	{"document_grants", `DELETE FROM document_grants WHERE user_id = $1`},
+	{"document_locks", `DELETE FROM document_locks WHERE locked_by = $1`},
+	{"share_links", `DELETE FROM share_links WHERE created_by = $1`},
+	{"document_stars", `DELETE FROM document_stars WHERE user_id = $1`},
+	{"recent_views", `DELETE FROM recent_views WHERE user_id = $1`},
+	{"idempotency_keys", `DELETE FROM idempotency_keys WHERE user_id = $1`},
+	{"break_glass_grants", `DELETE FROM break_glass_grants WHERE user_id = $1`},
+	{"policy_template_links", `
+		DELETE FROM policy_template_links
+		WHERE principal_type = 'DocumentApp::User' AND principal_id = $1
+	`},
+}
+
+// anonymizedRows are the rows that record what the user $1 did and are
+// kept, with the user replaced by the pseudonym $2 and their IP address
+// removed, by the name they are reported under
+var anonymizedRows = []struct{ name, query string }{
+	{"document_versions", `UPDATE document_versions SET replaced_by = $2 WHERE replaced_by = $1`},
+	{"attachments", `UPDATE attachments SET uploaded_by = $2 WHERE uploaded_by = $1`},
+	{"documents.legal_hold_by", `UPDATE documents SET legal_hold_by = $2 WHERE legal_hold_by = $1`},
+	{"webhooks", `UPDATE webhooks SET created_by = $2 WHERE created_by = $1`},
+	{"document_templates", `UPDATE document_templates SET created_by = $2 WHERE created_by = $1`},
+	{"retention_audit", `UPDATE retention_audit SET owner_id = $2 WHERE owner_id = $1`},
+	{"break_glass_grants.granted_by", `UPDATE break_glass_grants SET granted_by = $2 WHERE granted_by = $1`},
+	{"policy_versions", `UPDATE policy_versions SET author = $2 WHERE author = $1`},
+	{"authz_audit", `UPDATE authz_audit SET principal_id = $2, ip_address = '' WHERE principal_id = $1`},
+	{"break_glass_audit", `UPDATE break_glass_audit SET principal_id = $2, ip_address = '' WHERE principal_id = $1`},
+}
+
+// EraseUser handles a data-subject erasure request: it removes everything
+// associated with a user ID in one transaction and answers with a report
+// of what was removed. The user's documents are deleted, or moved to
+// reassign_to if given; documents under legal hold are never deleted and
+// are kept under a pseudonym instead. Records of what the user did to
+// other users' data, including the audit log, are kept with the user ID
+// replaced by the pseudonym, which is random and not reported; audit
+// records still buffered are written under the pseudonym too. The user
+// need not be recorded.
+func (h *Handler) EraseUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	var input models.ErasureInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var v validator
+	if input.ReassignTo == userID {
+		v.add("reassign_to", fieldInvalidValue, "reassign_to must be another user")
+	}
+	if err := v.err(); err != nil {
+		respondValidationError(w, err)
+		return
+	}
+
+	pseudonym, err := newPseudonym()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	report := models.ErasureReport{
+		UserID:              userID,
+		ErasedAt:            h.clock.Now(),
+		ReassignedTo:        input.ReassignTo,
+		DeletedDocuments:    []string{},
+		ReassignedDocuments: []string{},
+		RetainedDocuments:   []string{},
+		Deleted:             map[string]int64{},
+		Anonymized:          map[string]int64{},
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// The documents in the trash no longer count towards usage
+	var usedDocuments, usedBytes int64
+	if err := tx.QueryRowContext(r.Context(), `
+		SELECT COUNT(*), COALESCE(SUM(COALESCE(content_size, octet_length(content))), 0)
+		FROM documents
+		WHERE owner_id = $1 AND deleted_at IS NULL
+	`, userID).Scan(&usedDocuments, &usedBytes); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	// Move the documents that are kept first, so that only the others are
+	// left to delete
+	newOwner, kept := input.ReassignTo, "TRUE"
+	if newOwner == "" {
+		newOwner, kept = pseudonym, "legal_hold"
+	}
+	ids, movedDocuments, movedBytes, err := reassignDocuments(r.Context(), tx, userID, newOwner, kept)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if input.ReassignTo != "" {
+		report.ReassignedDocuments = ids
+	} else {
+		report.RetainedDocuments = ids
+	}
+
+	attachmentIDs, err := deleteAttachments(r.Context(), tx, "owner_id = $1", userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	deleted, keys, err := deleteDocuments(r.Context(), tx, "owner_id = $1", userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	for _, doc := range deleted {
+		report.DeletedDocuments = append(report.DeletedDocuments, doc.ID)
+	}
+
+	for _, rows := range erasedRows {
+		res, err := tx.ExecContext(r.Context(), rows.query, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		report.Deleted[rows.name], _ = res.RowsAffected()
+	}
+	// Links to the deleted documents would otherwise keep naming them
+	res, err := tx.ExecContext(r.Context(), `
+		DELETE FROM policy_template_links
+		WHERE resource_type = 'DocumentApp::Document' AND resource_id = ANY($1)
+	`, pq.Array(report.DeletedDocuments))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	links, _ := res.RowsAffected()
+	report.Deleted["policy_template_links"] += links
+
+	// Audit records not yet written would bring the user back after the
+	// stored ones are anonymized
+	if h.pseudonymizer != nil {
+		h.pseudonymizer.Pseudonymize(userID, pseudonym)
+	}
+	for _, rows := range anonymizedRows {
+		res, err := tx.ExecContext(r.Context(), rows.query, userID, pseudonym)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		report.Anonymized[rows.name], _ = res.RowsAffected()
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	h.removeAttachmentFiles(r.Context(), attachmentIDs)
+	h.removeStoredObjects(r.Context(), keys)
+	h.recordUsage(userID, -usedDocuments, -usedBytes)
+	h.recordUsage(newOwner, movedDocuments, movedBytes)
+	for _, doc := range deleted {
+		if doc.DeletedAt == nil {
+			h.publishDocumentEvent(webhook.DocumentDeleted, doc.ID, userID)
+		}
+	}
+	if report.Deleted["policy_template_links"] > 0 && h.reloader != nil {
+		if _, err := h.reloader.Reload(r.Context()); err != nil {
+			log.Printf("Failed to reload policies after erasing a user: %v", err)
+		}
+	}
+	respondJSON(w, http.StatusOK, report)
+}
+
+// reassignDocuments moves the documents of userID matching kept to
+// newOwner. It returns their IDs and the number and size of those not in
+// the trash.
+func reassignDocuments(ctx context.Context, tx *sql.Tx, userID, newOwner, kept string) ([]string, int64, int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE documents SET owner_id = $2
+		WHERE owner_id = $1 AND `+kept+`
+		RETURNING id, deleted_at IS NULL, COALESCE(content_size, octet_length(content))
+	`, userID, newOwner)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to reassign documents: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	var documents, bytes int64
+	for rows.Next() {
+		var id string
+		var live bool
+		var size int64
+		if err := rows.Scan(&id, &live, &size); err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to scan reassigned document: %w", err)
+		}
+		ids = append(ids, id)
+		if live {
+			documents++
+			bytes += size
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to reassign documents: %w", err)
+	}
+	return ids, documents, bytes, nil
+}
+
+// newPseudonym returns a random user ID to replace an erased one
+func newPseudonym() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate pseudonym: %w", err)
+	}
+	return "erased-" + hex.EncodeToString(b), nil
+}
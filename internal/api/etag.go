@@ -0,0 +1,102 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// documentETag identifies the revision of a document by when it was last
+// updated. The time is taken as the database stores it, to the
+// microsecond and without its zone, so a document read back after an
+// update has the ETag the update responded with.
+func documentETag(doc models.Document) string {
+	updated := doc.UpdatedAt.Round(time.Microsecond).Format("2006-01-02T15:04:05.000000")
+	sum := sha256.Sum256([]byte(doc.ID + "\x00" + updated))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// setDocumentETag sets the ETag header of a response holding doc
+func setDocumentETag(w http.ResponseWriter, doc models.Document) {
+	w.Header().Set("ETag", documentETag(doc))
+}
+
+// setDocumentValidators sets the ETag and Last-Modified headers of a
+// response holding doc, for conditional requests
+func setDocumentValidators(w http.ResponseWriter, doc models.Document) {
+	setDocumentETag(w, doc)
+	w.Header().Set("Last-Modified", doc.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// isConditionalGet reports whether r asks for a document only if it has
+// changed
+func isConditionalGet(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// notModified reports whether the client's copy of doc is current: if
+// If-None-Match holds its ETag, or, without If-None-Match, if it has not
+// been updated since If-Modified-Since. Last-Modified is to the second, so
+// a document updated within the second after it is taken as unchanged
+// unless the client also sends its ETag.
+func notModified(r *http.Request, doc models.Document) bool {
+	if etags := r.Header.Get("If-None-Match"); etags != "" {
+		etag := documentETag(doc)
+		for _, candidate := range strings.Split(etags, ",") {
+			// If-None-Match compares weakly
+			c := strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if c == "*" || c == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !doc.UpdatedAt.Truncate(time.Second).After(since)
+}
+
+// respondNotModified responds with 304 and the validators of doc
+func respondNotModified(w http.ResponseWriter, doc models.Document) {
+	setDocumentValidators(w, doc)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// requireIfMatch responds with 428 if the request has no If-Match header
+func requireIfMatch(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("If-Match") == "" {
+		respondError(w, http.StatusPreconditionRequired, "If-Match is required: send the ETag of the document as you last read it")
+		return false
+	}
+	return true
+}
+
+// errETagMismatch is returned when a document is changed with an ETag
+// other than its own
+var errETagMismatch = errors.New("the document has changed since it was read")
+
+// matchETag reports whether etags, a list as in If-Match, holds the ETag
+// of doc. "*" matches any document.
+func matchETag(etags string, doc models.Document) bool {
+	etag := documentETag(doc)
+	for _, candidate := range strings.Split(etags, ",") {
+		// Weak ETags never match, as If-Match compares strongly
+		if c := strings.TrimSpace(candidate); c == "*" || c == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondETagMismatch responds with 412 and the current ETag of doc
+func respondETagMismatch(w http.ResponseWriter, doc models.Document) {
+	setDocumentETag(w, doc)
+	respondError(w, http.StatusPreconditionFailed, "The document has changed since it was read")
+}
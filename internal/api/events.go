@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/lib/pq"
+)
+
+// documentsChannel is notified by a trigger on documents with each change
+// as a documentChange in JSON
+const documentsChannel = "documents_changed"
+
+const (
+	// documentEventBuffer is how many changes a stream may fall behind
+	// before it is ended
+	documentEventBuffer = 64
+	// documentEventHeartbeat is how often an idle stream is sent a comment,
+	// so that proxies do not close it
+	documentEventHeartbeat = 30 * time.Second
+)
+
+// resyncEvent tells a stream that changes may have been missed
+const resyncEvent = "resync"
+
+// documentChange is a change to a document as notified by the database
+type documentChange struct {
+	Type       string `json:"type"`
+	DocumentID string `json:"document_id"`
+	OwnerID    string `json:"owner_id"`
+}
+
+// DocumentEvents fans the document changes notified by Postgres out to the
+// event streams of StreamDocumentEvents, over one LISTEN connection
+type DocumentEvents struct {
+	dsn string
+
+	mu      sync.Mutex
+	streams map[chan documentChange]struct{}
+	closed  bool
+}
+
+// NewDocumentEvents creates the document event source. dsn is used for
+// the dedicated listener connection.
+func NewDocumentEvents(dsn string) *DocumentEvents {
+	return &DocumentEvents{dsn: dsn, streams: map[chan documentChange]struct{}{}}
+}
+
+// SetDocumentEvents enables the document event stream
+func (h *Handler) SetDocumentEvents(events *DocumentEvents) {
+	h.documentEvents = events
+}
+
+// Run listens for document changes until ctx is cancelled, then ends all
+// streams
+func (e *DocumentEvents) Run(ctx context.Context) error {
+	defer e.Close()
+	listener := pq.NewListener(e.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Document event listener: %v", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(documentsChannel); err != nil {
+		return fmt.Errorf("failed to listen for document changes: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				// The connection was re-established and notifications
+				// may have been lost
+				e.broadcast(documentChange{Type: resyncEvent})
+				continue
+			}
+			var change documentChange
+			if err := json.Unmarshal([]byte(n.Extra), &change); err != nil {
+				log.Printf("Invalid document change notification %q: %v", n.Extra, err)
+				continue
+			}
+			e.broadcast(change)
+		case <-time.After(90 * time.Second):
+			// Detect a dead connection while no notifications arrive
+			go listener.Ping()
+		}
+	}
+}
+
+// Close ends all streams, e.g. so that they do not hold up a graceful
+// shutdown. Streams opened afterwards end immediately.
+func (e *DocumentEvents) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for stream := range e.streams {
+		close(stream)
+		delete(e.streams, stream)
+	}
+}
+
+// subscribe returns a channel receiving every change, and a function to
+// stop receiving them. The channel is closed when the stream falls behind
+// or the events are closed.
+func (e *DocumentEvents) subscribe() (<-chan documentChange, func()) {
+	stream := make(chan documentChange, documentEventBuffer)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		close(stream)
+		return stream, func() {}
+	}
+	e.streams[stream] = struct{}{}
+	return stream, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.streams[stream]; ok {
+			close(stream)
+			delete(e.streams, stream)
+		}
+	}
+}
+
+// broadcast sends change to every stream, ending those that have fallen
+// behind rather than waiting for them
+func (e *DocumentEvents) broadcast(change documentChange) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for stream := range e.streams {
+		select {
+		case stream <- change:
+		default:
+			close(stream)
+			delete(e.streams, stream)
+		}
+	}
+}
+
+// StreamDocumentEvents handles streaming the changes to documents the
+// caller may get as server-sent events, until the client disconnects.
+// Each event is named after its type, e.g. document.updated, with a
+// models.DocumentEventData as data. A resync event means changes may have
+// been missed. The stream ends if the client falls behind; clients should
+// reconnect and reload what they show, as after a resync.
+func (h *Handler) StreamDocumentEvents(w http.ResponseWriter, r *http.Request) {
+	if h.documentEvents == nil {
+		respondError(w, http.StatusNotImplemented, "Document events are not available")
+		return
+	}
+
+	changes, unsubscribe := h.documentEvents.subscribe()
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Document events cannot be streamed: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(documentEventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			data := []byte("{}")
+			if change.Type != resyncEvent {
+				allowed, err := h.canGetDocument(r, change.DocumentID)
+				if err != nil {
+					log.Printf("Failed to authorize document event for %s: %v", change.DocumentID, err)
+					continue
+				}
+				if !allowed {
+					continue
+				}
+				data, _ = json.Marshal(models.DocumentEventData{DocumentID: change.DocumentID, OwnerID: change.OwnerID})
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", change.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// canGetDocument reports whether the caller of r may get the document
+// documentID, including one in the trash. Like findDocuments, it filters
+// in SQL when the policies allow it, so that documents the caller may not
+// see are not recorded as denials.
+func (h *Handler) canGetDocument(r *http.Request, documentID string) (bool, error) {
+	filter, err := h.authorizer.ResourceFilter(r.Context(), cedar.RequestFromHTTP(r, "GetDocument", ""))
+	var where string
+	var args []any
+	if err == nil {
+		where, args, err = cedar.DocumentSQL(filter, []any{documentID})
+	}
+	if errors.Is(err, cedar.ErrUnsupportedFilter) {
+		decision, err := h.authorizer.Authorize(r.Context(), cedar.RequestFromHTTP(r, "GetDocument", documentID))
+		if errors.Is(err, cedar.ErrResourceNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return decision.Allowed, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var allowed bool
+	err = h.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM documents WHERE id = $1 AND (`+where+`))
+	`, args...).Scan(&allowed)
+	return allowed, err
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/lib/pq"
+)
+
+// maxFacetValues is the number of most common values counted per facet
+const maxFacetValues = 100
+
+// documentFacet is a facet the document list can be counted by, with the
+// grouped query counting it, whose %s is the WHERE condition
+type documentFacet struct {
+	name, query string
+}
+
+// documentFacets are the facets in the order they are counted
+var documentFacets = []documentFacet{
+	{"tag", `
+		SELECT tag, COUNT(*) FROM documents CROSS JOIN LATERAL unnest(tags) AS t(tag)
+		WHERE %s GROUP BY tag`},
+	{"group", `
+		SELECT document_group_id, COUNT(*) FROM documents
+		WHERE %s GROUP BY document_group_id`},
+	{"owner", `
+		SELECT owner_id, COUNT(*) FROM documents
+		WHERE %s GROUP BY owner_id`},
+	{"classification", `
+		SELECT classification, COUNT(*) FROM documents
+		WHERE %s GROUP BY classification`},
+}
+
+// parseFacets reads the facets query parameter, a comma-separated list of
+// facet names
+func parseFacets(params url.Values) ([]string, error) {
+	v := params.Get("facets")
+	if v == "" {
+		return nil, nil
+	}
+	var facets []string
+	for _, name := range strings.Split(v, ",") {
+		known := slices.ContainsFunc(documentFacets, func(f documentFacet) bool {
+			return f.name == name
+		})
+		if !known {
+			return nil, fmt.Errorf("unknown facet %q: use tag, group, owner, or classification", name)
+		}
+		if !slices.Contains(facets, name) {
+			facets = append(facets, name)
+		}
+	}
+	return facets, nil
+}
+
+// countFacets counts the documents matching narrow for which the caller
+// of r is allowed action by each of facets, whichever page is listed. The
+// counts come from one snapshot, so they agree with each other.
+func (h *Handler) countFacets(r *http.Request, action string, narrow documentFilter, facets []string) (map[string][]models.FacetCount, error) {
+	filter, err := h.authorizer.ResourceFilter(r.Context(), cedar.RequestFromHTTP(r, action, ""))
+	var where string
+	var args []any
+	if err == nil {
+		where, args, err = cedar.DocumentSQL(filter, nil)
+	}
+	if errors.Is(err, cedar.ErrUnsupportedFilter) {
+		// Count the documents the caller may list, checked one by one
+		log.Printf("Falling back to per-document facet counting: %v", err)
+		documents, err := h.listAuthorizedDocuments(r, action, narrow, documentPage{sort: "created_at", desc: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count facets: %w", err)
+		}
+		ids := make([]string, 0, len(documents))
+		for _, doc := range documents {
+			ids = append(ids, doc.ID)
+		}
+		where, args, err = "id = ANY($1)", []any{pq.Array(ids)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter documents: %w", err)
+	}
+	where, args = narrow.sql(where, args)
+
+	tx, err := h.db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count facets: %w", err)
+	}
+	defer tx.Rollback()
+
+	counts := map[string][]models.FacetCount{}
+	for _, facet := range documentFacets {
+		if !slices.Contains(facets, facet.name) {
+			continue
+		}
+		values, err := countFacet(r.Context(), tx, fmt.Sprintf(facet.query, where), args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s facet: %w", facet.name, err)
+		}
+		counts[facet.name] = values
+	}
+	return counts, nil
+}
+
+// countFacet runs a facet query and returns its most common values, most
+// common first
+func countFacet(ctx context.Context, tx *sql.Tx, query string, args []any) ([]models.FacetCount, error) {
+	args = append(slices.Clip(args), maxFacetValues)
+	query += fmt.Sprintf(" ORDER BY COUNT(*) DESC, 1 NULLS LAST LIMIT $%d", len(args))
+	values := []models.FacetCount{}
+	err := countDocuments(ctx, tx, query, args, func(rows *sql.Rows) error {
+		var c models.FacetCount
+		var value sql.NullString
+		if err := rows.Scan(&value, &c.Count); err != nil {
+			return err
+		}
+		if value.Valid {
+			c.Value = &value.String
+		}
+		values = append(values, c)
+		return nil
+	})
+	return values, err
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// documentFieldNames are the fields of a document, as in JSON, that a
+// sparse fieldset can select
+var documentFieldNames = []string{"id", "title", "content", "owner_id", "document_group_id", "classification", "tags", "metadata", "created_at", "updated_at", "deleted_at"}
+
+// documentFields is a sparse fieldset: the fields of each document a
+// response holds, or every field if nil
+type documentFields map[string]bool
+
+// parseDocumentFields reads the fields query parameter, a comma-separated
+// list of document fields. The ID is always included, so that documents
+// can still be told apart.
+func parseDocumentFields(params url.Values) (documentFields, error) {
+	v := params.Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+	fields := documentFields{"id": true}
+	for _, name := range strings.Split(v, ",") {
+		if !slices.Contains(documentFieldNames, name) {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields[name] = true
+	}
+	return fields, nil
+}
+
+// has reports whether the fieldset includes the field name
+func (f documentFields) has(name string) bool {
+	return f == nil || f[name]
+}
+
+// project returns doc, for encoding as JSON, with only the fields of the
+// fieldset
+func (f documentFields) project(doc models.Document) any {
+	if f == nil {
+		return doc
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return doc
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return doc
+	}
+	for name := range fields {
+		if !f[name] {
+			delete(fields, name)
+		}
+	}
+	return fields
+}
+
+// list returns documents as a List with only the fields of the fieldset,
+// in JSON and in the columns
+func (f documentFields) list(documents []models.Document) List {
+	list := newList(documents, documentColumns, documentRow)
+	if f == nil {
+		return list
+	}
+	var keep []int
+	list.Columns = nil
+	for i, column := range documentColumns {
+		if f[column] {
+			keep = append(keep, i)
+			list.Columns = append(list.Columns, column)
+		}
+	}
+	row := list.Row
+	list.Row = func(i int) []string {
+		all := row(i)
+		values := make([]string, len(keep))
+		for j, k := range keep {
+			values[j] = all[k]
+		}
+		return values
+	}
+	for i, doc := range documents {
+		list.Items[i] = f.project(doc)
+	}
+	return list
+}
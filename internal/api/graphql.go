@@ -0,0 +1,459 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+)
+
+// graphQLSchema is the schema of the GraphQL endpoint
+//
+//go:embed documents.graphql
+var graphQLSchema string
+
+// maxGraphQLDepth limits how deeply a GraphQL query may nest fields
+const maxGraphQLDepth = 8
+
+// errGraphQLNotFound is returned for a document that does not exist
+var errGraphQLNotFound = errors.New("Document not found")
+
+// graphQLRequestKey is the context key of the HTTP request a GraphQL query
+// came in, from which authorization requests are built
+type graphQLRequestKey struct{}
+
+// GraphQL handles GraphQL queries and mutations of documents. Every field
+// that exposes more than the document is authorized with the policies on
+// its own, so a query gets what the caller may see, with an error for each
+// field denied.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphQLRequestKey{}, r)
+	respondJSON(w, http.StatusOK, h.graphQL.Exec(ctx, params.Query, params.OperationName, params.Variables))
+}
+
+// accessDeniedError is returned for a GraphQL field the caller may not see
+type accessDeniedError struct {
+	decision cedar.AuthzDecision
+}
+
+func (e accessDeniedError) Error() string {
+	return e.decision.DenyMessage()
+}
+
+// Extensions adds the deny code and policies to the GraphQL error
+func (e accessDeniedError) Extensions() map[string]any {
+	reasons := e.decision.MatchedPolicies
+	if reasons == nil {
+		reasons = []string{}
+	}
+	return map[string]any{
+		"code":    e.decision.Code,
+		"reasons": reasons,
+	}
+}
+
+// authorizeGraphQL checks that the caller of a GraphQL query may perform
+// action on the resource
+func (h *Handler) authorizeGraphQL(ctx context.Context, action, resourceID string) error {
+	r := ctx.Value(graphQLRequestKey{}).(*http.Request)
+	req := cedar.RequestFromHTTP(r, action, resourceID)
+	if req.UserID == "" || req.UserRole == "" {
+		return errors.New("Missing user headers")
+	}
+	decision, err := h.authorizer.Authorize(ctx, req)
+	if errors.Is(err, cedar.ErrResourceNotFound) {
+		return errGraphQLNotFound
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errors.New("Authorization timed out")
+	}
+	if err != nil {
+		return fmt.Errorf("Authorization error: %w", err)
+	}
+	if !decision.Allowed {
+		return accessDeniedError{decision}
+	}
+	return nil
+}
+
+// graphQLResolver resolves the query and mutation fields
+type graphQLResolver struct {
+	h *Handler
+}
+
+// Document resolves a single document, or null if there is none
+func (q *graphQLResolver) Document(ctx context.Context, args struct{ ID graphql.ID }) (*documentResolver, error) {
+	id := string(args.ID)
+	err := q.h.authorizeGraphQL(ctx, "GetDocument", id)
+	if err == errGraphQLNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := q.h.fetchDocument(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &documentResolver{h: q.h, doc: doc}, nil
+}
+
+// documentsArgs are the arguments of the documents query, named like the
+// query parameters of the document list
+type documentsArgs struct {
+	Sort            *string
+	Order           *string
+	Limit           *int32
+	Cursor          *string
+	Offset          *int32
+	OwnerID         *string
+	DocumentGroupID *string
+	CreatedAfter    *graphql.Time
+	CreatedBefore   *graphql.Time
+}
+
+// params returns the arguments as document list query parameters
+func (a documentsArgs) params() url.Values {
+	params := url.Values{}
+	for name, v := range map[string]*string{
+		"sort":              a.Sort,
+		"order":             a.Order,
+		"cursor":            a.Cursor,
+		"owner_id":          a.OwnerID,
+		"document_group_id": a.DocumentGroupID,
+	} {
+		if v != nil {
+			params.Set(name, *v)
+		}
+	}
+	if a.Limit != nil {
+		params.Set("limit", strconv.Itoa(int(*a.Limit)))
+	}
+	if a.Offset != nil {
+		params.Set("offset", strconv.Itoa(int(*a.Offset)))
+	}
+	if a.CreatedAfter != nil {
+		params.Set("created_after", a.CreatedAfter.Format(time.RFC3339))
+	}
+	if a.CreatedBefore != nil {
+		params.Set("created_before", a.CreatedBefore.Format(time.RFC3339))
+	}
+	return params
+}
+
+// Documents resolves a page of the documents the caller may list
+func (q *graphQLResolver) Documents(ctx context.Context, args documentsArgs) (*documentPageResolver, error) {
+	collection, _ := cedar.Collection(nil)
+	if err := q.h.authorizeGraphQL(ctx, "ListDocuments", collection.ID); err != nil {
+		return nil, err
+	}
+	page, err := parseDocumentPage(args.params())
+	if err != nil {
+		return nil, err
+	}
+	narrow, err := parseDocumentFilter(args.params())
+	if err != nil {
+		return nil, err
+	}
+	documents, err := q.h.findDocuments(ctx.Value(graphQLRequestKey{}).(*http.Request), "ListDocuments", narrow, page)
+	if err != nil {
+		return nil, err
+	}
+	return &documentPageResolver{h: q.h, page: page.response(documents)}, nil
+}
+
+// documentInput is the input of createDocument
+type documentInput struct {
+	Title          string
+	Content        string
+	Classification *string
+	Tags           *[]string
+}
+
+// CreateDocument creates a document owned by the caller
+func (q *graphQLResolver) CreateDocument(ctx context.Context, args struct{ Input documentInput }) (*documentResolver, error) {
+	collection, _ := cedar.Collection(nil)
+	if err := q.h.authorizeGraphQL(ctx, "CreateDocument", collection.ID); err != nil {
+		return nil, err
+	}
+	input := models.DocumentInput{Title: args.Input.Title, Content: args.Input.Content}
+	if args.Input.Classification != nil {
+		input.Classification = *args.Input.Classification
+	}
+	if args.Input.Tags != nil {
+		input.Tags = *args.Input.Tags
+	}
+
+	r := ctx.Value(graphQLRequestKey{}).(*http.Request)
+	now := q.h.clock.Now()
+	doc, err := q.h.newDocument(input, r.Header.Get("X-User-ID"), now)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := q.h.insertDocument(ctx, q.h.db, doc)
+	if isUniqueViolation(err) {
+		return nil, errors.New("Document ID is already taken, try again")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Database error: %w", err)
+	}
+	q.h.recordUsage(doc.OwnerID, 1, stored.bytes(doc.Content))
+	q.h.publishDocumentEvent(webhook.DocumentCreated, doc.ID, doc.OwnerID)
+	return &documentResolver{h: q.h, doc: doc}, nil
+}
+
+// documentPatch is the input of updateDocument; only the fields set change
+type documentPatch struct {
+	Title          *string
+	Content        *string
+	Classification *string
+	Tags           *[]string
+}
+
+// UpdateDocument changes the fields set in the patch
+func (q *graphQLResolver) UpdateDocument(ctx context.Context, args struct {
+	ID    graphql.ID
+	Etag  string
+	Input documentPatch
+}) (*documentResolver, error) {
+	id := string(args.ID)
+	if err := q.h.authorizeGraphQL(ctx, "UpdateDocument", id); err != nil {
+		return nil, err
+	}
+	r := ctx.Value(graphQLRequestKey{}).(*http.Request)
+	patch := args.Input
+	authorize := func(action string) error {
+		return q.h.authorizeGraphQL(ctx, action, id)
+	}
+	doc, err := q.h.modifyDocument(ctx, id, r.Header.Get("X-User-ID"), args.Etag, patch.Content != nil, authorize, func(doc *models.Document) error {
+		if patch.Title != nil {
+			doc.Title = *patch.Title
+		}
+		if patch.Content != nil {
+			doc.Content = *patch.Content
+		}
+		if patch.Classification != nil {
+			doc.Classification = *patch.Classification
+		}
+		if patch.Tags != nil {
+			doc.Tags = append([]string{}, *patch.Tags...)
+		}
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		return nil, errGraphQLNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := q.h.hideUnreadableContent(ctx, cedar.RequestFromHTTP(r, "GetDocument", id), &doc); err != nil {
+		return nil, fmt.Errorf("Authorization error: %w", err)
+	}
+	return &documentResolver{h: q.h, doc: doc}, nil
+}
+
+// DeleteDocument moves a document to the trash
+func (q *graphQLResolver) DeleteDocument(ctx context.Context, args struct {
+	ID   graphql.ID
+	Etag string
+}) (bool, error) {
+	id := string(args.ID)
+	if err := q.h.authorizeGraphQL(ctx, "DeleteDocument", id); err != nil {
+		return false, err
+	}
+	_, err := q.h.trashDocument(ctx, id, args.Etag)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return err == nil, err
+}
+
+// documentPageResolver resolves a page of documents
+type documentPageResolver struct {
+	h    *Handler
+	page models.DocumentsResponse
+}
+
+func (p *documentPageResolver) Documents() []*documentResolver {
+	documents := make([]*documentResolver, len(p.page.Documents))
+	for i, doc := range p.page.Documents {
+		documents[i] = &documentResolver{h: p.h, doc: doc}
+	}
+	return documents
+}
+
+func (p *documentPageResolver) NextCursor() *string {
+	if p.page.NextCursor == "" {
+		return nil
+	}
+	return &p.page.NextCursor
+}
+
+// documentResolver resolves the fields of a document the caller may see
+type documentResolver struct {
+	h   *Handler
+	doc models.Document
+}
+
+func (d *documentResolver) ID() graphql.ID         { return graphql.ID(d.doc.ID) }
+func (d *documentResolver) Title() string          { return d.doc.Title }
+func (d *documentResolver) Content() string        { return d.doc.Content }
+func (d *documentResolver) Classification() string { return d.doc.Classification }
+func (d *documentResolver) Tags() []string         { return d.doc.Tags }
+func (d *documentResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: d.doc.CreatedAt}
+}
+func (d *documentResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: d.doc.UpdatedAt}
+}
+func (d *documentResolver) Etag() string { return documentETag(d.doc) }
+
+// Owner resolves the owner, whose details are authorized field by field
+func (d *documentResolver) Owner(ctx context.Context) (*userResolver, error) {
+	user, err := d.h.loadUser(ctx, d.doc.OwnerID)
+	if err == sql.ErrNoRows {
+		return &userResolver{h: d.h, user: models.User{ID: d.doc.OwnerID}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load user: %w", err)
+	}
+	return &userResolver{h: d.h, user: user, recorded: true}, nil
+}
+
+// Group resolves the document group, or null if the document has none
+func (d *documentResolver) Group(ctx context.Context) (*documentGroupResolver, error) {
+	if !d.doc.DocumentGroupID.Valid {
+		return nil, nil
+	}
+	var g models.DocumentGroup
+	err := d.h.db.QueryRowContext(ctx, `
+		SELECT id, name, created_at FROM document_groups WHERE id = $1
+	`, d.doc.DocumentGroupID.String).Scan(&g.ID, &g.Name, &g.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("Database error: %w", err)
+	}
+	return &documentGroupResolver{g}, nil
+}
+
+// Versions resolves the earlier versions if the caller may list them
+func (d *documentResolver) Versions(ctx context.Context) (*[]*documentVersionResolver, error) {
+	if err := d.h.authorizeGraphQL(ctx, "ListDocumentVersions", d.doc.ID); err != nil {
+		return nil, err
+	}
+	versions, err := d.h.documentVersions(ctx, d.doc.ID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*documentVersionResolver, len(versions))
+	for i, v := range versions {
+		resolvers[i] = &documentVersionResolver{v}
+	}
+	return &resolvers, nil
+}
+
+// userResolver resolves a user. Beyond the ID and name, the fields
+// require ManageUsers, as the user endpoints do.
+type userResolver struct {
+	h        *Handler
+	user     models.User
+	recorded bool
+
+	once   sync.Once
+	denied error
+}
+
+// authorize checks ManageUsers once for all the fields of the user
+func (u *userResolver) authorize(ctx context.Context) error {
+	u.once.Do(func() {
+		collection, _ := cedar.Collection(nil)
+		u.denied = u.h.authorizeGraphQL(ctx, "ManageUsers", collection.ID)
+	})
+	return u.denied
+}
+
+func (u *userResolver) ID() graphql.ID { return graphql.ID(u.user.ID) }
+
+func (u *userResolver) Name() *string {
+	if !u.recorded {
+		return nil
+	}
+	return &u.user.Name
+}
+
+func (u *userResolver) Role(ctx context.Context) (*string, error) {
+	if err := u.authorize(ctx); err != nil || !u.recorded {
+		return nil, err
+	}
+	return &u.user.Role, nil
+}
+
+func (u *userResolver) Groups(ctx context.Context) (*[]string, error) {
+	if err := u.authorize(ctx); err != nil || !u.recorded {
+		return nil, err
+	}
+	return &u.user.Groups, nil
+}
+
+func (u *userResolver) Department(ctx context.Context) (*string, error) {
+	if err := u.authorize(ctx); err != nil || !u.recorded {
+		return nil, err
+	}
+	return &u.user.Department, nil
+}
+
+func (u *userResolver) ClearanceLevel(ctx context.Context) (*int32, error) {
+	if err := u.authorize(ctx); err != nil || !u.recorded {
+		return nil, err
+	}
+	level := int32(u.user.ClearanceLevel)
+	return &level, nil
+}
+
+// documentGroupResolver resolves a document group
+type documentGroupResolver struct {
+	g models.DocumentGroup
+}
+
+func (g *documentGroupResolver) ID() graphql.ID { return graphql.ID(g.g.ID) }
+func (g *documentGroupResolver) Name() string   { return g.g.Name }
+
+// documentVersionResolver resolves an earlier version of a document
+type documentVersionResolver struct {
+	v models.DocumentVersion
+}
+
+func (v *documentVersionResolver) Version() int32          { return int32(v.v.Version) }
+func (v *documentVersionResolver) Title() string           { return v.v.Title }
+func (v *documentVersionResolver) Content() string         { return v.v.Content }
+func (v *documentVersionResolver) Classification() string  { return v.v.Classification }
+func (v *documentVersionResolver) Tags() []string          { return v.v.Tags }
+func (v *documentVersionResolver) ReplacedBy() string      { return v.v.ReplacedBy }
+func (v *documentVersionResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: v.v.UpdatedAt} }
+func (v *documentVersionResolver) ReplacedAt() graphql.Time {
+	return graphql.Time{Time: v.v.ReplacedAt}
+}
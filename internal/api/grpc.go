@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/api/documentspb"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/iputil"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer serves the documents gRPC API, sharing the database, storage,
+// and authorizer of the handler it was created from. Callers are
+// identified by metadata named like the HTTP user headers.
+type GRPCServer struct {
+	documentspb.UnimplementedDocumentServiceServer
+	h *Handler
+}
+
+// NewGRPCServer creates the gRPC API of h
+func NewGRPCServer(h *Handler) *GRPCServer {
+	return &GRPCServer{h: h}
+}
+
+// GetDocument returns a document
+func (s *GRPCServer) GetDocument(ctx context.Context, req *documentspb.GetDocumentRequest) (*documentspb.Document, error) {
+	if _, err := s.authorize(ctx, "GetDocument", req.GetId()); err != nil {
+		return nil, err
+	}
+	doc, err := s.h.fetchDocument(ctx, req.GetId())
+	if err != nil {
+		return nil, documentStatus(err)
+	}
+	return documentProto(doc), nil
+}
+
+// CreateDocument creates a document owned by the caller
+func (s *GRPCServer) CreateDocument(ctx context.Context, req *documentspb.CreateDocumentRequest) (*documentspb.Document, error) {
+	collection, _ := cedar.Collection(nil)
+	authz, err := s.authorize(ctx, "CreateDocument", collection.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.h.clock.Now()
+	doc, err := s.h.newDocument(models.DocumentInput{
+		Title:          req.GetTitle(),
+		Content:        req.GetContent(),
+		Classification: req.GetClassification(),
+		Tags:           req.GetTags(),
+	}, authz.UserID, now)
+	if err != nil {
+		return nil, documentStatus(err)
+	}
+	stored, err := s.h.insertDocument(ctx, s.h.db, doc)
+	if isUniqueViolation(err) {
+		return nil, status.Error(codes.AlreadyExists, "Document ID is already taken, try again")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Database error: %v", err)
+	}
+	s.h.recordUsage(doc.OwnerID, 1, stored.bytes(doc.Content))
+	s.h.publishDocumentEvent(webhook.DocumentCreated, doc.ID, doc.OwnerID)
+	return documentProto(doc), nil
+}
+
+// UpdateDocument changes the fields of a document named by the update
+// mask, or the fields PUT replaces without one
+func (s *GRPCServer) UpdateDocument(ctx context.Context, req *documentspb.UpdateDocumentRequest) (*documentspb.Document, error) {
+	in := req.GetDocument()
+	authz, err := s.authorize(ctx, "UpdateDocument", in.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if in.GetEtag() == "" {
+		return nil, status.Error(codes.FailedPrecondition, "etag is required: send the etag of the document as you last read it")
+	}
+
+	paths := req.GetUpdateMask().GetPaths()
+	replacesContent := len(paths) == 0
+	for _, path := range paths {
+		switch path {
+		case "content":
+			replacesContent = true
+		case "title", "classification", "tags":
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown field: %s", path)
+		}
+	}
+	authorize := func(action string) error {
+		_, err := s.authorize(ctx, action, in.GetId())
+		return err
+	}
+	doc, err := s.h.modifyDocument(ctx, in.GetId(), authz.UserID, in.GetEtag(), replacesContent, authorize, func(doc *models.Document) error {
+		if len(paths) == 0 {
+			doc.Title = in.GetTitle()
+			doc.Content = in.GetContent()
+			if in.GetClassification() != "" {
+				doc.Classification = in.GetClassification()
+			}
+			if in.GetTags() != nil {
+				doc.Tags = in.GetTags()
+			}
+		}
+		for _, path := range paths {
+			switch path {
+			case "title":
+				doc.Title = in.GetTitle()
+			case "content":
+				doc.Content = in.GetContent()
+			case "classification":
+				doc.Classification = in.GetClassification()
+			case "tags":
+				doc.Tags = append([]string{}, in.GetTags()...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, documentStatus(err)
+	}
+	authz.Action = "GetDocument"
+	if err := s.h.hideUnreadableContent(ctx, authz, &doc); err != nil {
+		return nil, authorizationStatus(err)
+	}
+	return documentProto(doc), nil
+}
+
+// DeleteDocument moves a document to the trash
+func (s *GRPCServer) DeleteDocument(ctx context.Context, req *documentspb.DeleteDocumentRequest) (*documentspb.DeleteDocumentResponse, error) {
+	authz, err := s.authorize(ctx, "DeleteDocument", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetEtag() == "" {
+		return nil, status.Error(codes.FailedPrecondition, "etag is required: send the etag of the document as you last read it")
+	}
+
+	doc, err := s.h.trashDocument(ctx, req.GetId(), req.GetEtag())
+	if err == sql.ErrNoRows {
+		return &documentspb.DeleteDocumentResponse{}, nil
+	}
+	if err != nil {
+		return nil, documentStatus(err)
+	}
+	log.Printf("Deleted document %s owned by %s over gRPC: user=%s", doc.ID, doc.OwnerID, authz.UserID)
+	return &documentspb.DeleteDocumentResponse{}, nil
+}
+
+// Authorize decides a request for an explicitly described principal, if
+// the caller may check authorization for others
+func (s *GRPCServer) Authorize(ctx context.Context, req *documentspb.AuthorizeRequest) (*documentspb.AuthorizeResponse, error) {
+	collection, _ := cedar.Collection(nil)
+	if _, err := s.authorize(ctx, "CheckAuthorization", collection.ID); err != nil {
+		return nil, err
+	}
+	principal := models.AuthzPrincipal{
+		ID:      req.GetPrincipal().GetId(),
+		Role:    req.GetPrincipal().GetRole(),
+		GroupID: req.GetPrincipal().GetGroupId(),
+	}
+	authz, err := checkRequest(principal, req.GetAction(), req.GetResourceId(), req.GetContext().AsMap())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	decision, err := s.h.authorizer.Authorize(ctx, authz)
+	if err != nil {
+		return nil, authorizationStatus(err)
+	}
+	return &documentspb.AuthorizeResponse{
+		Decision: decisionName(decision),
+		Allowed:  decision.Allowed,
+		Code:     decision.Code,
+		Reason:   decision.Reason,
+		Reasons:  decision.MatchedPolicies,
+	}, nil
+}
+
+// authorize checks that the caller may perform action on the resource,
+// like the Require middleware does for HTTP requests, and returns the
+// request it was authorized with
+func (s *GRPCServer) authorize(ctx context.Context, action, resourceID string) (cedar.AuthzRequest, error) {
+	req, err := s.h.requestFromGRPC(ctx, action, resourceID)
+	if err != nil {
+		return cedar.AuthzRequest{}, err
+	}
+	decision, err := s.h.authorizer.Authorize(ctx, req)
+	if err != nil {
+		return cedar.AuthzRequest{}, authorizationStatus(err)
+	}
+	if !decision.Allowed {
+		log.Printf("Access denied over gRPC: %s %s user=%s code=%s policies=%v", action, resourceID, req.UserID, decision.Code, decision.MatchedPolicies)
+		return cedar.AuthzRequest{}, status.Error(codes.PermissionDenied, decision.DenyMessage())
+	}
+	return req, nil
+}
+
+// requestFromGRPC builds the authorization request of a gRPC call from
+// its metadata and peer, as cedar.RequestFromHTTP does from the headers
+// of an HTTP request, resolving recorded users as ResolveUser does.
+// Context attributes derived from the HTTP request, such as the request
+// method, the request rate, and break-glass tokens, are not available.
+func (h *Handler) requestFromGRPC(ctx context.Context, action, resourceID string) (cedar.AuthzRequest, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: http.Header{}}
+	for k, values := range md {
+		for _, v := range values {
+			r.Header.Add(k, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	err := h.resolveUser(ctx, r.Header)
+	var notMember notGroupMemberError
+	if errors.As(err, &notMember) {
+		return cedar.AuthzRequest{}, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return cedar.AuthzRequest{}, status.Errorf(codes.Internal, "Failed to load user: %v", err)
+	}
+
+	ipInfo := iputil.GetIPInfo(r)
+	req := cedar.AuthzRequest{
+		UserID:      r.Header.Get("X-User-ID"),
+		UserRole:    r.Header.Get("X-User-Role"),
+		UserGroupID: r.Header.Get("X-User-Group-ID"),
+		Action:      action,
+		ResourceID:  resourceID,
+		IPAddress:   ipInfo.IPAddress,
+		IsPrivateIP: ipInfo.IsPrivateIP,
+		IsJapanIP:   ipInfo.IsJapanIP,
+		MFAVerified: cedar.MFAVerifiedFromHTTP(r),
+		Unknown:     cedar.UnknownIPAttributes(ipInfo),
+	}
+	if req.UserID == "" || req.UserRole == "" {
+		return cedar.AuthzRequest{}, status.Error(codes.Unauthenticated, "Missing user metadata")
+	}
+	return req, nil
+}
+
+// authorizationStatus converts a failed authorization check to a gRPC
+// status
+func authorizationStatus(err error) error {
+	switch {
+	case errors.Is(err, cedar.ErrInvalidRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, cedar.ErrResourceNotFound):
+		return status.Error(codes.NotFound, "Document not found")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.Unavailable, "Authorization timed out")
+	default:
+		return status.Errorf(codes.Internal, "Authorization error: %v", err)
+	}
+}
+
+// documentStatus converts an error reading or changing a document to a
+// gRPC status, keeping errors that are statuses already, such as denials
+func documentStatus(err error) error {
+	var invalid invalidChangeError
+	var locked documentLockedError
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		return status.Error(codes.NotFound, "Document not found")
+	case errors.Is(err, errETagMismatch):
+		return status.Error(codes.Aborted, "The document has changed since it was read")
+	case errors.As(err, &locked):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case fieldErrors(err) != nil:
+		return invalidArgumentStatus(err)
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// invalidArgumentStatus converts a validationError to a gRPC status
+// carrying the field errors as BadRequest details
+func invalidArgumentStatus(err error) error {
+	violations := []*errdetails.BadRequest_FieldViolation{}
+	for _, fe := range fieldErrors(err) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       fe.Field,
+			Description: fe.Message,
+			Reason:      fe.Code,
+		})
+	}
+	st, detailErr := status.New(codes.InvalidArgument, err.Error()).WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if detailErr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return st.Err()
+}
+
+// documentProto converts a document to its gRPC message
+func documentProto(doc models.Document) *documentspb.Document {
+	return &documentspb.Document{
+		Id:              doc.ID,
+		Title:           doc.Title,
+		Content:         doc.Content,
+		OwnerId:         doc.OwnerID,
+		DocumentGroupId: doc.DocumentGroupID.String,
+		Classification:  doc.Classification,
+		Tags:            doc.Tags,
+		CreatedAt:       timestamppb.New(doc.CreatedAt),
+		UpdatedAt:       timestamppb.New(doc.UpdatedAt),
+		Etag:            documentETag(doc),
+	}
+}
+
+// LogRPC is a gRPC interceptor that logs each call with its outcome and
+// duration
+func LogRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	log.Printf("gRPC %s %s in %s", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
@@ -1,60 +1,142 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"reflect"
 	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/graph-gophers/graphql-go"
 	"github.com/ksakiyama/study-cedar/internal/cedar"
-	"github.com/ksakiyama/study-cedar/internal/iputil"
+	"github.com/ksakiyama/study-cedar/internal/clock"
 	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/storage"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+	"github.com/lib/pq"
 )
 
+// Authorizer decides whether requests are permitted. It is implemented by
+// *cedar.Authorizer regardless of where policies are evaluated, and by
+// cedartest.MockAuthorizer for handler tests.
+type Authorizer interface {
+	Authorize(ctx context.Context, req cedar.AuthzRequest) (cedar.AuthzDecision, error)
+	AuthorizeBatch(ctx context.Context, reqs []cedar.AuthzRequest) []cedar.BatchResult
+	ResourceFilter(ctx context.Context, req cedar.AuthzRequest) (cedar.Filter, error)
+}
+
+var _ Authorizer = (*cedar.Authorizer)(nil)
+
 // Handler contains dependencies for API handlers
 type Handler struct {
-	db            *sql.DB
-	authorizer    *cedar.Authorizer
+	db             *sql.DB
+	authorizer     Authorizer
+	policies       PolicyManager
+	audit          AuditQuerier
+	pseudonymizer  AuditPseudonymizer
+	simulator      Simulator
+	reviewer       AccessReviewer
+	directory      PrincipalDirectory
+	breakGlass     BreakGlassGranter
+	sharer         DocumentSharer
+	reloader       PolicyReloader
+	newDocuments   NewDocumentAuthorizer
+	usage          UsageRecorder
+	events         EventPublisher
+	documentEvents *DocumentEvents
+	attachments    *AttachmentConfig
+	storage        storage.Backend
+	// contentThreshold is the size above which document content is kept
+	// in storage
+	contentThreshold int64
+	// lockTTL is how long a document stays checked out; 0 disables locks
+	lockTTL time.Duration
+	// titleLimit and contentLimit are the longest title, in characters,
+	// and content, in bytes, a document may have
+	titleLimit   int
+	contentLimit int
+	sanitizer    ContentSanitizer
+	rendered     *renderCache
+	// shareLinkSecret signs share link tokens; nil disables share links
+	shareLinkSecret []byte
+	shareLinkMaxTTL time.Duration
+	recentViews     *RecentViews
+	// retentionGrace is how long expired documents stay in the trash
+	retentionGrace time.Duration
+	openAPI        []byte
+	graphQL        *graphql.Schema
+	// listEncoders encode list responses in media types other than JSON
+	listEncoders   map[string]ListEncoder
+	clock          clock.Clock
 	isShuttingDown atomic.Bool
 }
 
-// NewHandler creates a new API handler
-func NewHandler(db *sql.DB, authorizer *cedar.Authorizer) *Handler {
-	return &Handler{
-		db:         db,
-		authorizer: authorizer,
-	}
+// NewHandler creates a new API handler. Policy management, simulation,
+// access review, sharing, and document templates are available when the
+// authorizer also implements PolicyManager, Simulator, AccessReviewer,
+// DocumentSharer, and NewDocumentAuthorizer.
+func NewHandler(db *sql.DB, authorizer Authorizer, clk clock.Clock) *Handler {
+	h := &Handler{
+		db:             db,
+		authorizer:     authorizer,
+		titleLimit:     maxTitleLength,
+		contentLimit:   maxContentLength,
+		rendered:       newRenderCache(defaultRenderCacheSize),
+		retentionGrace: defaultRetentionGrace,
+		listEncoders:   defaultListEncoders(),
+		clock:          clk,
+	}
+	h.graphQL = graphql.MustParseSchema(graphQLSchema, &graphQLResolver{h: h}, graphql.MaxDepth(maxGraphQLDepth))
+	if pm, ok := authorizer.(PolicyManager); ok {
+		h.policies = pm
+	}
+	if s, ok := authorizer.(Simulator); ok {
+		h.simulator = s
+	}
+	if ar, ok := authorizer.(AccessReviewer); ok {
+		h.reviewer = ar
+	}
+	if ds, ok := authorizer.(DocumentSharer); ok {
+		h.sharer = ds
+	}
+	if pr, ok := authorizer.(PolicyReloader); ok {
+		h.reloader = pr
+	}
+	if na, ok := authorizer.(NewDocumentAuthorizer); ok {
+		h.newDocuments = na
+	}
+	return h
 }
 
-// SetShuttingDown sets the shutting down state
-func (h *Handler) SetShuttingDown(shuttingDown bool) {
-	h.isShuttingDown.Store(shuttingDown)
+// UsageRecorder is told how document changes affect their owner's usage.
+// It is implemented by *cedar.DocumentUsage.
+type UsageRecorder interface {
+	Add(userID string, documents, bytes int64)
 }
 
-// checkGroupAccess checks if a user group has access to a document group
-func (h *Handler) checkGroupAccess(userGroupID, documentGroupID string) bool {
-	// If either group ID is empty, no group access
-	if userGroupID == "" || documentGroupID == "" {
-		return false
-	}
-
-	// Check if association exists
-	var exists bool
-	err := h.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM group_associations
-			WHERE user_group_id = $1 AND document_group_id = $2
-		)
-	`, userGroupID, documentGroupID).Scan(&exists)
+// SetDocumentUsage keeps usage up to date as documents change
+func (h *Handler) SetDocumentUsage(usage UsageRecorder) {
+	h.usage = usage
+}
 
-	if err != nil {
-		return false
+// recordUsage adjusts the owner's usage if it is tracked
+func (h *Handler) recordUsage(userID string, documents, bytes int64) {
+	if h.usage != nil {
+		h.usage.Add(userID, documents, bytes)
 	}
+}
 
-	return exists
+// SetShuttingDown sets the shutting down state
+func (h *Handler) SetShuttingDown(shuttingDown bool) {
+	h.isShuttingDown.Store(shuttingDown)
 }
 
 // HealthCheck handles health check requests
@@ -74,187 +156,339 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, response)
 }
 
-// ListDocuments handles document listing
+// ListDocuments handles document listing in the requested order,
+// optionally a page at a time and with facet counts. The caller has been
+// authorized for ListDocuments on the collection by the route middleware.
 func (h *Handler) ListDocuments(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	userRole := r.Header.Get("X-User-Role")
-	userGroupID := r.Header.Get("X-User-Group-ID")
+	h.listDocuments(w, r, "ListDocuments", false)
+}
 
-	if userID == "" || userRole == "" {
-		respondError(w, http.StatusBadRequest, "Missing user headers")
+// ListMyDocuments handles listing the documents owned by the caller,
+// whichever groups they are in, in the requested order and optionally a
+// page at a time. The caller has been authorized for ListDocuments on the
+// collection by the route middleware, and only the documents the policies
+// let them list are shown, as in the full list.
+func (h *Handler) ListMyDocuments(w http.ResponseWriter, r *http.Request) {
+	page, err := parseDocumentPage(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	narrow, err := parseDocumentFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	narrow.ownerID = r.Header.Get("X-User-ID")
 
-	// Get IP address information
-	ipInfo := iputil.GetIPInfo(r)
-
-	// Check basic authorization (for listing, we set has_group_access to true for role-based check)
-	authorized, err := h.authorizer.Authorize(cedar.AuthzRequest{
-		UserID:         userID,
-		UserRole:       userRole,
-		Action:         "ListDocuments",
-		ResourceID:     "documents",
-		IPAddress:      ipInfo.IPAddress,
-		IsPrivateIP:    ipInfo.IsPrivateIP,
-		IsJapanIP:      ipInfo.IsJapanIP,
-		HasGroupAccess: true, // For list operation, check role only
-	})
+	documents, err := h.findDocuments(r, "ListDocuments", narrow, page)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
+		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.respondDocuments(w, r, page, page.response(documents))
+}
 
-	if !authorized {
-		respondError(w, http.StatusForbidden, "Access denied: Geographic restriction or insufficient permissions")
-		return
-	}
-
-	// Fetch documents from database with group filtering
-	var rows *sql.Rows
-	if userRole == "admin" {
-		// Admins can see all documents
-		rows, err = h.db.Query(`
-			SELECT id, title, content, owner_id, document_group_id, created_at, updated_at
-			FROM documents
-			ORDER BY created_at DESC
-		`)
-	} else if userGroupID != "" {
-		// Users with group: only show documents from associated groups
-		rows, err = h.db.Query(`
-			SELECT d.id, d.title, d.content, d.owner_id, d.document_group_id, d.created_at, d.updated_at
-			FROM documents d
-			LEFT JOIN group_associations ga ON d.document_group_id = ga.document_group_id
-			WHERE ga.user_group_id = $1 OR d.document_group_id IS NULL
-			ORDER BY d.created_at DESC
-		`, userGroupID)
-	} else {
-		// Users without group: only show documents without group
-		rows, err = h.db.Query(`
-			SELECT id, title, content, owner_id, document_group_id, created_at, updated_at
-			FROM documents
-			WHERE document_group_id IS NULL
-			ORDER BY created_at DESC
-		`)
+// listDocuments lists the documents, in the trash if deleted is set,
+// for which the caller is allowed action, with the counts of the facets
+// asked for
+func (h *Handler) listDocuments(w http.ResponseWriter, r *http.Request, action string, deleted bool) {
+	page, err := parseDocumentPage(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	narrow, err := parseDocumentFilter(r.URL.Query())
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	narrow.deleted = deleted
+	facets, err := parseFacets(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	documents, err := h.findDocuments(r, action, narrow, page)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	response := page.response(documents)
+	if facets != nil {
+		response.Facets, err = h.countFacets(r, action, narrow, facets)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	h.respondDocuments(w, r, page, response)
+}
+
+// findDocuments returns the documents matching narrow for which the
+// caller of r is allowed action, in page order and up to one more than
+// the page holds
+func (h *Handler) findDocuments(r *http.Request, action string, narrow documentFilter, page documentPage) ([]models.Document, error) {
+	// Ask the policies which documents the caller may list and filter in SQL
+	filter, err := h.authorizer.ResourceFilter(r.Context(), cedar.RequestFromHTTP(r, action, ""))
+	var where string
+	var args []any
+	if err == nil {
+		where, args, err = cedar.DocumentSQL(filter, nil)
+	}
+	if errors.Is(err, cedar.ErrUnsupportedFilter) {
+		// Policies that cannot be expressed in SQL are checked per document
+		log.Printf("Falling back to per-document list filtering: %v", err)
+		documents, err := h.listAuthorizedDocuments(r, action, narrow, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list documents: %w", err)
+		}
+		return documents, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter documents: %w", err)
+	}
+
+	// Fetch documents from database with policy filtering
+	where, args = narrow.sql(where, args)
+	query, args := page.query(where, args, false)
+	return h.queryDocuments(r.Context(), query, args)
+}
+
+// queryDocuments returns the documents selected by a page query, with
+// their content
+func (h *Handler) queryDocuments(ctx context.Context, query string, args []any) ([]models.Document, error) {
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
 	defer rows.Close()
 
 	documents := []models.Document{}
 	for rows.Next() {
 		var doc models.Document
-		if err := rows.Scan(&doc.ID, &doc.Title, &doc.Content, &doc.OwnerID, &doc.DocumentGroupID, &doc.CreatedAt, &doc.UpdatedAt); err != nil {
-			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
-			return
+		var stored storedContent
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.Content, &stored.key, &stored.size, &doc.OwnerID, &doc.DocumentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.Metadata, &doc.CreatedAt, &doc.UpdatedAt, &doc.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		if err := h.loadContent(ctx, &doc.Content, stored); err != nil {
+			return nil, err
 		}
 		documents = append(documents, doc)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	return documents, nil
+}
 
-	response := models.DocumentsResponse{
-		Documents: documents,
+// listAuthorizedDocuments loads the documents matching narrow after the
+// page's cursor and keeps those the caller is allowed action on, checking
+// each one against the policies, past the page's offset and up to one more
+// than the page holds
+func (h *Handler) listAuthorizedDocuments(r *http.Request, action string, narrow documentFilter, page documentPage) ([]models.Document, error) {
+	where, args := narrow.sql("TRUE", nil)
+	query, args := page.query(where, args, true)
+	rows, err := h.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
 	}
-	respondJSON(w, http.StatusOK, response)
+	defer rows.Close()
+
+	var all []models.Document
+	var allStored []storedContent
+	for rows.Next() {
+		var doc models.Document
+		var stored storedContent
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.Content, &stored.key, &stored.size, &doc.OwnerID, &doc.DocumentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.Metadata, &doc.CreatedAt, &doc.UpdatedAt, &doc.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		all = append(all, doc)
+		allStored = append(allStored, stored)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+
+	documents := []models.Document{}
+	skip := page.offset
+	for i, doc := range all {
+		if page.limit > 0 && len(documents) > page.limit {
+			break
+		}
+		decision, err := h.authorizer.Authorize(r.Context(), cedar.RequestFromHTTP(r, action, doc.ID))
+		if errors.Is(err, cedar.ErrResourceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to authorize document %s: %w", doc.ID, err)
+		}
+		if decision.Allowed && skip > 0 {
+			skip--
+			continue
+		}
+		if decision.Allowed {
+			// Only the content of documents in the page is loaded
+			if err := h.loadContent(r.Context(), &doc.Content, allStored[i]); err != nil {
+				return nil, err
+			}
+			documents = append(documents, doc)
+		}
+	}
+	return documents, nil
 }
 
-// GetDocument handles fetching a single document
+// GetDocument handles fetching a single document, with only the fields
+// asked for if any. A request whose If-None-Match or If-Modified-Since
+// still holds is answered with 304 before the content is loaded, and is
+// not recorded as a view.
 func (h *Handler) GetDocument(w http.ResponseWriter, r *http.Request) {
 	documentID := chi.URLParam(r, "documentId")
-	userID := r.Header.Get("X-User-ID")
-	userRole := r.Header.Get("X-User-Role")
-	userGroupID := r.Header.Get("X-User-Group-ID")
-
-	if userID == "" || userRole == "" {
-		respondError(w, http.StatusBadRequest, "Missing user headers")
+	fields, err := parseDocumentFields(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if isConditionalGet(r) {
+		rev, err := h.documentRevision(r.Context(), documentID)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Document not found")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if notModified(r, rev) {
+			respondNotModified(w, rev)
+			return
+		}
+	}
 
-	// Fetch document to get owner and group
-	var doc models.Document
-	err := h.db.QueryRow(`
-		SELECT id, title, content, owner_id, document_group_id, created_at, updated_at
-		FROM documents
-		WHERE id = $1
-	`, documentID).Scan(&doc.ID, &doc.Title, &doc.Content, &doc.OwnerID, &doc.DocumentGroupID, &doc.CreatedAt, &doc.UpdatedAt)
-
+	doc, err := h.fetchDocument(r.Context(), documentID)
 	if err == sql.ErrNoRows {
 		respondError(w, http.StatusNotFound, "Document not found")
 		return
 	}
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Check group access
-	hasGroupAccess := false
-	if doc.DocumentGroupID.Valid {
-		hasGroupAccess = h.checkGroupAccess(userGroupID, doc.DocumentGroupID.String)
-	} else {
-		// Document has no group, allow access
-		hasGroupAccess = true
-	}
-
-	// Get IP address information
-	ipInfo := iputil.GetIPInfo(r)
+	h.recordView(r, doc.ID)
+	setDocumentValidators(w, doc)
+	respondJSON(w, http.StatusOK, fields.project(doc))
+}
 
-	// Check authorization
-	authorized, err := h.authorizer.Authorize(cedar.AuthzRequest{
-		UserID:          userID,
-		UserRole:        userRole,
-		Action:          "GetDocument",
-		ResourceID:      documentID,
-		ResourceOwnerID: doc.OwnerID,
-		IPAddress:       ipInfo.IPAddress,
-		IsPrivateIP:     ipInfo.IsPrivateIP,
-		IsJapanIP:       ipInfo.IsJapanIP,
-		HasGroupAccess:  hasGroupAccess,
-	})
+// HeadDocument handles checking that a document exists, answering with
+// the headers GetDocument would but without the document, whose content
+// is not loaded. Unlike GetDocument, it does not record a view. The
+// caller has been authorized for GetDocument by the route middleware.
+func (h *Handler) HeadDocument(w http.ResponseWriter, r *http.Request) {
+	rev, err := h.documentRevision(r.Context(), chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	if !authorized {
-		respondError(w, http.StatusForbidden, "Access denied: Geographic restriction or insufficient permissions")
+	if notModified(r, rev) {
+		respondNotModified(w, rev)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, doc)
+	setDocumentValidators(w, rev)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 }
 
-// CreateDocument handles document creation
-func (h *Handler) CreateDocument(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	userRole := r.Header.Get("X-User-Role")
+// documentRevision returns the document documentID with only its ID and
+// update time, enough for its ETag and Last-Modified, or sql.ErrNoRows if
+// there is none outside the trash
+func (h *Handler) documentRevision(ctx context.Context, documentID string) (models.Document, error) {
+	doc := models.Document{ID: documentID}
+	err := h.db.QueryRowContext(ctx, `
+		SELECT updated_at FROM documents WHERE id = $1 AND deleted_at IS NULL
+	`, documentID).Scan(&doc.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.Document{}, err
+	}
+	if err != nil {
+		return models.Document{}, fmt.Errorf("failed to fetch document: %w", err)
+	}
+	return doc, nil
+}
 
-	if userID == "" || userRole == "" {
-		respondError(w, http.StatusBadRequest, "Missing user headers")
-		return
+// fetchDocument returns the document documentID with its content, or
+// sql.ErrNoRows if there is none outside the trash
+func (h *Handler) fetchDocument(ctx context.Context, documentID string) (models.Document, error) {
+	var doc models.Document
+	var stored storedContent
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, title, content, content_key, content_size, owner_id, document_group_id, classification, tags, metadata, created_at, updated_at
+		FROM documents
+		WHERE id = $1 AND deleted_at IS NULL
+	`, documentID).Scan(&doc.ID, &doc.Title, &doc.Content, &stored.key, &stored.size, &doc.OwnerID, &doc.DocumentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.Metadata, &doc.CreatedAt, &doc.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.Document{}, err
 	}
+	if err != nil {
+		return models.Document{}, fmt.Errorf("failed to fetch document: %w", err)
+	}
+	if err := h.loadContent(ctx, &doc.Content, stored); err != nil {
+		return models.Document{}, err
+	}
+	return doc, nil
+}
 
-	// Get IP address information
-	ipInfo := iputil.GetIPInfo(r)
+// GetCapabilities handles listing what the caller may do with a document,
+// so clients can decide which controls to show. The caller has been
+// authorized for GetDocument by the route middleware.
+func (h *Handler) GetCapabilities(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentId")
 
-	// Check authorization (for creation, use role-based access only)
-	authorized, err := h.authorizer.Authorize(cedar.AuthzRequest{
-		UserID:         userID,
-		UserRole:       userRole,
-		Action:         "CreateDocument",
-		ResourceID:     "documents",
-		IPAddress:      ipInfo.IPAddress,
-		IsPrivateIP:    ipInfo.IsPrivateIP,
-		IsJapanIP:      ipInfo.IsJapanIP,
-		HasGroupAccess: true, // For creation, check role only
-	})
+	actions, err := cedar.DocumentActions()
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
 		return
 	}
+	reqs := make([]cedar.AuthzRequest, len(actions))
+	for i, action := range actions {
+		reqs[i] = cedar.RequestFromHTTP(r, action, documentID)
+	}
+
+	response := models.CapabilitiesResponse{
+		DocumentID: documentID,
+		Allowed:    []string{},
+		Denied:     []string{},
+	}
+	for i, res := range h.authorizer.AuthorizeBatch(r.Context(), reqs) {
+		if res.Err != nil {
+			respondCheckError(w, res.Err)
+			return
+		}
+		if res.Decision.Allowed {
+			response.Allowed = append(response.Allowed, actions[i])
+		} else {
+			response.Denied = append(response.Denied, actions[i])
+		}
+	}
+	respondJSON(w, http.StatusOK, response)
+}
 
-	if !authorized {
-		respondError(w, http.StatusForbidden, "Access denied: Geographic restriction or insufficient permissions")
+// CreateDocument handles document creation. With an Idempotency-Key
+// header the document is created once per key, and retries with the key
+// get the response to the first request. With a template_id query
+// parameter the document is pre-filled from the template and created in
+// its document group, which the caller must be allowed to create in.
+func (h *Handler) CreateDocument(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key is too long: maximum is %d characters", maxIdempotencyKeyLength))
 		return
 	}
 
@@ -264,92 +498,159 @@ func (h *Handler) CreateDocument(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	groupID, ok := h.applyDocumentTemplate(w, r, &input)
+	if !ok {
+		return
+	}
 
 	// Create document
-	doc := models.Document{
-		ID:        fmt.Sprintf("doc-%d", time.Now().Unix()),
-		Title:     input.Title,
-		Content:   input.Content,
-		OwnerID:   userID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	now := h.clock.Now()
+	doc, err := h.newDocument(input, userID, now)
+	if fieldErrors(err) != nil {
+		respondValidationError(w, err)
+		return
+	} else if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-
-	_, err = h.db.Exec(`
-		INSERT INTO documents (id, title, content, owner_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, doc.ID, doc.Title, doc.Content, doc.OwnerID, doc.CreatedAt, doc.UpdatedAt)
-
-	if err != nil {
+	doc.DocumentGroupID = groupID
+	if err := checkGroupMetadata(r.Context(), h.db, doc); fieldErrors(err) != nil {
+		respondValidationError(w, err)
+		return
+	} else if err != nil {
 		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
 		return
 	}
+	if groupID.Valid && !h.authorizeNewDocument(w, r, doc) {
+		return
+	}
 
-	respondJSON(w, http.StatusCreated, doc)
-}
-
-// UpdateDocument handles document updates
-func (h *Handler) UpdateDocument(w http.ResponseWriter, r *http.Request) {
-	documentID := chi.URLParam(r, "documentId")
-	userID := r.Header.Get("X-User-ID")
-	userRole := r.Header.Get("X-User-Role")
-	userGroupID := r.Header.Get("X-User-Group-ID")
-
-	if userID == "" || userRole == "" {
-		respondError(w, http.StatusBadRequest, "Missing user headers")
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
 		return
 	}
+	defer tx.Rollback()
 
-	// Fetch document to get owner and group
-	var doc models.Document
-	err := h.db.QueryRow(`
-		SELECT id, title, content, owner_id, document_group_id, created_at, updated_at
-		FROM documents
-		WHERE id = $1
-	`, documentID).Scan(&doc.ID, &doc.Title, &doc.Content, &doc.OwnerID, &doc.DocumentGroupID, &doc.CreatedAt, &doc.UpdatedAt)
+	if idempotencyKey != "" {
+		fingerprint, err := requestFingerprint(input)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		response, err := claimIdempotencyKey(r.Context(), tx, userID, idempotencyKey, fingerprint, now)
+		if errors.Is(err, errIdempotencyKeyReused) {
+			respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if response != nil {
+			var created models.Document
+			if err := json.Unmarshal(response, &created); err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Sprintf("Invalid stored response: %v", err))
+				return
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			setDocumentETag(w, created)
+			respondJSON(w, http.StatusCreated, created)
+			return
+		}
+	}
 
-	if err == sql.ErrNoRows {
-		respondError(w, http.StatusNotFound, "Document not found")
+	stored, err := h.insertDocument(r.Context(), tx, doc)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
 		return
 	}
+	if idempotencyKey != "" {
+		err = saveIdempotentResponse(r.Context(), tx, userID, idempotencyKey, doc)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
+		h.removeStoredContent(stored)
 		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
 		return
 	}
+	h.recordUsage(doc.OwnerID, 1, stored.bytes(doc.Content))
+	h.publishDocumentEvent(webhook.DocumentCreated, doc.ID, doc.OwnerID)
 
-	// Check group access
-	hasGroupAccess := false
-	if doc.DocumentGroupID.Valid {
-		hasGroupAccess = h.checkGroupAccess(userGroupID, doc.DocumentGroupID.String)
-	} else {
-		hasGroupAccess = true
+	setDocumentETag(w, doc)
+	respondJSON(w, http.StatusCreated, doc)
+}
+
+// newDocument validates input and returns the document it creates for
+// ownerID under a new random ID, or a validationError
+func (h *Handler) newDocument(input models.DocumentInput, ownerID string, now time.Time) (models.Document, error) {
+	if input.Classification == "" {
+		input.Classification = defaultClassification
+	}
+	if input.Tags == nil {
+		input.Tags = []string{}
+	}
+	if input.Metadata == nil {
+		input.Metadata = models.Metadata{}
+	}
+	doc := models.Document{
+		Title:          input.Title,
+		Content:        input.Content,
+		OwnerID:        ownerID,
+		Classification: input.Classification,
+		Tags:           input.Tags,
+		Metadata:       input.Metadata,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := h.checkDocument(&doc, true); err != nil {
+		return models.Document{}, err
+	}
+	id, err := newDocumentID()
+	if err != nil {
+		return models.Document{}, err
 	}
+	doc.ID = id
+	return doc, nil
+}
 
-	// Get IP address information
-	ipInfo := iputil.GetIPInfo(r)
+// newDocumentID returns a random document ID
+func newDocumentID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate document ID: %w", err)
+	}
+	return "doc-" + hex.EncodeToString(b), nil
+}
 
-	// Check authorization
-	authorized, err := h.authorizer.Authorize(cedar.AuthzRequest{
-		UserID:          userID,
-		UserRole:        userRole,
-		Action:          "UpdateDocument",
-		ResourceID:      documentID,
-		ResourceOwnerID: doc.OwnerID,
-		IPAddress:       ipInfo.IPAddress,
-		IsPrivateIP:     ipInfo.IsPrivateIP,
-		IsJapanIP:       ipInfo.IsJapanIP,
-		HasGroupAccess:  hasGroupAccess,
-	})
+// insertDocument writes a new document with db, a database or a
+// transaction, putting large content in storage. The stored content is
+// removed again if the insert fails, but not if a transaction is rolled
+// back later.
+func (h *Handler) insertDocument(ctx context.Context, db interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}, doc models.Document) (storedContent, error) {
+	content, stored, err := h.storeContent(ctx, doc.ID, doc.Content)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
-		return
+		return storedContent{}, err
 	}
-
-	if !authorized {
-		respondError(w, http.StatusForbidden, "Access denied: Geographic restriction or insufficient permissions")
-		return
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO documents (id, title, content, content_key, content_size, owner_id, document_group_id, classification, tags, metadata, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, doc.ID, doc.Title, content, stored.key, stored.size, doc.OwnerID, doc.DocumentGroupID, doc.Classification, pq.Array(doc.Tags), doc.Metadata, doc.CreatedAt, doc.UpdatedAt)
+	if err != nil {
+		h.removeStoredContent(stored)
+		return storedContent{}, err
 	}
+	return stored, nil
+}
 
+// UpdateDocument handles document updates. The document as it was before
+// is kept as a new version, and changing its classification also requires
+// ClassifyDocument.
+func (h *Handler) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var input models.DocumentInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -357,97 +658,283 @@ func (h *Handler) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update document
-	doc.Title = input.Title
-	doc.Content = input.Content
-	doc.UpdatedAt = time.Now()
+	h.updateDocument(w, r, true, func(doc *models.Document) error {
+		doc.Title = input.Title
+		doc.Content = input.Content
+		if input.Classification != "" {
+			doc.Classification = input.Classification
+		}
+		if input.Tags != nil {
+			doc.Tags = input.Tags
+		}
+		if input.Metadata != nil {
+			doc.Metadata = input.Metadata
+		}
+		return nil
+	})
+}
 
-	_, err = h.db.Exec(`
-		UPDATE documents
-		SET title = $1, content = $2, updated_at = $3
-		WHERE id = $4
-	`, doc.Title, doc.Content, doc.UpdatedAt, doc.ID)
+// updateDocument applies change to the document in the URL if the
+// request's If-Match holds, keeping the document as it was before as a new
+// version, and responds with the updated document, without its content
+// unless the caller may read it. Errors from change are answered with 400,
+// a classification change the caller may not make with 403, invalid fields
+// of the updated document with 422 or 413, and documents checked out by
+// someone else with 423. Unless replacesContent is set, change must leave
+// the content alone, since content kept in storage is not loaded for it.
+func (h *Handler) updateDocument(w http.ResponseWriter, r *http.Request, replacesContent bool, change func(*models.Document) error) {
+	if !requireIfMatch(w, r) {
+		return
+	}
 
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+	documentID := chi.URLParam(r, "documentId")
+	authorize := func(action string) error {
+		return h.authorizeDocument(r, action, documentID)
+	}
+	doc, err := h.modifyDocument(r.Context(), documentID, r.Header.Get("X-User-ID"), r.Header.Get("If-Match"), replacesContent, authorize, change)
+	var invalid invalidChangeError
+	var locked documentLockedError
+	var denied accessDeniedError
+	switch {
+	case err == sql.ErrNoRows:
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	case errors.Is(err, errETagMismatch):
+		respondETagMismatch(w, doc)
+		return
+	case errors.As(err, &denied):
+		respondDenied(w, denied.decision)
+		return
+	case errors.As(err, &locked):
+		respondDocumentLocked(w, locked)
+		return
+	case errors.As(err, &invalid):
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	case fieldErrors(err) != nil:
+		respondValidationError(w, err)
+		return
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if err := h.hideUnreadableContent(r.Context(), cedar.RequestFromHTTP(r, "GetDocument", doc.ID), &doc); err != nil {
+		respondCheckError(w, err)
+		return
+	}
+	setDocumentETag(w, doc)
 	respondJSON(w, http.StatusOK, doc)
 }
 
-// DeleteDocument handles document deletion
-func (h *Handler) DeleteDocument(w http.ResponseWriter, r *http.Request) {
-	documentID := chi.URLParam(r, "documentId")
-	userID := r.Header.Get("X-User-ID")
-	userRole := r.Header.Get("X-User-Role")
-	userGroupID := r.Header.Get("X-User-Group-ID")
+// authorizeDocument returns an accessDeniedError if the caller of r may not
+// perform action on the document documentID
+func (h *Handler) authorizeDocument(r *http.Request, action, documentID string) error {
+	decision, err := h.authorizer.Authorize(r.Context(), cedar.RequestFromHTTP(r, action, documentID))
+	if err != nil {
+		return fmt.Errorf("failed to authorize %s: %w", action, err)
+	}
+	if !decision.Allowed {
+		return accessDeniedError{decision}
+	}
+	return nil
+}
 
-	if userID == "" || userRole == "" {
-		respondError(w, http.StatusBadRequest, "Missing user headers")
-		return
+// hideUnreadableContent clears the content of doc unless req, for
+// GetDocument on it, is allowed, so that answering a write with the
+// document does not show the caller what they may not read
+func (h *Handler) hideUnreadableContent(ctx context.Context, req cedar.AuthzRequest, doc *models.Document) error {
+	decision, err := h.authorizer.Authorize(ctx, req)
+	if err != nil {
+		return err
 	}
+	if !decision.Allowed {
+		doc.Content = ""
+	}
+	return nil
+}
 
-	// Fetch document to get owner and group
-	var doc models.Document
-	err := h.db.QueryRow(`
-		SELECT id, owner_id, document_group_id
-		FROM documents
-		WHERE id = $1
-	`, documentID).Scan(&doc.ID, &doc.OwnerID, &doc.DocumentGroupID)
+// invalidChangeError is returned when a change cannot be applied to a
+// document
+type invalidChangeError struct {
+	err error
+}
+
+func (e invalidChangeError) Error() string {
+	return e.err.Error()
+}
+
+// modifyDocument applies change to the document documentID on behalf of
+// userID if etags, as in If-Match, holds its ETag, keeping the document as
+// it was before as a new version, and returns the updated document. If
+// change changes the classification, authorize must allow
+// ClassifyDocument, and its error is returned otherwise. It returns
+// sql.ErrNoRows if there is no such document, errETagMismatch with the
+// current document if it has changed, a documentLockedError if someone
+// else has checked it out, an invalidChangeError if change fails, and a
+// validationError if the changed document is invalid. Unless
+// replacesContent is set, change must leave the content alone, since
+// content kept in storage is not loaded for it.
+func (h *Handler) modifyDocument(ctx context.Context, documentID, userID, etags string, replacesContent bool, authorize func(action string) error, change func(*models.Document) error) (models.Document, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Document{}, fmt.Errorf("failed to update document: %w", err)
+	}
+	defer tx.Rollback()
 
+	// Fetch document
+	doc, stored, err := lockDocument(ctx, tx, documentID)
 	if err == sql.ErrNoRows {
-		respondError(w, http.StatusNotFound, "Document not found")
-		return
+		return models.Document{}, err
 	}
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
-		return
+		return models.Document{}, fmt.Errorf("failed to update document: %w", err)
 	}
-
-	// Check group access
-	hasGroupAccess := false
-	if doc.DocumentGroupID.Valid {
-		hasGroupAccess = h.checkGroupAccess(userGroupID, doc.DocumentGroupID.String)
-	} else {
-		hasGroupAccess = true
+	if !matchETag(etags, doc) {
+		return doc, errETagMismatch
+	}
+	if err := h.checkDocumentLock(ctx, tx, doc.ID, userID); err != nil {
+		return models.Document{}, err
 	}
 
-	// Get IP address information
-	ipInfo := iputil.GetIPInfo(r)
+	// Update document
+	if err := saveDocumentVersion(ctx, tx, doc, stored, userID, h.clock.Now()); err != nil {
+		return models.Document{}, err
+	}
+	oldSize := stored.bytes(doc.Content)
+	oldMetadata := doc.Metadata
+	oldClassification := doc.Classification
+	if err := change(&doc); err != nil {
+		return models.Document{}, invalidChangeError{err}
+	}
+	if err := h.checkDocument(&doc, replacesContent); err != nil {
+		return models.Document{}, err
+	}
+	if doc.Classification != oldClassification {
+		if err := authorize("ClassifyDocument"); err != nil {
+			return models.Document{}, err
+		}
+	}
+	// Metadata written before the group's schema changed is kept until it
+	// is changed itself
+	if !reflect.DeepEqual(doc.Metadata, oldMetadata) {
+		if err := checkGroupMetadata(ctx, tx, doc); err != nil {
+			return models.Document{}, err
+		}
+	}
+	doc.UpdatedAt = h.clock.Now()
 
-	// Check authorization
-	authorized, err := h.authorizer.Authorize(cedar.AuthzRequest{
-		UserID:          userID,
-		UserRole:        userRole,
-		Action:          "DeleteDocument",
-		ResourceID:      documentID,
-		ResourceOwnerID: doc.OwnerID,
-		IPAddress:       ipInfo.IPAddress,
-		IsPrivateIP:     ipInfo.IsPrivateIP,
-		IsJapanIP:       ipInfo.IsJapanIP,
-		HasGroupAccess:  hasGroupAccess,
-	})
+	content := doc.Content
+	if replacesContent {
+		content, stored, err = h.storeContent(ctx, doc.ID, doc.Content)
+		if err != nil {
+			return models.Document{}, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE documents
+		SET title = $1, content = $2, content_key = $3, content_size = $4, classification = $5, tags = $6, metadata = $7, updated_at = $8
+		WHERE id = $9
+	`, doc.Title, content, stored.key, stored.size, doc.Classification, pq.Array(doc.Tags), doc.Metadata, doc.UpdatedAt, doc.ID)
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
-		return
+		if replacesContent {
+			h.removeStoredContent(stored)
+		}
+		return models.Document{}, fmt.Errorf("failed to update document: %w", err)
+	}
+	h.recordUsage(doc.OwnerID, 0, stored.bytes(content)-oldSize)
+	h.publishDocumentEvent(webhook.DocumentUpdated, doc.ID, doc.OwnerID)
+	if !replacesContent {
+		if err := h.loadContent(ctx, &doc.Content, stored); err != nil {
+			return models.Document{}, err
+		}
 	}
+	return doc, nil
+}
+
+// defaultClassification is given to documents created without one
+const defaultClassification = "internal"
 
-	if !authorized {
-		respondError(w, http.StatusForbidden, "Access denied: Geographic restriction or insufficient permissions")
+// validClassification reports whether c is a known document classification
+func validClassification(c string) bool {
+	switch c {
+	case "public", "internal", "confidential":
+		return true
+	}
+	return false
+}
+
+// DeleteDocument handles document deletion by moving the document to the
+// trash, from which it can be restored until it is purged. The request's
+// If-Match must hold. A document that does not exist has already been
+// answered with 404 by the route middleware; deleting one that is already
+// in the trash succeeds.
+func (h *Handler) DeleteDocument(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentId")
+	if !requireIfMatch(w, r) {
 		return
 	}
 
-	// Delete document
-	_, err = h.db.Exec(`DELETE FROM documents WHERE id = $1`, documentID)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+	doc, err := h.trashDocument(r.Context(), documentID, r.Header.Get("If-Match"))
+	switch {
+	case err == sql.ErrNoRows:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case errors.Is(err, errETagMismatch):
+		respondETagMismatch(w, doc)
+		return
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if decision, ok := cedar.DecisionFromContext(r.Context()); ok {
+		log.Printf("Deleted document %s owned by %s: user=%s policies=%v", documentID, doc.OwnerID, r.Header.Get("X-User-ID"), decision.MatchedPolicies)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// trashDocument moves the document documentID to the trash if etags, as
+// in If-Match, holds its ETag, and returns it without its content. It
+// returns sql.ErrNoRows if there is no such document and errETagMismatch
+// with the current document if it has changed.
+func (h *Handler) trashDocument(ctx context.Context, documentID, etags string) (models.Document, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Document{}, fmt.Errorf("failed to delete document: %w", err)
+	}
+	defer tx.Rollback()
+
+	doc, stored, err := lockDocument(ctx, tx, documentID)
+	if err == sql.ErrNoRows {
+		return models.Document{}, err
+	}
+	if err != nil {
+		return models.Document{}, fmt.Errorf("failed to delete document: %w", err)
+	}
+	if !matchETag(etags, doc) {
+		return doc, errETagMismatch
+	}
+
+	// Delete document
+	_, err = tx.ExecContext(ctx, `
+		UPDATE documents SET deleted_at = $1 WHERE id = $2
+	`, h.clock.Now(), documentID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return models.Document{}, fmt.Errorf("failed to delete document: %w", err)
+	}
+	h.recordUsage(doc.OwnerID, -1, -stored.bytes(doc.Content))
+	h.publishDocumentEvent(webhook.DocumentDeleted, doc.ID, doc.OwnerID)
+	doc.Content = ""
+	return doc, nil
+}
+
 // Helper functions
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
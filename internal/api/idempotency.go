@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxIdempotencyKeyLength limits the length of an Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// errIdempotencyKeyReused is returned when a key is sent again with a
+// different request
+var errIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+
+// requestFingerprint identifies the request a key was first used with
+func requestFingerprint(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// claimIdempotencyKey records that userID is making the request with
+// fingerprint under key, in tx. If the key was used before it returns the
+// response stored for it instead, or errIdempotencyKeyReused if it was
+// used with a different request. A concurrent request with the same key
+// waits for tx to end.
+func claimIdempotencyKey(ctx context.Context, tx *sql.Tx, userID, key, fingerprint string, now time.Time) (json.RawMessage, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO NOTHING
+	`, userID, key, fingerprint, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, nil
+	}
+
+	var stored string
+	var response []byte
+	err = tx.QueryRowContext(ctx, `
+		SELECT fingerprint, response FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`, userID, key).Scan(&stored, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up idempotency key: %w", err)
+	}
+	if stored != fingerprint {
+		return nil, errIdempotencyKeyReused
+	}
+	return response, nil
+}
+
+// saveIdempotentResponse stores the response to the request that claimed
+// key, to be replayed to retries
+func saveIdempotentResponse(ctx context.Context, tx *sql.Tx, userID, key string, response any) error {
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE idempotency_keys SET response = $3
+		WHERE user_id = $1 AND key = $2
+	`, userID, key, string(b))
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys forgets the idempotency keys used more than ttl ago
+func (h *Handler) PurgeIdempotencyKeys(ctx context.Context, ttl time.Duration) error {
+	if _, err := h.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE created_at < $1
+	`, h.clock.Now().Add(-ttl)); err != nil {
+		return fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return nil
+}
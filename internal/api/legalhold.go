@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// GetLegalHold handles showing whether a document, in the trash or not, is
+// under legal hold. The caller has been authorized for GetDocument by the
+// route middleware.
+func (h *Handler) GetLegalHold(w http.ResponseWriter, r *http.Request) {
+	hold, err := h.legalHold(r.Context(), chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, hold)
+}
+
+// SetLegalHold handles placing a document under legal hold or releasing
+// it. While held, deleting the document is refused by policy and neither
+// the trash nor the retention purge removes it. The caller has been
+// authorized for SetLegalHold by the route middleware.
+func (h *Handler) SetLegalHold(w http.ResponseWriter, r *http.Request) {
+	var input models.LegalHoldInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var v validator
+	if input.LegalHold == nil {
+		v.add("legal_hold", fieldRequired, "legal_hold is required")
+	}
+	if err := v.err(); err != nil {
+		respondValidationError(w, err)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentId")
+	res, err := h.db.ExecContext(r.Context(), `
+		UPDATE documents SET legal_hold = $1, legal_hold_by = $2, legal_hold_at = $3
+		WHERE id = $4
+	`, *input.LegalHold, r.Header.Get("X-User-ID"), h.clock.Now(), documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+
+	hold, err := h.legalHold(r.Context(), documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, hold)
+}
+
+// legalHold returns the legal hold of a document, or sql.ErrNoRows if
+// there is no such document
+func (h *Handler) legalHold(ctx context.Context, documentID string) (models.LegalHold, error) {
+	var hold models.LegalHold
+	var setBy sql.NullString
+	var setAt sql.NullTime
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, legal_hold, legal_hold_by, legal_hold_at
+		FROM documents
+		WHERE id = $1
+	`, documentID).Scan(&hold.DocumentID, &hold.LegalHold, &setBy, &setAt)
+	if err == sql.ErrNoRows {
+		return models.LegalHold{}, err
+	}
+	if err != nil {
+		return models.LegalHold{}, fmt.Errorf("failed to fetch legal hold: %w", err)
+	}
+	if setBy.Valid {
+		hold.SetBy = &setBy.String
+	}
+	if setAt.Valid {
+		hold.SetAt = &setAt.Time
+	}
+	return hold, nil
+}
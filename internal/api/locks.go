@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// SetDocumentLocks enables checking documents out for ttl at a time
+func (h *Handler) SetDocumentLocks(ttl time.Duration) {
+	h.lockTTL = ttl
+}
+
+// documentLockedError is returned when a document is checked out by
+// another user
+type documentLockedError struct {
+	lock models.DocumentLock
+}
+
+func (e documentLockedError) Error() string {
+	return fmt.Sprintf("The document is locked by %s until %s", e.lock.LockedBy, e.lock.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// activeLock returns the unexpired lock on the document documentID, or
+// sql.ErrNoRows if there is none
+func activeLock(ctx context.Context, tx *sql.Tx, documentID string, now time.Time) (models.DocumentLock, error) {
+	var lock models.DocumentLock
+	err := tx.QueryRowContext(ctx, `
+		SELECT document_id, locked_by, locked_at, expires_at
+		FROM document_locks
+		WHERE document_id = $1 AND expires_at > $2
+	`, documentID, now).Scan(&lock.DocumentID, &lock.LockedBy, &lock.LockedAt, &lock.ExpiresAt)
+	return lock, err
+}
+
+// checkDocumentLock returns a documentLockedError if the document
+// documentID is checked out by someone other than userID. The document
+// must be locked with lockDocument, so that it cannot be checked out
+// until tx ends.
+func (h *Handler) checkDocumentLock(ctx context.Context, tx *sql.Tx, documentID, userID string) error {
+	if h.lockTTL <= 0 {
+		return nil
+	}
+	lock, err := activeLock(ctx, tx, documentID, h.clock.Now())
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check document lock: %w", err)
+	}
+	if lock.LockedBy != userID {
+		return documentLockedError{lock}
+	}
+	return nil
+}
+
+// respondDocumentLocked answers an update of a document checked out by
+// someone else
+func respondDocumentLocked(w http.ResponseWriter, err documentLockedError) {
+	respondError(w, http.StatusLocked, err.Error())
+}
+
+// GetDocumentLock handles showing who has checked out a document
+func (h *Handler) GetDocumentLock(w http.ResponseWriter, r *http.Request) {
+	if h.lockTTL <= 0 {
+		respondError(w, http.StatusNotImplemented, "Document locks are not available")
+		return
+	}
+
+	var lock models.DocumentLock
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT l.document_id, l.locked_by, l.locked_at, l.expires_at
+		FROM document_locks l
+		JOIN documents d ON d.id = l.document_id
+		WHERE l.document_id = $1 AND l.expires_at > $2 AND d.deleted_at IS NULL
+	`, chi.URLParam(r, "documentId"), h.clock.Now()).Scan(&lock.DocumentID, &lock.LockedBy, &lock.LockedAt, &lock.ExpiresAt)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "The document is not locked")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, lock)
+}
+
+// LockDocument handles checking a document out, so that nobody else can
+// update it until the lock expires or is released. Locking a document the
+// caller has already checked out extends the lock. The caller has been
+// authorized for UpdateDocument by the route middleware.
+func (h *Handler) LockDocument(w http.ResponseWriter, r *http.Request) {
+	if h.lockTTL <= 0 {
+		respondError(w, http.StatusNotImplemented, "Document locks are not available")
+		return
+	}
+	userID := r.Header.Get("X-User-ID")
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the row so that the document is not checked out twice at once
+	doc, _, err := lockDocument(r.Context(), tx, chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	now := h.clock.Now()
+	lock := models.DocumentLock{DocumentID: doc.ID, LockedBy: userID, LockedAt: now, ExpiresAt: now.Add(h.lockTTL)}
+	current, err := activeLock(r.Context(), tx, doc.ID, now)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	case current.LockedBy != userID:
+		respondDocumentLocked(w, documentLockedError{current})
+		return
+	default:
+		lock.LockedAt = current.LockedAt
+	}
+
+	_, err = tx.ExecContext(r.Context(), `
+		INSERT INTO document_locks (document_id, locked_by, locked_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (document_id) DO UPDATE
+		SET locked_by = EXCLUDED.locked_by, locked_at = EXCLUDED.locked_at, expires_at = EXCLUDED.expires_at
+	`, lock.DocumentID, lock.LockedBy, lock.LockedAt, lock.ExpiresAt)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, lock)
+}
+
+// UnlockDocument handles checking a document back in. Unlocking a
+// document that is not locked succeeds; one locked by someone else is
+// answered with 423. The caller has been authorized for UpdateDocument by
+// the route middleware.
+func (h *Handler) UnlockDocument(w http.ResponseWriter, r *http.Request) {
+	if h.lockTTL <= 0 {
+		respondError(w, http.StatusNotImplemented, "Document locks are not available")
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	doc, _, err := lockDocument(r.Context(), tx, chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	err = h.checkDocumentLock(r.Context(), tx, doc.ID, r.Header.Get("X-User-ID"))
+	var locked documentLockedError
+	if errors.As(err, &locked) {
+		respondDocumentLocked(w, locked)
+		return
+	}
+	if err == nil {
+		_, err = tx.ExecContext(r.Context(), `DELETE FROM document_locks WHERE document_id = $1`, doc.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
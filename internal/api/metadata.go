@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// Limits on document metadata. Integers are limited to those JSON numbers
+// hold exactly.
+const (
+	maxMetadataKeys        = 50
+	maxMetadataValueLength = 500
+	maxMetadataInteger     = 1<<53 - 1
+)
+
+// metadataKeyPattern matches metadata keys, which are also used as Cedar
+// tag names and in the metadata.<key> list filters
+var metadataKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// metadataType returns the type of a metadata value as declared in
+// metadata schemas, or "" if metadata cannot hold it
+func metadataType(value any) string {
+	switch value := value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		n, err := value.Int64()
+		if err == nil && n >= -maxMetadataInteger && n <= maxMetadataInteger {
+			return "integer"
+		}
+	}
+	return ""
+}
+
+// metadata checks the keys and values of metadata and, if schema is not
+// nil, that they are those it declares
+func (v *validator) metadata(field string, metadata models.Metadata, schema models.MetadataSchema) {
+	if len(metadata) > maxMetadataKeys {
+		v.add(field, fieldTooMany, fmt.Sprintf("%s must have at most %d keys", field, maxMetadataKeys))
+		return
+	}
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		name := field + "." + key
+		if !metadataKeyPattern.MatchString(key) {
+			v.add(name, fieldInvalidValue, fmt.Sprintf("%s keys must be lowercase letters, digits, and underscores, starting with a letter", field))
+			continue
+		}
+		typ := metadataType(metadata[key])
+		switch typ {
+		case "":
+			v.add(name, fieldInvalidValue, fmt.Sprintf("%s must be a string, an integer, or a boolean", name))
+			continue
+		case "string":
+			v.text(name, metadata[key].(string), maxMetadataValueLength, false, false)
+		}
+		if schema == nil {
+			continue
+		}
+		declared, ok := schema[key]
+		if !ok {
+			v.add(name, fieldInvalidValue, fmt.Sprintf("%s is not declared by the document group", name))
+		} else if declared.Type != typ {
+			v.add(name, fieldInvalidValue, fmt.Sprintf("%s must be a %s", name, declared.Type))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(schema)) {
+		if _, ok := metadata[key]; !ok && schema[key].Required {
+			v.add(field+"."+key, fieldRequired, fmt.Sprintf("%s.%s is required", field, key))
+		}
+	}
+}
+
+// checkMetadataSchema checks the keys and types a document group declares
+func checkMetadataSchema(schema models.MetadataSchema) error {
+	for _, key := range slices.Sorted(maps.Keys(schema)) {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("metadata_schema key %q must be lowercase letters, digits, and underscores, starting with a letter", key)
+		}
+		switch schema[key].Type {
+		case "string", "integer", "boolean":
+		default:
+			return fmt.Errorf("metadata_schema.%s.type must be string, integer, or boolean", key)
+		}
+	}
+	return nil
+}
+
+// checkGroupMetadata checks the metadata of doc against the schema of its
+// document group, if it has one, read with db, a database or a
+// transaction. It returns a validationError if the metadata does not
+// match.
+func checkGroupMetadata(ctx context.Context, db interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}, doc models.Document) error {
+	if !doc.DocumentGroupID.Valid {
+		return nil
+	}
+	var schema models.MetadataSchema
+	err := db.QueryRowContext(ctx, `
+		SELECT metadata_schema FROM document_groups WHERE id = $1
+	`, doc.DocumentGroupID.String).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load metadata schema: %w", err)
+	}
+	if schema == nil {
+		return nil
+	}
+	var v validator
+	v.metadata("metadata", doc.Metadata, schema)
+	return v.err()
+}
+
+// mergeMetadataPatch applies a merge patch to metadata: keys set to null
+// are removed and the others replaced
+func mergeMetadataPatch(metadata models.Metadata, patch json.RawMessage) (models.Metadata, error) {
+	if isJSONNull(patch) {
+		return models.Metadata{}, nil
+	}
+	var changes models.Metadata
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, errors.New("metadata must be an object")
+	}
+	merged := maps.Clone(metadata)
+	if merged == nil {
+		merged = models.Metadata{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,324 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// sortColumn is a documents column the list can be sorted by
+type sortColumn struct {
+	// column is the SQL identifier; only these identifiers, never
+	// caller input, are written into queries
+	column string
+	// key returns a document's value as it is kept in cursors
+	key func(models.Document) string
+	// parse converts a cursor key back into a query argument
+	parse func(string) (any, error)
+}
+
+// timeColumn sorts by a timestamp column
+func timeColumn(column string, value func(models.Document) time.Time) sortColumn {
+	return sortColumn{
+		column: column,
+		key:    func(d models.Document) string { return value(d).Format(time.RFC3339Nano) },
+		parse: func(key string) (any, error) {
+			return time.Parse(time.RFC3339Nano, key)
+		},
+	}
+}
+
+// sortColumns are the values accepted by the sort parameter
+var sortColumns = map[string]sortColumn{
+	"created_at": timeColumn("created_at", func(d models.Document) time.Time { return d.CreatedAt }),
+	"updated_at": timeColumn("updated_at", func(d models.Document) time.Time { return d.UpdatedAt }),
+	"title": {
+		column: "title",
+		key:    func(d models.Document) string { return d.Title },
+		parse:  func(key string) (any, error) { return key, nil },
+	},
+}
+
+// documentFilter narrows the document list beyond what the caller may see
+type documentFilter struct {
+	// deleted selects the documents in the trash instead of the others
+	deleted       bool
+	ownerID       string
+	groupID       string
+	createdAfter  time.Time
+	createdBefore time.Time
+	// starredBy and viewedBy select the documents starred and recently
+	// viewed by the user
+	starredBy string
+	viewedBy  string
+	// metadata selects the documents whose metadata has each key with the
+	// value as text
+	metadata map[string]string
+}
+
+// parseDocumentFilter reads the owner_id, document_group_id,
+// created_after, and created_before query parameters, and metadata.<key>
+// for each metadata key to match
+func parseDocumentFilter(params url.Values) (documentFilter, error) {
+	f := documentFilter{
+		ownerID: params.Get("owner_id"),
+		groupID: params.Get("document_group_id"),
+	}
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"created_after", &f.createdAfter}, {"created_before", &f.createdBefore}} {
+		if v := params.Get(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return documentFilter{}, fmt.Errorf("%s must be an RFC 3339 time", bound.name)
+			}
+			*bound.dst = t
+		}
+	}
+	for name := range params {
+		key, ok := strings.CutPrefix(name, "metadata.")
+		if !ok {
+			continue
+		}
+		if !metadataKeyPattern.MatchString(key) {
+			return documentFilter{}, fmt.Errorf("%s is not a valid metadata key", key)
+		}
+		if f.metadata == nil {
+			f.metadata = map[string]string{}
+		}
+		f.metadata[key] = params.Get(name)
+	}
+	return f, nil
+}
+
+// sql adds the filter's conditions to where, whose placeholders are args
+func (f documentFilter) sql(where string, args []any) (string, []any) {
+	if f.deleted {
+		where = fmt.Sprintf("(%s) AND deleted_at IS NOT NULL", where)
+	} else {
+		where = fmt.Sprintf("(%s) AND deleted_at IS NULL", where)
+	}
+	for _, c := range []struct {
+		set       bool
+		condition string
+		value     any
+	}{
+		{f.ownerID != "", "owner_id = $%d", f.ownerID},
+		{f.groupID != "", "document_group_id = $%d", f.groupID},
+		{!f.createdAfter.IsZero(), "created_at >= $%d", f.createdAfter},
+		{!f.createdBefore.IsZero(), "created_at < $%d", f.createdBefore},
+		{f.starredBy != "", "id IN (SELECT document_id FROM document_stars WHERE user_id = $%d)", f.starredBy},
+		{f.viewedBy != "", "id IN (SELECT document_id FROM recent_views WHERE user_id = $%d)", f.viewedBy},
+	} {
+		if c.set {
+			args = append(args, c.value)
+			where = fmt.Sprintf("(%s) AND %s", where, fmt.Sprintf(c.condition, len(args)))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(f.metadata)) {
+		args = append(args, key, f.metadata[key])
+		where = fmt.Sprintf("(%s) AND metadata ->> $%d = $%d", where, len(args)-1, len(args))
+	}
+	return where, args
+}
+
+// documentCursor is the position after the last document of a page. It
+// records the order it was made for, so it cannot be used with another.
+type documentCursor struct {
+	Sort  string `json:"sort"`
+	Order string `json:"order"`
+	Key   string `json:"key"`
+	ID    string `json:"id"`
+}
+
+// documentPage selects the order of the document list and a page of it,
+// and the fields of the documents in it. A zero limit lists every
+// document. A page starts after a cursor or, failing that, after skipping
+// offset documents.
+type documentPage struct {
+	sort   string
+	desc   bool
+	limit  int
+	after  *documentCursor
+	offset int
+	fields documentFields
+}
+
+// parseDocumentPage reads the sort, order, limit, cursor, offset, and
+// fields query parameters. Documents are sorted by created_at, newest
+// first, unless asked otherwise; ties are broken by ID. A cursor or an
+// offset without a limit pages with the default size.
+func parseDocumentPage(params url.Values) (documentPage, error) {
+	p := documentPage{sort: "created_at", desc: true}
+
+	if v := params.Get("sort"); v != "" {
+		if _, ok := sortColumns[v]; !ok {
+			return documentPage{}, fmt.Errorf("cannot sort by %q: use created_at, updated_at, or title", v)
+		}
+		p.sort = v
+	}
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		p.desc = false
+	default:
+		return documentPage{}, errors.New("order must be asc or desc")
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return documentPage{}, errors.New("invalid cursor")
+		}
+		var c documentCursor
+		if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+			return documentPage{}, errors.New("invalid cursor")
+		}
+		if c.Sort != p.sort || c.Order != p.order() {
+			return documentPage{}, errors.New("the cursor was made for another sort order")
+		}
+		if _, err := sortColumns[p.sort].parse(c.Key); err != nil {
+			return documentPage{}, errors.New("invalid cursor")
+		}
+		p.after = &c
+		p.limit = defaultPageSize
+	}
+
+	if v := params.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return documentPage{}, errors.New("offset must be a number from 0")
+		}
+		if p.after != nil {
+			return documentPage{}, errors.New("offset cannot be combined with cursor")
+		}
+		p.offset = n
+		p.limit = defaultPageSize
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return documentPage{}, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		p.limit = n
+	}
+
+	fields, err := parseDocumentFields(params)
+	if err != nil {
+		return documentPage{}, err
+	}
+	p.fields = fields
+	return p, nil
+}
+
+// order returns the order parameter the page was made with
+func (p documentPage) order() string {
+	if p.desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// query selects the documents matching where, whose placeholders are
+// args, that come after the cursor, in page order. Unless all is set, the
+// offset is skipped and one more row than the limit is fetched to tell
+// whether there is a next page.
+// The content is left empty, and not loaded from storage, unless the
+// page's fields include it.
+func (p documentPage) query(where string, args []any, all bool) (string, []any) {
+	column := sortColumns[p.sort].column
+	direction, after := "ASC", ">"
+	if p.desc {
+		direction, after = "DESC", "<"
+	}
+
+	if p.after != nil {
+		key, _ := sortColumns[p.sort].parse(p.after.Key)
+		args = append(args, key, p.after.ID)
+		where = fmt.Sprintf("(%s) AND (%s, id) %s ($%d, $%d)", where, column, after, len(args)-1, len(args))
+	}
+	content := "content, content_key"
+	if !p.fields.has("content") {
+		content = "'' AS content, NULL AS content_key"
+	}
+	query := `
+		SELECT id, title, ` + content + `, content_size, owner_id, document_group_id, classification, tags, metadata, created_at, updated_at, deleted_at
+		FROM documents
+		WHERE ` + where + `
+		ORDER BY ` + column + ` ` + direction + `, id ` + direction
+	if p.limit > 0 && !all {
+		args = append(args, p.limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if p.offset > 0 && !all {
+		args = append(args, p.offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	return query, args
+}
+
+// sparseDocumentsResponse is a DocumentsResponse with only some fields of
+// each document
+type sparseDocumentsResponse struct {
+	Documents  []any                          `json:"documents"`
+	NextCursor string                         `json:"next_cursor,omitempty"`
+	Facets     map[string][]models.FacetCount `json:"facets,omitempty"`
+}
+
+// respondDocuments writes a page of documents, with the page's fields, in
+// the media type the request accepts. The cursor of the next page is also
+// given in a Link header, since only the JSON response has room for it.
+func (h *Handler) respondDocuments(w http.ResponseWriter, r *http.Request, page documentPage, response models.DocumentsResponse) {
+	if response.NextCursor != "" {
+		next := *r.URL
+		params := next.Query()
+		params.Set("cursor", response.NextCursor)
+		next.RawQuery = params.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	list := page.fields.list(response.Documents)
+	if page.fields == nil {
+		h.respondList(w, r, response, list)
+		return
+	}
+	h.respondList(w, r, sparseDocumentsResponse{
+		Documents:  list.Items,
+		NextCursor: response.NextCursor,
+		Facets:     response.Facets,
+	}, list)
+}
+
+// response returns the page of documents, which holds up to one more than
+// the limit, with the cursor of the next page if there is one
+func (p documentPage) response(documents []models.Document) models.DocumentsResponse {
+	response := models.DocumentsResponse{Documents: documents}
+	if p.limit > 0 && len(documents) > p.limit {
+		response.Documents = documents[:p.limit]
+		last := response.Documents[p.limit-1]
+		b, _ := json.Marshal(documentCursor{
+			Sort:  p.sort,
+			Order: p.order(),
+			Key:   sortColumns[p.sort].key(last),
+			ID:    last.ID,
+		})
+		response.NextCursor = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return response
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// TestDocumentPageOffset checks that offset pages with the default size,
+// cannot be combined with a cursor, and is skipped in SQL
+func TestDocumentPageOffset(t *testing.T) {
+	page, err := parseDocumentPage(url.Values{"offset": {"100"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.offset != 100 || page.limit != defaultPageSize {
+		t.Errorf("offset %d limit %d, want 100 %d", page.offset, page.limit, defaultPageSize)
+	}
+	query, args := page.query("TRUE", nil, false)
+	if !strings.HasSuffix(query, "LIMIT $1 OFFSET $2") || len(args) != 2 || args[0] != defaultPageSize+1 || args[1] != 100 {
+		t.Errorf("query %q with %v, want LIMIT %d OFFSET 100", query, args, defaultPageSize+1)
+	}
+	if query, _ := page.query("TRUE", nil, true); strings.Contains(query, "OFFSET") {
+		t.Errorf("query of all documents %q skips the offset", query)
+	}
+
+	documents := make([]models.Document, defaultPageSize+1)
+	documents[defaultPageSize-1].ID = "doc-1"
+	cursor := page.response(documents).NextCursor
+	for _, tt := range []struct {
+		params url.Values
+		want   string
+	}{
+		{url.Values{"offset": {"-1"}}, "offset must be a number from 0"},
+		{url.Values{"offset": {"ten"}}, "offset must be a number from 0"},
+		{url.Values{"offset": {"10"}, "cursor": {cursor}}, "offset cannot be combined with cursor"},
+	} {
+		if _, err := parseDocumentPage(tt.params); err == nil || err.Error() != tt.want {
+			t.Errorf("%v: got error %v, want %q", tt.params, err, tt.want)
+		}
+	}
+}
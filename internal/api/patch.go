@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// mergePatchType is the media type of JSON Merge Patch (RFC 7396)
+const mergePatchType = "application/merge-patch+json"
+
+// PatchDocument handles a partial update of a document with a JSON Merge
+// Patch: only the fields present in the body change. Since the fields of
+// a document other than metadata are not objects, each present field is
+// replaced; tags are replaced as a whole and cleared with null. Metadata
+// is merged: keys set to null are removed, and null clears it. The
+// title, content, and classification cannot be removed.
+func (h *Handler) PatchDocument(w http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mediaType != mergePatchType && mediaType != "application/json") {
+			respondError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Expected %s", mergePatchType))
+			return
+		}
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: expected a JSON object")
+		return
+	}
+	for field := range patch {
+		switch field {
+		case "title", "content", "classification", "tags", "metadata":
+		default:
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown field: %s", field))
+			return
+		}
+	}
+
+	_, replacesContent := patch["content"]
+	h.updateDocument(w, r, replacesContent, func(doc *models.Document) error {
+		return mergeDocumentPatch(doc, patch)
+	})
+}
+
+// mergeDocumentPatch applies the fields of a merge patch to doc
+func mergeDocumentPatch(doc *models.Document, patch map[string]json.RawMessage) error {
+	for _, field := range []struct {
+		name string
+		dst  *string
+	}{
+		{"title", &doc.Title},
+		{"content", &doc.Content},
+		{"classification", &doc.Classification},
+	} {
+		value, ok := patch[field.name]
+		if !ok {
+			continue
+		}
+		if isJSONNull(value) {
+			return fmt.Errorf("%s cannot be removed", field.name)
+		}
+		if err := json.Unmarshal(value, field.dst); err != nil {
+			return fmt.Errorf("%s must be a string", field.name)
+		}
+	}
+
+	if value, ok := patch["tags"]; ok {
+		var tags []string
+		if err := json.Unmarshal(value, &tags); err != nil {
+			return errors.New("tags must be an array of strings")
+		}
+		if tags == nil {
+			tags = []string{}
+		}
+		doc.Tags = tags
+	}
+
+	if value, ok := patch["metadata"]; ok {
+		metadata, err := mergeMetadataPatch(doc.Metadata, value)
+		if err != nil {
+			return err
+		}
+		doc.Metadata = metadata
+	}
+	return nil
+}
+
+// isJSONNull reports whether value is the JSON null literal
+func isJSONNull(value json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(value), []byte("null"))
+}
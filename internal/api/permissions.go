@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// GetDocumentPermissions handles listing the users granted access to a
+// document. The caller has been authorized for ShareDocument by the route
+// middleware, as for changing the grants.
+func (h *Handler) GetDocumentPermissions(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentId")
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT user_id, access
+		FROM document_grants
+		WHERE document_id = $1
+		ORDER BY user_id
+	`, documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.DocumentPermissions{DocumentID: documentID, Grants: []models.DocumentGrant{}}
+	for rows.Next() {
+		var g models.DocumentGrant
+		if err := rows.Scan(&g.UserID, &g.Access); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.Grants = append(response.Grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// PutDocumentPermissions handles replacing the users granted access to a
+// document
+func (h *Handler) PutDocumentPermissions(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentId")
+
+	var input models.DocumentPermissionsInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	seen := map[string]bool{}
+	for i, g := range input.Grants {
+		if g.UserID == "" {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Grant %d: user_id is required", i))
+			return
+		}
+		if seen[g.UserID] {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("User %s is granted access more than once", g.UserID))
+			return
+		}
+		seen[g.UserID] = true
+		if g.Access != string(cedar.ShareRead) && g.Access != string(cedar.ShareWrite) {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Grant %d: access must be read or write", i))
+			return
+		}
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(r.Context(), `
+		DELETE FROM document_grants WHERE document_id = $1
+	`, documentID); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	now := h.clock.Now()
+	for _, g := range input.Grants {
+		if _, err := tx.ExecContext(r.Context(), `
+			INSERT INTO document_grants (document_id, user_id, access, created_at) VALUES ($1, $2, $3, $4)
+		`, documentID, g.UserID, g.Access, now); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	h.GetDocumentPermissions(w, r)
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// PolicyManager changes the stored policies and keeps their history. It is
+// implemented by *cedar.Authorizer.
+type PolicyManager interface {
+	PolicyVersions(ctx context.Context) ([]cedar.PolicyVersion, error)
+	UpdatePolicy(ctx context.Context, id, content, author string) (cedar.PolicyVersion, error)
+	RollbackPolicies(ctx context.Context, version int64, author string) (cedar.PolicyVersion, error)
+	DiffPolicyVersions(ctx context.Context, from, to int64, corpus []cedar.AuthzRequest) (cedar.PolicyDiff, error)
+	ExportPolicies(ctx context.Context) (cedar.PolicyExport, error)
+	ImportPolicies(ctx context.Context, export cedar.PolicyExport, author string) (cedar.PolicyVersion, error)
+}
+
+// requirePolicyManager reports whether policy management is available and
+// writes the error response otherwise
+func (h *Handler) requirePolicyManager(w http.ResponseWriter) bool {
+	if h.policies == nil {
+		respondError(w, http.StatusNotImplemented, "Policy management is not available")
+		return false
+	}
+	return true
+}
+
+// ListPolicyVersions handles listing the policy version history. The
+// caller has been authorized for ManagePolicies by the route middleware.
+func (h *Handler) ListPolicyVersions(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePolicyManager(w) {
+		return
+	}
+
+	versions, err := h.policies.PolicyVersions(r.Context())
+	if err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+
+	response := models.PolicyVersionsResponse{
+		Versions: make([]models.PolicyVersion, 0, len(versions)),
+	}
+	for _, v := range versions {
+		response.Versions = append(response.Versions, toPolicyVersion(v))
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// UpdatePolicy handles replacing the content of a stored policy. The
+// caller has been authorized for ManagePolicies by the route middleware.
+func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePolicyManager(w) {
+		return
+	}
+	policyID := chi.URLParam(r, "policyId")
+
+	var input models.PolicyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	v, err := h.policies.UpdatePolicy(r.Context(), policyID, input.Content, r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, toPolicyVersion(v))
+}
+
+// RollbackPolicies handles restoring the policies of a prior version. The
+// caller has been authorized for ManagePolicies by the route middleware.
+func (h *Handler) RollbackPolicies(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePolicyManager(w) {
+		return
+	}
+
+	version, err := strconv.ParseInt(chi.URLParam(r, "version"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version")
+		return
+	}
+
+	v, err := h.policies.RollbackPolicies(r.Context(), version, r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, toPolicyVersion(v))
+}
+
+// DiffPolicyVersions handles comparing two policy versions. The requests
+// in the body, or the most recent audit log entries, are replayed against
+// both and the decisions that flip are reported. The caller has been
+// authorized for DiffPolicies by the route middleware.
+func (h *Handler) DiffPolicyVersions(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePolicyManager(w) {
+		return
+	}
+
+	var input models.PolicyDiffInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.AuditLimit == 0 {
+		input.AuditLimit = defaultAuditLimit
+	}
+	if input.AuditLimit < 1 || input.AuditLimit > maxAuditLimit {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("audit_limit must be between 1 and %d", maxAuditLimit))
+		return
+	}
+
+	var corpus []cedar.AuthzRequest
+	var err error
+	switch {
+	case len(input.Requests) > 0:
+		corpus, err = requestCorpus(input.Requests)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case h.audit == nil:
+		respondError(w, http.StatusBadRequest, "No requests provided and the audit log is not available")
+		return
+	default:
+		corpus, err = h.auditCorpus(r.Context(), input.AuditLimit)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+
+	diff, err := h.policies.DiffPolicyVersions(r.Context(), input.From, input.To, corpus)
+	if err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+
+	response := models.PolicyDiffResponse{
+		From:     diff.From,
+		To:       diff.To,
+		Changes:  make([]models.PolicyChange, 0, len(diff.Changes)),
+		Replayed: diff.Replayed,
+		Skipped:  diff.Skipped,
+		Flips:    make([]models.DecisionFlip, 0, len(diff.Flips)),
+	}
+	for _, c := range diff.Changes {
+		response.Changes = append(response.Changes, models.PolicyChange{
+			ID:     c.ID,
+			Kind:   c.Kind,
+			Before: c.Before,
+			After:  c.After,
+		})
+	}
+	for _, f := range diff.Flips {
+		response.Flips = append(response.Flips, models.DecisionFlip{
+			Principal: models.AuthzPrincipal{ID: f.Request.UserID, Role: f.Request.UserRole, GroupID: f.Request.UserGroupID},
+			Action:    f.Request.Action,
+			Resource:  models.AuthzResource{ID: f.Request.ResourceID},
+			Before:    checkResponse(f.Before),
+			After:     checkResponse(f.After),
+		})
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// ExportPolicies handles exporting the active policies, schema, and
+// template links so they can be imported into another environment. The
+// caller has been authorized for ExportPolicies by the route middleware.
+func (h *Handler) ExportPolicies(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePolicyManager(w) {
+		return
+	}
+
+	export, err := h.policies.ExportPolicies(r.Context())
+	if err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+
+	response := models.PolicyExport{
+		Policies:  export.Policies,
+		Schema:    export.Schema,
+		Templates: export.Templates,
+		Links:     make([]models.PolicyTemplateLink, 0, len(export.Links)),
+	}
+	for _, l := range export.Links {
+		response.Links = append(response.Links, models.PolicyTemplateLink{
+			TemplateID: l.TemplateID,
+			Principal:  models.EntityRef{Type: l.Principal.Type, ID: l.Principal.ID},
+			Resource:   models.EntityRef{Type: l.Resource.Type, ID: l.Resource.ID},
+		})
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// ImportPolicies handles replacing the stored policies, templates, and
+// links with an export. The caller has been authorized for ImportPolicies
+// by the route middleware.
+func (h *Handler) ImportPolicies(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePolicyManager(w) {
+		return
+	}
+
+	var input models.PolicyExport
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	export := cedar.PolicyExport{
+		Policies:  input.Policies,
+		Schema:    input.Schema,
+		Templates: input.Templates,
+	}
+	for _, l := range input.Links {
+		export.Links = append(export.Links, cedar.TemplateLink{
+			TemplateID: l.TemplateID,
+			Principal:  cedar.EntityRef{Type: l.Principal.Type, ID: l.Principal.ID},
+			Resource:   cedar.EntityRef{Type: l.Resource.Type, ID: l.Resource.ID},
+		})
+	}
+
+	v, err := h.policies.ImportPolicies(r.Context(), export, r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondPolicyError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, toPolicyVersion(v))
+}
+
+// requestCorpus builds the requests to replay from explicit checks
+func requestCorpus(entries []models.AuthzCheckInput) ([]cedar.AuthzRequest, error) {
+	corpus := make([]cedar.AuthzRequest, 0, len(entries))
+	for i, entry := range entries {
+		req, err := checkRequest(entry.Principal, entry.Action, entry.Resource.ID, entry.Context)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %w", i, err)
+		}
+		corpus = append(corpus, req)
+	}
+	return corpus, nil
+}
+
+// auditCorpus returns the distinct requests among the most recent audit
+// log entries
+func (h *Handler) auditCorpus(ctx context.Context, limit int) ([]cedar.AuthzRequest, error) {
+	records, err := h.audit.Query(ctx, cedar.AuditQuery{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	type requestKey struct {
+		principal, role, group, action, resource, ip string
+		private, japan, mfa                          bool
+	}
+	var corpus []cedar.AuthzRequest
+	seen := map[requestKey]bool{}
+	for _, rec := range records {
+		key := requestKey{rec.PrincipalID, rec.PrincipalRole, rec.PrincipalGroup, rec.Action, rec.ResourceID, rec.IPAddress, rec.IsPrivateIP, rec.IsJapanIP, rec.MFAVerified}
+		if !seen[key] {
+			seen[key] = true
+			corpus = append(corpus, rec.Request())
+		}
+	}
+	return corpus, nil
+}
+
+func toPolicyVersion(v cedar.PolicyVersion) models.PolicyVersion {
+	return models.PolicyVersion{
+		Version:   v.Version,
+		Author:    v.Author,
+		Message:   v.Message,
+		CreatedAt: v.CreatedAt,
+	}
+}
+
+// respondPolicyError maps policy management errors to status codes
+func respondPolicyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cedar.ErrVersionNotFound):
+		respondError(w, http.StatusNotFound, "Policy version not found")
+	case errors.Is(err, cedar.ErrInvalidPolicy):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, cedar.ErrNoPolicyStore):
+		respondError(w, http.StatusConflict, "Policies are not stored in the database; set POLICY_SOURCE=db")
+	default:
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Policy error: %v", err))
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// recentView is a user's view of a document waiting to be written
+type recentView struct {
+	userID     string
+	documentID string
+	at         time.Time
+}
+
+// RecentViews records the documents each user has viewed in the
+// recent_views table in the background, so that reads never wait on the
+// write. Only the latest views of each user are kept; when the buffer is
+// full, views are dropped and logged.
+type RecentViews struct {
+	db    *sql.DB
+	limit int
+	views chan recentView
+}
+
+// NewRecentViews creates a recorder that buffers up to bufferSize views
+// and keeps the last limit documents viewed by each user
+func NewRecentViews(db *sql.DB, bufferSize, limit int) *RecentViews {
+	return &RecentViews{
+		db:    db,
+		limit: limit,
+		views: make(chan recentView, bufferSize),
+	}
+}
+
+// SetRecentViews records the documents fetched by each user and enables
+// the list of recently viewed documents
+func (h *Handler) SetRecentViews(views *RecentViews) {
+	h.recentViews = views
+}
+
+// Record queues a view of documentID by userID without blocking
+func (v *RecentViews) Record(userID, documentID string, at time.Time) {
+	select {
+	case v.views <- recentView{userID: userID, documentID: documentID, at: at}:
+	default:
+		log.Printf("Recent view buffer full, dropping view: user=%s document=%s", userID, documentID)
+	}
+}
+
+// Run writes queued views until ctx is cancelled, then writes whatever is
+// still buffered and returns
+func (v *RecentViews) Run(ctx context.Context) {
+	for {
+		select {
+		case view := <-v.views:
+			v.write(view)
+		case <-ctx.Done():
+			for {
+				select {
+				case view := <-v.views:
+					v.write(view)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write records a view and forgets the user's views beyond the limit
+func (v *RecentViews) write(view recentView) {
+	// Use a fresh context so the final views still get written after
+	// shutdown
+	ctx := context.Background()
+	_, err := v.db.ExecContext(ctx, `
+		INSERT INTO recent_views (user_id, document_id, viewed_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, document_id) DO UPDATE SET viewed_at = GREATEST(recent_views.viewed_at, EXCLUDED.viewed_at)
+	`, view.userID, view.documentID, view.at)
+	if err == nil {
+		_, err = v.db.ExecContext(ctx, `
+			DELETE FROM recent_views
+			WHERE user_id = $1 AND document_id NOT IN (
+				SELECT document_id FROM recent_views
+				WHERE user_id = $1
+				ORDER BY viewed_at DESC, document_id
+				LIMIT $2
+			)
+		`, view.userID, v.limit)
+	}
+	// A document deleted in the meantime is not worth recording
+	if err != nil && !isForeignKeyViolation(err) {
+		log.Printf("Failed to record view of %s by %s: %v", view.documentID, view.userID, err)
+	}
+}
+
+// recordView records that the caller of r viewed documentID, if views are
+// recorded and the caller is known
+func (h *Handler) recordView(r *http.Request, documentID string) {
+	userID := r.Header.Get("X-User-ID")
+	if h.recentViews == nil || userID == "" {
+		return
+	}
+	h.recentViews.Record(userID, documentID, h.clock.Now())
+}
+
+// ListRecentDocuments handles listing the documents the caller has viewed
+// most recently and may still read, last viewed first. The caller has been
+// authorized for ListDocuments on the collection by the route middleware.
+func (h *Handler) ListRecentDocuments(w http.ResponseWriter, r *http.Request) {
+	if h.recentViews == nil {
+		respondError(w, http.StatusNotImplemented, "Recently viewed documents are not recorded")
+		return
+	}
+	userID := r.Header.Get("X-User-ID")
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT document_id, viewed_at FROM recent_views WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+	viewed := map[string]time.Time{}
+	for rows.Next() {
+		var documentID string
+		var at time.Time
+		if err := rows.Scan(&documentID, &at); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		viewed[documentID] = at
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	// The views are capped, so all of them are checked at once
+	documents, err := h.findDocuments(r, "GetDocument", documentFilter{viewedBy: userID}, documentPage{sort: "created_at", desc: true})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := models.RecentDocumentsResponse{Documents: make([]models.RecentDocument, 0, len(documents))}
+	for _, doc := range documents {
+		response.Documents = append(response.Documents, models.RecentDocument{Document: doc, ViewedAt: viewed[doc.ID]})
+	}
+	slices.SortStableFunc(response.Documents, func(a, b models.RecentDocument) int {
+		return b.ViewedAt.Compare(a.ViewedAt)
+	})
+	respondJSON(w, http.StatusOK, response)
+}
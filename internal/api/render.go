@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown converts document content to HTML. Raw HTML in the content is
+// dropped rather than passed through.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// renderPolicy sanitizes rendered HTML, so that links and images cannot
+// carry scripts
+var renderPolicy = bluemonday.UGCPolicy()
+
+// renderMarkdown converts Markdown to sanitized HTML
+func renderMarkdown(content string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(content), &buf); err != nil {
+		return "", fmt.Errorf("failed to render document: %w", err)
+	}
+	return renderPolicy.Sanitize(buf.String()), nil
+}
+
+// defaultRenderCacheSize is how many rendered documents are kept unless
+// SetRenderCache says otherwise
+const defaultRenderCacheSize = 1000
+
+// SetRenderCache keeps the HTML of up to size rendered documents; 0
+// disables caching
+func (h *Handler) SetRenderCache(size int) {
+	if size <= 0 {
+		h.rendered = nil
+		return
+	}
+	h.rendered = newRenderCache(size)
+}
+
+// GetRenderedDocument handles fetching a document with its Markdown
+// content rendered to sanitized HTML. The caller has been authorized for
+// GetDocument by the route middleware.
+func (h *Handler) GetRenderedDocument(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.fetchDocument(r.Context(), chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The ETag changes with every update, so it identifies the content
+	etag := documentETag(doc)
+	html, ok := h.rendered.get(etag)
+	if !ok {
+		html, err = renderMarkdown(doc.Content)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		h.rendered.put(etag, html)
+	}
+
+	setDocumentETag(w, doc)
+	respondJSON(w, http.StatusOK, models.RenderedDocument{
+		DocumentID: doc.ID,
+		Title:      doc.Title,
+		HTML:       html,
+		UpdatedAt:  doc.UpdatedAt,
+	})
+}
+
+type renderEntry struct {
+	etag string
+	html string
+}
+
+// renderCache is an LRU cache of rendered documents by ETag. A nil cache
+// keeps nothing.
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newRenderCache(size int) *renderCache {
+	return &renderCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the HTML rendered for the document revision etag
+func (c *renderCache) get(etag string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[etag]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*renderEntry).html, true
+}
+
+// put stores the HTML of a document revision, evicting the least recently
+// used entry when full
+func (c *renderCache) put(etag, html string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[etag]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*renderEntry).etag)
+	}
+	c.entries[etag] = c.order.PushFront(&renderEntry{etag: etag, html: html})
+}
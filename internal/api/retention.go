@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+)
+
+// documentExpiry is when a document expires: at its own expiry if it has
+// one, and otherwise after the retention of its document group, if any,
+// counted from its creation
+const documentExpiry = `COALESCE(expires_at, created_at + (
+	SELECT retention_days FROM document_groups g WHERE g.id = document_group_id
+) * INTERVAL '1 day')`
+
+// defaultRetentionGrace is how long expired documents stay in the trash
+// unless SetRetentionGrace says otherwise
+const defaultRetentionGrace = 30 * 24 * time.Hour
+
+// Actions recorded in retention_audit
+const (
+	retentionArchived = "archived"
+	retentionPurged   = "purged"
+)
+
+// GetDocumentRetention handles showing when a document expires and, if it
+// has, when it is purged. The caller has been authorized for GetDocument
+// by the route middleware.
+func (h *Handler) GetDocumentRetention(w http.ResponseWriter, r *http.Request) {
+	retention, err := h.documentRetention(r.Context(), chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, retention)
+}
+
+// SetDocumentRetention handles setting or, with a null expires_at,
+// clearing the expiry of a document, which takes precedence over the
+// retention of its group. The expiry must be in the future, and documents
+// in the trash are left as they are. Since an expiry deletes the document,
+// the caller has been authorized for DeleteDocument by the route
+// middleware.
+func (h *Handler) SetDocumentRetention(w http.ResponseWriter, r *http.Request) {
+	var input models.DocumentRetentionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(h.clock.Now()) {
+		respondError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentId")
+	res, err := h.db.ExecContext(r.Context(), `
+		UPDATE documents SET expires_at = $1 WHERE id = $2 AND deleted_at IS NULL
+	`, input.ExpiresAt, documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+
+	retention, err := h.documentRetention(r.Context(), documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, retention)
+}
+
+// documentRetention returns the retention of a document, in the trash or
+// not, or sql.ErrNoRows if there is no such document
+func (h *Handler) documentRetention(ctx context.Context, documentID string) (models.DocumentRetention, error) {
+	var retention models.DocumentRetention
+	var days sql.NullInt64
+	var expiresAt, expiry, expiredAt sql.NullTime
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, expires_at, (
+			SELECT retention_days FROM document_groups g WHERE g.id = document_group_id
+		), `+documentExpiry+`, expired_at
+		FROM documents
+		WHERE id = $1
+	`, documentID).Scan(&retention.DocumentID, &expiresAt, &days, &expiry, &expiredAt)
+	if err == sql.ErrNoRows {
+		return models.DocumentRetention{}, err
+	}
+	if err != nil {
+		return models.DocumentRetention{}, fmt.Errorf("failed to fetch document retention: %w", err)
+	}
+	if expiresAt.Valid {
+		retention.ExpiresAt = &expiresAt.Time
+	}
+	if days.Valid {
+		n := int(days.Int64)
+		retention.RetentionDays = &n
+	}
+	if expiry.Valid {
+		retention.ExpiryAt = &expiry.Time
+	}
+	if expiredAt.Valid {
+		retention.ExpiredAt = &expiredAt.Time
+		purgeAt := expiredAt.Time.Add(h.retentionGrace)
+		retention.PurgeAt = &purgeAt
+	}
+	return retention, nil
+}
+
+// SetRetentionGrace keeps expired documents in the trash for grace before
+// ExpireDocuments purges them
+func (h *Handler) SetRetentionGrace(grace time.Duration) {
+	h.retentionGrace = grace
+}
+
+// ExpireDocuments moves expired documents to the trash and purges those
+// that have been there for longer than the grace period. Both are recorded
+// in retention_audit.
+func (h *Handler) ExpireDocuments(ctx context.Context) error {
+	var errs []error
+	if err := h.archiveExpiredDocuments(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to expire documents: %w", err))
+	}
+	if err := h.purgeExpiredDocuments(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to purge expired documents: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// archiveExpiredDocuments moves the documents that have expired to the
+// trash, except those under legal hold
+func (h *Handler) archiveExpiredDocuments(ctx context.Context) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := h.clock.Now()
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE documents SET deleted_at = $1, expired_at = $1
+		WHERE deleted_at IS NULL AND NOT legal_hold AND `+documentExpiry+` <= $1
+		RETURNING id, title, owner_id, document_group_id,
+			CASE WHEN content_key IS NOT NULL THEN content_size ELSE octet_length(content) END
+	`, now)
+	if err != nil {
+		return fmt.Errorf("failed to move expired documents to the trash: %w", err)
+	}
+	var archived []models.Document
+	var sizes []int64
+	for rows.Next() {
+		var doc models.Document
+		var size int64
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.OwnerID, &doc.DocumentGroupID, &size); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan expired document: %w", err)
+		}
+		archived = append(archived, doc)
+		sizes = append(sizes, size)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to move expired documents to the trash: %w", err)
+	}
+	if err := recordRetention(ctx, tx, archived, retentionArchived, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, doc := range archived {
+		h.recordUsage(doc.OwnerID, -1, -sizes[i])
+		h.publishDocumentEvent(webhook.DocumentDeleted, doc.ID, doc.OwnerID)
+	}
+	if len(archived) > 0 {
+		log.Printf("Moved %d expired documents to the trash", len(archived))
+	}
+	return nil
+}
+
+// purgeExpiredDocuments deletes the documents that expired longer than the
+// grace period ago
+func (h *Handler) purgeExpiredDocuments(ctx context.Context) error {
+	now := h.clock.Now()
+	cutoff := now.Add(-h.retentionGrace)
+	if err := h.purgeAttachments(ctx, expiredBefore, cutoff); err != nil {
+		return err
+	}
+	purged, err := h.purgeDocuments(ctx, expiredBefore, cutoff)
+	if err != nil {
+		return err
+	}
+	if len(purged) == 0 {
+		return nil
+	}
+	log.Printf("Purged %d expired documents", len(purged))
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := recordRetention(ctx, tx, purged, retentionPurged, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordRetention writes what the retention job did to documents to
+// retention_audit
+func recordRetention(ctx context.Context, tx *sql.Tx, documents []models.Document, action string, at time.Time) error {
+	for _, doc := range documents {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO retention_audit (document_id, title, owner_id, document_group_id, action, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, doc.ID, doc.Title, doc.OwnerID, doc.DocumentGroupID, action, at); err != nil {
+			return fmt.Errorf("failed to record %s document %s: %w", action, doc.ID, err)
+		}
+	}
+	return nil
+}
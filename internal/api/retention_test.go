@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// TestSetDocumentRetention checks that setting an expiry is authorized as
+// deleting the document, so an editor who may update it is still denied,
+// and that an expiry in the past is rejected
+func TestSetDocumentRetention(t *testing.T) {
+	authorizer, err := cedar.NewAuthorizer(cedar.WithEntityProvider(cedar.StaticEntityProvider{
+		Documents: map[string]cedar.Document{
+			"doc-1": {ID: "doc-1", OwnerID: "user-1", GroupID: "doc-group-sales", Classification: "internal"},
+		},
+		GroupAssociations: map[string][]string{
+			"doc-group-sales": {"user-group-sales"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewFake(time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC))
+	h := NewHandler(nil, authorizer, clk)
+	router := chi.NewRouter()
+	router.With(authorizer.Require("DeleteDocument", cedar.URLParam("documentId"))).
+		Put("/documents/{documentId}/retention", h.SetDocumentRetention)
+
+	editor := cedar.AuthzRequest{UserID: "user-2", UserRole: "editor", UserGroupID: "user-group-sales",
+		Action: "UpdateDocument", ResourceID: "doc-1", IPAddress: "10.0.0.1", IsPrivateIP: true}
+	decision, err := authorizer.Authorize(context.Background(), editor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Allowed {
+		t.Fatalf("editor may not update doc-1: %+v", decision)
+	}
+
+	tests := []struct {
+		name      string
+		userID    string
+		expiresAt string
+		status    int
+	}{
+		{"editor who does not own it", "user-2", "2026-12-31T00:00:00Z", http.StatusForbidden},
+		{"owner with a past expiry", "user-1", "2026-01-05T09:00:00Z", http.StatusBadRequest},
+		{"owner with the current time", "user-1", "2026-01-05T10:00:00Z", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.NewReader(`{"expires_at":"` + tt.expiresAt + `"}`)
+			r := httptest.NewRequest(http.MethodPut, "/documents/doc-1/retention", body)
+			r.RemoteAddr = "10.0.0.1:40000"
+			r.Header.Set("X-User-ID", tt.userID)
+			r.Header.Set("X-User-Role", "editor")
+			r.Header.Set("X-User-Group-ID", "user-group-sales")
+			r.Header.Set("X-MFA-Verified", "true")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
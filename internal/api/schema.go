@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// GetSchema handles returning the Cedar schema, in the JSON schema format
+// or, with format=cedar, in the Cedar schema format
+func (h *Handler) GetSchema(w http.ResponseWriter, r *http.Request) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		data, err := cedar.SchemaJSON()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to convert schema: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	case "cedar":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(cedar.SchemaText())
+	default:
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid format: %s", format))
+	}
+}
+
+// ListActions handles listing the actions requests can name, with the
+// principal and resource types and the context attributes each accepts
+func (h *Handler) ListActions(w http.ResponseWriter, r *http.Request) {
+	actions, err := cedar.Actions()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read schema: %v", err))
+		return
+	}
+
+	response := models.ActionCatalogResponse{
+		Actions: make([]models.ActionInfo, 0, len(actions)),
+	}
+	for _, a := range actions {
+		info := models.ActionInfo{
+			Name:           a.Name,
+			Groups:         a.Groups,
+			PrincipalTypes: a.PrincipalTypes,
+			ResourceTypes:  a.ResourceTypes,
+			Collection:     a.Collection,
+			Context:        make([]models.ContextAttribute, 0, len(a.Context)),
+		}
+		if info.Groups == nil {
+			info.Groups = []string{}
+		}
+		for _, attr := range a.Context {
+			info.Context = append(info.Context, models.ContextAttribute{
+				Name:     attr.Name,
+				Type:     attr.Type,
+				Required: attr.Required,
+			})
+		}
+		response.Actions = append(response.Actions, info)
+	}
+	respondJSON(w, http.StatusOK, response)
+}
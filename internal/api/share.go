@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// DocumentSharer grants single users access to single documents. It is
+// implemented by *cedar.Authorizer.
+type DocumentSharer interface {
+	ShareDocument(ctx context.Context, documentID, userID string, access cedar.ShareAccess) (cedar.TemplateLink, error)
+}
+
+// ShareDocument handles granting a user read or write access to a
+// document. The caller has been authorized for ShareDocument by the route
+// middleware.
+func (h *Handler) ShareDocument(w http.ResponseWriter, r *http.Request) {
+	if h.sharer == nil {
+		respondError(w, http.StatusNotImplemented, "Sharing is not available")
+		return
+	}
+	documentID := chi.URLParam(r, "documentId")
+
+	var input models.ShareInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.Access == "" {
+		input.Access = string(cedar.ShareRead)
+	}
+
+	link, err := h.sharer.ShareDocument(r.Context(), documentID, input.UserID, cedar.ShareAccess(input.Access))
+	if errors.Is(err, cedar.ErrInvalidRequest) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, cedar.ErrTemplateNotFound) {
+		respondError(w, http.StatusNotImplemented, "Sharing templates are not installed")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to share document: %v", err))
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, models.DocumentShare{
+		ID:         link.ID,
+		DocumentID: documentID,
+		UserID:     input.UserID,
+		Access:     input.Access,
+	})
+}
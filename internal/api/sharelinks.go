@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// defaultShareLinkTTL is how long a share link is valid unless the request
+// says otherwise
+const defaultShareLinkTTL = 24 * time.Hour
+
+// SetShareLinks enables public share links, whose tokens are signed with
+// secret and valid for at most maxTTL
+func (h *Handler) SetShareLinks(secret []byte, maxTTL time.Duration) {
+	h.shareLinkSecret = secret
+	h.shareLinkMaxTTL = maxTTL
+}
+
+// shareLinkToken returns the token of the link id expiring at expires: the
+// ID and expiry, signed so that neither can be changed
+func (h *Handler) shareLinkToken(id string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + h.signShareLink(payload)
+}
+
+// signShareLink returns the signature of a share link token payload
+func (h *Handler) signShareLink(payload string) string {
+	mac := hmac.New(sha256.New, h.shareLinkSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseShareLinkToken returns the link ID of a token signed by
+// shareLinkToken, and whether the token has not expired
+func (h *Handler) parseShareLinkToken(token string) (id string, unexpired bool, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false, errors.New("invalid share link token")
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(h.signShareLink(payload))) {
+		return "", false, errors.New("invalid share link token")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", false, errors.New("invalid share link token")
+	}
+	return parts[0], h.clock.Now().Unix() < expires, nil
+}
+
+// authorizeShareLink decides whether the document documentID may be read
+// through a public link on behalf of userID, as the user named by its
+// role and user group, from the client of r
+func (h *Handler) authorizeShareLink(r *http.Request, documentID, userID, role, group string) (cedar.AuthzDecision, error) {
+	req := cedar.RequestFromHTTP(r, "GetDocument", documentID)
+	req.UserID = userID
+	req.UserRole = role
+	req.UserGroupID = group
+	// Whoever holds the link has not signed in as its creator
+	req.MFAVerified = false
+	req.PublicLink = true
+	return h.authorizer.Authorize(r.Context(), req)
+}
+
+// CreateShareLink handles creating a public link to a document. The link
+// reads the document on behalf of the caller, so it is refused unless the
+// caller could read it through a link now. The caller has been authorized
+// for ShareDocument by the route middleware.
+func (h *Handler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	if h.shareLinkSecret == nil {
+		respondError(w, http.StatusNotImplemented, "Share links are not available")
+		return
+	}
+	documentID := chi.URLParam(r, "documentId")
+
+	var input models.ShareLinkInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	ttl := min(defaultShareLinkTTL, h.shareLinkMaxTTL)
+	if input.ExpiresIn != "" {
+		d, err := time.ParseDuration(input.ExpiresIn)
+		var v validator
+		switch {
+		case err != nil:
+			v.add("expires_in", fieldInvalidValue, "expires_in must be a duration such as 24h")
+		case d <= 0 || d > h.shareLinkMaxTTL:
+			v.add("expires_in", fieldInvalidValue, fmt.Sprintf("expires_in must be between 0 and %s", h.shareLinkMaxTTL))
+		}
+		if err := v.err(); err != nil {
+			respondValidationError(w, err)
+			return
+		}
+		ttl = d
+	}
+
+	userID := r.Header.Get("X-User-ID")
+	role := r.Header.Get("X-User-Role")
+	group := r.Header.Get("X-User-Group-ID")
+	decision, err := h.authorizeShareLink(r, documentID, userID, role, group)
+	if err != nil {
+		respondCheckError(w, err)
+		return
+	}
+	if !decision.Allowed {
+		respondDenied(w, decision)
+		return
+	}
+
+	id, err := newShareLinkID()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	now := h.clock.Now()
+	link := models.ShareLink{
+		ID:         id,
+		DocumentID: documentID,
+		CreatedBy:  userID,
+		CreatedAt:  now,
+		// Tokens carry whole seconds
+		ExpiresAt: now.Add(ttl).Truncate(time.Second),
+	}
+	_, err = h.db.ExecContext(r.Context(), `
+		INSERT INTO share_links (id, document_id, created_by, created_role, created_group, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, link.ID, link.DocumentID, link.CreatedBy, role, group, link.CreatedAt, link.ExpiresAt)
+	if isForeignKeyViolation(err) {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	link.Token = h.shareLinkToken(link.ID, link.ExpiresAt)
+	link.URL = "/api/v1/shared/" + link.Token
+	respondJSON(w, http.StatusCreated, link)
+}
+
+// ListShareLinks handles listing the share links of a document, newest
+// first, including expired and revoked ones. Their tokens are not shown.
+// The caller has been authorized for ShareDocument by the route
+// middleware.
+func (h *Handler) ListShareLinks(w http.ResponseWriter, r *http.Request) {
+	if h.shareLinkSecret == nil {
+		respondError(w, http.StatusNotImplemented, "Share links are not available")
+		return
+	}
+	documentID := chi.URLParam(r, "documentId")
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT id, document_id, created_by, created_at, expires_at, revoked_at
+		FROM share_links
+		WHERE document_id = $1
+		ORDER BY created_at DESC, id
+	`, documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.ShareLinksResponse{DocumentID: documentID, Links: []models.ShareLink{}}
+	for rows.Next() {
+		var link models.ShareLink
+		if err := rows.Scan(&link.ID, &link.DocumentID, &link.CreatedBy, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.Links = append(response.Links, link)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// RevokeShareLink handles revoking a share link of a document, so that its
+// token no longer opens the document. Revoking a revoked link succeeds.
+// The caller has been authorized for ShareDocument by the route
+// middleware.
+func (h *Handler) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	if h.shareLinkSecret == nil {
+		respondError(w, http.StatusNotImplemented, "Share links are not available")
+		return
+	}
+	res, err := h.db.ExecContext(r.Context(), `
+		UPDATE share_links SET revoked_at = COALESCE(revoked_at, $1)
+		WHERE id = $2 AND document_id = $3
+	`, h.clock.Now(), chi.URLParam(r, "linkId"), chi.URLParam(r, "documentId"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "Share link not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeShareLinks deletes the share links that expired or were revoked
+// more than retention ago
+func (h *Handler) PurgeShareLinks(ctx context.Context, retention time.Duration) error {
+	res, err := h.db.ExecContext(ctx, `
+		DELETE FROM share_links WHERE LEAST(expires_at, revoked_at) < $1
+	`, h.clock.Now().Add(-retention))
+	if err != nil {
+		return fmt.Errorf("failed to purge share links: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Purged %d expired share links", n)
+	}
+	return nil
+}
+
+// GetSharedDocument handles reading a document through a public share
+// link, without signing in. The request is authorized for GetDocument on
+// behalf of the link's creator, with their current role and user group if
+// they are recorded, and with "is_public_link" set, so the link stops
+// working when the creator loses access or the policies forbid sharing the
+// document by link.
+func (h *Handler) GetSharedDocument(w http.ResponseWriter, r *http.Request) {
+	if h.shareLinkSecret == nil {
+		respondError(w, http.StatusNotImplemented, "Share links are not available")
+		return
+	}
+	id, unexpired, err := h.parseShareLinkToken(chi.URLParam(r, "token"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Share link not found")
+		return
+	}
+	if !unexpired {
+		respondError(w, http.StatusGone, "The share link has expired")
+		return
+	}
+
+	var documentID string
+	var revokedAt *time.Time
+	creator := http.Header{}
+	var userID, role, group string
+	err = h.db.QueryRowContext(r.Context(), `
+		SELECT l.document_id, l.created_by, l.created_role, l.created_group, l.revoked_at
+		FROM share_links l
+		JOIN documents d ON d.id = l.document_id
+		WHERE l.id = $1 AND d.deleted_at IS NULL
+	`, id).Scan(&documentID, &userID, &role, &group, &revokedAt)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Share link not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if revokedAt != nil {
+		respondError(w, http.StatusGone, "The share link has been revoked")
+		return
+	}
+
+	creator.Set("X-User-ID", userID)
+	creator.Set("X-User-Role", role)
+	if group != "" {
+		creator.Set("X-User-Group-ID", group)
+	}
+	err = h.resolveUser(r.Context(), creator)
+	var notMember notGroupMemberError
+	if errors.As(err, &notMember) {
+		respondError(w, http.StatusForbidden, "The creator of the share link can no longer read the document")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load user: %v", err))
+		return
+	}
+	decision, err := h.authorizeShareLink(r, documentID, userID, creator.Get("X-User-Role"), creator.Get("X-User-Group-ID"))
+	if err != nil {
+		respondCheckError(w, err)
+		return
+	}
+	if !decision.Allowed {
+		respondDenied(w, decision)
+		return
+	}
+
+	doc, err := h.fetchDocument(r.Context(), documentID)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Share link not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	setDocumentETag(w, doc)
+	respondJSON(w, http.StatusOK, doc)
+}
+
+// newShareLinkID returns a random share link ID
+func newShareLinkID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate share link ID: %w", err)
+	}
+	return "lnk-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar/cedartest"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// TestShareLinkTokenExpiry checks that a share link token is valid until
+// the moment it expires, as told by the handler's clock
+func TestShareLinkTokenExpiry(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC))
+	h := NewHandler(nil, cedartest.NewMockAuthorizer(), clk)
+	h.SetShareLinks([]byte("test-secret"), 24*time.Hour)
+	token := h.shareLinkToken("link-1", clk.Now().Add(time.Hour))
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		unexpired bool
+	}{
+		{"just issued", 0, true},
+		{"a second before expiry", time.Hour - time.Second, true},
+		{"at expiry", time.Second, false},
+		{"after expiry", time.Hour, false},
+	}
+	for _, step := range steps {
+		clk.Advance(step.advance)
+		id, unexpired, err := h.parseShareLinkToken(token)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if id != "link-1" {
+			t.Errorf("%s: link %q, want link-1", step.name, id)
+		}
+		if unexpired != step.unexpired {
+			t.Errorf("%s: unexpired %t, want %t", step.name, unexpired, step.unexpired)
+		}
+	}
+
+	if _, _, err := h.parseShareLinkToken(token[:len(token)-1] + "x"); err == nil {
+		t.Error("token with a changed signature was accepted")
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// Simulator decides hypothetical requests without side effects. It is
+// implemented by *cedar.Authorizer.
+type Simulator interface {
+	Simulate(ctx context.Context, req cedar.AuthzRequest, doc *cedar.Document) (cedar.Simulation, error)
+}
+
+// SimulateAuthorization handles an admin's what-if authorization request.
+// The principal, resource, and context are taken from the body, and the
+// response lists the policies that decided it. The caller has been
+// authorized for SimulateAuthorization by the route middleware.
+func (h *Handler) SimulateAuthorization(w http.ResponseWriter, r *http.Request) {
+	if h.simulator == nil {
+		respondError(w, http.StatusNotImplemented, "Simulation is not available")
+		return
+	}
+
+	var input models.AuthzSimulateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req, err := checkRequest(input.Principal, input.Action, input.Resource.ID, input.Context)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var doc *cedar.Document
+	if res := input.Resource; res.OwnerID != "" {
+		if res.Classification == "" {
+			res.Classification = defaultClassification
+		}
+		if !validClassification(res.Classification) {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid classification: %s", res.Classification))
+			return
+		}
+		doc = &cedar.Document{
+			ID:             res.ID,
+			OwnerID:        res.OwnerID,
+			GroupID:        res.GroupID,
+			Classification: res.Classification,
+			Tags:           res.Tags,
+			Metadata:       res.Metadata,
+		}
+	}
+
+	sim, err := h.simulator.Simulate(r.Context(), req, doc)
+	if err != nil {
+		respondCheckError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.AuthzSimulateResponse{
+		Decision:    decisionName(sim.Decision),
+		Allowed:     sim.Decision.Allowed,
+		Diagnostics: diagnostics(sim.Decision),
+		Policies:    firedPolicies(sim.Policies),
+		Overridden:  firedPolicies(sim.Overridden),
+		Explanation: sim.Explanation,
+	})
+}
+
+// firedPolicies describes policies with their source position
+func firedPolicies(fired []cedar.FiredPolicy) []models.FiredPolicy {
+	policies := make([]models.FiredPolicy, 0, len(fired))
+	for _, p := range fired {
+		policies = append(policies, models.FiredPolicy{
+			ID:       p.ID,
+			Effect:   p.Effect,
+			Position: fmt.Sprintf("%s:%d", p.Position.Filename, p.Position.Line),
+			Source:   p.Source,
+		})
+	}
+	return policies
+}
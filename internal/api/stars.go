@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// StarDocument handles starring a document for the caller. Starring a
+// starred document succeeds. The caller has been authorized for
+// GetDocument by the route middleware.
+func (h *Handler) StarDocument(w http.ResponseWriter, r *http.Request) {
+	_, err := h.db.ExecContext(r.Context(), `
+		INSERT INTO document_stars (user_id, document_id, starred_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, document_id) DO NOTHING
+	`, r.Header.Get("X-User-ID"), chi.URLParam(r, "documentId"), h.clock.Now())
+	if isForeignKeyViolation(err) {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnstarDocument handles removing the caller's star from a document.
+// Unstarring a document that is not starred succeeds. The caller has been
+// authorized for GetDocument by the route middleware.
+func (h *Handler) UnstarDocument(w http.ResponseWriter, r *http.Request) {
+	_, err := h.db.ExecContext(r.Context(), `
+		DELETE FROM document_stars WHERE user_id = $1 AND document_id = $2
+	`, r.Header.Get("X-User-ID"), chi.URLParam(r, "documentId"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListStarredDocuments handles listing the documents the caller has
+// starred and may still read, with the same order, paging, and filters as
+// the document list. The caller has been authorized for ListDocuments on
+// the collection by the route middleware.
+func (h *Handler) ListStarredDocuments(w http.ResponseWriter, r *http.Request) {
+	page, err := parseDocumentPage(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	narrow, err := parseDocumentFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	narrow.starredBy = r.Header.Get("X-User-ID")
+
+	// Starred documents may have been granted rather than listable
+	documents, err := h.findDocuments(r, "GetDocument", narrow, page)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.respondDocuments(w, r, page, page.response(documents))
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// statsIntervals are the values accepted by the interval parameter
+var statsIntervals = map[string]bool{"day": true, "week": true, "month": true}
+
+// GetDocumentStats handles reporting how many documents there are by
+// owner, by document group, and by when they were created. Documents in
+// the trash are not counted. The owner_id, document_group_id,
+// created_after, and created_before parameters narrow the documents
+// counted as they narrow the document list, and interval sets the length
+// of the creation periods. The caller has been authorized for
+// ViewDocumentStats on the collection by the route middleware, since the
+// counts cover documents the caller may not see.
+func (h *Handler) GetDocumentStats(w http.ResponseWriter, r *http.Request) {
+	narrow, err := parseDocumentFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	if !statsIntervals[interval] {
+		respondError(w, http.StatusBadRequest, "interval must be day, week, or month")
+		return
+	}
+	where, args := narrow.sql("TRUE", nil)
+
+	response := models.DocumentStatsResponse{
+		ByOwner:         []models.OwnerCount{},
+		ByDocumentGroup: []models.DocumentGroupCount{},
+		Interval:        interval,
+		Created:         []models.PeriodCount{},
+	}
+	// Count from one snapshot so that the breakdowns add up to the total
+	tx, err := h.db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(r.Context(), `
+		SELECT COUNT(*) FROM documents WHERE `+where, args...).Scan(&response.Total); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	err = countDocuments(r.Context(), tx, `
+		SELECT owner_id, COUNT(*) FROM documents WHERE `+where+`
+		GROUP BY owner_id ORDER BY COUNT(*) DESC, owner_id
+	`, args, func(rows *sql.Rows) error {
+		var c models.OwnerCount
+		if err := rows.Scan(&c.OwnerID, &c.Count); err != nil {
+			return err
+		}
+		response.ByOwner = append(response.ByOwner, c)
+		return nil
+	})
+	if err == nil {
+		err = countDocuments(r.Context(), tx, `
+			SELECT document_group_id, COUNT(*) FROM documents WHERE `+where+`
+			GROUP BY document_group_id ORDER BY COUNT(*) DESC, document_group_id NULLS LAST
+		`, args, func(rows *sql.Rows) error {
+			var c models.DocumentGroupCount
+			var groupID sql.NullString
+			if err := rows.Scan(&groupID, &c.Count); err != nil {
+				return err
+			}
+			if groupID.Valid {
+				c.DocumentGroupID = &groupID.String
+			}
+			response.ByDocumentGroup = append(response.ByDocumentGroup, c)
+			return nil
+		})
+	}
+	if err == nil {
+		// interval is one of statsIntervals, never caller input
+		err = countDocuments(r.Context(), tx, `
+			SELECT date_trunc('`+interval+`', created_at) AS start, COUNT(*) FROM documents WHERE `+where+`
+			GROUP BY start ORDER BY start
+		`, args, func(rows *sql.Rows) error {
+			var c models.PeriodCount
+			if err := rows.Scan(&c.Start, &c.Count); err != nil {
+				return err
+			}
+			response.Created = append(response.Created, c)
+			return nil
+		})
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// countDocuments runs an aggregate query and passes each row to scan
+func countDocuments(ctx context.Context, tx *sql.Tx, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/lib/pq"
+)
+
+// NewDocumentAuthorizer decides requests on documents that are about to be
+// created. It is implemented by *cedar.Authorizer.
+type NewDocumentAuthorizer interface {
+	AuthorizeNewDocument(ctx context.Context, req cedar.AuthzRequest, doc cedar.Document) (cedar.AuthzDecision, error)
+}
+
+// templateColumns are the columns read by scanTemplate
+const templateColumns = `id, document_group_id, name, title, content, classification, tags, created_by, created_at, updated_at`
+
+// scanTemplate scans a row selected with templateColumns
+func scanTemplate(row interface{ Scan(...any) error }) (models.DocumentTemplate, error) {
+	var t models.DocumentTemplate
+	err := row.Scan(&t.ID, &t.DocumentGroupID, &t.Name, &t.Title, &t.Content, &t.Classification, pq.Array(&t.Tags), &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+// validTemplateInput fills in the defaults of input, checks it, and
+// sanitizes its content, returning a validationError if it is invalid.
+// Unlike a document, a template may leave the title empty.
+func (h *Handler) validTemplateInput(input *models.DocumentTemplateInput) error {
+	if input.Classification == "" {
+		input.Classification = defaultClassification
+	}
+	if input.Tags == nil {
+		input.Tags = []string{}
+	}
+	var v validator
+	v.text("name", input.Name, maxNameLength, true, false)
+	v.text("title", input.Title, h.titleLimit, false, false)
+	v.content("content", input.Content, h.contentLimit)
+	v.classification("classification", input.Classification)
+	v.tags("tags", input.Tags)
+	if err := v.err(); err != nil {
+		return err
+	}
+	input.Content = h.sanitizeContent(input.Content)
+	return nil
+}
+
+// ListDocumentTemplates handles listing the templates of a document group.
+// The caller has been authorized for ManageDocumentGroups by the route
+// middleware, as for every template endpoint.
+func (h *Handler) ListDocumentTemplates(w http.ResponseWriter, r *http.Request) {
+	groupID := chi.URLParam(r, "groupId")
+
+	var exists bool
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM document_groups WHERE id = $1)
+	`, groupID).Scan(&exists)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !exists {
+		respondError(w, http.StatusNotFound, "Document group not found")
+		return
+	}
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT `+templateColumns+` FROM document_templates WHERE document_group_id = $1 ORDER BY name, id
+	`, groupID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.DocumentTemplatesResponse{DocumentGroupID: groupID, Templates: []models.DocumentTemplate{}}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.Templates = append(response.Templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// CreateDocumentTemplate handles adding a template to a document group
+func (h *Handler) CreateDocumentTemplate(w http.ResponseWriter, r *http.Request) {
+	var input models.DocumentTemplateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := h.validTemplateInput(&input); err != nil {
+		respondValidationError(w, err)
+		return
+	}
+
+	id, err := newTemplateID()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	now := h.clock.Now()
+	t := models.DocumentTemplate{
+		ID:              id,
+		DocumentGroupID: chi.URLParam(r, "groupId"),
+		Name:            input.Name,
+		Title:           input.Title,
+		Content:         input.Content,
+		Classification:  input.Classification,
+		Tags:            input.Tags,
+		CreatedBy:       r.Header.Get("X-User-ID"),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	_, err = h.db.ExecContext(r.Context(), `
+		INSERT INTO document_templates (id, document_group_id, name, title, content, classification, tags, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, t.ID, t.DocumentGroupID, t.Name, t.Title, t.Content, t.Classification, pq.Array(t.Tags), t.CreatedBy, t.CreatedAt, t.UpdatedAt)
+	if isForeignKeyViolation(err) {
+		respondError(w, http.StatusNotFound, "Document group not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusCreated, t)
+}
+
+// GetDocumentTemplate handles fetching a template of a document group
+func (h *Handler) GetDocumentTemplate(w http.ResponseWriter, r *http.Request) {
+	t, err := scanTemplate(h.db.QueryRowContext(r.Context(), `
+		SELECT `+templateColumns+` FROM document_templates WHERE id = $1 AND document_group_id = $2
+	`, chi.URLParam(r, "templateId"), chi.URLParam(r, "groupId")))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Template not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, t)
+}
+
+// UpdateDocumentTemplate handles replacing a template of a document group.
+// Documents already created from it are not changed.
+func (h *Handler) UpdateDocumentTemplate(w http.ResponseWriter, r *http.Request) {
+	var input models.DocumentTemplateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := h.validTemplateInput(&input); err != nil {
+		respondValidationError(w, err)
+		return
+	}
+
+	t, err := scanTemplate(h.db.QueryRowContext(r.Context(), `
+		UPDATE document_templates
+		SET name = $1, title = $2, content = $3, classification = $4, tags = $5, updated_at = $6
+		WHERE id = $7 AND document_group_id = $8
+		RETURNING `+templateColumns+`
+	`, input.Name, input.Title, input.Content, input.Classification, pq.Array(input.Tags), h.clock.Now(), chi.URLParam(r, "templateId"), chi.URLParam(r, "groupId")))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Template not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, t)
+}
+
+// DeleteDocumentTemplate handles deleting a template of a document group.
+// Documents already created from it are kept.
+func (h *Handler) DeleteDocumentTemplate(w http.ResponseWriter, r *http.Request) {
+	res, err := h.db.ExecContext(r.Context(), `
+		DELETE FROM document_templates WHERE id = $1 AND document_group_id = $2
+	`, chi.URLParam(r, "templateId"), chi.URLParam(r, "groupId"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "Template not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyDocumentTemplate fills in the fields input leaves empty from the
+// template named by the template_id query parameter, if any, and returns
+// the template's document group, which the document is created in. It
+// responds and returns false if the template cannot be used.
+func (h *Handler) applyDocumentTemplate(w http.ResponseWriter, r *http.Request, input *models.DocumentInput) (sql.NullString, bool) {
+	templateID := r.URL.Query().Get("template_id")
+	if templateID == "" {
+		return sql.NullString{}, true
+	}
+	if h.newDocuments == nil {
+		respondError(w, http.StatusNotImplemented, "Document templates are not available")
+		return sql.NullString{}, false
+	}
+
+	t, err := scanTemplate(h.db.QueryRowContext(r.Context(), `
+		SELECT `+templateColumns+` FROM document_templates WHERE id = $1
+	`, templateID))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown template: %s", templateID))
+		return sql.NullString{}, false
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return sql.NullString{}, false
+	}
+
+	if input.Title == "" {
+		input.Title = t.Title
+	}
+	if input.Content == "" {
+		input.Content = t.Content
+	}
+	if input.Classification == "" {
+		input.Classification = t.Classification
+	}
+	if input.Tags == nil {
+		input.Tags = t.Tags
+	}
+	return sql.NullString{String: t.DocumentGroupID, Valid: true}, true
+}
+
+// authorizeNewDocument checks that the caller may create doc, which the
+// route middleware could only check against the collection since the
+// document does not exist yet. It responds and returns false if not.
+func (h *Handler) authorizeNewDocument(w http.ResponseWriter, r *http.Request, doc models.Document) bool {
+	decision, err := h.newDocuments.AuthorizeNewDocument(r.Context(), cedar.RequestFromHTTP(r, "CreateDocument", doc.ID), cedar.Document{
+		ID:             doc.ID,
+		OwnerID:        doc.OwnerID,
+		GroupID:        doc.DocumentGroupID.String,
+		Classification: doc.Classification,
+		Tags:           doc.Tags,
+		Metadata:       cedar.MetadataTags(doc.Metadata),
+	})
+	if err != nil {
+		respondCheckError(w, err)
+		return false
+	}
+	if !decision.Allowed {
+		respondDenied(w, decision)
+		return false
+	}
+	return true
+}
+
+// newTemplateID returns a random template ID
+func newTemplateID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate template ID: %w", err)
+	}
+	return "tpl-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/lib/pq"
+)
+
+// ListTrash handles listing the deleted documents the caller may restore,
+// with the same order, paging, and filters as the document list. The
+// caller has been authorized for ListDocuments on the collection by the
+// route middleware.
+func (h *Handler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	h.listDocuments(w, r, "RestoreDocument", true)
+}
+
+// RestoreDocument handles taking a document out of the trash
+func (h *Handler) RestoreDocument(w http.ResponseWriter, r *http.Request) {
+	var doc models.Document
+	var stored storedContent
+	err := h.db.QueryRowContext(r.Context(), `
+		UPDATE documents SET deleted_at = NULL, expired_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, title, content, content_key, content_size, owner_id, document_group_id, classification, tags, metadata, created_at, updated_at
+	`, chi.URLParam(r, "documentId")).Scan(&doc.ID, &doc.Title, &doc.Content, &stored.key, &stored.size, &doc.OwnerID, &doc.DocumentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.Metadata, &doc.CreatedAt, &doc.UpdatedAt)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusConflict, "Document is not in the trash")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	h.recordUsage(doc.OwnerID, 1, stored.bytes(doc.Content))
+	if err := h.loadContent(r.Context(), &doc.Content, stored); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	setDocumentETag(w, doc)
+	respondJSON(w, http.StatusOK, doc)
+}
+
+// PurgeTrash permanently deletes the documents that have been in the trash
+// for longer than retention
+func (h *Handler) PurgeTrash(ctx context.Context, retention time.Duration) error {
+	cutoff := h.clock.Now().Add(-retention)
+	if err := h.purgeAttachments(ctx, trashedBefore, cutoff); err != nil {
+		return fmt.Errorf("failed to purge trash: %w", err)
+	}
+	purged, err := h.purgeDocuments(ctx, trashedBefore, cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to purge trash: %w", err)
+	}
+	if len(purged) > 0 {
+		log.Printf("Purged %d documents from the trash", len(purged))
+	}
+	return nil
+}
+
+// Conditions on documents selecting those to purge before the time $1.
+// Documents moved to the trash when they expired are purged after the
+// retention grace period instead of the trash retention. Documents under
+// legal hold are never purged.
+const (
+	trashedBefore = "deleted_at < $1 AND expired_at IS NULL AND NOT legal_hold"
+	expiredBefore = "deleted_at IS NOT NULL AND expired_at < $1 AND NOT legal_hold"
+)
+
+// purgeDocuments deletes the documents matching where, one of the purge
+// conditions, with their versions, and then the content of both kept in
+// storage. It returns the purged documents without their content.
+func (h *Handler) purgeDocuments(ctx context.Context, where string, cutoff time.Time) ([]models.Document, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	purged, keys, err := deleteDocuments(ctx, tx, where, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	h.removeStoredObjects(ctx, keys)
+	return purged, nil
+}
+
+// deleteDocuments deletes the documents matching where, given args, with
+// their versions. It returns the deleted documents without their content,
+// and the keys of the content of both kept in storage, which the caller
+// removes once tx is committed.
+func deleteDocuments(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]models.Document, []string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT content_key FROM documents
+		WHERE `+where+` AND content_key IS NOT NULL
+		UNION
+		SELECT content_key FROM document_versions
+		WHERE document_id IN (SELECT id FROM documents WHERE `+where+`) AND content_key IS NOT NULL
+	`, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query stored content: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan stored content: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to query stored content: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		DELETE FROM documents WHERE `+where+`
+		RETURNING id, title, owner_id, document_group_id, deleted_at
+	`, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	deleted := []models.Document{}
+	for rows.Next() {
+		var doc models.Document
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.OwnerID, &doc.DocumentGroupID, &doc.DeletedAt); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan deleted document: %w", err)
+		}
+		deleted = append(deleted, doc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	return deleted, keys, nil
+}
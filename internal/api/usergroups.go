@@ -0,0 +1,225 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the Postgres error code for a duplicate key
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is a duplicate key error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// ListUserGroups handles listing the user groups. The caller has been
+// authorized for ManageUserGroups by the route middleware, as for every
+// user group endpoint.
+func (h *Handler) ListUserGroups(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT id, name, created_at FROM user_groups ORDER BY id
+	`)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.UserGroupsResponse{UserGroups: []models.UserGroup{}}
+	for rows.Next() {
+		var g models.UserGroup
+		if err := rows.Scan(&g.ID, &g.Name, &g.CreatedAt); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.UserGroups = append(response.UserGroups, g)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// CreateUserGroup handles creating a user group
+func (h *Handler) CreateUserGroup(w http.ResponseWriter, r *http.Request) {
+	var input models.UserGroupInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.ID == "" || input.Name == "" {
+		respondError(w, http.StatusBadRequest, "id and name are required")
+		return
+	}
+
+	g := models.UserGroup{ID: input.ID, Name: input.Name, CreatedAt: h.clock.Now()}
+	_, err := h.db.ExecContext(r.Context(), `
+		INSERT INTO user_groups (id, name, created_at) VALUES ($1, $2, $3)
+	`, g.ID, g.Name, g.CreatedAt)
+	if isUniqueViolation(err) {
+		respondError(w, http.StatusConflict, fmt.Sprintf("User group %s already exists", g.ID))
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusCreated, g)
+}
+
+// GetUserGroup handles fetching a user group
+func (h *Handler) GetUserGroup(w http.ResponseWriter, r *http.Request) {
+	var g models.UserGroup
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT id, name, created_at FROM user_groups WHERE id = $1
+	`, chi.URLParam(r, "groupId")).Scan(&g.ID, &g.Name, &g.CreatedAt)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "User group not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, g)
+}
+
+// UpdateUserGroup handles renaming a user group
+func (h *Handler) UpdateUserGroup(w http.ResponseWriter, r *http.Request) {
+	var input models.UserGroupInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.Name == "" {
+		respondError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	var g models.UserGroup
+	err := h.db.QueryRowContext(r.Context(), `
+		UPDATE user_groups SET name = $1 WHERE id = $2
+		RETURNING id, name, created_at
+	`, input.Name, chi.URLParam(r, "groupId")).Scan(&g.ID, &g.Name, &g.CreatedAt)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "User group not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, g)
+}
+
+// DeleteUserGroup handles deleting a user group together with its members
+// and its associations with document groups
+func (h *Handler) DeleteUserGroup(w http.ResponseWriter, r *http.Request) {
+	res, err := h.db.ExecContext(r.Context(), `
+		DELETE FROM user_groups WHERE id = $1
+	`, chi.URLParam(r, "groupId"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "User group not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListUserGroupMembers handles listing the members of a user group
+func (h *Handler) ListUserGroupMembers(w http.ResponseWriter, r *http.Request) {
+	groupID := chi.URLParam(r, "groupId")
+	if !h.userGroupExists(w, r, groupID) {
+		return
+	}
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT user_id FROM user_group_members WHERE user_group_id = $1 ORDER BY user_id
+	`, groupID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.UserGroupMembersResponse{UserGroupID: groupID, Members: []string{}}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.Members = append(response.Members, userID)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// AddUserGroupMember handles adding a user to a user group. Adding a
+// member twice is not an error.
+func (h *Handler) AddUserGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID := chi.URLParam(r, "groupId")
+	if !h.userGroupExists(w, r, groupID) {
+		return
+	}
+
+	_, err := h.db.ExecContext(r.Context(), `
+		INSERT INTO user_group_members (user_group_id, user_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_group_id, user_id) DO NOTHING
+	`, groupID, chi.URLParam(r, "userId"), h.clock.Now())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveUserGroupMember handles removing a user from a user group
+func (h *Handler) RemoveUserGroupMember(w http.ResponseWriter, r *http.Request) {
+	res, err := h.db.ExecContext(r.Context(), `
+		DELETE FROM user_group_members WHERE user_group_id = $1 AND user_id = $2
+	`, chi.URLParam(r, "groupId"), chi.URLParam(r, "userId"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "Membership not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userGroupExists reports whether the user group exists and writes the
+// error response otherwise
+func (h *Handler) userGroupExists(w http.ResponseWriter, r *http.Request, groupID string) bool {
+	var exists bool
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM user_groups WHERE id = $1)
+	`, groupID).Scan(&exists)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return false
+	}
+	if !exists {
+		respondError(w, http.StatusNotFound, "User group not found")
+		return false
+	}
+	return true
+}
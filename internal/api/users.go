@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/lib/pq"
+)
+
+// userRoles are the roles a recorded user can have. An empty role leaves
+// the role to the X-User-Role header.
+var userRoles = []string{"", "admin", "group_admin", "editor", "viewer"}
+
+// ResolveUser is middleware that takes the caller's role and group from
+// the users table instead of trusting the headers alone. A recorded role
+// replaces X-User-Role. For users with recorded group memberships,
+// X-User-Group-ID must name one of them, and defaults to the only one.
+// Users who are not recorded are passed through unchanged.
+func (h *Handler) ResolveUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Clone()
+		err := h.resolveUser(r.Context(), header)
+		var notMember notGroupMemberError
+		if errors.As(err, &notMember) {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load user: %v", err))
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.Header = header
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notGroupMemberError is returned when a caller acts for a user group the
+// user is not recorded in
+type notGroupMemberError struct {
+	userID, group string
+}
+
+func (e notGroupMemberError) Error() string {
+	return fmt.Sprintf("User %s is not a member of %s", e.userID, e.group)
+}
+
+// resolveUser sets the X-User-Role and X-User-Group-ID of header from the
+// recorded user named by X-User-ID, as ResolveUser describes
+func (h *Handler) resolveUser(ctx context.Context, header http.Header) error {
+	userID := header.Get("X-User-ID")
+	if userID == "" {
+		return nil
+	}
+
+	user, err := h.loadUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Role != "" {
+		header.Set("X-User-Role", user.Role)
+	}
+	group := header.Get("X-User-Group-ID")
+	switch {
+	case len(user.Groups) == 0:
+	case group == "" && len(user.Groups) == 1:
+		header.Set("X-User-Group-ID", user.Groups[0])
+	case group != "" && !slices.Contains(user.Groups, group):
+		return notGroupMemberError{userID, group}
+	}
+	return nil
+}
+
+// ListUsers handles listing the recorded users. The caller has been
+// authorized for ManageUsers by the route middleware, as for every user
+// endpoint.
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT `+userColumns+`
+		FROM users
+		ORDER BY id
+	`)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.UsersResponse{Users: []models.User{}}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.Users = append(response.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// GetUser handles fetching a recorded user
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.loadUser(r.Context(), chi.URLParam(r, "userId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, user)
+}
+
+// PutUser handles creating or replacing a recorded user and their group
+// memberships
+func (h *Handler) PutUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	var input models.UserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !slices.Contains(userRoles, input.Role) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid role: %s", input.Role))
+		return
+	}
+	if input.EmploymentStatus == "" {
+		input.EmploymentStatus = "active"
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	var created bool
+	err = tx.QueryRowContext(r.Context(), `
+		INSERT INTO users (id, name, role, department, clearance_level, employment_status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, role = EXCLUDED.role, department = EXCLUDED.department,
+			clearance_level = EXCLUDED.clearance_level, employment_status = EXCLUDED.employment_status
+		RETURNING xmax = 0
+	`, userID, input.Name, input.Role, input.Department, input.ClearanceLevel, input.EmploymentStatus, h.clock.Now()).Scan(&created)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `
+		DELETE FROM user_group_members WHERE user_id = $1
+	`, userID); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	for _, group := range input.Groups {
+		_, err := tx.ExecContext(r.Context(), `
+			INSERT INTO user_group_members (user_group_id, user_id, created_at) VALUES ($1, $2, $3)
+			ON CONFLICT (user_group_id, user_id) DO NOTHING
+		`, group, userID, h.clock.Now())
+		if isForeignKeyViolation(err) {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown user group: %s", group))
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	user, err := h.loadUser(r.Context(), userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondJSON(w, status, user)
+}
+
+// DeleteUser handles deleting a recorded user and their group memberships.
+// Their documents are kept.
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(r.Context(), `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `
+		DELETE FROM user_group_members WHERE user_id = $1
+	`, userID); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userColumns are the columns read by scanUser
+const userColumns = `id, name, role, department, clearance_level, employment_status, created_at,
+	ARRAY(SELECT user_group_id FROM user_group_members WHERE user_id = users.id ORDER BY user_group_id)`
+
+// scanUser scans a row selected with userColumns
+func scanUser(row interface{ Scan(...any) error }) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Name, &user.Role, &user.Department, &user.ClearanceLevel, &user.EmploymentStatus, &user.CreatedAt, pq.Array(&user.Groups))
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.Groups == nil {
+		user.Groups = []string{}
+	}
+	return user, nil
+}
+
+// loadUser reads a recorded user with their group memberships, or returns
+// sql.ErrNoRows
+func (h *Handler) loadUser(ctx context.Context, userID string) (models.User, error) {
+	return scanUser(h.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id = $1
+	`, userID))
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// Limits on the fields of documents and templates. Titles and names are
+// counted in characters, as by their VARCHAR(500) columns, and content in
+// bytes. The title and content limits are the defaults of SetContentLimits.
+const (
+	maxTitleLength   = 500
+	maxNameLength    = 500
+	maxContentLength = 1 << 20
+	maxTags          = 50
+	maxTagLength     = 100
+)
+
+// Codes of field errors
+const (
+	fieldRequired         = "REQUIRED"
+	fieldTooLong          = "TOO_LONG"
+	fieldTooLarge         = "TOO_LARGE"
+	fieldTooMany          = "TOO_MANY"
+	fieldInvalidUTF8      = "INVALID_UTF8"
+	fieldInvalidCharacter = "INVALID_CHARACTER"
+	fieldInvalidValue     = "INVALID_VALUE"
+)
+
+// validationError is returned when fields of a request body are invalid
+type validationError []models.FieldError
+
+func (e validationError) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Extensions adds the field errors to the GraphQL error
+func (e validationError) Extensions() map[string]any {
+	return map[string]any{
+		"code":   "VALIDATION_FAILED",
+		"errors": []models.FieldError(e),
+	}
+}
+
+// fieldErrors returns the field errors of err if it is a validationError
+func fieldErrors(err error) []models.FieldError {
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		return invalid
+	}
+	return nil
+}
+
+// validationStatus returns the HTTP status of a validationError: 413 if
+// content is too large, and 422 otherwise
+func validationStatus(err error) int {
+	for _, fe := range fieldErrors(err) {
+		if fe.Code == fieldTooLarge {
+			return http.StatusRequestEntityTooLarge
+		}
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// respondValidationError answers a request body that failed validation:
+// with the status and field errors of a validationError, or with 400
+func respondValidationError(w http.ResponseWriter, err error) {
+	fields := fieldErrors(err)
+	if fields == nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := validationStatus(err)
+	respondJSON(w, status, models.ErrorResponse{
+		Error:   http.StatusText(status),
+		Message: "The request has invalid fields",
+		Errors:  fields,
+	})
+}
+
+// validator collects the field errors of a request body
+type validator struct {
+	errors validationError
+}
+
+// add records an error of field
+func (v *validator) add(field, code, message string) {
+	v.errors = append(v.errors, models.FieldError{Field: field, Code: code, Message: message})
+}
+
+// err returns the collected errors as a validationError, or nil
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
+
+// text checks that value is valid UTF-8 of at most max characters without
+// control characters, except for line breaks and tabs if multiline is set.
+// An empty value is an error if required is set.
+func (v *validator) text(field, value string, max int, required, multiline bool) {
+	if value == "" {
+		if required {
+			v.add(field, fieldRequired, fmt.Sprintf("%s is required", field))
+		}
+		return
+	}
+	if !v.utf8(field, value, multiline) {
+		return
+	}
+	if utf8.RuneCountInString(value) > max {
+		v.add(field, fieldTooLong, fmt.Sprintf("%s must be at most %d characters", field, max))
+	}
+}
+
+// content checks that value is valid UTF-8 text of at most max bytes
+func (v *validator) content(field, value string, max int) {
+	if !v.utf8(field, value, true) {
+		return
+	}
+	if len(value) > max {
+		v.add(field, fieldTooLarge, fmt.Sprintf("%s must be at most %d bytes", field, max))
+	}
+}
+
+// utf8 checks that value is valid UTF-8 without control characters, except
+// for line breaks and tabs if multiline is set, and reports whether it is
+func (v *validator) utf8(field, value string, multiline bool) bool {
+	if !utf8.ValidString(value) {
+		v.add(field, fieldInvalidUTF8, fmt.Sprintf("%s must be valid UTF-8", field))
+		return false
+	}
+	for _, c := range value {
+		if !unicode.IsControl(c) || (multiline && (c == '\n' || c == '\r' || c == '\t')) {
+			continue
+		}
+		v.add(field, fieldInvalidCharacter, fmt.Sprintf("%s must not contain control character %U", field, c))
+		return false
+	}
+	return true
+}
+
+// classification checks that value is a known document classification
+func (v *validator) classification(field, value string) {
+	if !validClassification(value) {
+		v.add(field, fieldInvalidValue, fmt.Sprintf("Invalid classification: %s", value))
+	}
+}
+
+// tags checks the number of tags and each tag
+func (v *validator) tags(field string, tags []string) {
+	if len(tags) > maxTags {
+		v.add(field, fieldTooMany, fmt.Sprintf("%s must have at most %d entries", field, maxTags))
+		return
+	}
+	for i, tag := range tags {
+		v.text(fmt.Sprintf("%s[%d]", field, i), tag, maxTagLength, true, false)
+	}
+}
+
+// ContentSanitizer cleans up the HTML in document content. It is
+// implemented by *bluemonday.Policy.
+type ContentSanitizer interface {
+	Sanitize(s string) string
+}
+
+// SetContentLimits limits document titles to maxTitle characters, at most
+// the 500 their column holds, and content to maxContent bytes
+func (h *Handler) SetContentLimits(maxTitle, maxContent int) {
+	h.titleLimit = min(maxTitle, maxTitleLength)
+	h.contentLimit = maxContent
+}
+
+// SetContentSanitizer sanitizes the content of documents and templates as
+// it is written
+func (h *Handler) SetContentSanitizer(sanitizer ContentSanitizer) {
+	h.sanitizer = sanitizer
+}
+
+// sanitizeContent returns content as it is to be written
+func (h *Handler) sanitizeContent(content string) string {
+	if h.sanitizer == nil {
+		return content
+	}
+	return h.sanitizer.Sanitize(content)
+}
+
+// checkDocument checks the fields of doc as it is about to be written and
+// sanitizes its content. The content is only touched if checkContent is
+// set, since content kept in storage is not loaded for every change. The
+// limits apply to the content as sent, before it is sanitized.
+func (h *Handler) checkDocument(doc *models.Document, checkContent bool) error {
+	var v validator
+	v.text("title", doc.Title, h.titleLimit, true, false)
+	if checkContent {
+		v.content("content", doc.Content, h.contentLimit)
+	}
+	v.classification("classification", doc.Classification)
+	v.tags("tags", doc.Tags)
+	v.metadata("metadata", doc.Metadata, nil)
+	if err := v.err(); err != nil {
+		return err
+	}
+	if checkContent {
+		doc.Content = h.sanitizeContent(doc.Content)
+	}
+	return nil
+}
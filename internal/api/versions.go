@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+	"github.com/lib/pq"
+)
+
+// ListDocumentVersions handles listing the earlier versions of a document,
+// newest first
+func (h *Handler) ListDocumentVersions(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentId")
+	versions, err := h.documentVersions(r.Context(), documentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, models.DocumentVersionsResponse{DocumentID: documentID, Versions: versions})
+}
+
+// documentVersions returns the earlier versions of a document, newest
+// first
+func (h *Handler) documentVersions(ctx context.Context, documentID string) ([]models.DocumentVersion, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+documentVersionColumns+`
+		FROM document_versions
+		WHERE document_id = $1
+		ORDER BY version DESC
+	`, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query document versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []models.DocumentVersion{}
+	for rows.Next() {
+		v, stored, err := scanDocumentVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan document version: %w", err)
+		}
+		if err := h.loadContent(ctx, &v.Content, stored); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query document versions: %w", err)
+	}
+	return versions, nil
+}
+
+// GetDocumentVersion handles fetching one earlier version of a document
+func (h *Handler) GetDocumentVersion(w http.ResponseWriter, r *http.Request) {
+	version, ok := versionParam(w, r, "version")
+	if !ok {
+		return
+	}
+	v, err := h.documentVersion(r.Context(), chi.URLParam(r, "documentId"), version)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, v)
+}
+
+// documentVersion returns an earlier version of a document with its
+// content, or sql.ErrNoRows if there is no such version
+func (h *Handler) documentVersion(ctx context.Context, documentID string, version int) (models.DocumentVersion, error) {
+	v, stored, err := scanDocumentVersion(h.db.QueryRowContext(ctx, `
+		SELECT `+documentVersionColumns+`
+		FROM document_versions
+		WHERE document_id = $1 AND version = $2
+	`, documentID, version))
+	if err == sql.ErrNoRows {
+		return models.DocumentVersion{}, err
+	}
+	if err != nil {
+		return models.DocumentVersion{}, fmt.Errorf("failed to fetch document version: %w", err)
+	}
+	if err := h.loadContent(ctx, &v.Content, stored); err != nil {
+		return models.DocumentVersion{}, err
+	}
+	return v, nil
+}
+
+// RestoreDocumentVersion handles bringing back the title, content,
+// classification, and tags of an earlier version. The state it replaces is
+// kept as a new version, so a restore can be undone like any update.
+// Bringing back another classification also requires ClassifyDocument, as
+// for updates.
+func (h *Handler) RestoreDocumentVersion(w http.ResponseWriter, r *http.Request) {
+	version, ok := versionParam(w, r, "version")
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	doc, docStored, err := lockDocument(r.Context(), tx, chi.URLParam(r, "documentId"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	err = h.checkDocumentLock(r.Context(), tx, doc.ID, r.Header.Get("X-User-ID"))
+	var locked documentLockedError
+	if errors.As(err, &locked) {
+		respondDocumentLocked(w, locked)
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	v, stored, err := scanDocumentVersion(tx.QueryRowContext(r.Context(), `
+		SELECT `+documentVersionColumns+`
+		FROM document_versions
+		WHERE document_id = $1 AND version = $2
+	`, doc.ID, version))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if v.Classification != doc.Classification {
+		err := h.authorizeDocument(r, "ClassifyDocument", doc.ID)
+		var denied accessDeniedError
+		if errors.As(err, &denied) {
+			respondDenied(w, denied.decision)
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	now := h.clock.Now()
+	if err := saveDocumentVersion(r.Context(), tx, doc, docStored, r.Header.Get("X-User-ID"), now); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	// Content in storage is shared with the version rather than copied
+	oldSize := docStored.bytes(doc.Content)
+	doc.Title = v.Title
+	doc.Content = v.Content
+	doc.Classification = v.Classification
+	doc.Tags = v.Tags
+	doc.UpdatedAt = now
+	if _, err := tx.ExecContext(r.Context(), `
+		UPDATE documents
+		SET title = $1, content = $2, content_key = $3, content_size = $4, classification = $5, tags = $6, updated_at = $7
+		WHERE id = $8
+	`, doc.Title, doc.Content, stored.key, stored.size, doc.Classification, pq.Array(doc.Tags), doc.UpdatedAt, doc.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	h.recordUsage(doc.OwnerID, 0, stored.bytes(doc.Content)-oldSize)
+	h.publishDocumentEvent(webhook.DocumentUpdated, doc.ID, doc.OwnerID)
+	if err := h.loadContent(r.Context(), &doc.Content, stored); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.hideUnreadableContent(r.Context(), cedar.RequestFromHTTP(r, "GetDocument", doc.ID), &doc); err != nil {
+		respondCheckError(w, err)
+		return
+	}
+
+	setDocumentETag(w, doc)
+	respondJSON(w, http.StatusOK, doc)
+}
+
+// versionParam parses the version in the URL parameter key, responding
+// with 400 if it is not a positive number
+func versionParam(w http.ResponseWriter, r *http.Request, key string) (int, bool) {
+	version, err := strconv.Atoi(chi.URLParam(r, key))
+	if err != nil || version < 1 {
+		respondError(w, http.StatusBadRequest, "Invalid version")
+		return 0, false
+	}
+	return version, true
+}
+
+// lockDocument reads a document and locks its row until tx ends, so
+// concurrent updates number their versions one after the other. Content
+// kept in storage is not loaded.
+func lockDocument(ctx context.Context, tx *sql.Tx, documentID string) (models.Document, storedContent, error) {
+	var doc models.Document
+	var stored storedContent
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, title, content, content_key, content_size, owner_id, document_group_id, classification, tags, metadata, created_at, updated_at
+		FROM documents
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, documentID).Scan(&doc.ID, &doc.Title, &doc.Content, &stored.key, &stored.size, &doc.OwnerID, &doc.DocumentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.Metadata, &doc.CreatedAt, &doc.UpdatedAt)
+	return doc, stored, err
+}
+
+// saveDocumentVersion keeps doc, with its content kept as stored says, as
+// it is before being overwritten by userID. The document must be locked
+// with lockDocument.
+func saveDocumentVersion(ctx context.Context, tx *sql.Tx, doc models.Document, stored storedContent, userID string, at time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO document_versions (document_id, version, title, content, content_key, content_size, classification, tags, updated_at, replaced_by, replaced_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		FROM document_versions
+		WHERE document_id = $1
+	`, doc.ID, doc.Title, doc.Content, stored.key, stored.size, doc.Classification, pq.Array(doc.Tags), doc.UpdatedAt, userID, at)
+	if err != nil {
+		return fmt.Errorf("failed to save document version: %w", err)
+	}
+	return nil
+}
+
+// documentVersionColumns are the columns read by scanDocumentVersion
+const documentVersionColumns = `document_id, version, title, content, content_key, content_size, classification, tags, updated_at, replaced_by, replaced_at`
+
+// scanDocumentVersion scans a row selected with documentVersionColumns.
+// Content kept in storage is not loaded.
+func scanDocumentVersion(row interface{ Scan(...any) error }) (models.DocumentVersion, storedContent, error) {
+	var v models.DocumentVersion
+	var stored storedContent
+	err := row.Scan(&v.DocumentID, &v.Version, &v.Title, &v.Content, &stored.key, &stored.size, &v.Classification, pq.Array(&v.Tags), &v.UpdatedAt, &v.ReplacedBy, &v.ReplacedAt)
+	if err != nil {
+		return models.DocumentVersion{}, storedContent{}, err
+	}
+	if v.Tags == nil {
+		v.Tags = []string{}
+	}
+	return v, stored, nil
+}
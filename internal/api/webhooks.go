@@ -0,0 +1,179 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/models"
+	"github.com/ksakiyama/study-cedar/internal/webhook"
+	"github.com/lib/pq"
+)
+
+// EventPublisher delivers events to webhook subscribers without blocking.
+// It is implemented by *webhook.Dispatcher.
+type EventPublisher interface {
+	Publish(eventType string, data any)
+}
+
+// SetWebhooks enables the webhook endpoints and publishes document events
+// to events
+func (h *Handler) SetWebhooks(events EventPublisher) {
+	h.events = events
+}
+
+// publishDocumentEvent publishes a document event if webhooks are enabled
+func (h *Handler) publishDocumentEvent(eventType, documentID, ownerID string) {
+	if h.events != nil {
+		h.events.Publish(eventType, models.DocumentEventData{DocumentID: documentID, OwnerID: ownerID})
+	}
+}
+
+// webhookColumns are the columns read by scanWebhook
+const webhookColumns = `id, url, events, created_by, created_at`
+
+// scanWebhook scans a row selected with webhookColumns
+func scanWebhook(row interface{ Scan(...any) error }) (models.Webhook, error) {
+	var hook models.Webhook
+	err := row.Scan(&hook.ID, &hook.URL, pq.Array(&hook.Events), &hook.CreatedBy, &hook.CreatedAt)
+	return hook, err
+}
+
+// ListWebhooks handles listing the registered webhooks
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		respondError(w, http.StatusNotImplemented, "Webhooks are not available")
+		return
+	}
+
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT `+webhookColumns+` FROM webhooks ORDER BY created_at, id
+	`)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	response := models.WebhooksResponse{Webhooks: []models.Webhook{}}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Scan error: %v", err))
+			return
+		}
+		response.Webhooks = append(response.Webhooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// CreateWebhook handles registering a webhook. The response holds the
+// secret its deliveries are signed with, which is not shown again.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		respondError(w, http.StatusNotImplemented, "Webhooks are not available")
+		return
+	}
+
+	var input models.WebhookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if u, err := url.Parse(input.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		respondError(w, http.StatusBadRequest, "url must be an absolute http or https URL")
+		return
+	}
+	if !webhook.ValidEvents(input.Events) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("events must list one or more of %s", strings.Join(webhook.Events, ", ")))
+		return
+	}
+
+	id, err := newWebhookID()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hook := models.Webhook{
+		ID:        id,
+		URL:       input.URL,
+		Events:    input.Events,
+		Secret:    secret,
+		CreatedBy: r.Header.Get("X-User-ID"),
+		CreatedAt: h.clock.Now(),
+	}
+	_, err = h.db.ExecContext(r.Context(), `
+		INSERT INTO webhooks (id, url, events, secret, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, hook.ID, hook.URL, pq.Array(hook.Events), hook.Secret, hook.CreatedBy, hook.CreatedAt)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusCreated, hook)
+}
+
+// GetWebhook handles fetching a webhook, without its secret
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		respondError(w, http.StatusNotImplemented, "Webhooks are not available")
+		return
+	}
+
+	hook, err := scanWebhook(h.db.QueryRowContext(r.Context(), `
+		SELECT `+webhookColumns+` FROM webhooks WHERE id = $1
+	`, chi.URLParam(r, "webhookId")))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, hook)
+}
+
+// DeleteWebhook handles unregistering a webhook. Deliveries already being
+// retried are still made.
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		respondError(w, http.StatusNotImplemented, "Webhooks are not available")
+		return
+	}
+
+	res, err := h.db.ExecContext(r.Context(), `DELETE FROM webhooks WHERE id = $1`, chi.URLParam(r, "webhookId"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newWebhookID returns a random webhook ID
+func newWebhookID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook ID: %w", err)
+	}
+	return "wh-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,173 @@
+package avp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/verifiedpermissions"
+	"github.com/aws/aws-sdk-go-v2/service/verifiedpermissions/types"
+	"github.com/cedar-policy/cedar-go"
+	authz "github.com/ksakiyama/study-cedar/internal/cedar"
+)
+
+// Client is the subset of the Verified Permissions API used by the evaluator
+type Client interface {
+	IsAuthorized(ctx context.Context, params *verifiedpermissions.IsAuthorizedInput, optFns ...func(*verifiedpermissions.Options)) (*verifiedpermissions.IsAuthorizedOutput, error)
+}
+
+// Evaluator delegates policy evaluation to an Amazon Verified Permissions
+// policy store. The policy store must contain the DocumentApp schema and
+// policies.
+type Evaluator struct {
+	client        Client
+	policyStoreID string
+}
+
+// NewEvaluator creates an evaluator for the given policy store
+func NewEvaluator(client Client, policyStoreID string) *Evaluator {
+	return &Evaluator{
+		client:        client,
+		policyStoreID: policyStoreID,
+	}
+}
+
+// Evaluate sends the request and its entities to Verified Permissions
+func (e *Evaluator) Evaluate(ctx context.Context, entities cedar.EntityMap, req cedar.Request) (authz.AuthzDecision, error) {
+	contextMap, err := recordToAttributes(req.Context)
+	if err != nil {
+		return authz.AuthzDecision{}, fmt.Errorf("failed to convert context: %w", err)
+	}
+
+	items := make([]types.EntityItem, 0, len(entities))
+	for _, entity := range entities {
+		// Verified Permissions takes actions and action groups from its
+		// own schema
+		if isAction(entity.UID.Type) {
+			continue
+		}
+		item, err := entityToItem(entity)
+		if err != nil {
+			return authz.AuthzDecision{}, fmt.Errorf("failed to convert entity %s: %w", entity.UID, err)
+		}
+		items = append(items, item)
+	}
+
+	out, err := e.client.IsAuthorized(ctx, &verifiedpermissions.IsAuthorizedInput{
+		PolicyStoreId: aws.String(e.policyStoreID),
+		Principal:     entityIdentifier(req.Principal),
+		Action: &types.ActionIdentifier{
+			ActionType: aws.String(string(req.Action.Type)),
+			ActionId:   aws.String(string(req.Action.ID)),
+		},
+		Resource: entityIdentifier(req.Resource),
+		Context:  &types.ContextDefinitionMemberContextMap{Value: contextMap},
+		Entities: &types.EntitiesDefinitionMemberEntityList{Value: items},
+	})
+	if err != nil {
+		return authz.AuthzDecision{}, err
+	}
+
+	decision := authz.AuthzDecision{
+		Allowed: out.Decision == types.DecisionAllow,
+	}
+	for _, p := range out.DeterminingPolicies {
+		decision.MatchedPolicies = append(decision.MatchedPolicies, aws.ToString(p.PolicyId))
+	}
+	for _, e := range out.Errors {
+		decision.Errors = append(decision.Errors, aws.ToString(e.ErrorDescription))
+	}
+	// Policy annotations are not returned, so denials are only told apart
+	// by whether a forbid decided them
+	switch {
+	case decision.Allowed:
+	case len(decision.MatchedPolicies) > 0:
+		decision.Code = authz.CodeForbidden
+	case len(decision.Errors) > 0:
+		decision.Code = authz.CodeEvaluationError
+	default:
+		decision.Code = authz.CodeInsufficientPermissions
+	}
+	return decision, nil
+}
+
+// isAction reports whether t is the action type of some namespace
+func isAction(t cedar.EntityType) bool {
+	return t == "Action" || strings.HasSuffix(string(t), "::Action")
+}
+
+func entityIdentifier(uid cedar.EntityUID) *types.EntityIdentifier {
+	return &types.EntityIdentifier{
+		EntityType: aws.String(string(uid.Type)),
+		EntityId:   aws.String(string(uid.ID)),
+	}
+}
+
+// entityToItem converts an entity for AVP, without its tags, which entity
+// items cannot hold
+func entityToItem(entity cedar.Entity) (types.EntityItem, error) {
+	attrs, err := recordToAttributes(entity.Attributes)
+	if err != nil {
+		return types.EntityItem{}, err
+	}
+
+	var parents []types.EntityIdentifier
+	for parent := range entity.Parents.All() {
+		parents = append(parents, *entityIdentifier(parent))
+	}
+
+	return types.EntityItem{
+		Identifier: entityIdentifier(entity.UID),
+		Attributes: attrs,
+		Parents:    parents,
+	}, nil
+}
+
+func recordToAttributes(record cedar.Record) (map[string]types.AttributeValue, error) {
+	attrs := make(map[string]types.AttributeValue, record.Len())
+	for k, v := range record.All() {
+		av, err := toAttributeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", k, err)
+		}
+		attrs[string(k)] = av
+	}
+	return attrs, nil
+}
+
+// toAttributeValue converts a Cedar value into its Verified Permissions form
+func toAttributeValue(v cedar.Value) (types.AttributeValue, error) {
+	switch v := v.(type) {
+	case cedar.Boolean:
+		return &types.AttributeValueMemberBoolean{Value: bool(v)}, nil
+	case cedar.String:
+		return &types.AttributeValueMemberString{Value: string(v)}, nil
+	case cedar.Long:
+		return &types.AttributeValueMemberLong{Value: int64(v)}, nil
+	case cedar.EntityUID:
+		return &types.AttributeValueMemberEntityIdentifier{Value: *entityIdentifier(v)}, nil
+	case cedar.Set:
+		values := make([]types.AttributeValue, 0, v.Len())
+		for item := range v.All() {
+			av, err := toAttributeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, av)
+		}
+		return &types.AttributeValueMemberSet{Value: values}, nil
+	case cedar.Record:
+		attrs, err := recordToAttributes(v)
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberRecord{Value: attrs}, nil
+	case cedar.IPAddr:
+		return &types.AttributeValueMemberIpaddr{Value: v.String()}, nil
+	case cedar.Decimal:
+		return &types.AttributeValueMemberDecimal{Value: v.String()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
@@ -0,0 +1,113 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// reviewRoles are the roles the policies distinguish. Access reviews
+// evaluate each of them for every user group.
+var reviewRoles = []string{"admin", "group_admin", "editor", "viewer"}
+
+// Access is what a principal may do with a document
+type Access struct {
+	Principal Principal
+	Actions   []string
+}
+
+// WhoCanAccess evaluates every document action for each principal against
+// the document and returns the principals allowed at least one action.
+// Requests are evaluated as if made from a private network after
+// multi-factor authentication, without caching or auditing them.
+func (a *Authorizer) WhoCanAccess(ctx context.Context, resourceID string, principals []Principal) ([]Access, error) {
+	actions, err := DocumentActions()
+	if err != nil {
+		return nil, err
+	}
+
+	var access []Access
+	for _, p := range principals {
+		entry := Access{Principal: p}
+		for _, action := range actions {
+			sim, err := a.Simulate(ctx, AuthzRequest{
+				UserID:      p.ID,
+				UserRole:    p.Role,
+				UserGroupID: p.GroupID,
+				Action:      action,
+				ResourceID:  resourceID,
+				IsPrivateIP: true,
+				MFAVerified: true,
+			}, nil)
+			if err != nil {
+				return nil, err
+			}
+			if sim.Decision.Allowed {
+				entry.Actions = append(entry.Actions, action)
+			}
+		}
+		if len(entry.Actions) > 0 {
+			access = append(access, entry)
+		}
+	}
+	return access, nil
+}
+
+// Directory lists the principals an access review considers
+type Directory struct {
+	db *sql.DB
+}
+
+// NewDirectory creates a directory backed by the given database
+func NewDirectory(db *sql.DB) *Directory {
+	return &Directory{db: db}
+}
+
+// Principals returns a member of each user group (and of no group) in each
+// role, followed by the users seen in the audit log over the last 90 days.
+// Group members have an empty ID, so they never match owner or sharing
+// rules.
+func (d *Directory) Principals(ctx context.Context) ([]Principal, error) {
+	groups := []string{""}
+	rows, err := d.db.QueryContext(ctx, `SELECT id FROM user_groups ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user groups: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user group: %w", err)
+		}
+		groups = append(groups, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var principals []Principal
+	for _, group := range groups {
+		for _, role := range reviewRoles {
+			principals = append(principals, Principal{Role: role, GroupID: group})
+		}
+	}
+
+	users, err := d.db.QueryContext(ctx, `
+		SELECT DISTINCT principal_id, principal_role, principal_group
+		FROM authz_audit
+		WHERE created_at >= CURRENT_TIMESTAMP - INTERVAL '90 days'
+		ORDER BY principal_id, principal_role, principal_group
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audited users: %w", err)
+	}
+	defer users.Close()
+	for users.Next() {
+		var p Principal
+		if err := users.Scan(&p.ID, &p.Role, &p.GroupID); err != nil {
+			return nil, fmt.Errorf("failed to scan audited user: %w", err)
+		}
+		principals = append(principals, p)
+	}
+	return principals, users.Err()
+}
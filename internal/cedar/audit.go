@@ -0,0 +1,327 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/lib/pq"
+)
+
+// AuditRecord is a single authorization decision as written to the audit log
+type AuditRecord struct {
+	// ID is the position of the record in the log, set by Query
+	ID              int64
+	Time            time.Time
+	PrincipalID     string
+	PrincipalRole   string
+	PrincipalGroup  string
+	Action          string
+	ResourceID      string
+	Allowed         bool
+	MatchedPolicies []string
+	// Code and Reason explain a denial, as in AuthzDecision. They are not
+	// stored in the audit tables.
+	Code        string
+	Reason      string
+	IPAddress   string
+	IsPrivateIP bool
+	IsJapanIP   bool
+	MFAVerified bool
+}
+
+// AuditSink receives every authorization decision. Record must not block
+// the request.
+type AuditSink interface {
+	Record(rec AuditRecord)
+}
+
+// WithAuditSink adds a sink that records every decision made by Authorize
+func WithAuditSink(sink AuditSink) Option {
+	return func(a *Authorizer) {
+		a.auditSinks = append(a.auditSinks, sink)
+	}
+}
+
+// audit hands the decision for r to the audit sinks, if any. Decisions
+// made with a break-glass token are first written to the break-glass
+// sinks, and an error is returned if any of them fails.
+func (a *Authorizer) audit(ctx context.Context, r AuthzRequest, req cedar.Request, decision AuthzDecision) error {
+	breakGlass := usesBreakGlass(req) && len(a.breakGlassSinks) > 0
+	if len(a.auditSinks) == 0 && !breakGlass {
+		return nil
+	}
+	rec := AuditRecord{
+		Time:            a.clock.Now(),
+		PrincipalID:     r.UserID,
+		PrincipalRole:   r.UserRole,
+		PrincipalGroup:  r.UserGroupID,
+		Action:          r.Action,
+		ResourceID:      r.ResourceID,
+		Allowed:         decision.Allowed,
+		MatchedPolicies: decision.MatchedPolicies,
+		Code:            decision.Code,
+		Reason:          decision.Reason,
+		IPAddress:       r.IPAddress,
+		IsPrivateIP:     r.IsPrivateIP,
+		IsJapanIP:       r.IsJapanIP,
+		MFAVerified:     r.MFAVerified,
+	}
+	if breakGlass {
+		for _, sink := range a.breakGlassSinks {
+			if err := sink.Write(ctx, rec); err != nil {
+				return fmt.Errorf("failed to record break-glass decision: %w", err)
+			}
+		}
+	}
+	for _, sink := range a.auditSinks {
+		sink.Record(rec)
+	}
+	return nil
+}
+
+const (
+	// auditBatchSize is the most records written in one INSERT
+	auditBatchSize = 100
+	// auditFlushInterval bounds how long a record waits in the buffer
+	auditFlushInterval = time.Second
+)
+
+// AuditLog writes audit records to the authz_audit table in the background.
+// Records are buffered so that authorization never waits on the database;
+// when the buffer is full, records are dropped and logged.
+type AuditLog struct {
+	db      *sql.DB
+	table   string
+	records chan AuditRecord
+
+	// writing is held while a batch is written, so Pseudonymize can wait
+	// for a write that started before it
+	writing sync.Mutex
+	// mu guards pseudonyms
+	mu sync.RWMutex
+	// pseudonyms replaces erased principal IDs in records written later
+	pseudonyms map[string]string
+}
+
+// NewAuditLog creates an audit log that buffers up to bufferSize records
+func NewAuditLog(db *sql.DB, bufferSize int) *AuditLog {
+	return &AuditLog{
+		db:      db,
+		table:   "authz_audit",
+		records: make(chan AuditRecord, bufferSize),
+	}
+}
+
+// NewBreakGlassAuditLog creates an audit log writing to the
+// break_glass_audit table, which has the same columns as authz_audit, for
+// use with WithBreakGlass. Its records are written with Write, so it has
+// no buffer and needs no Run.
+func NewBreakGlassAuditLog(db *sql.DB) *AuditLog {
+	return &AuditLog{db: db, table: "break_glass_audit"}
+}
+
+// Record queues rec for writing without blocking
+func (l *AuditLog) Record(rec AuditRecord) {
+	select {
+	case l.records <- rec:
+	default:
+		log.Printf("Audit buffer full, dropping record: principal=%s action=%s resource=%s allowed=%t",
+			rec.PrincipalID, rec.Action, rec.ResourceID, rec.Allowed)
+	}
+}
+
+// Write writes rec before returning, for records that must not be dropped
+func (l *AuditLog) Write(ctx context.Context, rec AuditRecord) error {
+	return l.write(ctx, []AuditRecord{rec})
+}
+
+// Run writes queued records in batches until ctx is cancelled, then writes
+// whatever is still buffered and returns
+func (l *AuditLog) Run(ctx context.Context) {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditRecord, 0, auditBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// Use a fresh context so the final flush still runs after shutdown
+		if err := l.write(context.Background(), batch); err != nil {
+			log.Printf("Failed to write %d audit records: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case rec := <-l.records:
+			batch = append(batch, rec)
+			if len(batch) == auditBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case rec := <-l.records:
+					batch = append(batch, rec)
+					if len(batch) == auditBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Pseudonymize makes records of principalID that are still buffered, or
+// recorded from now on, be written with pseudonym as the principal and no
+// IP address, as erasure does to the stored ones. It returns once any
+// write already under way has finished, so that the stored records can
+// then be updated without one slipping past.
+func (l *AuditLog) Pseudonymize(principalID, pseudonym string) {
+	l.mu.Lock()
+	if l.pseudonyms == nil {
+		l.pseudonyms = map[string]string{}
+	}
+	l.pseudonyms[principalID] = pseudonym
+	l.mu.Unlock()
+
+	l.writing.Lock()
+	defer l.writing.Unlock()
+}
+
+// write inserts a batch of records in a single statement
+func (l *AuditLog) write(ctx context.Context, batch []AuditRecord) error {
+	l.writing.Lock()
+	defer l.writing.Unlock()
+	l.mu.RLock()
+	for i, rec := range batch {
+		if pseudonym, ok := l.pseudonyms[rec.PrincipalID]; ok {
+			batch[i].PrincipalID = pseudonym
+			batch[i].IPAddress = ""
+		}
+	}
+	l.mu.RUnlock()
+
+	const columns = 12
+	values := make([]string, 0, len(batch))
+	args := make([]any, 0, len(batch)*columns)
+	for i, rec := range batch {
+		policies := rec.MatchedPolicies
+		if policies == nil {
+			policies = []string{}
+		}
+		placeholders := make([]string, columns)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*columns+j+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, rec.Time, rec.PrincipalID, rec.PrincipalRole, rec.PrincipalGroup,
+			rec.Action, rec.ResourceID, rec.Allowed, pq.Array(policies),
+			rec.IPAddress, rec.IsPrivateIP, rec.IsJapanIP, rec.MFAVerified)
+	}
+
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO `+l.table+` (created_at, principal_id, principal_role, principal_group, action, resource_id,
+			allowed, matched_policies, ip_address, is_private_ip, is_japan_ip, mfa_verified)
+		VALUES `+strings.Join(values, ", "), args...)
+	return err
+}
+
+// AuditQuery filters audit records. Empty fields match everything.
+type AuditQuery struct {
+	PrincipalID string
+	ResourceID  string
+	Action      string
+	// Actions matches records of any of the actions
+	Actions []string
+	// AllowedOnly leaves out denied requests
+	AllowedOnly bool
+	Since       time.Time
+	Until       time.Time
+	// Before matches the records older than it, to page through the log
+	Before *AuditPosition
+	Limit  int
+}
+
+// AuditPosition is the position of a record in the order returned by
+// Query
+type AuditPosition struct {
+	Time time.Time `json:"time"`
+	ID   int64     `json:"id"`
+}
+
+// Query returns matching audit records, newest first
+func (l *AuditLog) Query(ctx context.Context, q AuditQuery) ([]AuditRecord, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if q.PrincipalID != "" {
+		add("principal_id = $%d", q.PrincipalID)
+	}
+	if q.ResourceID != "" {
+		add("resource_id = $%d", q.ResourceID)
+	}
+	if q.Action != "" {
+		add("action = $%d", q.Action)
+	}
+	if len(q.Actions) > 0 {
+		add("action = ANY($%d)", pq.Array(q.Actions))
+	}
+	if q.AllowedOnly {
+		conds = append(conds, "allowed")
+	}
+	if !q.Since.IsZero() {
+		add("created_at >= $%d", q.Since)
+	}
+	if !q.Until.IsZero() {
+		add("created_at < $%d", q.Until)
+	}
+	if q.Before != nil {
+		args = append(args, q.Before.Time, q.Before.ID)
+		conds = append(conds, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, q.Limit)
+
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT id, created_at, principal_id, principal_role, principal_group, action, resource_id,
+			allowed, matched_policies, ip_address, is_private_ip, is_japan_ip, mfa_verified
+		FROM `+l.table+`
+		`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $`+fmt.Sprint(len(args)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var rec AuditRecord
+		if err := rows.Scan(&rec.ID, &rec.Time, &rec.PrincipalID, &rec.PrincipalRole, &rec.PrincipalGroup, &rec.Action, &rec.ResourceID,
+			&rec.Allowed, pq.Array(&rec.MatchedPolicies), &rec.IPAddress, &rec.IsPrivateIP, &rec.IsJapanIP, &rec.MFAVerified); err != nil {
+			return nil, fmt.Errorf("failed to scan audit record: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
@@ -1,124 +1,461 @@
 package cedar
 
 import (
-	_ "embed"
-	"encoding/json"
+	"context"
+	"embed"
+	"errors"
 	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cedar-policy/cedar-go"
+	"github.com/ksakiyama/study-cedar/internal/clock"
 )
 
-//go:embed policies/policy.cedar
-var policyContent string
+// embeddedPolicies holds the default policies. Files are merged in name
+// order.
+//
+//go:embed policies/*.cedar
+var embeddedPolicies embed.FS
 
 // Authorizer handles Cedar authorization
 type Authorizer struct {
-	policySet *cedar.PolicySet
+	policySet         atomic.Pointer[cedar.PolicySet]
+	policyDir         string
+	policyStore       *PolicyStore
+	policyBundle      *PolicyBundle
+	templateStore     *TemplateStore
+	clock             clock.Clock
+	cache             *decisionCache
+	cacheTTL          time.Duration
+	cacheSize         int
+	entityProvider    EntityProvider
+	contextBuilders   []ContextBuilder
+	evaluator         Evaluator
+	multi             *MultiAuthorizer
+	shadowDir         string
+	shadowSet         atomic.Pointer[cedar.PolicySet]
+	schema            *schemaInfo
+	sliceRoots        atomic.Pointer[[]cedar.EntityUID]
+	auditSinks        []AuditSink
+	breakGlass        *BreakGlassStore
+	breakGlassSinks   []BreakGlassSink
+	unknownStrategies map[string]UnknownStrategy
+	recorder          *RequestRecorder
+	timeout           time.Duration
+	reloadMu          sync.Mutex
+	policyChecksum    string
+}
+
+// Option configures an Authorizer
+type Option func(*Authorizer)
+
+// WithClock sets the clock used for time-dependent evaluation
+func WithClock(c clock.Clock) Option {
+	return func(a *Authorizer) {
+		a.clock = c
+	}
+}
+
+// WithPolicyDir loads policies from *.cedar files in dir instead of the
+// embedded policy file
+func WithPolicyDir(dir string) Option {
+	return func(a *Authorizer) {
+		a.policyDir = dir
+	}
+}
+
+// WithPolicyStore loads policies from the database instead of the
+// embedded policy file
+func WithPolicyStore(store *PolicyStore) Option {
+	return func(a *Authorizer) {
+		a.policyStore = store
+	}
+}
+
+// WithTemplateStore adds the template-linked policies from the database to
+// the policy set
+func WithTemplateStore(store *TemplateStore) Option {
+	return func(a *Authorizer) {
+		a.templateStore = store
+	}
+}
+
+// WithDecisionCache caches decisions for identical requests for ttl,
+// keeping at most size entries. The cache is cleared on policy reload.
+func WithDecisionCache(ttl time.Duration, size int) Option {
+	return func(a *Authorizer) {
+		a.cacheTTL = ttl
+		a.cacheSize = size
+	}
+}
+
+// WithEntityProvider sets where principal, resource, and group entities
+// are loaded from. Without one, only the principal entity is known.
+func WithEntityProvider(p EntityProvider) Option {
+	return func(a *Authorizer) {
+		a.entityProvider = p
+	}
+}
+
+// WithContextBuilders appends builders that enrich the request context
+func WithContextBuilders(builders ...ContextBuilder) Option {
+	return func(a *Authorizer) {
+		a.contextBuilders = append(a.contextBuilders, builders...)
+	}
+}
+
+// WithTimeout bounds each authorization, including entity loading and
+// remote evaluation. Requests that run out of time fail with an error
+// wrapping context.DeadlineExceeded.
+func WithTimeout(d time.Duration) Option {
+	return func(a *Authorizer) {
+		a.timeout = d
+	}
+}
+
+// Evaluator decides a fully built Cedar request somewhere other than the
+// local policy set, e.g. a managed policy store
+type Evaluator interface {
+	Evaluate(ctx context.Context, entities cedar.EntityMap, req cedar.Request) (AuthzDecision, error)
+}
+
+// WithEvaluator delegates policy evaluation to e. Entity loading, context
+// building, and caching still happen locally.
+func WithEvaluator(e Evaluator) Option {
+	return func(a *Authorizer) {
+		a.evaluator = e
+	}
 }
 
 // NewAuthorizer creates a new Cedar authorizer
-func NewAuthorizer() (*Authorizer, error) {
-	// Parse policies
-	policySet, err := cedar.NewPolicySetFromBytes("policy.cedar", []byte(policyContent))
+func NewAuthorizer(opts ...Option) (*Authorizer, error) {
+	a := &Authorizer{
+		clock:          clock.Real{},
+		entityProvider: principalOnlyProvider{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	schema, err := embeddedSchema()
 	if err != nil {
+		return nil, err
+	}
+	a.schema = schema
+	if err := schema.checkUnknownStrategies(a.unknownStrategies); err != nil {
+		return nil, fmt.Errorf("invalid unknown context strategy: %w", err)
+	}
+	if a.multi != nil {
+		if err := a.multi.checkActions(schema); err != nil {
+			return nil, fmt.Errorf("invalid external PDP configuration: %w", err)
+		}
+	}
+	if a.cacheTTL > 0 && a.cacheSize > 0 {
+		a.cache = newDecisionCache(a.cacheTTL, a.cacheSize, a.clock)
+	}
+
+	// Parse policies
+	if _, err := a.reload(context.Background()); err != nil {
 		return nil, fmt.Errorf("failed to parse policies: %w", err)
 	}
 
-	return &Authorizer{
-		policySet: policySet,
-	}, nil
+	return a, nil
 }
 
 // IsAuthorized checks if a user is authorized to perform an action on a resource
-func (a *Authorizer) IsAuthorized(userID, userRole, action, resourceID, resourceOwnerID, ipAddress string, isPrivateIP, isJapanIP, hasGroupAccess bool) (bool, error) {
-	// Create principal (user)
-	principal := cedar.NewEntityUID(cedar.EntityType("DocumentApp::User"), cedar.String(userID))
-
-	// Create action
-	actionUID := cedar.NewEntityUID(cedar.EntityType("DocumentApp::Action"), cedar.String(action))
-
-	// Create resource (document)
-	resource := cedar.NewEntityUID(cedar.EntityType("DocumentApp::Document"), cedar.String(resourceID))
-
-	// Build entities as JSON
-	entitiesJSON := []map[string]interface{}{
-		{
-			"uid": map[string]string{
-				"type": "DocumentApp::User",
-				"id":   userID,
-			},
-			"attrs": map[string]interface{}{
-				"role": userRole,
-			},
-			"parents": []interface{}{},
-		},
-	}
-
-	// Add resource entity if it exists
-	if resourceID != "" && resourceOwnerID != "" {
-		entitiesJSON = append(entitiesJSON, map[string]interface{}{
-			"uid": map[string]string{
-				"type": "DocumentApp::Document",
-				"id":   resourceID,
-			},
-			"attrs": map[string]interface{}{
-				"owner": map[string]string{
-					"type":     "DocumentApp::User",
-					"id":       resourceOwnerID,
-					"__entity": "true",
-				},
-			},
-			"parents": []interface{}{},
-		})
-	}
-
-	// Convert to JSON and parse into EntityMap
-	entitiesJSONBytes, err := json.Marshal(entitiesJSON)
-	if err != nil {
-		return false, fmt.Errorf("failed to marshal entities: %w", err)
+func (a *Authorizer) IsAuthorized(ctx context.Context, userID, userRole, userGroupID, action, resourceID, ipAddress string, isPrivateIP, isJapanIP bool) (AuthzDecision, error) {
+	return a.Authorize(ctx, AuthzRequest{
+		UserID:      userID,
+		UserRole:    userRole,
+		UserGroupID: userGroupID,
+		Action:      action,
+		ResourceID:  resourceID,
+		IPAddress:   ipAddress,
+		IsPrivateIP: isPrivateIP,
+		IsJapanIP:   isJapanIP,
+	})
+}
+
+// Authorize checks if the request is permitted by the active policies.
+// It gives up once ctx is done, returning an error that wraps ctx.Err().
+func (a *Authorizer) Authorize(ctx context.Context, r AuthzRequest) (AuthzDecision, error) {
+	ctx, span := startAuthzSpan(ctx, r.Action)
+	defer span.End()
+
+	decision, err := a.authorize(ctx, r, nil)
+	endAuthzSpan(span, decision, err)
+	return decision, err
+}
+
+// AuthorizeNewDocument checks r like Authorize, but with doc as the
+// resource in place of loading r.ResourceID, for a document that is about
+// to be created. Unlike Simulate, the decision is audited.
+func (a *Authorizer) AuthorizeNewDocument(ctx context.Context, r AuthzRequest, doc Document) (AuthzDecision, error) {
+	ctx, span := startAuthzSpan(ctx, r.Action)
+	defer span.End()
+
+	decision, err := a.authorize(ctx, r, &doc)
+	endAuthzSpan(span, decision, err)
+	return decision, err
+}
+
+// authorize implements Authorize and AuthorizeNewDocument within their
+// span. When doc is set, it is the resource.
+func (a *Authorizer) authorize(ctx context.Context, r AuthzRequest, doc *Document) (AuthzDecision, error) {
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return AuthzDecision{}, fmt.Errorf("authorization aborted: %w", err)
 	}
 
+	buildStart := time.Now()
 	var entities cedar.EntityMap
-	if err := json.Unmarshal(entitiesJSONBytes, &entities); err != nil {
-		return false, fmt.Errorf("failed to unmarshal entities: %w", err)
+	var req cedar.Request
+	var err error
+	if doc != nil {
+		r.ResourceID = doc.ID
+		entities, req, err = a.prepareHypothetical(ctx, r, *doc)
+	} else {
+		entities, req, err = a.prepare(ctx, r)
+	}
+	if err != nil {
+		return AuthzDecision{}, err
+	}
+	traceEntityBuild(ctx, buildStart)
+	if a.recorder != nil {
+		a.recorder.record(entities, req)
 	}
 
-	// Create context with IP information and group access
+	// Serve repeated identical checks from the cache. Requests with unknown
+	// context attributes are not cached, since their key does not tell
+	// them apart from requests where the values are known.
+	var key cacheKey
+	useCache := a.cache != nil && len(r.Unknown) == 0
+	if useCache {
+		key, useCache = a.cacheKey(entities, req)
+	}
+	if useCache {
+		if cached, ok := a.cache.get(key); ok {
+			traceCacheHit(ctx)
+			a.compareShadow(r, entities, req, cached)
+			if err := a.audit(ctx, r, req, cached); err != nil {
+				return AuthzDecision{}, err
+			}
+			observeDecision(r.Action, cached)
+			return cached, nil
+		}
+	}
+
+	// Do not start evaluating if loading used up the deadline
+	if err := ctx.Err(); err != nil {
+		return AuthzDecision{}, fmt.Errorf("authorization aborted: %w", err)
+	}
+
+	// Evaluate authorization
+	start := time.Now()
+	result, err := a.evaluateUnknown(ctx, entities, req, r.Unknown)
+	if err != nil {
+		return AuthzDecision{}, err
+	}
+	observeEvaluation(r.Action, start)
+
+	if useCache {
+		a.cache.put(key, result)
+	}
+	a.compareShadow(r, entities, req, result)
+	if err := a.audit(ctx, r, req, result); err != nil {
+		return AuthzDecision{}, err
+	}
+	observeDecision(r.Action, result)
+
+	return result, nil
+}
+
+// evaluate decides a prepared request with the evaluator or the local
+// policy set, then consults the external PDP if configured
+func (a *Authorizer) evaluate(ctx context.Context, entities cedar.EntityMap, req cedar.Request) (AuthzDecision, error) {
+	var result AuthzDecision
+	if a.evaluator != nil {
+		var err error
+		result, err = a.evaluator.Evaluate(ctx, entities, req)
+		if err != nil {
+			return AuthzDecision{}, fmt.Errorf("failed to evaluate request: %w", err)
+		}
+	} else {
+		policySet := a.policySet.Load()
+		decision, diag := policySet.IsAuthorized(entities, req)
+		result = newDecision(policySet, entities, req, decision, diag)
+	}
+
+	if a.multi != nil {
+		var err error
+		result, err = a.multi.decide(ctx, result, entities, req)
+		if err != nil {
+			return AuthzDecision{}, fmt.Errorf("failed to evaluate request: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// prepare loads the entities and builds the Cedar request for r
+func (a *Authorizer) prepare(ctx context.Context, r AuthzRequest) (cedar.EntityMap, cedar.Request, error) {
+	if err := a.checkAction(r.Action); err != nil {
+		return nil, cedar.Request{}, err
+	}
+	principal := Principal{ID: r.UserID, Role: r.UserRole, GroupID: r.UserGroupID}
+
+	// Load principal, resource, and group entities
+	entities, err := a.entityProvider.Entities(ctx, principal, r.ResourceID)
+	if err != nil {
+		return nil, cedar.Request{}, err
+	}
+	return a.buildRequest(ctx, r, entities)
+}
+
+// buildRequest builds the Cedar request for r around the loaded entities
+func (a *Authorizer) buildRequest(ctx context.Context, r AuthzRequest, entities cedar.EntityMap) (cedar.EntityMap, cedar.Request, error) {
+
+	// Create context with IP and MFA information
 	contextMap := cedar.RecordMap{
-		"ip_address":       cedar.String(ipAddress),
-		"is_private_ip":    cedar.Boolean(isPrivateIP),
-		"is_japan_ip":      cedar.Boolean(isJapanIP),
-		"has_group_access": cedar.Boolean(hasGroupAccess),
+		"ip_address":    cedar.String(r.IPAddress),
+		"is_private_ip": cedar.Boolean(r.IsPrivateIP),
+		"is_japan_ip":   cedar.Boolean(r.IsJapanIP),
+		"mfa_verified":  cedar.Boolean(r.MFAVerified),
+		breakGlassAttr:  cedar.False,
+		publicLinkAttr:  cedar.False,
+	}
+	for k, v := range r.Context {
+		value, err := contextValue(v)
+		if err != nil {
+			return nil, cedar.Request{}, fmt.Errorf("%w: context %q: %v", ErrInvalidRequest, k, err)
+		}
+		contextMap[cedar.String(k)] = value
+	}
+
+	// A valid break-glass token overrides the caller's context
+	breakGlass, err := a.breakGlassActive(ctx, r)
+	if err != nil {
+		return nil, cedar.Request{}, err
+	}
+	if breakGlass {
+		contextMap[breakGlassAttr] = cedar.True
+	}
+	// Neither can the caller's context hide a public link
+	if r.PublicLink {
+		contextMap[publicLinkAttr] = cedar.True
+	}
+
+	// Let configured builders enrich the context
+	for _, builder := range a.contextBuilders {
+		if err := builder.BuildContext(ctx, r, contextMap); err != nil {
+			return nil, cedar.Request{}, fmt.Errorf("failed to build context: %w", err)
+		}
+	}
+	if err := a.setUnknown(r, contextMap); err != nil {
+		return nil, cedar.Request{}, err
 	}
 
 	// Create request
 	req := cedar.Request{
-		Principal: principal,
-		Action:    actionUID,
-		Resource:  resource,
+		Principal: cedar.NewEntityUID(userType, cedar.String(r.UserID)),
+		Action:    cedar.NewEntityUID(actionType, cedar.String(r.Action)),
+		Resource:  cedar.NewEntityUID(documentType, cedar.String(r.ResourceID)),
 		Context:   cedar.NewRecord(contextMap),
 	}
 
-	// Evaluate authorization
-	decision, _ := a.policySet.IsAuthorized(entities, req)
-
-	return decision == cedar.Allow, nil
+	// Drop entities that cannot affect the decision
+	entities = a.schema.sliceEntities(entities, req, *a.sliceRoots.Load())
+	return entities, req, nil
 }
 
 // AuthzRequest represents an authorization request
 type AuthzRequest struct {
-	UserID          string
-	UserRole        string
-	Action          string
-	ResourceID      string
-	ResourceOwnerID string
-	IPAddress       string
-	IsPrivateIP     bool
-	IsJapanIP       bool
-	HasGroupAccess  bool
-}
-
-// Authorize is a convenience method for authorization
-func (a *Authorizer) Authorize(req AuthzRequest) (bool, error) {
-	return a.IsAuthorized(req.UserID, req.UserRole, req.Action, req.ResourceID, req.ResourceOwnerID, req.IPAddress, req.IsPrivateIP, req.IsJapanIP, req.HasGroupAccess)
+	UserID      string
+	UserRole    string
+	UserGroupID string
+	Action      string
+	ResourceID  string
+	IPAddress   string
+	IsPrivateIP bool
+	IsJapanIP   bool
+	// MFAVerified reports whether the user completed multi-factor
+	// authentication
+	MFAVerified bool
+	// PublicLink is set for requests made through a public share link on
+	// behalf of the user who created it. It sets "is_public_link".
+	PublicLink bool
+	// PendingDocuments and PendingBytes count the documents, and the size
+	// of their content, that a bulk create makes before the one a
+	// CreateDocument request is for. DocumentUsage adds them to what the
+	// user already owns.
+	PendingDocuments int64
+	PendingBytes     int64
+	// Context holds additional context attributes supplied by the caller.
+	// Strings, booleans, and whole numbers are supported.
+	Context map[string]any
+	// Unknown lists Bool context attributes whose value could not be
+	// determined, e.g. "is_japan_ip" after a failed GeoIP lookup. They are
+	// evaluated according to the configured UnknownStrategy.
+	Unknown []string
+	// HTTPRequest is the originating HTTP request, if any. Context
+	// builders use it to derive attributes such as the request method.
+	// Its context is not used; pass one to Authorize instead.
+	HTTPRequest *http.Request
+}
+
+// ErrInvalidRequest is returned for malformed authorization requests
+var ErrInvalidRequest = errors.New("invalid authorization request")
+
+// ErrUnknownAction is returned when the requested action is not declared
+// in the schema. Such requests would otherwise always be denied.
+var ErrUnknownAction = errors.New("action not declared in schema")
+
+// checkAction rejects actions the schema does not declare
+func (a *Authorizer) checkAction(action string) error {
+	if _, ok := a.schema.actions[cedar.String(action)]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownAction, action)
+	}
+	return nil
+}
+
+// Validate checks that the request carries everything needed for evaluation
+func (r AuthzRequest) Validate() error {
+	if r.UserID == "" || r.UserRole == "" {
+		return fmt.Errorf("%w: missing user id or role", ErrInvalidRequest)
+	}
+	if r.Action == "" {
+		return fmt.Errorf("%w: missing action", ErrInvalidRequest)
+	}
+	if r.ResourceID == "" {
+		return fmt.Errorf("%w: missing resource id", ErrInvalidRequest)
+	}
+	return nil
+}
+
+// BatchResult holds the outcome of a single entry in a batch authorization
+type BatchResult struct {
+	Decision AuthzDecision
+	Err      error
+}
+
+// AuthorizeBatch evaluates each request independently. A malformed entry
+// gets its own error in the result slot while the remaining entries are
+// still evaluated. Entries not yet evaluated when ctx is done fail with
+// ctx's error.
+func (a *Authorizer) AuthorizeBatch(ctx context.Context, reqs []AuthzRequest) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	for i, req := range reqs {
+		if err := req.Validate(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Decision, results[i].Err = a.Authorize(ctx, req)
+	}
+	return results
 }
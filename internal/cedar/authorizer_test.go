@@ -0,0 +1,71 @@
+package cedar
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestAuthorizeBatch checks that each entry of a batch gets its own
+// decision or error, and that bad entries do not affect the others
+func TestAuthorizeBatch(t *testing.T) {
+	a, err := NewAuthorizer(WithEntityProvider(StaticEntityProvider{
+		Documents: map[string]Document{
+			"doc-1": {ID: "doc-1", OwnerID: "user-2", Classification: "public"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := func(action, resourceID string) AuthzRequest {
+		return AuthzRequest{
+			UserID:      "user-1",
+			UserRole:    "viewer",
+			Action:      action,
+			ResourceID:  resourceID,
+			IPAddress:   "10.0.0.1",
+			IsPrivateIP: true,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		req     AuthzRequest
+		allowed bool
+		err     error
+	}{
+		{"allowed entry", entry("GetDocument", "doc-1"), true, nil},
+		{"denied entry", entry("DeleteDocument", "doc-1"), false, nil},
+		{"missing action", entry("", "doc-1"), false, ErrInvalidRequest},
+		{"missing resource", entry("GetDocument", ""), false, ErrInvalidRequest},
+		{"unknown action", entry("PublishDocument", "doc-1"), false, ErrUnknownAction},
+		{"unknown document", entry("GetDocument", "doc-missing"), false, ErrResourceNotFound},
+		{"missing user", AuthzRequest{Action: "GetDocument", ResourceID: "doc-1"}, false, ErrInvalidRequest},
+	}
+	reqs := make([]AuthzRequest, len(tests))
+	for i, tt := range tests {
+		reqs[i] = tt.req
+	}
+
+	results := a.AuthorizeBatch(context.Background(), reqs)
+	if len(results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := results[i]
+			if tt.err != nil {
+				if !errors.Is(res.Err, tt.err) {
+					t.Errorf("error %v, want %v", res.Err, tt.err)
+				}
+				return
+			}
+			if res.Err != nil {
+				t.Fatalf("unexpected error: %v", res.Err)
+			}
+			if res.Decision.Allowed != tt.allowed {
+				t.Errorf("allowed %t, want %t", res.Decision.Allowed, tt.allowed)
+			}
+		})
+	}
+}
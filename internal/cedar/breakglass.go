@@ -0,0 +1,144 @@
+package cedar
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// BreakGlassHeader carries a break-glass token issued by BreakGlassStore.Grant
+const BreakGlassHeader = "X-Break-Glass-Token"
+
+// MaxBreakGlassDuration bounds how long a break-glass token stays valid
+const MaxBreakGlassDuration = 4 * time.Hour
+
+// breakGlassAttr is the context attribute set while a valid break-glass
+// token is presented. Policies exempt it from the geographic and group
+// restrictions.
+const breakGlassAttr = "break_glass"
+
+// ErrInvalidBreakGlass is returned for break-glass grants that cannot be
+// issued
+var ErrInvalidBreakGlass = errors.New("invalid break-glass grant")
+
+// BreakGlassGrant is an emergency override issued to a single user
+type BreakGlassGrant struct {
+	UserID    string
+	GrantedBy string
+	Reason    string
+	ExpiresAt time.Time
+	// Token is only known when the grant is issued; the database keeps
+	// its hash
+	Token string
+}
+
+// BreakGlassStore issues and checks break-glass tokens kept in the
+// break_glass_grants table
+type BreakGlassStore struct {
+	db    *sql.DB
+	clock clock.Clock
+}
+
+// NewBreakGlassStore creates a break-glass store backed by the given database
+func NewBreakGlassStore(db *sql.DB, clk clock.Clock) *BreakGlassStore {
+	return &BreakGlassStore{db: db, clock: clk}
+}
+
+// BreakGlassSink stores decisions made with a break-glass token. Unlike
+// AuditSink, Write returns only once the record is stored, since such
+// decisions must never go unrecorded.
+type BreakGlassSink interface {
+	Write(ctx context.Context, rec AuditRecord) error
+}
+
+// WithBreakGlass sets "break_glass" in the context of requests that carry
+// a valid token for their user, and writes every decision made with one to
+// sinks in addition to the regular audit sinks. A decision that cannot be
+// written is not returned; Authorize fails instead.
+func WithBreakGlass(store *BreakGlassStore, sinks ...BreakGlassSink) Option {
+	return func(a *Authorizer) {
+		a.breakGlass = store
+		a.breakGlassSinks = append(a.breakGlassSinks, sinks...)
+	}
+}
+
+// Grant issues a token that lets userID bypass the geographic and group
+// restrictions for d, at most MaxBreakGlassDuration
+func (s *BreakGlassStore) Grant(ctx context.Context, userID, grantedBy, reason string, d time.Duration) (BreakGlassGrant, error) {
+	if userID == "" || reason == "" {
+		return BreakGlassGrant{}, fmt.Errorf("%w: user and reason are required", ErrInvalidBreakGlass)
+	}
+	if d <= 0 || d > MaxBreakGlassDuration {
+		return BreakGlassGrant{}, fmt.Errorf("%w: duration must be between 0 and %s", ErrInvalidBreakGlass, MaxBreakGlassDuration)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return BreakGlassGrant{}, fmt.Errorf("failed to generate break-glass token: %w", err)
+	}
+	grant := BreakGlassGrant{
+		UserID:    userID,
+		GrantedBy: grantedBy,
+		Reason:    reason,
+		ExpiresAt: s.clock.Now().Add(d),
+		Token:     hex.EncodeToString(secret),
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO break_glass_grants (token_hash, user_id, granted_by, reason, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, hashToken(grant.Token), grant.UserID, grant.GrantedBy, grant.Reason, grant.ExpiresAt)
+	if err != nil {
+		return BreakGlassGrant{}, fmt.Errorf("failed to store break-glass grant: %w", err)
+	}
+	return grant, nil
+}
+
+// valid reports whether token is an unexpired grant for userID
+func (s *BreakGlassStore) valid(ctx context.Context, userID, token string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM break_glass_grants
+			WHERE token_hash = $1 AND user_id = $2 AND expires_at > $3
+		)
+	`, hashToken(token), userID, s.clock.Now()).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check break-glass token: %w", err)
+	}
+	return exists, nil
+}
+
+// hashToken is how tokens are stored, so a database dump does not reveal
+// usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// breakGlassActive reports whether r carries a valid break-glass token.
+// Tokens are only accepted from the HTTP request, and not through public
+// links, whose holders are not the user the token was granted to.
+func (a *Authorizer) breakGlassActive(ctx context.Context, r AuthzRequest) (bool, error) {
+	if a.breakGlass == nil || r.HTTPRequest == nil || r.PublicLink {
+		return false, nil
+	}
+	token := r.HTTPRequest.Header.Get(BreakGlassHeader)
+	if token == "" {
+		return false, nil
+	}
+	return a.breakGlass.valid(ctx, r.UserID, token)
+}
+
+// usesBreakGlass reports whether req was built with a valid break-glass token
+func usesBreakGlass(req cedar.Request) bool {
+	v, ok := req.Context.Get(breakGlassAttr)
+	return ok && v == cedar.True
+}
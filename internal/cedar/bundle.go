@@ -0,0 +1,255 @@
+package cedar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// maxBundleSize bounds how much of a bundle response is read
+const maxBundleSize = 10 << 20
+
+// emptyPayloadHash is the SHA-256 of an empty request body, as S3 expects
+// for signed GET requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// PolicyBundle fetches policies from a gzipped tarball of *.cedar files
+// served over HTTPS or stored in S3. Conditional requests with the last
+// ETag avoid downloading an unchanged bundle again. A bundle that fails to
+// download or parse is ignored and the last good one stays in effect.
+type PolicyBundle struct {
+	url       string
+	client    *http.Client
+	awsConfig *aws.Config
+	cacheFile string
+
+	// Last good bundle and its ETag. Only accessed from reload, which
+	// holds the authorizer's reload lock.
+	etag  string
+	files []policyFile
+}
+
+// BundleOption configures a PolicyBundle
+type BundleOption func(*PolicyBundle)
+
+// WithBundleAWSConfig sets the credentials and region used to sign
+// requests for s3:// bundles
+func WithBundleAWSConfig(cfg aws.Config) BundleOption {
+	return func(b *PolicyBundle) {
+		b.awsConfig = &cfg
+	}
+}
+
+// WithBundleCacheFile keeps a copy of the last good bundle at path, so the
+// server can start with it while the bundle source is unreachable
+func WithBundleCacheFile(path string) BundleOption {
+	return func(b *PolicyBundle) {
+		b.cacheFile = path
+	}
+}
+
+// NewPolicyBundle creates a bundle source for an https:// URL or an
+// s3://bucket/key object. S3 bundles require WithBundleAWSConfig.
+func NewPolicyBundle(rawURL string, opts ...BundleOption) (*PolicyBundle, error) {
+	b := &PolicyBundle{
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy bundle URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		b.url = u.String()
+	case "s3":
+		if b.awsConfig == nil {
+			return nil, errors.New("s3 policy bundles require an AWS config")
+		}
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("invalid S3 policy bundle URL %q: want s3://bucket/key", rawURL)
+		}
+		b.url = (&url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", u.Host, b.awsConfig.Region),
+			Path:   "/" + key,
+		}).String()
+	default:
+		return nil, fmt.Errorf("unsupported policy bundle URL scheme %q", u.Scheme)
+	}
+	return b, nil
+}
+
+// WithPolicyBundle loads policies from a remote bundle instead of the
+// embedded policy file
+func WithPolicyBundle(b *PolicyBundle) Option {
+	return func(a *Authorizer) {
+		a.policyBundle = b
+	}
+}
+
+// load returns the current bundle. Until a bundle has been fetched, a
+// failed fetch falls back to the cache file, if any.
+func (b *PolicyBundle) load(ctx context.Context) ([]policyFile, error) {
+	files, err := b.fetch(ctx)
+	if err == nil {
+		return files, nil
+	}
+	if b.files != nil || b.cacheFile == "" {
+		return nil, err
+	}
+
+	data, cacheErr := os.ReadFile(b.cacheFile)
+	if cacheErr != nil {
+		return nil, err
+	}
+	files, cacheErr = readBundle(data)
+	if cacheErr != nil {
+		return nil, err
+	}
+	log.Printf("Using cached policy bundle %s: %v", b.cacheFile, err)
+	b.files = files
+	return files, nil
+}
+
+// fetch downloads the bundle if it changed since the last good one
+func (b *PolicyBundle) fetch(ctx context.Context) ([]policyFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.etag != "" {
+		req.Header.Set("If-None-Match", b.etag)
+	}
+	if b.awsConfig != nil {
+		if err := b.sign(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch policy bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return b.files, nil
+	default:
+		return nil, fmt.Errorf("failed to fetch policy bundle: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch policy bundle: %w", err)
+	}
+	if len(data) > maxBundleSize {
+		return nil, fmt.Errorf("policy bundle exceeds %d bytes", maxBundleSize)
+	}
+	files, err := readBundle(data)
+	if err != nil {
+		return nil, err
+	}
+	// Only a bundle that parses becomes the last good one
+	if _, err := parsePolicyFiles(files); err != nil {
+		return nil, err
+	}
+
+	b.etag = resp.Header.Get("ETag")
+	b.files = files
+	if b.cacheFile != "" {
+		if err := writeFileAtomic(b.cacheFile, data); err != nil {
+			log.Printf("Failed to cache policy bundle: %v", err)
+		}
+	}
+	return files, nil
+}
+
+// sign adds AWS Signature Version 4 headers for S3
+func (b *PolicyBundle) sign(ctx context.Context, req *http.Request) error {
+	creds, err := b.awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	signer := v4.NewSigner(func(o *v4.SignerOptions) {
+		// S3 object keys are signed as they are sent
+		o.DisableURIPathEscaping = true
+	})
+	return signer.SignHTTP(ctx, creds, req, emptyPayloadHash, "s3", b.awsConfig.Region, time.Now())
+}
+
+// readBundle extracts the *.cedar files from a gzipped tarball, sorted by
+// name. Directories inside the archive are ignored.
+func readBundle(data []byte) ([]policyFile, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy bundle: %w", err)
+	}
+	defer gz.Close()
+
+	var files []policyFile
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || path.Ext(hdr.Name) != ".cedar" {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from policy bundle: %w", hdr.Name, err)
+		}
+		files = append(files, policyFile{name: path.Base(hdr.Name), content: content})
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no .cedar files found in policy bundle")
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+// writeFileAtomic replaces path with data so readers never see a partial
+// file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,162 @@
+package cedar
+
+import (
+	"cmp"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// cacheKey identifies an authorization request. The context hash also
+// covers the entity attributes so that a role or owner change is a miss.
+// It is a SHA-256 hash so that no two requests share a key in practice,
+// since a shared key would return one request's decision for the other.
+type cacheKey struct {
+	principal string
+	action    string
+	resource  string
+	context   [sha256.Size]byte
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	decision AuthzDecision
+	expires  time.Time
+}
+
+// decisionCache is an LRU cache of authorization decisions with a TTL
+type decisionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	clock   clock.Clock
+	entries map[cacheKey]*list.Element
+	order   *list.List
+}
+
+func newDecisionCache(ttl time.Duration, size int, clk clock.Clock) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		size:    size,
+		clock:   clk,
+		entries: make(map[cacheKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// newCacheKey builds the cache key for a Cedar request. Entities are
+// hashed in UID order straight from their typed values, without
+// serializing them to JSON. Each value is preceded by its length, and the
+// entities and their parents by their number, so that different requests
+// never hash the same input.
+func newCacheKey(entities cedar.EntityMap, req cedar.Request) cacheKey {
+	h := sha256.New()
+	write := func(b []byte) {
+		h.Write(binary.AppendUvarint(nil, uint64(len(b))))
+		h.Write(b)
+	}
+	h.Write(binary.AppendUvarint(nil, uint64(len(entities))))
+	for _, uid := range slices.SortedFunc(maps.Keys(entities), compareUIDs) {
+		e := entities[uid]
+		write(uid.MarshalCedar())
+		h.Write(binary.AppendUvarint(nil, uint64(e.Parents.Len())))
+		for _, parent := range slices.SortedFunc(e.Parents.All(), compareUIDs) {
+			write(parent.MarshalCedar())
+		}
+		write(e.Attributes.MarshalCedar())
+		write(e.Tags.MarshalCedar())
+	}
+	write(req.Context.MarshalCedar())
+
+	key := cacheKey{
+		principal: req.Principal.String(),
+		action:    req.Action.String(),
+		resource:  req.Resource.String(),
+	}
+	h.Sum(key.context[:0])
+	return key
+}
+
+// cacheKeyContext is implemented by context builders whose attributes
+// change too often for the decision cache to be keyed on them as they are
+type cacheKeyContext interface {
+	// cacheContext rewrites the builder's attributes in attrs, a copy of
+	// the request context, into what the decision cache is keyed on. It
+	// reports false if decisions must not be cached at all.
+	cacheContext(attrs cedar.RecordMap) bool
+}
+
+// cacheKey builds the cache key for a Cedar request with the context as
+// the context builders key it, or reports false if the request must not
+// be cached
+func (a *Authorizer) cacheKey(entities cedar.EntityMap, req cedar.Request) (cacheKey, bool) {
+	attrs := req.Context.Map()
+	for _, builder := range a.contextBuilders {
+		if b, ok := builder.(cacheKeyContext); ok && !b.cacheContext(attrs) {
+			return cacheKey{}, false
+		}
+	}
+	req.Context = cedar.NewRecord(attrs)
+	return newCacheKey(entities, req), true
+}
+
+func compareUIDs(a, b cedar.EntityUID) int {
+	return cmp.Or(cmp.Compare(a.Type, b.Type), cmp.Compare(a.ID, b.ID))
+}
+
+// get returns a cached decision if present and not expired
+func (c *decisionCache) get(key cacheKey) (AuthzDecision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return AuthzDecision{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.clock.Now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return AuthzDecision{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.decision, true
+}
+
+// put stores a decision, evicting the least recently used entry when full
+func (c *decisionCache) put(key cacheKey, decision AuthzDecision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.clock.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.decision = decision
+		entry.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, decision: decision, expires: expires})
+}
+
+// purge drops all cached decisions
+func (c *decisionCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[cacheKey]*list.Element)
+	c.order.Init()
+}
@@ -0,0 +1,138 @@
+package cedar
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// countingEvaluator allows everything and counts the requests it decides
+type countingEvaluator struct {
+	calls atomic.Int64
+}
+
+func (e *countingEvaluator) Evaluate(context.Context, cedar.EntityMap, cedar.Request) (AuthzDecision, error) {
+	e.calls.Add(1)
+	return AuthzDecision{Allowed: true}, nil
+}
+
+// TestDecisionCacheTimeContext checks that the time of the request does not
+// keep repeated requests from being served from the cache within the hour
+func TestDecisionCacheTimeContext(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC))
+	hours, err := ParseBusinessHours("09:00-18:00", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	evaluator := &countingEvaluator{}
+	a, err := NewAuthorizer(
+		WithClock(clk),
+		WithDecisionCache(2*time.Hour, 10),
+		WithEvaluator(evaluator),
+		WithEntityProvider(StaticEntityProvider{
+			Documents: map[string]Document{"doc-1": {ID: "doc-1", OwnerID: "user-1", Classification: "public"}},
+		}),
+		WithContextBuilders(TimeContext(clk, hours)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := AuthzRequest{UserID: "user-1", UserRole: "viewer", Action: "GetDocument", ResourceID: "doc-1"}
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		calls   int64
+	}{
+		{"first request is evaluated", 0, 1},
+		{"repeat a second later hits the cache", time.Second, 1},
+		{"repeat later in the hour hits the cache", 30 * time.Minute, 1},
+		{"repeat in the next hour is evaluated", 30 * time.Minute, 2},
+		{"repeat within that hour hits the cache", time.Minute, 2},
+	}
+	for _, step := range steps {
+		clk.Advance(step.advance)
+		decision, err := a.Authorize(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !decision.Allowed {
+			t.Errorf("%s: denied", step.name)
+		}
+		if got := evaluator.calls.Load(); got != step.calls {
+			t.Errorf("%s: %d evaluations, want %d", step.name, got, step.calls)
+		}
+	}
+}
+
+// TestNewCacheKey checks that requests differing in anything the policies
+// can see have different keys, including when the same bytes are split
+// differently between their values
+func TestNewCacheKey(t *testing.T) {
+	user := cedar.NewEntityUID("DocumentApp::User", "user-1")
+	doc := cedar.NewEntityUID("DocumentApp::Document", "doc-1")
+	group := cedar.NewEntityUID("DocumentApp::UserGroup", "group-1")
+
+	type request struct {
+		context   cedar.RecordMap
+		userAttrs cedar.RecordMap
+		parents   []cedar.EntityUID
+		docAttrs  cedar.RecordMap
+	}
+	key := func(r request) cacheKey {
+		entities := cedar.EntityMap{
+			user: {UID: user, Parents: cedar.NewEntityUIDSet(r.parents...), Attributes: cedar.NewRecord(r.userAttrs)},
+			doc:  {UID: doc, Attributes: cedar.NewRecord(r.docAttrs)},
+		}
+		return newCacheKey(entities, cedar.Request{
+			Principal: user,
+			Action:    cedar.NewEntityUID("DocumentApp::Action", "GetDocument"),
+			Resource:  doc,
+			Context:   cedar.NewRecord(r.context),
+		})
+	}
+	base := request{
+		context:   cedar.RecordMap{"mfa_verified": cedar.True},
+		userAttrs: cedar.RecordMap{"role": cedar.String("viewer")},
+		parents:   []cedar.EntityUID{group},
+		docAttrs:  cedar.RecordMap{"owner_id": cedar.String("user-2")},
+	}
+
+	tests := []struct {
+		name string
+		req  request
+		same bool
+	}{
+		{"identical request", base, true},
+		{"context differs", request{
+			context:   cedar.RecordMap{"mfa_verified": cedar.False},
+			userAttrs: base.userAttrs, parents: base.parents, docAttrs: base.docAttrs,
+		}, false},
+		{"principal attribute differs", request{
+			context:   base.context,
+			userAttrs: cedar.RecordMap{"role": cedar.String("admin")},
+			parents:   base.parents, docAttrs: base.docAttrs,
+		}, false},
+		{"parent differs", request{
+			context: base.context, userAttrs: base.userAttrs, docAttrs: base.docAttrs,
+		}, false},
+		{"attribute moved to another entity", request{
+			context:   base.context,
+			userAttrs: cedar.RecordMap{"role": cedar.String("viewer"), "owner_id": cedar.String("user-2")},
+			parents:   base.parents,
+			docAttrs:  cedar.RecordMap{},
+		}, false},
+	}
+	want := key(base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key(tt.req); (got == want) != tt.same {
+				t.Errorf("key equal to the base request's: %t, want %t", got == want, tt.same)
+			}
+		})
+	}
+}
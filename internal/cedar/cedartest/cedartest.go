@@ -0,0 +1,139 @@
+// Package cedartest provides an in-memory authorizer for testing handlers
+// without the Cedar policy engine or the embedded policies. MockAuthorizer
+// implements api.Authorizer.
+package cedartest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+)
+
+// decisionKey identifies a programmed decision. An empty resource ID
+// matches every resource.
+type decisionKey struct {
+	action     string
+	resourceID string
+}
+
+type outcome struct {
+	decision cedar.AuthzDecision
+	err      error
+}
+
+// MockAuthorizer answers with programmed decisions and records every
+// request it is asked about. Requests nothing was programmed for are
+// denied with INSUFFICIENT_PERMISSIONS. It is safe for concurrent use.
+type MockAuthorizer struct {
+	mu        sync.Mutex
+	outcomes  map[decisionKey]outcome
+	filter    cedar.Filter
+	filterErr error
+	calls     []cedar.AuthzRequest
+}
+
+// NewMockAuthorizer creates a mock that denies everything. ResourceFilter
+// returns cedar.ErrUnsupportedFilter until SetFilter is called, so list
+// handlers fall back to asking Authorize about each resource.
+func NewMockAuthorizer() *MockAuthorizer {
+	return &MockAuthorizer{
+		outcomes:  map[decisionKey]outcome{},
+		filterErr: cedar.ErrUnsupportedFilter,
+	}
+}
+
+// Allow permits action on resourceID, or on every resource when resourceID
+// is empty
+func (m *MockAuthorizer) Allow(action, resourceID string) {
+	m.SetDecision(action, resourceID, cedar.AuthzDecision{Allowed: true})
+}
+
+// Deny denies action on resourceID with the given reason code, or on every
+// resource when resourceID is empty
+func (m *MockAuthorizer) Deny(action, resourceID, code string) {
+	m.SetDecision(action, resourceID, cedar.AuthzDecision{Code: code})
+}
+
+// SetDecision programs the decision returned for action on resourceID, or
+// on every resource when resourceID is empty
+func (m *MockAuthorizer) SetDecision(action, resourceID string, decision cedar.AuthzDecision) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes[decisionKey{action, resourceID}] = outcome{decision: decision}
+}
+
+// SetError makes Authorize fail with err for action on resourceID, or on
+// every resource when resourceID is empty
+func (m *MockAuthorizer) SetError(action, resourceID string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes[decisionKey{action, resourceID}] = outcome{err: err}
+}
+
+// SetFilter sets what ResourceFilter returns
+func (m *MockAuthorizer) SetFilter(filter cedar.Filter, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filter = filter
+	m.filterErr = err
+}
+
+// Calls returns the requests passed to Authorize and AuthorizeBatch, in
+// order
+func (m *MockAuthorizer) Calls() []cedar.AuthzRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	calls := make([]cedar.AuthzRequest, len(m.calls))
+	copy(calls, m.calls)
+	return calls
+}
+
+// Reset forgets recorded calls. Programmed decisions are kept.
+func (m *MockAuthorizer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+}
+
+// Authorize records req and returns the decision programmed for it, or
+// ctx's error once ctx is done
+func (m *MockAuthorizer) Authorize(ctx context.Context, req cedar.AuthzRequest) (cedar.AuthzDecision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, req)
+
+	if err := ctx.Err(); err != nil {
+		return cedar.AuthzDecision{}, err
+	}
+
+	o, ok := m.outcomes[decisionKey{req.Action, req.ResourceID}]
+	if !ok {
+		o, ok = m.outcomes[decisionKey{req.Action, ""}]
+	}
+	if !ok {
+		return cedar.AuthzDecision{Code: cedar.CodeInsufficientPermissions}, nil
+	}
+	return o.decision, o.err
+}
+
+// AuthorizeBatch validates and authorizes each request like the real
+// authorizer
+func (m *MockAuthorizer) AuthorizeBatch(ctx context.Context, reqs []cedar.AuthzRequest) []cedar.BatchResult {
+	results := make([]cedar.BatchResult, len(reqs))
+	for i, req := range reqs {
+		if err := req.Validate(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Decision, results[i].Err = m.Authorize(ctx, req)
+	}
+	return results
+}
+
+// ResourceFilter returns the filter set with SetFilter
+func (m *MockAuthorizer) ResourceFilter(_ context.Context, _ cedar.AuthzRequest) (cedar.Filter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filter, m.filterErr
+}
@@ -0,0 +1,143 @@
+package cedar
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// ContextBuilder enriches the Cedar context of an authorization request.
+// Builders run in order after the built-in IP attributes and the request's
+// own context attributes are set and may add or overwrite attributes in attrs.
+type ContextBuilder interface {
+	BuildContext(ctx context.Context, req AuthzRequest, attrs cedar.RecordMap) error
+}
+
+// ContextBuilderFunc adapts a function to the ContextBuilder interface
+type ContextBuilderFunc func(ctx context.Context, req AuthzRequest, attrs cedar.RecordMap) error
+
+// BuildContext calls f
+func (f ContextBuilderFunc) BuildContext(ctx context.Context, req AuthzRequest, attrs cedar.RecordMap) error {
+	return f(ctx, req, attrs)
+}
+
+// publicLinkAttr is the context attribute set for requests made through a
+// public share link, so policies can keep documents from being shared that
+// way
+const publicLinkAttr = "is_public_link"
+
+// RequestMethodContext adds the HTTP method as "request_method"
+var RequestMethodContext = ContextBuilderFunc(func(_ context.Context, req AuthzRequest, attrs cedar.RecordMap) error {
+	if req.HTTPRequest != nil {
+		attrs["request_method"] = cedar.String(req.HTTPRequest.Method)
+	}
+	return nil
+})
+
+// BusinessHours is the working day used for "is_business_hours": Monday to
+// Friday from Start to End, as offsets from midnight in Location
+type BusinessHours struct {
+	Location *time.Location
+	Start    time.Duration
+	End      time.Duration
+}
+
+// ParseBusinessHours parses hours such as "09:00-18:00" in the named time
+// zone
+func ParseBusinessHours(hours, timezone string) (BusinessHours, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return BusinessHours{}, fmt.Errorf("invalid time zone %q: %w", timezone, err)
+	}
+
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return BusinessHours{}, fmt.Errorf("invalid business hours %q: expected HH:MM-HH:MM", hours)
+	}
+	bh := BusinessHours{Location: loc}
+	for _, t := range []struct {
+		text string
+		dst  *time.Duration
+	}{{from, &bh.Start}, {to, &bh.End}} {
+		parsed, err := time.Parse("15:04", strings.TrimSpace(t.text))
+		if err != nil {
+			return BusinessHours{}, fmt.Errorf("invalid business hours %q: expected HH:MM-HH:MM", hours)
+		}
+		*t.dst = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+	if bh.End <= bh.Start {
+		return BusinessHours{}, fmt.Errorf("invalid business hours %q: end must be after start", hours)
+	}
+	return bh, nil
+}
+
+// contains reports whether t falls within business hours
+func (bh BusinessHours) contains(t time.Time) bool {
+	t = t.In(bh.Location)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	return sinceMidnight >= bh.Start && sinceMidnight < bh.End
+}
+
+// TimeContext adds the time of the request according to clk:
+// "request_time" as Unix seconds, "day_of_week" as an English day name in
+// the business hours time zone, and "is_business_hours". The decision
+// cache keys on "request_time" to the hour, so a policy comparing it with
+// a time that is not on the hour may be decided from the cache up to the
+// cache TTL late.
+func TimeContext(clk clock.Clock, hours BusinessHours) ContextBuilder {
+	return timeContext{clock: clk, hours: hours}
+}
+
+type timeContext struct {
+	clock clock.Clock
+	hours BusinessHours
+}
+
+// BuildContext sets the time attributes
+func (c timeContext) BuildContext(_ context.Context, _ AuthzRequest, attrs cedar.RecordMap) error {
+	now := c.clock.Now()
+	attrs["request_time"] = cedar.Long(now.Unix())
+	attrs["day_of_week"] = cedar.String(now.In(c.hours.Location).Weekday().String())
+	attrs["is_business_hours"] = cedar.Boolean(c.hours.contains(now))
+	return nil
+}
+
+// cacheContext truncates "request_time" to the hour, which changes every
+// second otherwise. "day_of_week" and "is_business_hours" change seldom
+// enough to be keyed on as they are.
+func (timeContext) cacheContext(attrs cedar.RecordMap) bool {
+	if t, ok := attrs["request_time"].(cedar.Long); ok {
+		attrs["request_time"] = t - t%cedar.Long(time.Hour/time.Second)
+	}
+	return true
+}
+
+// contextValue converts a caller-supplied context attribute into a Cedar
+// value. Numbers decoded from JSON arrive as float64 and must be whole.
+func contextValue(v any) (cedar.Value, error) {
+	switch v := v.(type) {
+	case string:
+		return cedar.String(v), nil
+	case bool:
+		return cedar.Boolean(v), nil
+	case int:
+		return cedar.Long(v), nil
+	case int64:
+		return cedar.Long(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v > math.MaxInt64 {
+			return nil, fmt.Errorf("%v is not a whole number", v)
+		}
+		return cedar.Long(v), nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", v)
+	}
+}
@@ -0,0 +1,208 @@
+package cedar
+
+import (
+	"fmt"
+	"iter"
+	"sort"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+)
+
+// Deny codes that do not come from a policy annotation
+const (
+	// CodeForbidden is used for forbids without a deny_code annotation
+	CodeForbidden = "FORBIDDEN"
+	// CodeInsufficientPermissions is used when no permit covers the
+	// principal and action at all
+	CodeInsufficientPermissions = "INSUFFICIENT_PERMISSIONS"
+	// CodeEvaluationError is used when the request was denied because
+	// policies failed to evaluate
+	CodeEvaluationError = "EVALUATION_ERROR"
+)
+
+// denyCodeAnnotation names the policy annotation holding a deny code. On a
+// forbid it is the code reported when the forbid denies a request. On a
+// permit it is the code reported when the permit covers the principal and
+// action but the resource does not meet its conditions, e.g. NOT_OWNER.
+const denyCodeAnnotation = "deny_code"
+
+// reasonAnnotation names the policy annotation holding a human-readable
+// explanation. It is reported with the deny code taken from the same
+// policy.
+const reasonAnnotation = "reason"
+
+// AuthzDecision describes the outcome of an authorization check
+type AuthzDecision struct {
+	// Allowed reports whether the request was permitted
+	Allowed bool
+	// MatchedPolicies lists the IDs of the policies that determined the
+	// decision, in source order: the permits on Allow, the forbids on an
+	// explicit Deny
+	MatchedPolicies []string
+	// ForbidOverride is set when a forbid denied a request that at least
+	// one permit policy would have allowed
+	ForbidOverride bool
+	// DecidingPolicy is the forbid reported for an explicit Deny: the
+	// first matching forbid in source order that carries a deny code, or
+	// else the first matching forbid. Code comes from it.
+	DecidingPolicy string
+	// OverriddenPolicies lists the permits, in source order, that matched
+	// but were overridden when ForbidOverride is set
+	OverriddenPolicies []string
+	// Errors holds policy evaluation errors. Policies that error are
+	// skipped, which can turn an expected Allow into a Deny.
+	Errors []string
+	// Code is a stable, machine-readable reason for a denial. It is empty
+	// when the request is allowed.
+	Code string
+	// Reason explains a denial in words. It comes from the @reason
+	// annotation of the policy Code was taken from, if any.
+	Reason string
+	// UnknownContext lists the context attributes that were unknown when
+	// the request was decided
+	UnknownContext []string
+}
+
+// DenyMessage describes a denial for an error response, naming the
+// deciding forbid and its reason when known
+func (d AuthzDecision) DenyMessage() string {
+	switch {
+	case d.DecidingPolicy != "" && d.Reason != "":
+		return fmt.Sprintf("Denied by policy %s: %s", d.DecidingPolicy, d.Reason)
+	case d.Reason != "":
+		return "Access denied: " + d.Reason
+	default:
+		return "Access denied: insufficient permissions"
+	}
+}
+
+// newDecision builds an AuthzDecision from a Cedar evaluation result
+func newDecision(policySet *cedar.PolicySet, entities cedar.EntityGetter, req cedar.Request, decision cedar.Decision, diag cedar.Diagnostic) AuthzDecision {
+	d := AuthzDecision{
+		Allowed: decision == cedar.Allow,
+	}
+	// Report policies in source order so the deciding forbid is stable
+	reasons := reasonIDs(policySet, diag)
+	for _, id := range reasons {
+		d.MatchedPolicies = append(d.MatchedPolicies, string(id))
+	}
+	for _, e := range diag.Errors {
+		d.Errors = append(d.Errors, e.String())
+	}
+
+	if d.Allowed {
+		return d
+	}
+	if len(reasons) == 0 {
+		d.Code, d.Reason = denyCode(policySet, entities, req, diag)
+		return d
+	}
+
+	// An explicit deny carries the matching forbids as reasons; re-evaluate
+	// the permits alone to find out which of them were overridden
+	deciding := decidingForbid(policySet, reasons)
+	d.DecidingPolicy = string(deciding)
+	annotations := policySet.Get(deciding).Annotations()
+	d.Code = CodeForbidden
+	if code, ok := annotations[denyCodeAnnotation]; ok {
+		d.Code = string(code)
+	}
+	d.Reason = string(annotations[reasonAnnotation])
+	permitDecision, permitDiag := cedar.Authorize(permitsOf(policySet), entities, req)
+	if permitDecision == cedar.Allow {
+		d.ForbidOverride = true
+		for _, id := range reasonIDs(policySet, permitDiag) {
+			d.OverriddenPolicies = append(d.OverriddenPolicies, string(id))
+		}
+	}
+	return d
+}
+
+// reasonIDs returns the policies in diag's reasons in source order
+func reasonIDs(policySet *cedar.PolicySet, diag cedar.Diagnostic) []cedar.PolicyID {
+	ids := make([]cedar.PolicyID, 0, len(diag.Reasons))
+	for _, reason := range diag.Reasons {
+		ids = append(ids, reason.PolicyID)
+	}
+	sortBySource(policySet, ids)
+	return ids
+}
+
+// decidingForbid picks the forbid reported for an explicit deny from the
+// matching forbids in source order: the first with a deny code, or else
+// the first
+func decidingForbid(policySet *cedar.PolicySet, forbids []cedar.PolicyID) cedar.PolicyID {
+	for _, id := range forbids {
+		if _, ok := policySet.Get(id).Annotations()[denyCodeAnnotation]; ok {
+			return id
+		}
+	}
+	return forbids[0]
+}
+
+// denyCode explains a default deny, where no forbid matched. It takes the
+// code and reason of a permit that would apply to some resource, since
+// only the resource kept it from matching.
+func denyCode(policySet *cedar.PolicySet, entities cedar.EntityGetter, req cedar.Request, diag cedar.Diagnostic) (code, reason string) {
+	if len(diag.Errors) > 0 {
+		return CodeEvaluationError, ""
+	}
+
+	env := eval.Env{
+		Entities:  entities,
+		Principal: req.Principal,
+		Action:    req.Action,
+		Resource:  eval.Variable(resourceVariable),
+		Context:   req.Context,
+	}
+	var ids []cedar.PolicyID
+	for id, policy := range permitsOf(policySet).All() {
+		if _, ok := policy.Annotations()[denyCodeAnnotation]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sortBySource(policySet, ids)
+	for _, id := range ids {
+		policy := policySet.Get(id)
+		if _, keep := eval.PartialPolicy(env, (*ast.Policy)(policy.AST())); keep {
+			annotations := policy.Annotations()
+			return string(annotations[denyCodeAnnotation]), string(annotations[reasonAnnotation])
+		}
+	}
+	return CodeInsufficientPermissions, ""
+}
+
+// sortBySource orders policy IDs by where the policies are defined
+func sortBySource(policySet *cedar.PolicySet, ids []cedar.PolicyID) {
+	sort.Slice(ids, func(i, j int) bool {
+		pi, pj := policySet.Get(ids[i]).Position(), policySet.Get(ids[j]).Position()
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+}
+
+// permitsOf returns an iterator over the permit policies of a policy set
+func permitsOf(policySet *cedar.PolicySet) cedar.PolicyIterator {
+	return permitIterator{policySet}
+}
+
+type permitIterator struct {
+	policySet *cedar.PolicySet
+}
+
+func (p permitIterator) All() iter.Seq2[cedar.PolicyID, *cedar.Policy] {
+	return func(yield func(cedar.PolicyID, *cedar.Policy) bool) {
+		for id, policy := range p.policySet.All() {
+			if policy.Effect() != cedar.Permit {
+				continue
+			}
+			if !yield(id, policy) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cedar
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+)
+
+// DecisionLogger writes authorization decisions as JSON lines. Denials are
+// always written; allows are sampled so that routine traffic does not drown
+// out the denials.
+type DecisionLogger struct {
+	logger     *slog.Logger
+	sampleRate float64
+	sample     func() float64
+}
+
+// NewDecisionLogger creates a decision logger writing to w that keeps the
+// given fraction (0 to 1) of allowed decisions
+func NewDecisionLogger(w io.Writer, sampleRate float64) *DecisionLogger {
+	return &DecisionLogger{
+		logger:     slog.New(slog.NewJSONHandler(w, nil)),
+		sampleRate: sampleRate,
+		sample:     rand.Float64,
+	}
+}
+
+// Record writes rec if it is a denial or falls within the sample
+func (l *DecisionLogger) Record(rec AuditRecord) {
+	decision := "deny"
+	if rec.Allowed {
+		if l.sample() >= l.sampleRate {
+			return
+		}
+		decision = "allow"
+	}
+
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, "authorization decision",
+		slog.Time("decided_at", rec.Time),
+		slog.String("principal", rec.PrincipalID),
+		slog.String("role", rec.PrincipalRole),
+		slog.String("group", rec.PrincipalGroup),
+		slog.String("action", rec.Action),
+		slog.String("resource", rec.ResourceID),
+		slog.String("decision", decision),
+		slog.String("code", rec.Code),
+		slog.String("reason", rec.Reason),
+		slog.Any("policies", rec.MatchedPolicies),
+		slog.String("ip_address", rec.IPAddress),
+		slog.Bool("is_private_ip", rec.IsPrivateIP),
+		slog.Bool("is_japan_ip", rec.IsJapanIP),
+		slog.Bool("mfa_verified", rec.MFAVerified),
+		slog.Bool("sampled", rec.Allowed),
+	)
+}
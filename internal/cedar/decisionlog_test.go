@@ -0,0 +1,40 @@
+package cedar
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// TestDecisionLoggerFields checks that a denial is logged with its code and
+// reason, and the policies that decided it in a field of their own
+func TestDecisionLoggerFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewDecisionLogger(&buf, 0)
+	l.Record(AuditRecord{
+		PrincipalID:     "user-3",
+		Action:          "GetDocument",
+		ResourceID:      "doc-1",
+		MatchedPolicies: []string{"geo-block-jp"},
+		Code:            "GEO_RESTRICTED",
+		Reason:          "access restricted to Japan",
+	})
+	l.Record(AuditRecord{PrincipalID: "user-1", Action: "GetDocument", ResourceID: "doc-1", Allowed: true})
+
+	var line struct {
+		Decision string   `json:"decision"`
+		Code     string   `json:"code"`
+		Reason   string   `json:"reason"`
+		Policies []string `json:"policies"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("want one JSON line for the denial: %v: %s", err, buf.String())
+	}
+	if line.Decision != "deny" || line.Code != "GEO_RESTRICTED" || line.Reason != "access restricted to Japan" {
+		t.Errorf("got decision %q code %q reason %q", line.Decision, line.Code, line.Reason)
+	}
+	if !slices.Equal(line.Policies, []string{"geo-block-jp"}) {
+		t.Errorf("got policies %v, want [geo-block-jp]", line.Policies)
+	}
+}
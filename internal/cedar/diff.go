@@ -0,0 +1,162 @@
+package cedar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// PolicyChange describes how one stored policy differs between two
+// versions. Kind is "added", "removed", or "modified".
+type PolicyChange struct {
+	ID     string
+	Kind   string
+	Before string
+	After  string
+}
+
+// DecisionFlip is a replayed request that the two versions decide
+// differently
+type DecisionFlip struct {
+	Request AuthzRequest
+	Before  AuthzDecision
+	After   AuthzDecision
+}
+
+// PolicyDiff compares two policy versions by their text and by replaying
+// requests against both
+type PolicyDiff struct {
+	From    int64
+	To      int64
+	Changes []PolicyChange
+	// Replayed counts the requests decided under both versions. Requests
+	// that can no longer be evaluated, e.g. because the document was
+	// deleted, are counted in Skipped.
+	Replayed int
+	Skipped  int
+	Flips    []DecisionFlip
+}
+
+// DiffPolicyVersions compares the stored policies of two versions and
+// replays corpus against both. Requests are evaluated with the current
+// entities and template-linked policies, so only the versions differ.
+func (a *Authorizer) DiffPolicyVersions(ctx context.Context, from, to int64, corpus []AuthzRequest) (PolicyDiff, error) {
+	if a.policyStore == nil {
+		return PolicyDiff{}, ErrNoPolicyStore
+	}
+	before, err := loadSnapshot(ctx, a.policyStore.db, from)
+	if err != nil {
+		return PolicyDiff{}, err
+	}
+	after, err := loadSnapshot(ctx, a.policyStore.db, to)
+	if err != nil {
+		return PolicyDiff{}, err
+	}
+
+	var links []policyFile
+	if a.templateStore != nil {
+		links, err = a.templateStore.loadLinkedPolicies(ctx)
+		if err != nil {
+			return PolicyDiff{}, err
+		}
+	}
+	beforeSet, err := snapshotPolicySet(before, links)
+	if err != nil {
+		return PolicyDiff{}, fmt.Errorf("version %d: %w", from, err)
+	}
+	afterSet, err := snapshotPolicySet(after, links)
+	if err != nil {
+		return PolicyDiff{}, fmt.Errorf("version %d: %w", to, err)
+	}
+
+	diff := PolicyDiff{
+		From:    from,
+		To:      to,
+		Changes: diffSnapshots(before, after),
+	}
+	for _, r := range corpus {
+		if err := ctx.Err(); err != nil {
+			return PolicyDiff{}, fmt.Errorf("policy diff aborted: %w", err)
+		}
+		var entities cedar.EntityMap
+		var req cedar.Request
+		err := r.Validate()
+		if err == nil {
+			entities, req, err = a.prepare(ctx, r)
+		}
+		if errors.Is(err, ErrResourceNotFound) || errors.Is(err, ErrUnknownAction) || errors.Is(err, ErrInvalidRequest) {
+			diff.Skipped++
+			continue
+		}
+		if err != nil {
+			return PolicyDiff{}, err
+		}
+
+		beforeDecision, beforeDiag := beforeSet.IsAuthorized(entities, req)
+		afterDecision, afterDiag := afterSet.IsAuthorized(entities, req)
+		diff.Replayed++
+		if beforeDecision == afterDecision {
+			continue
+		}
+		diff.Flips = append(diff.Flips, DecisionFlip{
+			Request: r,
+			Before:  newDecision(beforeSet, entities, req, beforeDecision, beforeDiag),
+			After:   newDecision(afterSet, entities, req, afterDecision, afterDiag),
+		})
+	}
+	return diff, nil
+}
+
+// snapshotPolicySet parses a stored version together with the
+// template-linked policies
+func snapshotPolicySet(snapshot map[string]string, links []policyFile) (*cedar.PolicySet, error) {
+	policySet, err := parsePolicyFiles(snapshotFiles(snapshot))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	if err := addLinkedPolicies(policySet, links); err != nil {
+		return nil, err
+	}
+	return policySet, nil
+}
+
+// diffSnapshots lists the stored policies that differ, ordered by ID
+func diffSnapshots(before, after map[string]string) []PolicyChange {
+	var changes []PolicyChange
+	for id, old := range before {
+		updated, ok := after[id]
+		switch {
+		case !ok:
+			changes = append(changes, PolicyChange{ID: id, Kind: "removed", Before: old})
+		case updated != old:
+			changes = append(changes, PolicyChange{ID: id, Kind: "modified", Before: old, After: updated})
+		}
+	}
+	for id, updated := range after {
+		if _, ok := before[id]; !ok {
+			changes = append(changes, PolicyChange{ID: id, Kind: "added", After: updated})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+	return changes
+}
+
+// Request returns the authorization request an audit record was made
+// for. Caller-supplied context attributes are not recorded and are
+// missing from it.
+func (rec AuditRecord) Request() AuthzRequest {
+	return AuthzRequest{
+		UserID:      rec.PrincipalID,
+		UserRole:    rec.PrincipalRole,
+		UserGroupID: rec.PrincipalGroup,
+		Action:      rec.Action,
+		ResourceID:  rec.ResourceID,
+		IPAddress:   rec.IPAddress,
+		IsPrivateIP: rec.IsPrivateIP,
+		IsJapanIP:   rec.IsJapanIP,
+		MFAVerified: rec.MFAVerified,
+	}
+}
@@ -0,0 +1,394 @@
+package cedar
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/lib/pq"
+)
+
+// Entity types used by the DocumentApp schema
+const (
+	userType          = cedar.EntityType("DocumentApp::User")
+	userGroupType     = cedar.EntityType("DocumentApp::UserGroup")
+	documentType      = cedar.EntityType("DocumentApp::Document")
+	documentGroupType = cedar.EntityType("DocumentApp::DocumentGroup")
+	actionType        = cedar.EntityType("DocumentApp::Action")
+)
+
+// collectionResourceID is the resource ID used for collection-level actions
+const collectionResourceID = "documents"
+
+// collectionActions act on the document collection rather than on a
+// single document
+var collectionActions = map[string]bool{
+	"ListDocuments":         true,
+	"CreateDocument":        true,
+	"ManageUserGroups":      true,
+	"ManageDocumentGroups":  true,
+	"ManageUsers":           true,
+	"ManageWebhooks":        true,
+	"ManagePolicies":        true,
+	"ViewAuditLog":          true,
+	"SimulateAuthorization": true,
+	"DiffPolicies":          true,
+	"ExportPolicies":        true,
+	"ImportPolicies":        true,
+	"GrantBreakGlass":       true,
+	"ViewDocumentStats":     true,
+	"CheckAuthorization":    true,
+}
+
+// ErrResourceNotFound is returned when the requested resource does not exist
+var ErrResourceNotFound = errors.New("resource not found")
+
+// Principal identifies the user making a request
+type Principal struct {
+	ID      string
+	Role    string
+	GroupID string
+}
+
+// EntityProvider loads the entities needed to evaluate a request
+type EntityProvider interface {
+	Entities(ctx context.Context, principal Principal, resourceID string) (cedar.EntityMap, error)
+}
+
+// UserAttributes holds the attributes of a user recorded in the users
+// table. Unlike the role, they are not taken from request headers.
+type UserAttributes struct {
+	Department       string
+	ClearanceLevel   int64
+	EmploymentStatus string
+}
+
+// userResolver is implemented by entity providers that know the recorded
+// attributes of users
+type userResolver interface {
+	userAttributes(ctx context.Context, userID string) (*UserAttributes, error)
+}
+
+// groupResolver is implemented by entity providers that know which user
+// groups a document group is associated with
+type groupResolver interface {
+	associatedUserGroups(ctx context.Context, documentGroupID string) ([]string, error)
+}
+
+// principalEntities builds the user entity and its group. A user in a group
+// has the group as parent and as its "group" attribute, so policies can
+// test "resource in principal.group". The recorded attributes are added
+// when the user is known.
+func principalEntities(principal Principal, recorded *UserAttributes) cedar.EntityMap {
+	user := cedar.Entity{
+		UID: cedar.NewEntityUID(userType, cedar.String(principal.ID)),
+	}
+	attrs := cedar.RecordMap{
+		"role": cedar.String(principal.Role),
+	}
+	if recorded != nil {
+		attrs["department"] = cedar.String(recorded.Department)
+		attrs["clearance_level"] = cedar.Long(recorded.ClearanceLevel)
+		attrs["employment_status"] = cedar.String(recorded.EmploymentStatus)
+	}
+
+	entities := cedar.EntityMap{}
+	if principal.GroupID != "" {
+		group := cedar.NewEntityUID(userGroupType, cedar.String(principal.GroupID))
+		user.Parents = cedar.NewEntityUIDSet(group)
+		attrs["group"] = group
+		entities[group] = cedar.Entity{UID: group}
+	}
+	user.Attributes = cedar.NewRecord(attrs)
+	entities[user.UID] = user
+
+	return entities
+}
+
+// principalOnlyProvider knows only about the principal. It is used when
+// no database-backed provider is configured.
+type principalOnlyProvider struct{}
+
+func (principalOnlyProvider) Entities(_ context.Context, principal Principal, _ string) (cedar.EntityMap, error) {
+	return principalEntities(principal, nil), nil
+}
+
+// PostgresEntityProvider loads documents and groups from the database
+type PostgresEntityProvider struct {
+	db     *sql.DB
+	groups *GroupCache
+}
+
+// ProviderOption configures a PostgresEntityProvider
+type ProviderOption func(*PostgresEntityProvider)
+
+// WithGroupCache serves group associations from c instead of querying
+// them for every request
+func WithGroupCache(c *GroupCache) ProviderOption {
+	return func(p *PostgresEntityProvider) {
+		p.groups = c
+	}
+}
+
+// NewPostgresEntityProvider creates an entity provider backed by the given database
+func NewPostgresEntityProvider(db *sql.DB, opts ...ProviderOption) *PostgresEntityProvider {
+	p := &PostgresEntityProvider{db: db}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Entities returns the principal with its recorded attributes, its user
+// group, and for document resources the document and its document group.
+// Group associations are modelled as the document group being a member of
+// each associated user group, so "resource in principal.group" holds for
+// associated groups.
+func (p *PostgresEntityProvider) Entities(ctx context.Context, principal Principal, resourceID string) (cedar.EntityMap, error) {
+	recorded, err := p.userAttributes(ctx, principal.ID)
+	if err != nil {
+		return nil, err
+	}
+	entities := principalEntities(principal, recorded)
+	if resourceID == collectionResourceID {
+		return entities, nil
+	}
+
+	doc := Document{ID: resourceID}
+	var documentGroupID sql.NullString
+	var metadata []byte
+	err = p.db.QueryRowContext(ctx, `
+		SELECT owner_id, document_group_id, classification, tags, legal_hold, metadata
+		FROM documents
+		WHERE id = $1
+	`, resourceID).Scan(&doc.OwnerID, &documentGroupID, &doc.Classification, pq.Array(&doc.Tags), &doc.LegalHold, &metadata)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: document %s", ErrResourceNotFound, resourceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load document: %w", err)
+	}
+	var values map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(metadata))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("failed to decode document metadata: %w", err)
+	}
+	doc.Metadata = MetadataTags(values)
+
+	doc.GroupID = documentGroupID.String
+	doc.Grants, err = p.documentGrants(ctx, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var userGroupIDs []string
+	if documentGroupID.Valid {
+		userGroupIDs, err = p.associatedUserGroups(ctx, documentGroupID.String)
+		if err != nil {
+			return nil, err
+		}
+	}
+	addDocument(entities, doc, userGroupIDs)
+
+	return entities, nil
+}
+
+// userAttributes returns the recorded attributes of a user, or nil if the
+// user is not in the users table
+func (p *PostgresEntityProvider) userAttributes(ctx context.Context, userID string) (*UserAttributes, error) {
+	var recorded UserAttributes
+	err := p.db.QueryRowContext(ctx, `
+		SELECT department, clearance_level, employment_status
+		FROM users
+		WHERE id = $1
+	`, userID).Scan(&recorded.Department, &recorded.ClearanceLevel, &recorded.EmploymentStatus)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return &recorded, nil
+}
+
+// associatedUserGroups returns the user groups associated with a document group
+func (p *PostgresEntityProvider) associatedUserGroups(ctx context.Context, documentGroupID string) ([]string, error) {
+	if p.groups != nil {
+		return p.groups.associatedUserGroups(documentGroupID), nil
+	}
+	return queryAssociatedUserGroups(ctx, p.db, documentGroupID)
+}
+
+// queryAssociatedUserGroups reads the user groups associated with a
+// document group from the database
+func queryAssociatedUserGroups(ctx context.Context, db *sql.DB, documentGroupID string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_group_id
+		FROM group_associations
+		WHERE document_group_id = $1
+	`, documentGroupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load group associations: %w", err)
+	}
+	defer rows.Close()
+
+	var userGroupIDs []string
+	for rows.Next() {
+		var userGroupID string
+		if err := rows.Scan(&userGroupID); err != nil {
+			return nil, fmt.Errorf("failed to scan group association: %w", err)
+		}
+		userGroupIDs = append(userGroupIDs, userGroupID)
+	}
+	return userGroupIDs, rows.Err()
+}
+
+// documentGrants reads the users granted access to a document in
+// document_grants
+func (p *PostgresEntityProvider) documentGrants(ctx context.Context, documentID string) (map[string]ShareAccess, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT user_id, access
+		FROM document_grants
+		WHERE document_id = $1
+	`, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load document grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := map[string]ShareAccess{}
+	for rows.Next() {
+		var userID string
+		var access ShareAccess
+		if err := rows.Scan(&userID, &access); err != nil {
+			return nil, fmt.Errorf("failed to scan document grant: %w", err)
+		}
+		grants[userID] = access
+	}
+	return grants, rows.Err()
+}
+
+// addDocument adds a document entity with its owner, classification, tags,
+// and legal hold as attributes. The users granted access are its "readers", and
+// those granted write access also its "writers". Its metadata are its
+// entity tags. A document in a group has the group as parent and as
+// its "group" attribute; the document group in turn has the associated
+// user groups as parents.
+func addDocument(entities cedar.EntityMap, doc Document, userGroupIDs []string) {
+	document := cedar.Entity{
+		UID: cedar.NewEntityUID(documentType, cedar.String(doc.ID)),
+	}
+	tags := make([]cedar.Value, 0, len(doc.Tags))
+	for _, tag := range doc.Tags {
+		tags = append(tags, cedar.String(tag))
+	}
+	var readers, writers []cedar.Value
+	for userID, access := range doc.Grants {
+		user := cedar.NewEntityUID(userType, cedar.String(userID))
+		readers = append(readers, user)
+		if access == ShareWrite {
+			writers = append(writers, user)
+		}
+	}
+	attrs := cedar.RecordMap{
+		"owner":          cedar.NewEntityUID(userType, cedar.String(doc.OwnerID)),
+		"classification": cedar.String(doc.Classification),
+		"tags":           cedar.NewSet(tags...),
+		"readers":        cedar.NewSet(readers...),
+		"writers":        cedar.NewSet(writers...),
+		"legal_hold":     cedar.Boolean(doc.LegalHold),
+	}
+
+	if doc.GroupID != "" {
+		userGroups := make([]cedar.EntityUID, 0, len(userGroupIDs))
+		for _, userGroupID := range userGroupIDs {
+			userGroups = append(userGroups, cedar.NewEntityUID(userGroupType, cedar.String(userGroupID)))
+		}
+		group := cedar.Entity{
+			UID:     cedar.NewEntityUID(documentGroupType, cedar.String(doc.GroupID)),
+			Parents: cedar.NewEntityUIDSet(userGroups...),
+		}
+		document.Parents = cedar.NewEntityUIDSet(group.UID)
+		attrs["group"] = group.UID
+		entities[group.UID] = group
+	}
+	document.Attributes = cedar.NewRecord(attrs)
+	metadata := make(cedar.RecordMap, len(doc.Metadata))
+	for key, value := range doc.Metadata {
+		metadata[cedar.String(key)] = cedar.String(value)
+	}
+	document.Tags = cedar.NewRecord(metadata)
+	entities[document.UID] = document
+}
+
+// MetadataTags returns document metadata as entity tags. Since the tags of
+// a document are strings, integers and booleans are given as text, as in
+// JSON.
+func MetadataTags(metadata map[string]any) map[string]string {
+	tags := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		tags[key] = fmt.Sprint(value)
+	}
+	return tags
+}
+
+// Document describes a document for StaticEntityProvider
+type Document struct {
+	ID             string
+	OwnerID        string
+	GroupID        string
+	Classification string
+	Tags           []string
+	// LegalHold is set while the document must not be deleted
+	LegalHold bool
+	// Metadata holds the document's custom fields as text
+	Metadata map[string]string
+	// Grants maps the IDs of users granted access to the document to
+	// their access
+	Grants map[string]ShareAccess
+}
+
+// StaticEntityProvider serves documents and group associations from
+// memory, e.g. for tests and policy scenarios
+type StaticEntityProvider struct {
+	// Documents maps document IDs to documents
+	Documents map[string]Document
+	// GroupAssociations maps document group IDs to associated user group IDs
+	GroupAssociations map[string][]string
+	// Users maps user IDs to their recorded attributes
+	Users map[string]UserAttributes
+}
+
+// Entities returns the principal and, for document resources, the document
+// and its group, in the same shape as PostgresEntityProvider
+func (p StaticEntityProvider) Entities(ctx context.Context, principal Principal, resourceID string) (cedar.EntityMap, error) {
+	recorded, _ := p.userAttributes(ctx, principal.ID)
+	entities := principalEntities(principal, recorded)
+	if resourceID == collectionResourceID {
+		return entities, nil
+	}
+
+	doc, ok := p.Documents[resourceID]
+	if !ok {
+		return nil, fmt.Errorf("%w: document %s", ErrResourceNotFound, resourceID)
+	}
+	addDocument(entities, doc, p.GroupAssociations[doc.GroupID])
+	return entities, nil
+}
+
+func (p StaticEntityProvider) userAttributes(_ context.Context, userID string) (*UserAttributes, error) {
+	recorded, ok := p.Users[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &recorded, nil
+}
+
+func (p StaticEntityProvider) associatedUserGroups(_ context.Context, documentGroupID string) ([]string, error) {
+	return p.GroupAssociations[documentGroupID], nil
+}
@@ -0,0 +1,186 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// PolicyExport is the active authorization setup as it is promoted between
+// environments: the policies by ID, the schema they were written against,
+// and the policy templates with their links
+type PolicyExport struct {
+	Policies  map[string]string
+	Schema    string
+	Templates map[string]string
+	// Links are identified by template, principal, and resource; their IDs
+	// are assigned anew on import
+	Links []TemplateLink
+}
+
+// ExportPolicies returns the active policies from the configured source
+// together with the schema and, if configured, the templates and links
+func (a *Authorizer) ExportPolicies(ctx context.Context) (PolicyExport, error) {
+	files, err := a.loadPolicyFiles(ctx)
+	if err != nil {
+		return PolicyExport{}, err
+	}
+	export := PolicyExport{
+		Policies:  map[string]string{},
+		Schema:    string(SchemaText()),
+		Templates: map[string]string{},
+	}
+	for _, f := range files {
+		export.Policies[f.name] = string(f.content)
+	}
+	if a.templateStore != nil {
+		export.Templates, export.Links, err = a.templateStore.export(ctx)
+		if err != nil {
+			return PolicyExport{}, err
+		}
+	}
+	return export, nil
+}
+
+// ImportPolicies replaces the stored policies, templates, and links with
+// those of an export in a single transaction, records a version attributed
+// to author, and activates it. Nothing changes unless the export was made
+// against the same schema and every policy and link parses.
+func (a *Authorizer) ImportPolicies(ctx context.Context, export PolicyExport, author string) (PolicyVersion, error) {
+	if a.policyStore == nil || a.templateStore == nil {
+		return PolicyVersion{}, ErrNoPolicyStore
+	}
+	if err := validateExport(export); err != nil {
+		return PolicyVersion{}, err
+	}
+
+	tx, err := a.policyStore.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	defer tx.Rollback()
+
+	now := a.clock.Now()
+	if err := replaceActivePolicies(ctx, tx, export.Policies, now); err != nil {
+		return PolicyVersion{}, err
+	}
+	if err := replaceTemplates(ctx, tx, export.Templates, export.Links); err != nil {
+		return PolicyVersion{}, err
+	}
+	v, err := recordVersion(ctx, tx, author, "import", now)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return PolicyVersion{}, err
+	}
+
+	if _, err := a.reload(ctx); err != nil {
+		return PolicyVersion{}, fmt.Errorf("failed to reload policies: %w", err)
+	}
+	return v, nil
+}
+
+// validateExport checks an export against the server's schema and that
+// its policies and links parse together
+func validateExport(export PolicyExport) error {
+	if export.Schema != string(SchemaText()) {
+		return fmt.Errorf("%w: the export was made with a different schema", ErrInvalidPolicy)
+	}
+	if err := validateSnapshot(export.Policies); err != nil {
+		return err
+	}
+	policySet, err := parsePolicyFiles(snapshotFiles(export.Policies))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	var links []policyFile
+	for i, l := range export.Links {
+		template, ok := export.Templates[l.TemplateID]
+		if !ok {
+			return fmt.Errorf("%w: link %d uses unknown template %q", ErrInvalidPolicy, i, l.TemplateID)
+		}
+		links = append(links, policyFile{
+			name:    fmt.Sprintf("link %d", i),
+			content: []byte(instantiateTemplate(template, l.Principal, l.Resource)),
+		})
+	}
+	if err := addLinkedPolicies(policySet, links); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	return nil
+}
+
+// export returns all templates and links, the links ordered by ID
+func (s *TemplateStore) export(ctx context.Context) (map[string]string, []TemplateLink, error) {
+	templates := map[string]string{}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, content FROM policy_templates`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query policy templates: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan policy template: %w", err)
+		}
+		templates[id] = content
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	linkRows, err := s.db.QueryContext(ctx, `
+		SELECT id, template_id, principal_type, principal_id, resource_type, resource_id
+		FROM policy_template_links
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query template links: %w", err)
+	}
+	defer linkRows.Close()
+	links := []TemplateLink{}
+	for linkRows.Next() {
+		var l TemplateLink
+		if err := linkRows.Scan(&l.ID, &l.TemplateID, &l.Principal.Type, &l.Principal.ID, &l.Resource.Type, &l.Resource.ID); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan template link: %w", err)
+		}
+		links = append(links, l)
+	}
+	return templates, links, linkRows.Err()
+}
+
+// replaceTemplates makes templates and links the only ones stored
+func replaceTemplates(ctx context.Context, tx *sql.Tx, templates map[string]string, links []TemplateLink) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM policy_template_links`); err != nil {
+		return fmt.Errorf("failed to delete template links: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM policy_templates`); err != nil {
+		return fmt.Errorf("failed to delete policy templates: %w", err)
+	}
+
+	ids := make([]string, 0, len(templates))
+	for id := range templates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO policy_templates (id, content) VALUES ($1, $2)
+		`, id, templates[id]); err != nil {
+			return fmt.Errorf("failed to import template %s: %w", id, err)
+		}
+	}
+	for _, l := range links {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO policy_template_links (template_id, principal_type, principal_id, resource_type, resource_id)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (template_id, principal_type, principal_id, resource_type, resource_id) DO NOTHING
+		`, l.TemplateID, l.Principal.Type, l.Principal.ID, l.Resource.Type, l.Resource.ID); err != nil {
+			return fmt.Errorf("failed to import template link: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package cedar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+)
+
+// ErrUnsupportedFilter is returned when the policies leave a constraint on
+// the resource that cannot be expressed as a Filter. Callers should fall
+// back to authorizing each resource individually.
+var ErrUnsupportedFilter = errors.New("policies cannot be reduced to a resource filter")
+
+// resourceVariable stands in for the unknown resource during partial
+// evaluation
+const resourceVariable = "resource"
+
+// Filter is a constraint on the resource that remains once the policies
+// have been evaluated for a known principal, action, and context
+type Filter interface {
+	filter()
+}
+
+// FilterConst matches every resource when true and none when false
+type FilterConst bool
+
+// FilterAnd matches resources matching both sides
+type FilterAnd struct{ Left, Right Filter }
+
+// FilterOr matches resources matching either side
+type FilterOr struct{ Left, Right Filter }
+
+// FilterNot matches resources not matching Arg
+type FilterNot struct{ Arg Filter }
+
+// FilterHas matches resources that have the attribute
+type FilterHas struct{ Attr string }
+
+// FilterAttrEquals matches resources whose attribute is the given entity
+type FilterAttrEquals struct {
+	Attr   string
+	Entity EntityRef
+}
+
+// FilterAttrString matches resources whose attribute is the given string
+type FilterAttrString struct{ Attr, Value string }
+
+// FilterContains matches resources whose set attribute contains the given
+// string
+type FilterContains struct{ Attr, Value string }
+
+// FilterContainsEntity matches resources whose set attribute contains the
+// given entity
+type FilterContainsEntity struct {
+	Attr   string
+	Entity EntityRef
+}
+
+// FilterHasTag matches resources that have the tag
+type FilterHasTag struct{ Key string }
+
+// FilterTagString matches resources whose tag is the given string
+type FilterTagString struct{ Key, Value string }
+
+// FilterEq matches the given resource
+type FilterEq struct{ Entity EntityRef }
+
+// FilterIn matches resources that are the entity or are (transitively) in it
+type FilterIn struct{ Entity EntityRef }
+
+func (FilterConst) filter()          {}
+func (FilterAnd) filter()            {}
+func (FilterOr) filter()             {}
+func (FilterNot) filter()            {}
+func (FilterHas) filter()            {}
+func (FilterAttrEquals) filter()     {}
+func (FilterAttrString) filter()     {}
+func (FilterContains) filter()       {}
+func (FilterContainsEntity) filter() {}
+func (FilterHasTag) filter()         {}
+func (FilterTagString) filter()      {}
+func (FilterEq) filter()             {}
+func (FilterIn) filter()             {}
+
+// and combines filters, folding constants
+func and(l, r Filter) Filter {
+	switch {
+	case l == FilterConst(false) || r == FilterConst(false):
+		return FilterConst(false)
+	case l == FilterConst(true):
+		return r
+	case r == FilterConst(true):
+		return l
+	}
+	return FilterAnd{l, r}
+}
+
+// or combines filters, folding constants
+func or(l, r Filter) Filter {
+	switch {
+	case l == FilterConst(true) || r == FilterConst(true):
+		return FilterConst(true)
+	case l == FilterConst(false):
+		return r
+	case r == FilterConst(false):
+		return l
+	}
+	return FilterOr{l, r}
+}
+
+// not negates a filter, folding constants
+func not(f Filter) Filter {
+	if c, ok := f.(FilterConst); ok {
+		return !c
+	}
+	return FilterNot{f}
+}
+
+// ResourceFilter partially evaluates the active policies with everything
+// but the resource known and returns the constraint a resource must meet
+// for the request to be allowed. r.ResourceID is ignored.
+func (a *Authorizer) ResourceFilter(ctx context.Context, r AuthzRequest) (Filter, error) {
+	if a.evaluator != nil {
+		return nil, ErrUnsupportedFilter
+	}
+
+	r.ResourceID = collectionResourceID
+	entities, req, err := a.prepare(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	env := eval.Env{
+		Entities:  entities,
+		Principal: req.Principal,
+		Action:    req.Action,
+		Resource:  eval.Variable(resourceVariable),
+		Context:   req.Context,
+	}
+
+	permits, forbids := Filter(FilterConst(false)), Filter(FilterConst(false))
+	for id, policy := range a.policySet.Load().All() {
+		residual, keep := eval.PartialPolicy(env, (*ast.Policy)(policy.AST()))
+		if !keep {
+			continue
+		}
+		f, err := policyFilter(residual)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUnsupportedFilter, id, err)
+		}
+		if policy.Effect() == cedar.Permit {
+			permits = or(permits, f)
+		} else {
+			forbids = or(forbids, f)
+		}
+	}
+
+	return and(permits, not(forbids)), nil
+}
+
+// policyFilter converts a partially evaluated policy into the filter under
+// which it is satisfied
+func policyFilter(p *ast.Policy) (Filter, error) {
+	f, err := resourceScopeFilter(p.Resource)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range p.Conditions {
+		// A condition that always errors makes Cedar skip the policy
+		if _, ok := eval.ToPartialError(c.Body); ok {
+			return FilterConst(false), nil
+		}
+		body, err := nodeFilter(c.Body)
+		if err != nil {
+			return nil, err
+		}
+		if c.Condition == ast.ConditionUnless {
+			body = not(body)
+		}
+		f = and(f, body)
+	}
+	return f, nil
+}
+
+func resourceScopeFilter(scope ast.IsResourceScopeNode) (Filter, error) {
+	switch s := scope.(type) {
+	case ast.ScopeTypeAll:
+		return FilterConst(true), nil
+	case ast.ScopeTypeEq:
+		return FilterEq{entityRef(s.Entity)}, nil
+	case ast.ScopeTypeIn:
+		return FilterIn{entityRef(s.Entity)}, nil
+	case ast.ScopeTypeIs:
+		return FilterConst(s.Type == documentType), nil
+	case ast.ScopeTypeIsIn:
+		if s.Type != documentType {
+			return FilterConst(false), nil
+		}
+		return FilterIn{entityRef(s.Entity)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported resource scope %T", scope)
+	}
+}
+
+func nodeFilter(n ast.IsNode) (Filter, error) {
+	switch n := n.(type) {
+	case ast.NodeValue:
+		b, ok := n.Value.(cedar.Boolean)
+		if !ok {
+			return nil, fmt.Errorf("unexpected value %s", n.Value)
+		}
+		return FilterConst(b), nil
+	case ast.NodeTypeAnd:
+		return binaryFilter(n.Left, n.Right, and)
+	case ast.NodeTypeOr:
+		return binaryFilter(n.Left, n.Right, or)
+	case ast.NodeTypeNot:
+		arg, err := nodeFilter(n.Arg)
+		if err != nil {
+			return nil, err
+		}
+		return not(arg), nil
+	case ast.NodeTypeHas:
+		if !isResource(n.Arg) {
+			return nil, fmt.Errorf("unsupported has on %T", n.Arg)
+		}
+		return FilterHas{string(n.Value)}, nil
+	case ast.NodeTypeHasTag:
+		key, ok := stringValue(n.Right)
+		if !isResource(n.Left) || !ok {
+			return nil, errors.New("unsupported hasTag expression")
+		}
+		return FilterHasTag{key}, nil
+	case ast.NodeTypeIn:
+		uid, ok := entityValue(n.Right)
+		if !isResource(n.Left) || !ok {
+			return nil, errors.New("unsupported in expression")
+		}
+		return FilterIn{entityRef(uid)}, nil
+	case ast.NodeTypeContains:
+		access, ok := n.Left.(ast.NodeTypeAccess)
+		if !ok || !isResource(access.Arg) {
+			return nil, errors.New("unsupported contains expression")
+		}
+		if value, ok := stringValue(n.Right); ok {
+			return FilterContains{Attr: string(access.Value), Value: value}, nil
+		}
+		if uid, ok := entityValue(n.Right); ok {
+			return FilterContainsEntity{Attr: string(access.Value), Entity: entityRef(uid)}, nil
+		}
+		return nil, errors.New("unsupported contains expression")
+	case ast.NodeTypeEquals:
+		return equalsFilter(n.Left, n.Right)
+	case ast.NodeTypeNotEquals:
+		f, err := equalsFilter(n.Left, n.Right)
+		if err != nil {
+			return nil, err
+		}
+		return not(f), nil
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", n)
+	}
+}
+
+func binaryFilter(l, r ast.IsNode, combine func(Filter, Filter) Filter) (Filter, error) {
+	left, err := nodeFilter(l)
+	if err != nil {
+		return nil, err
+	}
+	right, err := nodeFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	return combine(left, right), nil
+}
+
+// equalsFilter handles resource == E, resource.attr == E,
+// resource.attr == "s", and resource.getTag("k") == "s" in either order
+func equalsFilter(l, r ast.IsNode) (Filter, error) {
+	if _, ok := l.(ast.NodeValue); ok {
+		l, r = r, l
+	}
+	if tag, ok := l.(ast.NodeTypeGetTag); ok {
+		key, isKey := stringValue(tag.Right)
+		value, isString := stringValue(r)
+		if !isResource(tag.Left) || !isKey || !isString {
+			return nil, errors.New("unsupported getTag equality")
+		}
+		return FilterTagString{Key: key, Value: value}, nil
+	}
+	access, isAccess := l.(ast.NodeTypeAccess)
+	isAccess = isAccess && isResource(access.Arg)
+	if s, ok := stringValue(r); ok && isAccess {
+		return FilterAttrString{Attr: string(access.Value), Value: s}, nil
+	}
+	uid, ok := entityValue(r)
+	if !ok {
+		return nil, errors.New("unsupported equality")
+	}
+	if isResource(l) {
+		return FilterEq{entityRef(uid)}, nil
+	}
+	if isAccess {
+		return FilterAttrEquals{Attr: string(access.Value), Entity: entityRef(uid)}, nil
+	}
+	return nil, errors.New("unsupported equality")
+}
+
+func isResource(n ast.IsNode) bool {
+	v, ok := n.(ast.NodeTypeVariable)
+	return ok && v.Name == resourceVariable
+}
+
+func entityValue(n ast.IsNode) (cedar.EntityUID, bool) {
+	v, ok := n.(ast.NodeValue)
+	if !ok {
+		return cedar.EntityUID{}, false
+	}
+	uid, ok := v.Value.(cedar.EntityUID)
+	return uid, ok
+}
+
+func stringValue(n ast.IsNode) (string, bool) {
+	v, ok := n.(ast.NodeValue)
+	if !ok {
+		return "", false
+	}
+	s, ok := v.Value.(cedar.String)
+	return string(s), ok
+}
+
+func entityRef(uid cedar.EntityUID) EntityRef {
+	return EntityRef{Type: string(uid.Type), ID: string(uid.ID)}
+}
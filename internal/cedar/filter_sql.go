@@ -0,0 +1,122 @@
+package cedar
+
+import (
+	"fmt"
+)
+
+// DocumentSQL translates a resource filter into a WHERE condition over the
+// documents table, mirroring how PostgresEntityProvider builds document
+// entities, whose tags are the metadata and whose readers and writers are
+// the users in document_grants. Placeholder values are appended to args.
+func DocumentSQL(f Filter, args []any) (string, []any, error) {
+	w := sqlWriter{args: args}
+	cond, err := w.write(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrUnsupportedFilter, err)
+	}
+	return cond, w.args, nil
+}
+
+type sqlWriter struct {
+	args []any
+}
+
+// arg adds a placeholder value and returns its reference
+func (w *sqlWriter) arg(v string) string {
+	w.args = append(w.args, v)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+func (w *sqlWriter) write(f Filter) (string, error) {
+	switch f := f.(type) {
+	case FilterConst:
+		if f {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case FilterAnd:
+		return w.binary(f.Left, f.Right, "AND")
+	case FilterOr:
+		return w.binary(f.Left, f.Right, "OR")
+	case FilterNot:
+		arg, err := w.write(f.Arg)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + arg + ")", nil
+	case FilterHas:
+		switch f.Attr {
+		case "owner", "classification", "tags", "readers", "writers", "legal_hold":
+			return "TRUE", nil
+		case "group":
+			return "document_group_id IS NOT NULL", nil
+		}
+		return "FALSE", nil
+	case FilterAttrEquals:
+		switch {
+		case f.Attr == "owner" && f.Entity.Type == string(userType):
+			return "owner_id = " + w.arg(f.Entity.ID), nil
+		case f.Attr == "group" && f.Entity.Type == string(documentGroupType):
+			return "document_group_id = " + w.arg(f.Entity.ID), nil
+		case f.Attr == "owner" || f.Attr == "group" || f.Attr == "classification" || f.Attr == "tags" || f.Attr == "readers" || f.Attr == "writers" || f.Attr == "legal_hold":
+			return "FALSE", nil
+		}
+		return "", fmt.Errorf("unknown document attribute %q", f.Attr)
+	case FilterAttrString:
+		switch f.Attr {
+		case "classification":
+			return "classification = " + w.arg(f.Value), nil
+		case "owner", "group", "tags", "readers", "writers", "legal_hold":
+			return "FALSE", nil
+		}
+		return "", fmt.Errorf("unknown document attribute %q", f.Attr)
+	case FilterContains:
+		if f.Attr != "tags" {
+			return "", fmt.Errorf("unsupported contains on document attribute %q", f.Attr)
+		}
+		return w.arg(f.Value) + " = ANY(tags)", nil
+	case FilterContainsEntity:
+		switch {
+		case f.Attr == "readers" && f.Entity.Type == string(userType):
+			return "id IN (SELECT document_id FROM document_grants WHERE user_id = " + w.arg(f.Entity.ID) + ")", nil
+		case f.Attr == "writers" && f.Entity.Type == string(userType):
+			return "id IN (SELECT document_id FROM document_grants WHERE user_id = " + w.arg(f.Entity.ID) + " AND access = 'write')", nil
+		case f.Attr == "readers" || f.Attr == "writers" || f.Attr == "tags":
+			return "FALSE", nil
+		}
+		return "", fmt.Errorf("unsupported contains on document attribute %q", f.Attr)
+	case FilterHasTag:
+		return "metadata ? " + w.arg(f.Key), nil
+	case FilterTagString:
+		return "metadata ->> " + w.arg(f.Key) + " = " + w.arg(f.Value), nil
+	case FilterEq:
+		if f.Entity.Type != string(documentType) {
+			return "FALSE", nil
+		}
+		return "id = " + w.arg(f.Entity.ID), nil
+	case FilterIn:
+		switch f.Entity.Type {
+		case string(documentType):
+			return "id = " + w.arg(f.Entity.ID), nil
+		case string(documentGroupType):
+			return "document_group_id = " + w.arg(f.Entity.ID), nil
+		case string(userGroupType):
+			return "document_group_id IN (SELECT document_group_id FROM group_associations WHERE user_group_id = " + w.arg(f.Entity.ID) + ")", nil
+		}
+		return "FALSE", nil
+	default:
+		return "", fmt.Errorf("unsupported filter %T", f)
+	}
+}
+
+func (w *sqlWriter) binary(l, r Filter, op string) (string, error) {
+	left, err := w.write(l)
+	if err != nil {
+		return "", err
+	}
+	right, err := w.write(r)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s %s %s)", left, op, right), nil
+}
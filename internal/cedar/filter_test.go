@@ -0,0 +1,212 @@
+package cedar
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// matchesFilter reports whether the entity uid in entities meets f, the
+// way DocumentSQL's condition would for the stored document
+func matchesFilter(t *testing.T, f Filter, entities cedar.EntityMap, uid cedar.EntityUID) bool {
+	t.Helper()
+	e := entities[uid]
+	attr := func(name string) (cedar.Value, bool) { return e.Attributes.Get(cedar.String(name)) }
+	switch f := f.(type) {
+	case FilterConst:
+		return bool(f)
+	case FilterAnd:
+		return matchesFilter(t, f.Left, entities, uid) && matchesFilter(t, f.Right, entities, uid)
+	case FilterOr:
+		return matchesFilter(t, f.Left, entities, uid) || matchesFilter(t, f.Right, entities, uid)
+	case FilterNot:
+		return !matchesFilter(t, f.Arg, entities, uid)
+	case FilterHas:
+		_, ok := attr(f.Attr)
+		return ok
+	case FilterAttrEquals:
+		v, ok := attr(f.Attr)
+		return ok && v.Equal(f.Entity.uid())
+	case FilterAttrString:
+		v, ok := attr(f.Attr)
+		return ok && v.Equal(cedar.String(f.Value))
+	case FilterContains:
+		v, ok := attr(f.Attr)
+		set, isSet := v.(cedar.Set)
+		return ok && isSet && set.Contains(cedar.String(f.Value))
+	case FilterContainsEntity:
+		v, ok := attr(f.Attr)
+		set, isSet := v.(cedar.Set)
+		return ok && isSet && set.Contains(f.Entity.uid())
+	case FilterHasTag:
+		_, ok := e.Tags.Get(cedar.String(f.Key))
+		return ok
+	case FilterTagString:
+		v, ok := e.Tags.Get(cedar.String(f.Key))
+		return ok && v.Equal(cedar.String(f.Value))
+	case FilterEq:
+		return uid == f.Entity.uid()
+	case FilterIn:
+		return inEntity(entities, uid, f.Entity.uid())
+	}
+	t.Fatalf("unknown filter %T", f)
+	return false
+}
+
+// inEntity reports whether uid is ancestor or one of its descendants
+func inEntity(entities cedar.EntityMap, uid, ancestor cedar.EntityUID) bool {
+	if uid == ancestor {
+		return true
+	}
+	for parent := range entities[uid].Parents.All() {
+		if inEntity(entities, parent, ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// listDocuments returns the IDs of the documents in provider that the
+// resource filter for r lets through
+func listDocuments(t *testing.T, a *Authorizer, provider StaticEntityProvider, r AuthzRequest) map[string]bool {
+	t.Helper()
+	filter, err := a.ResourceFilter(context.Background(), r)
+	if err != nil {
+		t.Fatalf("ResourceFilter: %v", err)
+	}
+	if _, _, err := DocumentSQL(filter, nil); err != nil {
+		t.Fatalf("DocumentSQL: %v", err)
+	}
+	listed := map[string]bool{}
+	for id := range provider.Documents {
+		principal := Principal{ID: r.UserID, Role: r.UserRole, GroupID: r.UserGroupID}
+		entities, err := provider.Entities(context.Background(), principal, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matchesFilter(t, filter, entities, cedar.NewEntityUID(documentType, cedar.String(id))) {
+			listed[id] = true
+		}
+	}
+	return listed
+}
+
+var listTestProvider = StaticEntityProvider{
+	Documents: map[string]Document{
+		"doc-public":       {ID: "doc-public", OwnerID: "user-2", Classification: "public"},
+		"doc-confidential": {ID: "doc-confidential", OwnerID: "user-2", Classification: "confidential"},
+		"doc-sales":        {ID: "doc-sales", OwnerID: "user-2", GroupID: "doc-group-sales", Classification: "internal"},
+		"doc-engineering":  {ID: "doc-engineering", OwnerID: "user-2", GroupID: "doc-group-engineering", Classification: "internal"},
+		"doc-granted": {ID: "doc-granted", OwnerID: "user-2", GroupID: "doc-group-engineering", Classification: "internal",
+			Grants: map[string]ShareAccess{"user-1": ShareRead}},
+	},
+	GroupAssociations: map[string][]string{
+		"doc-group-sales":       {"user-group-sales"},
+		"doc-group-engineering": {"user-group-engineering"},
+	},
+}
+
+// TestListVisibilityFromPolicies checks that the documents a list shows
+// are exactly those the policies allow the caller to list, one by one
+func TestListVisibilityFromPolicies(t *testing.T) {
+	a, err := NewAuthorizer(WithEntityProvider(listTestProvider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		principal AuthzRequest
+		want      []string
+	}{
+		{AuthzRequest{UserID: "user-admin", UserRole: "admin"},
+			[]string{"doc-public", "doc-confidential", "doc-sales", "doc-engineering", "doc-granted"}},
+		{AuthzRequest{UserID: "user-2", UserRole: "editor", UserGroupID: "user-group-engineering"},
+			[]string{"doc-public", "doc-engineering", "doc-granted"}},
+		{AuthzRequest{UserID: "user-1", UserRole: "viewer", UserGroupID: "user-group-sales"},
+			[]string{"doc-public", "doc-sales", "doc-granted"}},
+		{AuthzRequest{UserID: "user-3", UserRole: "viewer"},
+			[]string{"doc-public"}},
+		{AuthzRequest{UserID: "user-4", UserRole: "group_admin", UserGroupID: "user-group-sales"},
+			[]string{"doc-sales"}},
+	}
+	for _, tt := range tests {
+		p := tt.principal
+		t.Run(p.UserRole+"/"+p.UserID, func(t *testing.T) {
+			p.Action = "ListDocuments"
+			p.IPAddress = "10.0.0.1"
+			p.IsPrivateIP = true
+			listed := listDocuments(t, a, listTestProvider, p)
+			if got := slices.Sorted(maps.Keys(listed)); !slices.Equal(got, slices.Sorted(slices.Values(tt.want))) {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+			for id := range listTestProvider.Documents {
+				r := p
+				r.ResourceID = id
+				decision, err := a.Authorize(context.Background(), r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if listed[id] != decision.Allowed {
+					t.Errorf("%s: listed %t, but Authorize allowed %t", id, listed[id], decision.Allowed)
+				}
+			}
+		})
+	}
+}
+
+// TestListVisibilityFollowsPolicyChange checks that replacing the policies
+// changes what is listed, with no role checked outside them
+func TestListVisibilityFollowsPolicyChange(t *testing.T) {
+	dir := t.TempDir()
+	policy := `permit(principal, action, resource) when { resource has classification && resource.classification == "public" };`
+	if err := os.WriteFile(filepath.Join(dir, "public-only.cedar"), []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthorizer(WithEntityProvider(listTestProvider), WithPolicyDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, role := range []string{"admin", "viewer"} {
+		listed := listDocuments(t, a, listTestProvider, AuthzRequest{UserID: "user-1", UserRole: role, Action: "ListDocuments"})
+		if len(listed) != 1 || !listed["doc-public"] {
+			t.Errorf("%s lists %v, want only doc-public", role, listed)
+		}
+	}
+}
+
+// TestDocumentSQLGrants checks that a document's readers and writers are
+// looked up in document_grants rather than making the filter unsupported
+func TestDocumentSQLGrants(t *testing.T) {
+	user := EntityRef{Type: string(userType), ID: "user-1"}
+	tests := []struct {
+		filter Filter
+		want   string
+		args   []any
+	}{
+		{FilterContainsEntity{Attr: "readers", Entity: user},
+			"id IN (SELECT document_id FROM document_grants WHERE user_id = $1)", []any{"user-1"}},
+		{FilterContainsEntity{Attr: "writers", Entity: user},
+			"id IN (SELECT document_id FROM document_grants WHERE user_id = $1 AND access = 'write')", []any{"user-1"}},
+		{FilterContainsEntity{Attr: "readers", Entity: EntityRef{Type: string(userGroupType), ID: "user-group-sales"}},
+			"FALSE", nil},
+	}
+	for _, tt := range tests {
+		got, args, err := DocumentSQL(tt.filter, nil)
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.filter, err)
+		}
+		if got != tt.want || !slices.Equal(args, tt.args) {
+			t.Errorf("%+v: got %q %v, want %q %v", tt.filter, got, args, tt.want, tt.args)
+		}
+	}
+	if _, _, err := DocumentSQL(FilterContainsEntity{Attr: "owner", Entity: user}, nil); !errors.Is(err, ErrUnsupportedFilter) {
+		t.Errorf("contains on owner: got %v, want ErrUnsupportedFilter", err)
+	}
+}
@@ -0,0 +1,129 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// groupAssociationsChannel is notified by a trigger on group_associations
+// with the ID of the document group whose associations changed, or an
+// empty payload when the whole table changed
+const groupAssociationsChannel = "group_associations_changed"
+
+// GroupCache keeps the group associations in memory so entity loading does
+// not query them on every request. Changes are picked up through Postgres
+// LISTEN/NOTIFY; after the listener reconnects, everything is reloaded in
+// case notifications were missed.
+type GroupCache struct {
+	db  *sql.DB
+	dsn string
+
+	mu sync.RWMutex
+	// associations maps document group IDs to associated user group IDs
+	associations map[string][]string
+}
+
+// NewGroupCache creates a group cache. dsn is used for the dedicated
+// listener connection.
+func NewGroupCache(db *sql.DB, dsn string) *GroupCache {
+	return &GroupCache{db: db, dsn: dsn}
+}
+
+// Load reads all group associations. It must be called before the cache
+// is used.
+func (c *GroupCache) Load(ctx context.Context) error {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT document_group_id, user_group_id
+		FROM group_associations
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load group associations: %w", err)
+	}
+	defer rows.Close()
+
+	associations := map[string][]string{}
+	for rows.Next() {
+		var documentGroupID, userGroupID string
+		if err := rows.Scan(&documentGroupID, &userGroupID); err != nil {
+			return fmt.Errorf("failed to scan group association: %w", err)
+		}
+		associations[documentGroupID] = append(associations[documentGroupID], userGroupID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.associations = associations
+	c.mu.Unlock()
+	return nil
+}
+
+// reloadGroup re-reads the associations of a single document group
+func (c *GroupCache) reloadGroup(ctx context.Context, documentGroupID string) error {
+	userGroupIDs, err := queryAssociatedUserGroups(ctx, c.db, documentGroupID)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(userGroupIDs) == 0 {
+		delete(c.associations, documentGroupID)
+	} else {
+		c.associations[documentGroupID] = userGroupIDs
+	}
+	return nil
+}
+
+// associatedUserGroups returns the cached user groups associated with a
+// document group
+func (c *GroupCache) associatedUserGroups(documentGroupID string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.associations[documentGroupID]
+}
+
+// Run listens for changes to group associations until ctx is cancelled
+func (c *GroupCache) Run(ctx context.Context) error {
+	listener := pq.NewListener(c.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Group cache listener: %v", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(groupAssociationsChannel); err != nil {
+		return fmt.Errorf("failed to listen for group association changes: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			var err error
+			switch {
+			case n == nil:
+				// The connection was re-established and notifications
+				// may have been lost
+				err = c.Load(ctx)
+			case n.Extra == "":
+				err = c.Load(ctx)
+			default:
+				err = c.reloadGroup(ctx, n.Extra)
+			}
+			if err != nil {
+				log.Printf("Failed to refresh group cache: %v", err)
+			}
+		case <-time.After(90 * time.Second):
+			// Detect a dead connection while no notifications arrive
+			go listener.Ping()
+		}
+	}
+}
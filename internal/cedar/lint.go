@@ -0,0 +1,348 @@
+package cedar
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+)
+
+// LintWarning is a likely mistake found in a policy. Warnings do not stop
+// policies from loading.
+type LintWarning struct {
+	PolicyID string
+	Position cedar.Position
+	Message  string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", w.Position.Filename, w.Position.Line, w.PolicyID, w.Message)
+}
+
+// Lint checks policies against the embedded schema. It reports policies
+// that can never apply, permits that an unconditional forbid always
+// overrides, and references to entity types or actions the schema does
+// not declare.
+func Lint(policySet *cedar.PolicySet) ([]LintWarning, error) {
+	s, err := embeddedSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []cedar.PolicyID
+	for id := range policySet.All() {
+		ids = append(ids, id)
+	}
+	// Report in source order
+	sortBySource(policySet, ids)
+
+	var warnings []LintWarning
+	for _, id := range ids {
+		policy := policySet.Get(id)
+		p := (*ast.Policy)(policy.AST())
+		warn := func(format string, args ...any) {
+			warnings = append(warnings, LintWarning{PolicyID: string(id), Position: policy.Position(), Message: fmt.Sprintf(format, args...)})
+		}
+
+		for _, msg := range s.undeclared(p) {
+			warn("%s", msg)
+		}
+		if reason := s.unreachable(p); reason != "" {
+			warn("policy can never apply: %s", reason)
+		}
+		if policy.Effect() != cedar.Permit {
+			continue
+		}
+		for _, fid := range ids {
+			forbid := policySet.Get(fid)
+			if forbid.Effect() != cedar.Forbid {
+				continue
+			}
+			if f := (*ast.Policy)(forbid.AST()); unconditional(f) && scopeCovers(f, p) {
+				warn("permit is always overridden by forbid %s", fid)
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// Lint checks the active policies against the embedded schema
+func (a *Authorizer) Lint() ([]LintWarning, error) {
+	return Lint(a.policySet.Load())
+}
+
+// ParsePolicies parses the *.cedar files in dir, or the embedded policies
+// when dir is empty, without creating an authorizer
+func ParsePolicies(dir string) (*cedar.PolicySet, error) {
+	var files []policyFile
+	var err error
+	if dir != "" {
+		files, err = readPolicyDir(dir)
+	} else {
+		files, err = embeddedPolicyFiles()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parsePolicyFiles(files)
+}
+
+// logLintWarnings lints a newly loaded policy set and logs what it finds
+func logLintWarnings(policySet *cedar.PolicySet) {
+	warnings, err := Lint(policySet)
+	if err != nil {
+		log.Printf("Failed to lint policies: %v", err)
+		return
+	}
+	for _, w := range warnings {
+		log.Printf("Policy lint warning: %s", w)
+	}
+}
+
+// unknownEnv leaves the whole request unknown, so partial evaluation only
+// folds what holds for every request
+var unknownEnv = eval.Env{
+	Entities:  cedar.EntityMap{},
+	Principal: eval.Variable("principal"),
+	Action:    eval.Variable("action"),
+	Resource:  eval.Variable("resource"),
+	Context:   eval.Variable("context"),
+}
+
+// unconditional reports whether the conditions of p hold for every request
+func unconditional(p *ast.Policy) bool {
+	residual, keep := eval.PartialPolicy(unknownEnv, p)
+	return keep && len(residual.Conditions) == 0
+}
+
+// unreachable explains why p matches no request, or returns ""
+func (s *schemaInfo) unreachable(p *ast.Policy) string {
+	residual, keep := eval.PartialPolicy(unknownEnv, p)
+	if !keep {
+		return "conditions are always false"
+	}
+	for _, c := range residual.Conditions {
+		if err, ok := eval.ToPartialError(c.Body); ok {
+			return fmt.Sprintf("condition always fails: %v", err)
+		}
+	}
+
+	actions := s.scopeActions(p.Action)
+	if len(actions) == 0 {
+		return ""
+	}
+	if t, ok := scopeEntityType(p.Principal); ok && !slices.ContainsFunc(actions, func(a schemaAction) bool {
+		return slices.Contains(a.principalTypes, t)
+	}) {
+		return fmt.Sprintf("no action in scope applies to principal type %s", t)
+	}
+	if t, ok := scopeEntityType(p.Resource); ok && !slices.ContainsFunc(actions, func(a schemaAction) bool {
+		return slices.Contains(a.resourceTypes, t)
+	}) {
+		return fmt.Sprintf("no action in scope applies to resource type %s", t)
+	}
+	return ""
+}
+
+// scopeActions returns the declared actions an action scope matches. An
+// "in" scope matches the members of the action groups it names.
+func (s *schemaInfo) scopeActions(scope ast.IsActionScopeNode) []schemaAction {
+	var groups []cedar.EntityUID
+	switch sc := scope.(type) {
+	case ast.ScopeTypeAll:
+		actions := make([]schemaAction, 0, len(s.actions))
+		for _, a := range s.actions {
+			actions = append(actions, a)
+		}
+		return actions
+	case ast.ScopeTypeEq:
+		if a, ok := s.actions[sc.Entity.ID]; ok && sc.Entity.Type == actionType {
+			return []schemaAction{a}
+		}
+		return nil
+	case ast.ScopeTypeIn:
+		groups = []cedar.EntityUID{sc.Entity}
+	case ast.ScopeTypeInSet:
+		groups = sc.Entities
+	}
+	var actions []schemaAction
+	for name, a := range s.actions {
+		uid := cedar.NewEntityUID(actionType, name)
+		if slices.ContainsFunc(groups, func(g cedar.EntityUID) bool { return s.inActionGroup(uid, g) }) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// scopeEntityType returns the entity type a principal or resource scope
+// requires, if it pins one down
+func scopeEntityType(scope any) (cedar.EntityType, bool) {
+	switch sc := scope.(type) {
+	case ast.ScopeTypeEq:
+		return sc.Entity.Type, true
+	case ast.ScopeTypeIs:
+		return sc.Type, true
+	case ast.ScopeTypeIsIn:
+		return sc.Type, true
+	}
+	return "", false
+}
+
+// undeclared lists the entity types and actions p refers to that the
+// schema does not declare
+func (s *schemaInfo) undeclared(p *ast.Policy) []string {
+	uids, types := policyReferences(p)
+
+	var msgs []string
+	seen := map[string]bool{}
+	report := func(msg string) {
+		if !seen[msg] {
+			seen[msg] = true
+			msgs = append(msgs, msg)
+		}
+	}
+	for _, uid := range uids {
+		if uid.Type == actionType {
+			if _, ok := s.actions[uid.ID]; !ok && !s.actionGroups[uid.ID] {
+				report(fmt.Sprintf("action %q is not declared in the schema", uid.ID))
+			}
+			continue
+		}
+		types = append(types, uid.Type)
+	}
+	for _, t := range types {
+		if t != actionType && !s.entityTypes[t] {
+			report(fmt.Sprintf("entity type %s is not declared in the schema", t))
+		}
+	}
+	return msgs
+}
+
+// policyReferences returns the entities and entity types p names in its
+// scope and conditions
+func policyReferences(p *ast.Policy) ([]cedar.EntityUID, []cedar.EntityType) {
+	var uids []cedar.EntityUID
+	var types []cedar.EntityType
+	addScope := func(scope any) {
+		switch sc := scope.(type) {
+		case ast.ScopeTypeEq:
+			uids = append(uids, sc.Entity)
+		case ast.ScopeTypeIn:
+			uids = append(uids, sc.Entity)
+		case ast.ScopeTypeInSet:
+			uids = append(uids, sc.Entities...)
+		case ast.ScopeTypeIs:
+			types = append(types, sc.Type)
+		case ast.ScopeTypeIsIn:
+			types = append(types, sc.Type)
+			uids = append(uids, sc.Entity)
+		}
+	}
+	addScope(p.Principal)
+	addScope(p.Action)
+	addScope(p.Resource)
+
+	for _, c := range p.Conditions {
+		ast.Inspect(ast.NewNode(c.Body), func(n ast.IsNode) bool {
+			switch n := n.(type) {
+			case ast.NodeValue:
+				uids = append(uids, valueEntities(n.Value)...)
+			case ast.NodeTypeIs:
+				types = append(types, n.EntityType)
+			case ast.NodeTypeIsIn:
+				types = append(types, n.EntityType)
+			}
+			return true
+		})
+	}
+	return uids, types
+}
+
+// valueEntities returns the entity references in a value
+func valueEntities(v cedar.Value) []cedar.EntityUID {
+	switch v := v.(type) {
+	case cedar.EntityUID:
+		return []cedar.EntityUID{v}
+	case cedar.Set:
+		var uids []cedar.EntityUID
+		for e := range v.All() {
+			uids = append(uids, valueEntities(e)...)
+		}
+		return uids
+	case cedar.Record:
+		var uids []cedar.EntityUID
+		for _, e := range v.All() {
+			uids = append(uids, valueEntities(e)...)
+		}
+		return uids
+	}
+	return nil
+}
+
+// scopeCovers reports whether every request matching the scope of p also
+// matches the scope of f
+func scopeCovers(f, p *ast.Policy) bool {
+	return entityScopeCovers(f.Principal, p.Principal) &&
+		actionScopeCovers(f.Action, p.Action) &&
+		entityScopeCovers(f.Resource, p.Resource)
+}
+
+func entityScopeCovers(f, p any) bool {
+	switch fs := f.(type) {
+	case ast.ScopeTypeAll:
+		return true
+	case ast.ScopeTypeEq:
+		ps, ok := p.(ast.ScopeTypeEq)
+		return ok && ps.Entity == fs.Entity
+	case ast.ScopeTypeIn:
+		switch ps := p.(type) {
+		case ast.ScopeTypeEq:
+			return ps.Entity == fs.Entity
+		case ast.ScopeTypeIn:
+			return ps.Entity == fs.Entity
+		case ast.ScopeTypeIsIn:
+			return ps.Entity == fs.Entity
+		}
+	case ast.ScopeTypeIs:
+		t, ok := scopeEntityType(p)
+		return ok && t == fs.Type
+	case ast.ScopeTypeIsIn:
+		ps, ok := p.(ast.ScopeTypeIsIn)
+		return ok && ps == fs
+	}
+	return false
+}
+
+func actionScopeCovers(f, p ast.IsActionScopeNode) bool {
+	var covered []cedar.EntityUID
+	switch fs := f.(type) {
+	case ast.ScopeTypeAll:
+		return true
+	case ast.ScopeTypeEq:
+		covered = []cedar.EntityUID{fs.Entity}
+	case ast.ScopeTypeIn:
+		covered = []cedar.EntityUID{fs.Entity}
+	case ast.ScopeTypeInSet:
+		covered = fs.Entities
+	}
+
+	switch ps := p.(type) {
+	case ast.ScopeTypeEq:
+		return slices.Contains(covered, ps.Entity)
+	case ast.ScopeTypeIn:
+		_, eq := f.(ast.ScopeTypeEq)
+		return !eq && slices.Contains(covered, ps.Entity)
+	case ast.ScopeTypeInSet:
+		for _, e := range ps.Entities {
+			if !slices.Contains(covered, e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package cedar
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	decisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cedar_authz_decisions_total",
+		Help: "Authorization decisions by action and decision.",
+	}, []string{"action", "decision"})
+
+	evaluationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cedar_authz_evaluation_duration_seconds",
+		Help:    "Time spent evaluating authorization requests, excluding cache hits.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"action"})
+)
+
+// observeDecision counts a decision returned by Authorize
+func observeDecision(action string, d AuthzDecision) {
+	decision := "deny"
+	if d.Allowed {
+		decision = "allow"
+	}
+	decisionsTotal.WithLabelValues(action, decision).Inc()
+}
+
+// observeEvaluation records how long evaluating a request took
+func observeEvaluation(action string, start time.Time) {
+	evaluationSeconds.WithLabelValues(action).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,44 @@
+package cedar
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// MFAHeader is set to "true" by the gateway when the user completed
+// multi-factor authentication
+const MFAHeader = "X-MFA-Verified"
+
+// MFAVerifiedFromHTTP reports whether the caller completed multi-factor
+// authentication, according to MFAHeader or, failing that, the "amr"
+// claim of the bearer token (RFC 8176). The token is not verified here; like
+// the user headers, it must be checked by the gateway in front of the
+// server.
+func MFAVerifiedFromHTTP(r *http.Request) bool {
+	if v := r.Header.Get(MFAHeader); v != "" {
+		return strings.EqualFold(v, "true")
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		AMR []string `json:"amr"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+	return slices.Contains(claims.AMR, "mfa")
+}
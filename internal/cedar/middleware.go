@@ -0,0 +1,155 @@
+package cedar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ksakiyama/study-cedar/internal/iputil"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// Resource identifies the resource an HTTP request acts on
+type Resource struct {
+	ID string
+}
+
+// ResourceResolver extracts the resource from an HTTP request
+type ResourceResolver func(*http.Request) (Resource, error)
+
+// Collection resolves to the document collection, for actions such as
+// listing or creating documents
+func Collection(*http.Request) (Resource, error) {
+	return Resource{ID: collectionResourceID}, nil
+}
+
+// URLParam resolves the resource ID from a chi URL parameter
+func URLParam(name string) ResourceResolver {
+	return func(r *http.Request) (Resource, error) {
+		id := chi.URLParam(r, name)
+		if id == "" {
+			return Resource{}, fmt.Errorf("%w: missing %s", ErrInvalidRequest, name)
+		}
+		return Resource{ID: id}, nil
+	}
+}
+
+// RequestFromHTTP builds the authorization request for the caller of r
+// from the user headers and the client IP
+func RequestFromHTTP(r *http.Request, action, resourceID string) AuthzRequest {
+	// Get IP address information
+	ipInfo := iputil.GetIPInfo(r)
+
+	return AuthzRequest{
+		UserID:      r.Header.Get("X-User-ID"),
+		UserRole:    r.Header.Get("X-User-Role"),
+		UserGroupID: r.Header.Get("X-User-Group-ID"),
+		Action:      action,
+		ResourceID:  resourceID,
+		IPAddress:   ipInfo.IPAddress,
+		IsPrivateIP: ipInfo.IsPrivateIP,
+		IsJapanIP:   ipInfo.IsJapanIP,
+		MFAVerified: MFAVerifiedFromHTTP(r),
+		Unknown:     UnknownIPAttributes(ipInfo),
+		HTTPRequest: r,
+	}
+}
+
+// UnknownIPAttributes lists the IP context attributes that are unknown
+// because the client address could not be classified
+func UnknownIPAttributes(ipInfo iputil.IPInfo) []string {
+	if ipInfo.Classified {
+		return nil
+	}
+	return []string{"is_private_ip", "is_japan_ip"}
+}
+
+// decisionKey is the context key of the decision made by Require
+type decisionKey struct{}
+
+// DecisionFromContext returns the decision with which Require let the
+// request through, so handlers and loggers can use its matched policies
+// and diagnostics without authorizing again
+func DecisionFromContext(ctx context.Context) (AuthzDecision, bool) {
+	decision, ok := ctx.Value(decisionKey{}).(AuthzDecision)
+	return decision, ok
+}
+
+// Require returns middleware that only lets requests through when the
+// caller may perform action on the resolved resource, with the decision
+// in the request context. Denied, malformed, and failed checks are
+// answered with a JSON error.
+func (a *Authorizer) Require(action string, resolve ResourceResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resource, err := resolve(r)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			req := RequestFromHTTP(r, action, resource.ID)
+			if req.UserID == "" || req.UserRole == "" {
+				respondError(w, http.StatusBadRequest, "Missing user headers")
+				return
+			}
+
+			decision, err := a.Authorize(r.Context(), req)
+			if errors.Is(err, ErrResourceNotFound) {
+				respondError(w, http.StatusNotFound, "Document not found")
+				return
+			}
+			if errors.Is(err, ErrUnknownAction) {
+				// A route declared with a misspelled action is a server bug
+				log.Printf("Route %s %s requires an undeclared action: %v", r.Method, r.URL.Path, err)
+				respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
+				return
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				respondError(w, http.StatusServiceUnavailable, "Authorization timed out")
+				return
+			}
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Sprintf("Authorization error: %v", err))
+				return
+			}
+			if !decision.Allowed {
+				respondForbidden(w, r, decision)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decisionKey{}, decision)))
+		})
+	}
+}
+
+// respondForbidden logs why a request was denied and returns 403 with the
+// deciding policies
+func respondForbidden(w http.ResponseWriter, r *http.Request, decision AuthzDecision) {
+	log.Printf("Access denied: %s %s user=%s code=%s policies=%v forbid_override=%t overridden=%v errors=%v unknown=%v",
+		r.Method, r.URL.Path, r.Header.Get("X-User-ID"), decision.Code, decision.MatchedPolicies, decision.ForbidOverride, decision.OverriddenPolicies, decision.Errors, decision.UnknownContext)
+
+	respondJSON(w, http.StatusForbidden, models.ErrorResponse{
+		Error:   http.StatusText(http.StatusForbidden),
+		Code:    decision.Code,
+		Message: decision.DenyMessage(),
+		Reasons: decision.MatchedPolicies,
+	})
+}
+
+func respondError(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, status, models.ErrorResponse{
+		Error:   http.StatusText(status),
+		Message: message,
+	})
+}
+
+func respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
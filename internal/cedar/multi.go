@@ -0,0 +1,106 @@
+package cedar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// PDPMode says how an external PDP takes part in deciding an action
+type PDPMode string
+
+const (
+	// PDPFallback asks the external PDP only when no local policy decided
+	// the request, i.e. nothing permitted or forbade it
+	PDPFallback PDPMode = "fallback"
+	// PDPCombine asks the external PDP after the local policies allow, and
+	// allows only if it agrees
+	PDPCombine PDPMode = "combine"
+)
+
+// MultiAuthorizer consults an external PDP, such as a company-wide
+// authorization service, after the local policies for selected actions.
+// Other actions are decided by the local policies alone, so they do not pay
+// for the round trip.
+type MultiAuthorizer struct {
+	external Evaluator
+	modes    map[string]PDPMode
+}
+
+// NewMultiAuthorizer creates a MultiAuthorizer that asks external for the
+// actions in modes
+func NewMultiAuthorizer(external Evaluator, modes map[string]PDPMode) *MultiAuthorizer {
+	return &MultiAuthorizer{
+		external: external,
+		modes:    modes,
+	}
+}
+
+// WithExternalPDP combines local decisions with m. Simulation, access
+// review, and policy diffs only use the local policies.
+func WithExternalPDP(m *MultiAuthorizer) Option {
+	return func(a *Authorizer) {
+		a.multi = m
+	}
+}
+
+// ParsePDPModes parses a comma-separated list of action=mode pairs, e.g.
+// "GetDocument=fallback,DeleteDocument=combine"
+func ParsePDPModes(spec string) (map[string]PDPMode, error) {
+	modes := map[string]PDPMode{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		action, mode, ok := strings.Cut(entry, "=")
+		if !ok || action == "" {
+			return nil, fmt.Errorf("invalid PDP action %q: want action=mode", entry)
+		}
+		switch m := PDPMode(mode); m {
+		case PDPFallback, PDPCombine:
+			modes[action] = m
+		default:
+			return nil, fmt.Errorf("invalid PDP mode %q for %s: want fallback or combine", mode, action)
+		}
+	}
+	return modes, nil
+}
+
+// checkActions rejects modes for actions the schema does not declare
+func (m *MultiAuthorizer) checkActions(schema *schemaInfo) error {
+	for action := range m.modes {
+		if _, ok := schema.actions[cedar.String(action)]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownAction, action)
+		}
+	}
+	return nil
+}
+
+// decide combines the local decision with the external PDP according to
+// the mode configured for the action
+func (m *MultiAuthorizer) decide(ctx context.Context, local AuthzDecision, entities cedar.EntityMap, req cedar.Request) (AuthzDecision, error) {
+	switch m.modes[string(req.Action.ID)] {
+	case PDPFallback:
+		if local.Allowed || len(local.MatchedPolicies) > 0 {
+			return local, nil
+		}
+	case PDPCombine:
+		if !local.Allowed {
+			return local, nil
+		}
+	default:
+		return local, nil
+	}
+
+	external, err := m.external.Evaluate(ctx, entities, req)
+	if err != nil {
+		return AuthzDecision{}, fmt.Errorf("external PDP: %w", err)
+	}
+	if external.Allowed && local.Allowed {
+		return local, nil
+	}
+	return external, nil
+}
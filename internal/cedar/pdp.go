@@ -0,0 +1,143 @@
+package cedar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// maxPDPResponseSize bounds how much of an external PDP response is read
+const maxPDPResponseSize = 1 << 20
+
+// HTTPEvaluator asks an external policy decision point over HTTP. The
+// request is POSTed as JSON in the shape of a Cedar authorization request:
+//
+//	{"principal": {"type": "DocumentApp::User", "id": "user-1"},
+//	 "action": {...}, "resource": {...}, "context": {...}, "entities": [...]}
+//
+// and the PDP answers like the Cedar agent does:
+//
+//	{"decision": "Allow", "diagnostics": {"reason": ["policy-id"], "errors": []}}
+type HTTPEvaluator struct {
+	url    string
+	client *http.Client
+	header http.Header
+}
+
+// PDPOption configures an HTTPEvaluator
+type PDPOption func(*HTTPEvaluator)
+
+// WithPDPHeader adds a header, e.g. Authorization, to every PDP request
+func WithPDPHeader(key, value string) PDPOption {
+	return func(e *HTTPEvaluator) {
+		e.header.Add(key, value)
+	}
+}
+
+// NewHTTPEvaluator creates an evaluator for the PDP endpoint at url.
+// Requests are bounded by the authorization timeout, and by a 10 second
+// client timeout otherwise.
+func NewHTTPEvaluator(url string, opts ...PDPOption) *HTTPEvaluator {
+	e := &HTTPEvaluator{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		header: http.Header{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// pdpEntityUID is the implicit JSON form of an entity UID
+type pdpEntityUID struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func newPDPEntityUID(uid cedar.EntityUID) pdpEntityUID {
+	return pdpEntityUID{Type: string(uid.Type), ID: string(uid.ID)}
+}
+
+type pdpRequest struct {
+	Principal pdpEntityUID    `json:"principal"`
+	Action    pdpEntityUID    `json:"action"`
+	Resource  pdpEntityUID    `json:"resource"`
+	Context   cedar.Record    `json:"context"`
+	Entities  cedar.EntityMap `json:"entities"`
+}
+
+type pdpResponse struct {
+	Decision    string `json:"decision"`
+	Diagnostics struct {
+		Reason []string `json:"reason"`
+		Errors []string `json:"errors"`
+	} `json:"diagnostics"`
+}
+
+// Evaluate sends the request and its entities to the PDP
+func (e *HTTPEvaluator) Evaluate(ctx context.Context, entities cedar.EntityMap, req cedar.Request) (AuthzDecision, error) {
+	body, err := json.Marshal(pdpRequest{
+		Principal: newPDPEntityUID(req.Principal),
+		Action:    newPDPEntityUID(req.Action),
+		Resource:  newPDPEntityUID(req.Resource),
+		Context:   req.Context,
+		Entities:  entities,
+	})
+	if err != nil {
+		return AuthzDecision{}, fmt.Errorf("failed to encode PDP request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return AuthzDecision{}, err
+	}
+	for k, v := range e.header {
+		httpReq.Header[k] = v
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return AuthzDecision{}, fmt.Errorf("PDP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return AuthzDecision{}, fmt.Errorf("PDP request failed: %s", resp.Status)
+	}
+
+	var out pdpResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxPDPResponseSize)).Decode(&out); err != nil {
+		return AuthzDecision{}, fmt.Errorf("invalid PDP response: %w", err)
+	}
+
+	decision := AuthzDecision{
+		MatchedPolicies: out.Diagnostics.Reason,
+		Errors:          out.Diagnostics.Errors,
+	}
+	switch {
+	case strings.EqualFold(out.Decision, "allow"):
+		decision.Allowed = true
+	case !strings.EqualFold(out.Decision, "deny"):
+		return AuthzDecision{}, fmt.Errorf("invalid PDP response: unknown decision %q", out.Decision)
+	}
+	// As with Verified Permissions, denials are only told apart by whether
+	// a policy decided them
+	switch {
+	case decision.Allowed:
+	case len(decision.MatchedPolicies) > 0:
+		decision.Code = CodeForbidden
+	case len(decision.Errors) > 0:
+		decision.Code = CodeEvaluationError
+	default:
+		decision.Code = CodeInsufficientPermissions
+	}
+	return decision, nil
+}
@@ -0,0 +1,201 @@
+package cedar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// policyFile is a named chunk of Cedar policy text
+type policyFile struct {
+	name    string
+	content []byte
+}
+
+// parseError is a syntax error in a policy file. The parser reports
+// positions as <input>:line:col; Error reports them against the file.
+type parseError struct {
+	file string
+	err  error
+}
+
+func (e *parseError) Error() string {
+	msg := e.err.Error()
+	if strings.Contains(msg, "<input>:") {
+		return strings.Replace(msg, "<input>:", e.file+":", 1)
+	}
+	return fmt.Sprintf("%s: %s", e.file, msg)
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+// idAnnotation names the policy annotation that sets a policy's ID
+const idAnnotation = "id"
+
+// parsePolicyFiles merges the given files into a single PolicySet.
+// Policies take their ID from an @id annotation; the others are numbered
+// sequentially across files in the given order.
+func parsePolicyFiles(files []policyFile) (*cedar.PolicySet, error) {
+	policySet := cedar.NewPolicySet()
+	n := 0
+	for _, f := range files {
+		policies, err := cedar.NewPolicyListFromBytes(f.name, f.content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse policies: %w", &parseError{file: f.name, err: err})
+		}
+		for _, p := range policies {
+			id := cedar.PolicyID(fmt.Sprintf("policy%d", n))
+			if name, ok := p.Annotations()[idAnnotation]; ok {
+				id = cedar.PolicyID(name)
+			}
+			n++
+			if policySet.Get(id) != nil {
+				return nil, fmt.Errorf("failed to parse policies: %s:%d: duplicate policy id %q", f.name, p.Position().Line, id)
+			}
+			policySet.Add(id, p)
+		}
+	}
+	return policySet, nil
+}
+
+// readPolicyDir reads all *.cedar files in dir sorted by name
+func readPolicyDir(dir string) ([]policyFile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.cedar"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .cedar files found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	files := make([]policyFile, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		files = append(files, policyFile{name: filepath.Base(path), content: content})
+	}
+	return files, nil
+}
+
+// embeddedPolicyFiles returns the embedded policy files sorted by name
+func embeddedPolicyFiles() ([]policyFile, error) {
+	paths, err := fs.Glob(embeddedPolicies, "policies/*.cedar")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	files := make([]policyFile, 0, len(paths))
+	for _, p := range paths {
+		content, err := embeddedPolicies.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, policyFile{name: path.Base(p), content: content})
+	}
+	return files, nil
+}
+
+// loadPolicyFiles reads the policy files from the configured source
+func (a *Authorizer) loadPolicyFiles(ctx context.Context) ([]policyFile, error) {
+	switch {
+	case a.policyStore != nil:
+		return a.policyStore.loadFiles(ctx)
+	case a.policyBundle != nil:
+		return a.policyBundle.load(ctx)
+	case a.policyDir != "":
+		return readPolicyDir(a.policyDir)
+	default:
+		return embeddedPolicyFiles()
+	}
+}
+
+// Reload re-reads policies from the configured source and atomically swaps
+// them in. It reports whether the policies changed; on error the current
+// policy set stays active.
+func (a *Authorizer) Reload(ctx context.Context) (bool, error) {
+	return a.reload(ctx)
+}
+
+// reload re-reads policies from the configured source and swaps them in
+// when they changed. On error the current policy set stays active.
+func (a *Authorizer) reload(ctx context.Context) (bool, error) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	files, err := a.loadPolicyFiles(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var links []policyFile
+	if a.templateStore != nil {
+		links, err = a.templateStore.loadLinkedPolicies(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Candidate policies are refreshed on every reload; a broken candidate
+	// never affects the active set
+	if a.shadowDir != "" {
+		if err := a.loadShadowPolicies(links); err != nil {
+			log.Printf("Failed to load shadow policies from %s: %v", a.shadowDir, err)
+		}
+		a.updateSliceRoots()
+	}
+
+	sum := checksum(append(files, links...))
+	if sum == a.policyChecksum {
+		return false, nil
+	}
+
+	policySet, err := parsePolicyFiles(files)
+	if err != nil {
+		return false, err
+	}
+	if err := addLinkedPolicies(policySet, links); err != nil {
+		return false, err
+	}
+	a.policySet.Store(policySet)
+	a.updateSliceRoots()
+	a.policyChecksum = sum
+	a.InvalidateCache()
+	logLintWarnings(policySet)
+
+	return true, nil
+}
+
+// checksum identifies a set of policy files by name and content
+func checksum(files []policyFile) string {
+	h := sha256.New()
+	for _, f := range files {
+		h.Write([]byte(f.name))
+		h.Write([]byte{0})
+		h.Write(f.content)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// InvalidateCache drops all cached decisions
+func (a *Authorizer) InvalidateCache() {
+	if a.cache != nil {
+		a.cache.purge()
+	}
+}
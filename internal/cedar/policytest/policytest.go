@@ -0,0 +1,189 @@
+// Package policytest runs authorization scenarios described in YAML files
+// against the Cedar policies, so policy edits can be checked against the
+// behaviour they are expected to keep.
+package policytest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	authz "github.com/ksakiyama/study-cedar/internal/cedar"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a scenario file. Documents, group associations, and users are
+// shared by all scenarios in the file.
+type File struct {
+	Documents         []Document          `yaml:"documents"`
+	GroupAssociations map[string][]string `yaml:"group_associations"`
+	Users             []User              `yaml:"users"`
+	Scenarios         []Scenario          `yaml:"scenarios"`
+}
+
+// Document is a document scenarios can refer to as their resource
+type Document struct {
+	ID             string   `yaml:"id"`
+	Owner          string   `yaml:"owner"`
+	Group          string   `yaml:"group"`
+	Classification string   `yaml:"classification"`
+	Tags           []string `yaml:"tags"`
+	LegalHold      bool     `yaml:"legal_hold"`
+	// Metadata holds the document's metadata, its entity tags
+	Metadata map[string]string `yaml:"metadata"`
+	// Grants maps user IDs to the access granted to them, read or write
+	Grants map[string]string `yaml:"grants"`
+}
+
+// User holds the recorded attributes of a user, as in the users table
+type User struct {
+	ID               string `yaml:"id"`
+	Department       string `yaml:"department"`
+	ClearanceLevel   int64  `yaml:"clearance_level"`
+	EmploymentStatus string `yaml:"employment_status"`
+}
+
+// Principal is the user making the request
+type Principal struct {
+	ID    string `yaml:"id"`
+	Role  string `yaml:"role"`
+	Group string `yaml:"group"`
+}
+
+// Scenario is a single authorization request and its expected decision
+type Scenario struct {
+	Name      string    `yaml:"name"`
+	Principal Principal `yaml:"principal"`
+	Action    string    `yaml:"action"`
+	// Resource is a document ID from the file, or "documents" for
+	// collection-level actions
+	Resource string `yaml:"resource"`
+	// Context holds the request context attributes. Strings, booleans, and
+	// integers are supported.
+	Context map[string]any `yaml:"context"`
+	// Unknown lists context attributes whose value could not be determined
+	Unknown []string `yaml:"unknown"`
+	// Expect is "allow" or "deny"
+	Expect string `yaml:"expect"`
+}
+
+// Load reads a scenario file
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, err
+	}
+
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return File{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i, s := range f.Scenarios {
+		if s.Expect != "allow" && s.Expect != "deny" {
+			return File{}, fmt.Errorf("%s: scenario %d (%s): expect must be allow or deny, got %q", path, i, s.Name, s.Expect)
+		}
+	}
+	return f, nil
+}
+
+// Evaluate authorizes every scenario in the file. Options are passed to
+// the authorizer, e.g. to evaluate a policy directory instead of the
+// embedded policies.
+func (f File) Evaluate(opts ...authz.Option) ([]authz.AuthzDecision, error) {
+	provider := authz.StaticEntityProvider{
+		Documents:         map[string]authz.Document{},
+		GroupAssociations: f.GroupAssociations,
+		Users:             map[string]authz.UserAttributes{},
+	}
+	for _, u := range f.Users {
+		provider.Users[u.ID] = authz.UserAttributes{
+			Department:       u.Department,
+			ClearanceLevel:   u.ClearanceLevel,
+			EmploymentStatus: u.EmploymentStatus,
+		}
+	}
+	for _, d := range f.Documents {
+		classification := d.Classification
+		if classification == "" {
+			classification = "internal"
+		}
+		grants := map[string]authz.ShareAccess{}
+		for userID, access := range d.Grants {
+			grants[userID] = authz.ShareAccess(access)
+		}
+		provider.Documents[d.ID] = authz.Document{
+			ID:             d.ID,
+			OwnerID:        d.Owner,
+			GroupID:        d.Group,
+			Classification: classification,
+			Tags:           d.Tags,
+			LegalHold:      d.LegalHold,
+			Metadata:       d.Metadata,
+			Grants:         grants,
+		}
+	}
+
+	a, err := authz.NewAuthorizer(append([]authz.Option{authz.WithEntityProvider(provider)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]authz.AuthzDecision, len(f.Scenarios))
+	for i, s := range f.Scenarios {
+		decisions[i], err = a.Authorize(context.Background(), authz.AuthzRequest{
+			UserID:      s.Principal.ID,
+			UserRole:    s.Principal.Role,
+			UserGroupID: s.Principal.Group,
+			Action:      s.Action,
+			ResourceID:  s.Resource,
+			Context:     s.Context,
+			Unknown:     s.Unknown,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scenario %q: %w", s.Name, err)
+		}
+	}
+	return decisions, nil
+}
+
+// Run loads every *.yaml file in dir and runs each scenario as a subtest
+func Run(t *testing.T, dir string, opts ...authz.Option) {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no scenario files in %s", dir)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decisions, err := f.Evaluate(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range f.Scenarios {
+				t.Run(s.Name, func(t *testing.T) {
+					got := "deny"
+					if decisions[i].Allowed {
+						got = "allow"
+					}
+					if got != s.Expect {
+						t.Errorf("%s %s on %s: got %s, want %s (policies %v, errors %v)",
+							s.Principal.ID, s.Action, s.Resource, got, s.Expect, decisions[i].MatchedPolicies, decisions[i].Errors)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+package policytest
+
+import "testing"
+
+// TestEmbeddedPolicies checks the embedded policies against the scenarios
+// in testdata
+func TestEmbeddedPolicies(t *testing.T) {
+	Run(t, "testdata")
+}
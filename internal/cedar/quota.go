@@ -0,0 +1,109 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// DocumentUsage adds what the user already owns to CreateDocument requests,
+// "owned_document_count" and "owned_storage_bytes", so forbid policies can
+// enforce quotas. Each user's usage is read from the database once per TTL
+// and kept current in between by Add, so creating a document does not scan
+// the owner's documents every time.
+type DocumentUsage struct {
+	db    *sql.DB
+	clock clock.Clock
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	users     map[string]usageEntry
+	lastSweep time.Time
+}
+
+type usageEntry struct {
+	documents int64
+	bytes     int64
+	expires   time.Time
+}
+
+// NewDocumentUsage creates a usage counter that rereads each user's usage
+// after ttl
+func NewDocumentUsage(db *sql.DB, clk clock.Clock, ttl time.Duration) *DocumentUsage {
+	return &DocumentUsage{
+		db:        db,
+		clock:     clk,
+		ttl:       ttl,
+		users:     map[string]usageEntry{},
+		lastSweep: clk.Now(),
+	}
+}
+
+// BuildContext sets "owned_document_count" and "owned_storage_bytes" on
+// CreateDocument requests, counting the request's pending documents as
+// owned already
+func (u *DocumentUsage) BuildContext(ctx context.Context, req AuthzRequest, attrs cedar.RecordMap) error {
+	if req.Action != "CreateDocument" {
+		return nil
+	}
+	usage, err := u.usage(ctx, req.UserID)
+	if err != nil {
+		return err
+	}
+	attrs["owned_document_count"] = cedar.Long(usage.documents + req.PendingDocuments)
+	attrs["owned_storage_bytes"] = cedar.Long(usage.bytes + req.PendingBytes)
+	return nil
+}
+
+// Add adjusts the usage of userID after a document is created, changed,
+// or deleted. Users whose usage is not cached are left to the next read.
+func (u *DocumentUsage) Add(userID string, documents, bytes int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if e, ok := u.users[userID]; ok {
+		e.documents += documents
+		e.bytes += bytes
+		u.users[userID] = e
+	}
+}
+
+// usage returns the cached usage of userID, reading it if it expired
+func (u *DocumentUsage) usage(ctx context.Context, userID string) (usageEntry, error) {
+	now := u.clock.Now()
+	u.mu.Lock()
+	e, ok := u.users[userID]
+	u.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e, nil
+	}
+
+	err := u.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(COALESCE(content_size, octet_length(content))), 0)
+		FROM documents
+		WHERE owner_id = $1 AND deleted_at IS NULL
+	`, userID).Scan(&e.documents, &e.bytes)
+	if err != nil {
+		return usageEntry{}, fmt.Errorf("failed to load document usage: %w", err)
+	}
+	e.expires = now.Add(u.ttl)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.users[userID] = e
+
+	// Forget users whose usage expired, so the map only holds active owners
+	if now.Sub(u.lastSweep) > u.ttl {
+		for id, old := range u.users {
+			if !now.Before(old.expires) {
+				delete(u.users, id)
+			}
+		}
+		u.lastSweep = now
+	}
+	return e, nil
+}
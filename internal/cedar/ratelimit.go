@@ -0,0 +1,97 @@
+package cedar
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// RequestRate counts each user's requests over a sliding window and adds
+// the count, including the current request, as "recent_request_count", so
+// forbid policies can throttle expensive actions. Requests are counted once
+// each by Count, however many authorization checks they make; other checks
+// such as simulations see the count without adding to it.
+type RequestRate struct {
+	clock  clock.Clock
+	window time.Duration
+
+	mu sync.Mutex
+	// requests holds each user's request times within the window, oldest
+	// first
+	requests  map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewRequestRate creates a request counter over the given window
+func NewRequestRate(clk clock.Clock, window time.Duration) *RequestRate {
+	return &RequestRate{
+		clock:     clk,
+		window:    window,
+		requests:  map[string][]time.Time{},
+		lastSweep: clk.Now(),
+	}
+}
+
+// Count is middleware that records a request by the user in X-User-ID
+func (r *RequestRate) Count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if userID := req.Header.Get("X-User-ID"); userID != "" {
+			r.record(userID)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// record adds a request by userID at the current time
+func (r *RequestRate) record(userID string) {
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests[userID] = append(r.recent(userID, now), now)
+
+	// Forget users who have not made a request within the window
+	if now.Sub(r.lastSweep) > r.window {
+		cutoff := now.Add(-r.window)
+		for user, times := range r.requests {
+			if !times[len(times)-1].After(cutoff) {
+				delete(r.requests, user)
+			}
+		}
+		r.lastSweep = now
+	}
+}
+
+// recent returns the times of userID's requests within the window ending
+// at now. r.mu must be held.
+func (r *RequestRate) recent(userID string, now time.Time) []time.Time {
+	cutoff := now.Add(-r.window)
+	times := r.requests[userID]
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// BuildContext sets "recent_request_count" without counting the check
+func (r *RequestRate) BuildContext(_ context.Context, req AuthzRequest, attrs cedar.RecordMap) error {
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attrs["recent_request_count"] = cedar.Long(len(r.recent(req.UserID, now)))
+	return nil
+}
+
+// cacheContext keeps decisions that depend on the request count out of the
+// decision cache, since the count changes with every request
+func (r *RequestRate) cacheContext(cedar.RecordMap) bool {
+	return false
+}
@@ -0,0 +1,144 @@
+package cedar
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// maxRecordedRequestSize bounds a single line of a request recording
+const maxRecordedRequestSize = 4 << 20
+
+// RecordedRequest is an authorization request as it was evaluated: the
+// Cedar request and the entities it could reach. It is written as a line
+// of JSON in the same shape as requests to an external PDP. The decision
+// is not recorded.
+type RecordedRequest struct {
+	Request  cedar.Request
+	Entities cedar.EntityMap
+}
+
+// MarshalJSON encodes the request like HTTPEvaluator does
+func (r RecordedRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pdpRequest{
+		Principal: newPDPEntityUID(r.Request.Principal),
+		Action:    newPDPEntityUID(r.Request.Action),
+		Resource:  newPDPEntityUID(r.Request.Resource),
+		Context:   r.Request.Context,
+		Entities:  r.Entities,
+	})
+}
+
+// UnmarshalJSON decodes a request written by MarshalJSON
+func (r *RecordedRequest) UnmarshalJSON(b []byte) error {
+	var in pdpRequest
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	uid := func(u pdpEntityUID) cedar.EntityUID {
+		return cedar.NewEntityUID(cedar.EntityType(u.Type), cedar.String(u.ID))
+	}
+	r.Request = cedar.Request{
+		Principal: uid(in.Principal),
+		Action:    uid(in.Action),
+		Resource:  uid(in.Resource),
+		Context:   in.Context,
+	}
+	r.Entities = in.Entities
+	return nil
+}
+
+// RequestRecorder writes every request the authorizer evaluates to a
+// writer, one JSON line each, so it can later be replayed against other
+// policies with Replay
+type RequestRecorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRequestRecorder creates a recorder writing to w
+func NewRequestRecorder(w io.Writer) *RequestRecorder {
+	return &RequestRecorder{enc: json.NewEncoder(w)}
+}
+
+// WithRequestRecorder records every request decided by Authorize,
+// including those served from the decision cache
+func WithRequestRecorder(r *RequestRecorder) Option {
+	return func(a *Authorizer) {
+		a.recorder = r
+	}
+}
+
+// record writes one request. Failures are logged so they never fail the
+// authorization.
+func (r *RequestRecorder) record(entities cedar.EntityMap, req cedar.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(RecordedRequest{Request: req, Entities: entities}); err != nil {
+		log.Printf("Failed to record authorization request: %v", err)
+	}
+}
+
+// ReadRecordedRequests reads a recording written by a RequestRecorder
+func ReadRecordedRequests(r io.Reader) ([]RecordedRequest, error) {
+	var requests []RecordedRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordedRequestSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req RecordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		requests = append(requests, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recorded requests: %w", err)
+	}
+	return requests, nil
+}
+
+// ReplayMismatch is a recorded request that two policy sets decide
+// differently. Index is its position in the recording.
+type ReplayMismatch struct {
+	Index     int
+	Request   RecordedRequest
+	Baseline  AuthzDecision
+	Candidate AuthzDecision
+}
+
+// Replay decides every recorded request with both policy sets and returns
+// the requests whose decisions differ in outcome or deny code. Policy IDs
+// are not compared, so policies can be split or renamed. Replaying with
+// the same policies must return nothing, which makes a recording a
+// regression test for policy refactors.
+func Replay(requests []RecordedRequest, baseline, candidate *cedar.PolicySet) []ReplayMismatch {
+	var mismatches []ReplayMismatch
+	for i, r := range requests {
+		before := decide(baseline, r)
+		after := decide(candidate, r)
+		if before.Allowed == after.Allowed && before.Code == after.Code {
+			continue
+		}
+		mismatches = append(mismatches, ReplayMismatch{
+			Index:     i,
+			Request:   r,
+			Baseline:  before,
+			Candidate: after,
+		})
+	}
+	return mismatches
+}
+
+// decide evaluates a recorded request against policySet
+func decide(policySet *cedar.PolicySet, r RecordedRequest) AuthzDecision {
+	decision, diag := policySet.IsAuthorized(r.Entities, r.Request)
+	return newDecision(policySet, r.Entities, r.Request, decision, diag)
+}
@@ -0,0 +1,304 @@
+package cedar
+
+import (
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/schema"
+)
+
+//go:embed policies/schema.cedarschema
+var schemaContent []byte
+
+// schemaInfo is the part of the schema the authorizer and linter use
+type schemaInfo struct {
+	entityTypes map[cedar.EntityType]bool
+	// entityAttrs lists, per entity type, the attributes that hold an
+	// entity or a set of entities
+	entityAttrs map[cedar.EntityType][]cedar.String
+	// actions holds the actions requests can name; actionGroups holds the
+	// actions that only group others
+	actions      map[cedar.String]schemaAction
+	actionGroups map[cedar.String]bool
+	// actionEntities holds every declared action with its groups as
+	// parents, so policies can test "action in" a group
+	actionEntities cedar.EntityMap
+}
+
+// schemaAction is the principal and resource types an action applies to,
+// the groups it is a member of, and its context attributes
+type schemaAction struct {
+	principalTypes []cedar.EntityType
+	resourceTypes  []cedar.EntityType
+	groups         []string
+	context        []AttributeInfo
+}
+
+// embeddedSchema parses the embedded schema once
+var embeddedSchema = sync.OnceValues(func() (*schemaInfo, error) {
+	return parseSchema(schemaContent)
+})
+
+// DocumentActions returns the actions declared in the schema that act on
+// a single document, sorted by name
+func DocumentActions() ([]string, error) {
+	s, err := embeddedSchema()
+	if err != nil {
+		return nil, err
+	}
+	var actions []string
+	for name := range s.actions {
+		if !collectionActions[string(name)] {
+			actions = append(actions, string(name))
+		}
+	}
+	sort.Strings(actions)
+	return actions, nil
+}
+
+// ActionInfo describes an action declared in the schema
+type ActionInfo struct {
+	Name string
+	// Groups lists the action groups the action is a member of
+	Groups         []string
+	PrincipalTypes []string
+	ResourceTypes  []string
+	// Collection is set for actions on the document collection rather
+	// than on a single document
+	Collection bool
+	Context    []AttributeInfo
+}
+
+// AttributeInfo describes an attribute of a schema record type
+type AttributeInfo struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+// Actions returns the actions requests can name, sorted by name
+func Actions() ([]ActionInfo, error) {
+	s, err := embeddedSchema()
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]ActionInfo, 0, len(s.actions))
+	for name, a := range s.actions {
+		info := ActionInfo{
+			Name:       string(name),
+			Groups:     a.groups,
+			Collection: collectionActions[string(name)],
+			Context:    a.context,
+		}
+		for _, t := range a.principalTypes {
+			info.PrincipalTypes = append(info.PrincipalTypes, string(t))
+		}
+		for _, t := range a.resourceTypes {
+			info.ResourceTypes = append(info.ResourceTypes, string(t))
+		}
+		actions = append(actions, info)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Name < actions[j].Name })
+	return actions, nil
+}
+
+// SchemaText returns the embedded schema in the Cedar schema format
+func SchemaText() []byte {
+	return schemaContent
+}
+
+// SchemaJSON returns the embedded schema in the Cedar JSON schema format
+func SchemaJSON() ([]byte, error) {
+	return schemaToJSON(schemaContent)
+}
+
+// schemaToJSON converts a schema in the Cedar schema format to its JSON form
+func schemaToJSON(src []byte) ([]byte, error) {
+	var s schema.Schema
+	s.SetFilename("schema.cedarschema")
+	if err := s.UnmarshalCedar(src); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	data, err := s.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert schema: %w", err)
+	}
+	return data, nil
+}
+
+// schemaType is an attribute type in the JSON schema format
+type schemaType struct {
+	Type       string                `json:"type"`
+	Name       string                `json:"name"`
+	Required   bool                  `json:"required"`
+	Element    *schemaType           `json:"element"`
+	Attributes map[string]schemaType `json:"attributes"`
+}
+
+// parseSchema reads entity types and actions from a Cedar schema. The
+// schema is converted to its JSON form, which is easier to walk.
+func parseSchema(src []byte) (*schemaInfo, error) {
+	data, err := schemaToJSON(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces map[string]struct {
+		EntityTypes map[string]struct {
+			Shape schemaType `json:"shape"`
+		} `json:"entityTypes"`
+		Actions map[string]struct {
+			MemberOf []struct {
+				ID   string `json:"id"`
+				Type string `json:"type"`
+			} `json:"memberOf"`
+			AppliesTo *struct {
+				PrincipalTypes []string    `json:"principalTypes"`
+				ResourceTypes  []string    `json:"resourceTypes"`
+				Context        *schemaType `json:"context"`
+			} `json:"appliesTo"`
+		} `json:"actions"`
+		CommonTypes map[string]schemaType `json:"commonTypes"`
+	}
+	if err := json.Unmarshal(data, &namespaces); err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	info := &schemaInfo{
+		entityTypes:    map[cedar.EntityType]bool{},
+		entityAttrs:    map[cedar.EntityType][]cedar.String{},
+		actions:        map[cedar.String]schemaAction{},
+		actionGroups:   map[cedar.String]bool{},
+		actionEntities: cedar.EntityMap{},
+	}
+	qualifiers := map[string]func(string) cedar.EntityType{}
+	for ns := range namespaces {
+		qualifiers[ns] = func(name string) cedar.EntityType {
+			if ns == "" || strings.Contains(name, "::") {
+				return cedar.EntityType(name)
+			}
+			return cedar.EntityType(ns + "::" + name)
+		}
+	}
+
+	// Entity types first, so attribute types can be resolved against them
+	for ns, def := range namespaces {
+		for name := range def.EntityTypes {
+			info.entityTypes[qualifiers[ns](name)] = true
+		}
+	}
+	for ns, def := range namespaces {
+		qualify := qualifiers[ns]
+		var holdsEntity func(t schemaType) bool
+		holdsEntity = func(t schemaType) bool {
+			switch t.Type {
+			case "Entity":
+				return true
+			case "EntityOrCommon":
+				return info.entityTypes[qualify(t.Name)]
+			case "Set":
+				return t.Element != nil && holdsEntity(*t.Element)
+			}
+			return false
+		}
+
+		for name, entity := range def.EntityTypes {
+			for attr, t := range entity.Shape.Attributes {
+				if holdsEntity(t) {
+					et := qualify(name)
+					info.entityAttrs[et] = append(info.entityAttrs[et], cedar.String(attr))
+				}
+			}
+		}
+		for name, action := range def.Actions {
+			var parents []cedar.EntityUID
+			for _, group := range action.MemberOf {
+				parents = append(parents, cedar.NewEntityUID(qualify(cmp.Or(group.Type, "Action")), cedar.String(group.ID)))
+			}
+			uid := cedar.NewEntityUID(qualify("Action"), cedar.String(name))
+			info.actionEntities[uid] = cedar.Entity{UID: uid, Parents: cedar.NewEntityUIDSet(parents...)}
+
+			// Actions that apply to nothing only serve as groups
+			if action.AppliesTo == nil {
+				info.actionGroups[cedar.String(name)] = true
+				continue
+			}
+			var sa schemaAction
+			for _, group := range action.MemberOf {
+				sa.groups = append(sa.groups, group.ID)
+			}
+			if c := action.AppliesTo.Context; c != nil {
+				// The context is a record, usually named by a common type
+				record := *c
+				if common, ok := def.CommonTypes[c.Name]; ok && c.Type == "EntityOrCommon" {
+					record = common
+				}
+				for attr, t := range record.Attributes {
+					sa.context = append(sa.context, AttributeInfo{
+						Name:     attr,
+						Type:     typeName(t, qualify, info.entityTypes),
+						Required: t.Required,
+					})
+				}
+				sort.Slice(sa.context, func(i, j int) bool { return sa.context[i].Name < sa.context[j].Name })
+			}
+			for _, t := range action.AppliesTo.PrincipalTypes {
+				sa.principalTypes = append(sa.principalTypes, qualify(t))
+			}
+			for _, t := range action.AppliesTo.ResourceTypes {
+				sa.resourceTypes = append(sa.resourceTypes, qualify(t))
+			}
+			info.actions[cedar.String(name)] = sa
+		}
+	}
+	return info, nil
+}
+
+// typeName spells out an attribute type, e.g. Set<String>
+func typeName(t schemaType, qualify func(string) cedar.EntityType, entityTypes map[cedar.EntityType]bool) string {
+	switch t.Type {
+	case "Entity":
+		return string(qualify(t.Name))
+	case "EntityOrCommon":
+		if entityTypes[qualify(t.Name)] {
+			return string(qualify(t.Name))
+		}
+		return t.Name
+	case "Set":
+		if t.Element == nil {
+			return "Set"
+		}
+		return "Set<" + typeName(*t.Element, qualify, entityTypes) + ">"
+	case "Extension":
+		return t.Name
+	}
+	return t.Type
+}
+
+// inActionGroup reports whether action is group itself or a member of it,
+// directly or through nested groups
+func (s *schemaInfo) inActionGroup(action, group cedar.EntityUID) bool {
+	seen := map[cedar.EntityUID]bool{}
+	queue := []cedar.EntityUID{action}
+	for len(queue) > 0 {
+		uid := queue[0]
+		queue = queue[1:]
+		if uid == group {
+			return true
+		}
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		for parent := range s.actionEntities[uid].Parents.All() {
+			queue = append(queue, parent)
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package cedar
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// WithShadowPolicyDir evaluates the *.cedar files in dir as a candidate
+// policy set alongside the active one. Requests where the candidate
+// decides differently are logged; responses always follow the active set.
+func WithShadowPolicyDir(dir string) Option {
+	return func(a *Authorizer) {
+		a.shadowDir = dir
+	}
+}
+
+// loadShadowPolicies re-reads the candidate policies. Template-linked
+// policies are added so the candidate only differs in the files themselves.
+func (a *Authorizer) loadShadowPolicies(links []policyFile) error {
+	files, err := readPolicyDir(a.shadowDir)
+	if err != nil {
+		return err
+	}
+	policySet, err := parsePolicyFiles(files)
+	if err != nil {
+		return err
+	}
+	if err := addLinkedPolicies(policySet, links); err != nil {
+		return err
+	}
+	a.shadowSet.Store(policySet)
+	return nil
+}
+
+// compareShadow evaluates req against the candidate policies and logs the
+// full request when the outcome differs from the active decision
+func (a *Authorizer) compareShadow(r AuthzRequest, entities cedar.EntityMap, req cedar.Request, active AuthzDecision) {
+	shadowSet := a.shadowSet.Load()
+	if shadowSet == nil {
+		return
+	}
+
+	decision, diag := shadowSet.IsAuthorized(entities, req)
+	if (decision == cedar.Allow) == active.Allowed {
+		return
+	}
+	candidate := newDecision(shadowSet, entities, req, decision, diag)
+
+	log.Printf("Shadow policy divergence: principal=%s role=%s group=%s action=%s resource=%s context=%s active=%s candidate=%s",
+		r.UserID, r.UserRole, r.UserGroupID, r.Action, r.ResourceID, req.Context,
+		describeDecision(active), describeDecision(candidate))
+}
+
+// describeDecision formats a decision for divergence logs
+func describeDecision(d AuthzDecision) string {
+	effect := "deny"
+	if d.Allowed {
+		effect = "allow"
+	}
+	return fmt.Sprintf("%s%v", effect, d.MatchedPolicies)
+}
@@ -0,0 +1,158 @@
+package cedar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// FiredPolicy describes a policy that determined a simulated decision
+type FiredPolicy struct {
+	ID       string
+	Effect   string
+	Position cedar.Position
+	Source   string
+}
+
+// Simulation is the outcome of a hypothetical authorization request
+type Simulation struct {
+	Decision AuthzDecision
+	// Policies details the policies in Decision.MatchedPolicies. It is
+	// empty when policies are evaluated remotely.
+	Policies []FiredPolicy
+	// Overridden details the permits a forbid overrode, listed in
+	// Decision.OverriddenPolicies
+	Overridden []FiredPolicy
+	// Explanation says in words which policy decided an explicit deny
+	// and why it took precedence
+	Explanation string
+}
+
+// Simulate decides r without side effects: the decision is not cached,
+// audited, or counted in metrics. When doc is set, it is evaluated as the
+// resource in place of loading r.ResourceID, so the document need not
+// exist.
+func (a *Authorizer) Simulate(ctx context.Context, r AuthzRequest, doc *Document) (Simulation, error) {
+	if err := a.checkAction(r.Action); err != nil {
+		return Simulation{}, err
+	}
+
+	var entities cedar.EntityMap
+	var req cedar.Request
+	var err error
+	if doc != nil {
+		r.ResourceID = doc.ID
+		entities, req, err = a.prepareHypothetical(ctx, r, *doc)
+	} else {
+		entities, req, err = a.prepare(ctx, r)
+	}
+	if err != nil {
+		return Simulation{}, err
+	}
+
+	decision, err := a.evaluateUnknown(ctx, entities, req, r.Unknown)
+	if err != nil {
+		return Simulation{}, err
+	}
+
+	sim := Simulation{Decision: decision}
+	if a.evaluator != nil {
+		return sim, nil
+	}
+	policySet := a.policySet.Load()
+	sim.Policies = firedPolicies(policySet, decision.MatchedPolicies)
+	sim.Overridden = firedPolicies(policySet, decision.OverriddenPolicies)
+	sim.Explanation = explain(decision, sim.Overridden)
+	return sim, nil
+}
+
+// firedPolicies details the policies with the given IDs
+func firedPolicies(policySet *cedar.PolicySet, ids []string) []FiredPolicy {
+	var fired []FiredPolicy
+	for _, id := range ids {
+		policy := policySet.Get(cedar.PolicyID(id))
+		if policy == nil {
+			continue
+		}
+		fired = append(fired, FiredPolicy{
+			ID:       id,
+			Effect:   effectName(policy.Effect()),
+			Position: policy.Position(),
+			Source:   string(policy.MarshalCedar()),
+		})
+	}
+	return fired
+}
+
+// explain describes which forbid decided an explicit deny and which
+// permits it overrode
+func explain(decision AuthzDecision, overridden []FiredPolicy) string {
+	if decision.DecidingPolicy == "" {
+		return ""
+	}
+	msg := fmt.Sprintf("forbid %s denied the request (code %s)", decision.DecidingPolicy, decision.Code)
+	if n := len(decision.MatchedPolicies); n > 1 {
+		msg += fmt.Sprintf("; %d forbids matched, and the first in source order with a deny code is reported", n)
+	}
+	if len(overridden) == 0 {
+		return msg + "; no permit matched either"
+	}
+	permits := make([]string, 0, len(overridden))
+	for _, p := range overridden {
+		permits = append(permits, fmt.Sprintf("%s (%s:%d)", p.ID, p.Position.Filename, p.Position.Line))
+	}
+	return msg + fmt.Sprintf("; it overrides permit %s because a matching forbid always takes precedence over permits", strings.Join(permits, ", "))
+}
+
+// prepareHypothetical builds the Cedar request for r with doc as the
+// resource, which need not exist
+func (a *Authorizer) prepareHypothetical(ctx context.Context, r AuthzRequest, doc Document) (cedar.EntityMap, cedar.Request, error) {
+	if err := a.checkAction(r.Action); err != nil {
+		return nil, cedar.Request{}, err
+	}
+	entities, err := a.hypotheticalEntities(ctx, r, doc)
+	if err != nil {
+		return nil, cedar.Request{}, err
+	}
+	return a.buildRequest(ctx, r, entities)
+}
+
+// hypotheticalEntities builds the entities for r with doc as the resource.
+// The principal's recorded attributes and the document group's
+// associations are looked up when the entity provider knows them.
+func (a *Authorizer) hypotheticalEntities(ctx context.Context, r AuthzRequest, doc Document) (cedar.EntityMap, error) {
+	if doc.ID == "" || doc.OwnerID == "" {
+		return nil, fmt.Errorf("%w: hypothetical document needs an id and owner", ErrInvalidRequest)
+	}
+
+	var userGroupIDs []string
+	if resolver, ok := a.entityProvider.(groupResolver); ok && doc.GroupID != "" {
+		var err error
+		userGroupIDs, err = resolver.associatedUserGroups(ctx, doc.GroupID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var recorded *UserAttributes
+	if resolver, ok := a.entityProvider.(userResolver); ok {
+		var err error
+		recorded, err = resolver.userAttributes(ctx, r.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entities := principalEntities(Principal{ID: r.UserID, Role: r.UserRole, GroupID: r.UserGroupID}, recorded)
+	addDocument(entities, doc, userGroupIDs)
+	return entities, nil
+}
+
+func effectName(e cedar.Effect) string {
+	if e == cedar.Permit {
+		return "permit"
+	}
+	return "forbid"
+}
@@ -0,0 +1,75 @@
+package cedar
+
+import (
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+)
+
+// sliceEntities returns the entities a request can reach: the principal,
+// action, and resource, entities referenced by the context or by the
+// policies themselves (roots), and everything reachable from those through
+// parents and the entity-valued attributes declared in the schema. Other
+// entities cannot affect the decision and are left out so that evaluation
+// stays fast however much the provider loads. Action entities, with their
+// action groups as parents, are taken from the schema.
+func (s *schemaInfo) sliceEntities(entities cedar.EntityMap, req cedar.Request, roots []cedar.EntityUID) cedar.EntityMap {
+	queue := []cedar.EntityUID{req.Principal, req.Action, req.Resource}
+	queue = append(queue, valueEntities(req.Context)...)
+	queue = append(queue, roots...)
+
+	sliced := cedar.EntityMap{}
+	for len(queue) > 0 {
+		uid := queue[0]
+		queue = queue[1:]
+		if _, done := sliced[uid]; done {
+			continue
+		}
+		entity, ok := entities[uid]
+		if !ok {
+			entity, ok = s.actionEntities[uid]
+		}
+		if !ok {
+			continue
+		}
+		sliced[uid] = entity
+
+		for parent := range entity.Parents.All() {
+			queue = append(queue, parent)
+		}
+		for _, attr := range s.entityAttrs[uid.Type] {
+			if v, ok := entity.Attributes.Get(attr); ok {
+				queue = append(queue, valueEntities(v)...)
+			}
+		}
+	}
+	return sliced
+}
+
+// policyEntities returns the entities named by the policies in the given
+// sets, which must be kept by sliceEntities
+func policyEntities(sets ...*cedar.PolicySet) []cedar.EntityUID {
+	seen := map[cedar.EntityUID]bool{}
+	var uids []cedar.EntityUID
+	for _, ps := range sets {
+		if ps == nil {
+			continue
+		}
+		for _, policy := range ps.All() {
+			refs, _ := policyReferences((*ast.Policy)(policy.AST()))
+			for _, uid := range refs {
+				if !seen[uid] {
+					seen[uid] = true
+					uids = append(uids, uid)
+				}
+			}
+		}
+	}
+	return uids
+}
+
+// updateSliceRoots recomputes the policy entities kept by slicing after
+// the active or candidate policies change
+func (a *Authorizer) updateSliceRoots() {
+	roots := policyEntities(a.policySet.Load(), a.shadowSet.Load())
+	a.sliceRoots.Store(&roots)
+}
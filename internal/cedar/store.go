@@ -0,0 +1,111 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/clock"
+)
+
+// PolicyStore loads policies from the policies table
+type PolicyStore struct {
+	db    *sql.DB
+	clock clock.Clock
+}
+
+// NewPolicyStore creates a policy store backed by the given database,
+// dating the versions it seeds by clk
+func NewPolicyStore(db *sql.DB, clk clock.Clock) *PolicyStore {
+	return &PolicyStore{db: db, clock: clk}
+}
+
+// loadFiles returns the active policies ordered by ID
+func (s *PolicyStore) loadFiles(ctx context.Context) ([]policyFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, content
+		FROM policies
+		WHERE active
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query policies: %w", err)
+	}
+	defer rows.Close()
+
+	var files []policyFile
+	for rows.Next() {
+		var id, content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan policy: %w", err)
+		}
+		files = append(files, policyFile{name: id, content: []byte(content)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no active policies in database")
+	}
+	return files, nil
+}
+
+// SeedDefaults inserts the embedded policies when the table is empty so a
+// fresh database starts with the same rules as the binary. The seeded
+// policies are recorded as the first version.
+func (s *PolicyStore) SeedDefaults(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to seed policies: %w", err)
+	}
+	defer tx.Rollback()
+
+	var seeded bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM policies)`).Scan(&seeded); err != nil {
+		return fmt.Errorf("failed to seed policies: %w", err)
+	}
+	if seeded {
+		return nil
+	}
+
+	files, err := embeddedPolicyFiles()
+	if err != nil {
+		return fmt.Errorf("failed to seed policies: %w", err)
+	}
+	for _, f := range files {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO policies (id, content) VALUES ($1, $2)
+		`, f.name, string(f.content)); err != nil {
+			return fmt.Errorf("failed to seed policies: %w", err)
+		}
+	}
+	if _, err := recordVersion(ctx, tx, "system", "seed embedded policies", s.clock.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PollPolicies reloads policies from the configured source at the given
+// interval until ctx is cancelled. Failed reloads keep the previous set.
+func (a *Authorizer) PollPolicies(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := a.reload(ctx)
+			if err != nil {
+				log.Printf("Policy refresh failed, keeping previous policies: %v", err)
+				continue
+			}
+			if changed {
+				log.Println("Refreshed policies")
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// ErrTemplateNotFound is returned when linking an unknown template
+var ErrTemplateNotFound = errors.New("policy template not found")
+
+// EntityRef identifies a Cedar entity by type and ID
+type EntityRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// uid converts the reference into a Cedar entity UID
+func (e EntityRef) uid() cedar.EntityUID {
+	return cedar.NewEntityUID(cedar.EntityType(e.Type), cedar.String(e.ID))
+}
+
+// TemplateLink instantiates a policy template for a principal and resource
+type TemplateLink struct {
+	ID         string    `json:"id"`
+	TemplateID string    `json:"template_id"`
+	Principal  EntityRef `json:"principal"`
+	Resource   EntityRef `json:"resource"`
+}
+
+// PolicyID returns the ID the linked policy has in the policy set
+func (l TemplateLink) PolicyID() string {
+	return "link-" + l.ID
+}
+
+// instantiateTemplate fills the ?principal and ?resource slots of a
+// Cedar policy template with entity UID literals
+func instantiateTemplate(template string, principal, resource EntityRef) string {
+	return strings.NewReplacer(
+		"?principal", principal.uid().String(),
+		"?resource", resource.uid().String(),
+	).Replace(template)
+}
+
+// TemplateStore persists policy templates and their links
+type TemplateStore struct {
+	db *sql.DB
+}
+
+// NewTemplateStore creates a template store backed by the given database
+func NewTemplateStore(db *sql.DB) *TemplateStore {
+	return &TemplateStore{db: db}
+}
+
+// loadLinkedPolicies instantiates every template link
+func (s *TemplateStore) loadLinkedPolicies(ctx context.Context) ([]policyFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT l.id, t.content, l.principal_type, l.principal_id, l.resource_type, l.resource_id
+		FROM policy_template_links l
+		JOIN policy_templates t ON t.id = l.template_id
+		ORDER BY l.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query template links: %w", err)
+	}
+	defer rows.Close()
+
+	var files []policyFile
+	for rows.Next() {
+		var link TemplateLink
+		var template string
+		if err := rows.Scan(&link.ID, &template, &link.Principal.Type, &link.Principal.ID, &link.Resource.Type, &link.Resource.ID); err != nil {
+			return nil, fmt.Errorf("failed to scan template link: %w", err)
+		}
+		files = append(files, policyFile{
+			name:    link.PolicyID(),
+			content: []byte(instantiateTemplate(template, link.Principal, link.Resource)),
+		})
+	}
+	return files, rows.Err()
+}
+
+// addLinkedPolicies parses the instantiated links into the policy set,
+// using the link's policy ID
+func addLinkedPolicies(policySet *cedar.PolicySet, links []policyFile) error {
+	for _, l := range links {
+		var policy cedar.Policy
+		if err := policy.UnmarshalCedar(l.content); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", l.name, err)
+		}
+		policySet.Add(cedar.PolicyID(l.name), &policy)
+	}
+	return nil
+}
+
+// LinkTemplate instantiates a template for the given principal and resource
+// and makes the resulting policy active
+func (a *Authorizer) LinkTemplate(ctx context.Context, templateID string, principal, resource EntityRef) (TemplateLink, error) {
+	if a.templateStore == nil {
+		return TemplateLink{}, errors.New("no template store configured")
+	}
+
+	var template string
+	err := a.templateStore.db.QueryRowContext(ctx, `
+		SELECT content FROM policy_templates WHERE id = $1
+	`, templateID).Scan(&template)
+	if err == sql.ErrNoRows {
+		return TemplateLink{}, ErrTemplateNotFound
+	}
+	if err != nil {
+		return TemplateLink{}, fmt.Errorf("failed to load template: %w", err)
+	}
+
+	// Reject links that would not produce a valid policy
+	var policy cedar.Policy
+	if err := policy.UnmarshalCedar([]byte(instantiateTemplate(template, principal, resource))); err != nil {
+		return TemplateLink{}, fmt.Errorf("failed to instantiate template %s: %w", templateID, err)
+	}
+
+	link := TemplateLink{
+		TemplateID: templateID,
+		Principal:  principal,
+		Resource:   resource,
+	}
+	err = a.templateStore.db.QueryRowContext(ctx, `
+		INSERT INTO policy_template_links (template_id, principal_type, principal_id, resource_type, resource_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (template_id, principal_type, principal_id, resource_type, resource_id)
+		DO UPDATE SET template_id = EXCLUDED.template_id
+		RETURNING id
+	`, templateID, principal.Type, principal.ID, resource.Type, resource.ID).Scan(&link.ID)
+	if err != nil {
+		return TemplateLink{}, fmt.Errorf("failed to create template link: %w", err)
+	}
+
+	if _, err := a.reload(ctx); err != nil {
+		return TemplateLink{}, fmt.Errorf("failed to reload policies: %w", err)
+	}
+	return link, nil
+}
+
+// ShareAccess is the access a document share grants
+type ShareAccess string
+
+const (
+	// ShareRead lets the user view the document
+	ShareRead ShareAccess = "read"
+	// ShareWrite lets the user view and update the document
+	ShareWrite ShareAccess = "write"
+)
+
+// shareTemplates are the templates in init.sql that share a document
+var shareTemplates = map[ShareAccess]string{
+	ShareRead:  "share-read",
+	ShareWrite: "share-write",
+}
+
+// ShareDocument grants userID access to documentID by linking the share
+// template for access
+func (a *Authorizer) ShareDocument(ctx context.Context, documentID, userID string, access ShareAccess) (TemplateLink, error) {
+	templateID, ok := shareTemplates[access]
+	if !ok {
+		return TemplateLink{}, fmt.Errorf("%w: access must be read or write", ErrInvalidRequest)
+	}
+	if userID == "" {
+		return TemplateLink{}, fmt.Errorf("%w: user is required", ErrInvalidRequest)
+	}
+	return a.LinkTemplate(ctx, templateID,
+		EntityRef{Type: string(userType), ID: userID},
+		EntityRef{Type: string(documentType), ID: documentID},
+	)
+}
+
+// UnlinkTemplate removes a template link and its policy
+func (a *Authorizer) UnlinkTemplate(ctx context.Context, linkID string) error {
+	if a.templateStore == nil {
+		return errors.New("no template store configured")
+	}
+
+	if _, err := a.templateStore.db.ExecContext(ctx, `
+		DELETE FROM policy_template_links WHERE id = $1
+	`, linkID); err != nil {
+		return fmt.Errorf("failed to delete template link: %w", err)
+	}
+
+	if _, err := a.reload(ctx); err != nil {
+		return fmt.Errorf("failed to reload policies: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package cedar
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the authorization spans. It uses the global tracer
+// provider, so spans are dropped unless the server configures one.
+var tracer = otel.Tracer("github.com/ksakiyama/study-cedar/internal/cedar")
+
+// startAuthzSpan starts the span for one authorization, as a child of the
+// span in ctx, e.g. the incoming HTTP request
+func startAuthzSpan(ctx context.Context, action string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "cedar.Authorize", trace.WithAttributes(
+		attribute.String("authz.action", action),
+	))
+}
+
+// traceEntityBuild records how long loading entities and building the
+// request took on the span in ctx
+func traceEntityBuild(ctx context.Context, start time.Time) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Float64("authz.entity_build_ms", float64(time.Since(start).Microseconds())/1000),
+	)
+}
+
+// traceCacheHit marks the span in ctx as served from the decision cache
+func traceCacheHit(ctx context.Context) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("authz.cached", true))
+}
+
+// endAuthzSpan records the outcome of an authorization on its span
+func endAuthzSpan(span trace.Span, d AuthzDecision, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	decision := "deny"
+	if d.Allowed {
+		decision = "allow"
+	}
+	span.SetAttributes(
+		attribute.String("authz.decision", decision),
+		attribute.Int("authz.matched_policies", len(d.MatchedPolicies)),
+	)
+	if d.Code != "" {
+		span.SetAttributes(attribute.String("authz.code", d.Code))
+	}
+	if len(d.UnknownContext) > 0 {
+		span.SetAttributes(attribute.StringSlice("authz.unknown_context", d.UnknownContext))
+	}
+}
@@ -0,0 +1,155 @@
+package cedar
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// UnknownStrategy says how a context attribute whose value could not be
+// determined, e.g. after a failed GeoIP lookup, is evaluated
+type UnknownStrategy string
+
+const (
+	// UnknownIgnore evaluates the attribute with the value it was given,
+	// or false
+	UnknownIgnore UnknownStrategy = "ignore"
+	// UnknownDeny allows the request only if it would be allowed whatever
+	// the attribute's value, so a forbid that might apply does
+	UnknownDeny UnknownStrategy = "deny"
+)
+
+// defaultUnknownStrategies makes the geographic restriction apply to
+// clients whose location is unknown. Other attributes are ignored.
+var defaultUnknownStrategies = map[string]UnknownStrategy{
+	"is_japan_ip":   UnknownDeny,
+	"is_private_ip": UnknownDeny,
+}
+
+// WithUnknownStrategies sets how unknown context attributes are evaluated,
+// in addition to the defaults for "is_japan_ip" and "is_private_ip".
+// Only Bool attributes declared in the schema can be named.
+func WithUnknownStrategies(strategies map[string]UnknownStrategy) Option {
+	return func(a *Authorizer) {
+		if a.unknownStrategies == nil {
+			a.unknownStrategies = map[string]UnknownStrategy{}
+		}
+		for attr, s := range strategies {
+			a.unknownStrategies[attr] = s
+		}
+	}
+}
+
+// ParseUnknownStrategies parses a comma-separated list of attribute=strategy
+// pairs, e.g. "is_japan_ip=deny,is_business_hours=ignore"
+func ParseUnknownStrategies(spec string) (map[string]UnknownStrategy, error) {
+	strategies := map[string]UnknownStrategy{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		attr, strategy, ok := strings.Cut(entry, "=")
+		if !ok || attr == "" {
+			return nil, fmt.Errorf("invalid unknown context strategy %q: want attribute=strategy", entry)
+		}
+		switch s := UnknownStrategy(strategy); s {
+		case UnknownIgnore, UnknownDeny:
+			strategies[attr] = s
+		default:
+			return nil, fmt.Errorf("invalid strategy %q for %s: want ignore or deny", strategy, attr)
+		}
+	}
+	return strategies, nil
+}
+
+// unknownStrategy returns the strategy configured for attr
+func (a *Authorizer) unknownStrategy(attr string) UnknownStrategy {
+	if s, ok := a.unknownStrategies[attr]; ok {
+		return s
+	}
+	if s, ok := defaultUnknownStrategies[attr]; ok {
+		return s
+	}
+	return UnknownIgnore
+}
+
+// checkUnknownStrategies rejects strategies for attributes that are not
+// Bool context attributes of any action
+func (s *schemaInfo) checkUnknownStrategies(strategies map[string]UnknownStrategy) error {
+	for attr := range strategies {
+		if !s.boolContextAttribute(attr) {
+			return fmt.Errorf("%q is not a Bool context attribute", attr)
+		}
+	}
+	return nil
+}
+
+// boolContextAttribute reports whether some action declares attr as a Bool
+// context attribute
+func (s *schemaInfo) boolContextAttribute(attr string) bool {
+	for _, action := range s.actions {
+		for _, c := range action.context {
+			if c.Name == attr && c.Type == "Boolean" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setUnknown checks the unknown attributes of r and gives those that have
+// no value false, so policies reading them do not fail
+func (a *Authorizer) setUnknown(r AuthzRequest, attrs cedar.RecordMap) error {
+	for _, attr := range r.Unknown {
+		if !a.schema.boolContextAttribute(attr) {
+			return fmt.Errorf("%w: unknown context %q is not a Bool attribute", ErrInvalidRequest, attr)
+		}
+		if _, ok := attrs[cedar.String(attr)]; !ok {
+			attrs[cedar.String(attr)] = cedar.False
+		}
+	}
+	return nil
+}
+
+// evaluateUnknown evaluates req, in which the attributes in unknown stand
+// for values that could not be determined. Attributes with the UnknownDeny
+// strategy are tried with both values and the request is allowed only if
+// every combination is; otherwise the first denial is returned.
+func (a *Authorizer) evaluateUnknown(ctx context.Context, entities cedar.EntityMap, req cedar.Request, unknown []string) (AuthzDecision, error) {
+	var vary []cedar.String
+	for _, attr := range unknown {
+		if a.unknownStrategy(attr) == UnknownDeny && !slices.Contains(vary, cedar.String(attr)) {
+			vary = append(vary, cedar.String(attr))
+		}
+	}
+
+	var result AuthzDecision
+	for combination := 0; combination < 1<<len(vary); combination++ {
+		variant := req
+		if len(vary) > 0 {
+			attrs := req.Context.Map()
+			for i, attr := range vary {
+				attrs[attr] = cedar.Boolean(combination&(1<<i) != 0)
+			}
+			variant.Context = cedar.NewRecord(attrs)
+		}
+		decision, err := a.evaluate(ctx, entities, variant)
+		if err != nil {
+			return AuthzDecision{}, err
+		}
+		if combination == 0 || !decision.Allowed {
+			result = decision
+		}
+		if !decision.Allowed {
+			break
+		}
+	}
+	if len(unknown) > 0 {
+		result.UnknownContext = unknown
+	}
+	return result, nil
+}
@@ -0,0 +1,261 @@
+package cedar
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrVersionNotFound is returned when rolling back to an unknown version
+	ErrVersionNotFound = errors.New("policy version not found")
+	// ErrNoPolicyStore is returned for policy changes when policies are not
+	// loaded from the database
+	ErrNoPolicyStore = errors.New("no policy store configured")
+	// ErrInvalidPolicy is returned when submitted policy text does not parse
+	ErrInvalidPolicy = errors.New("invalid policy")
+)
+
+// PolicyVersion records one change to the stored policies. Each version
+// holds a snapshot of all active policies at that point.
+type PolicyVersion struct {
+	Version   int64     `json:"version"`
+	Author    string    `json:"author"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordVersion snapshots the active policies as a new version
+func recordVersion(ctx context.Context, tx *sql.Tx, author, message string, at time.Time) (PolicyVersion, error) {
+	v := PolicyVersion{Author: author, Message: message, CreatedAt: at}
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO policy_versions (author, message, policies, created_at)
+		SELECT $1, $2, COALESCE(jsonb_object_agg(id, content), '{}'::jsonb), $3
+		FROM policies
+		WHERE active
+		RETURNING version
+	`, author, message, at).Scan(&v.Version)
+	if err != nil {
+		return PolicyVersion{}, fmt.Errorf("failed to record policy version: %w", err)
+	}
+	return v, nil
+}
+
+// savePolicy creates or replaces a stored policy and records the change
+func (s *PolicyStore) savePolicy(ctx context.Context, id, content, author string, at time.Time) (PolicyVersion, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO policies (id, content, active, created_at, updated_at)
+		VALUES ($1, $2, TRUE, $3, $3)
+		ON CONFLICT (id) DO UPDATE SET content = EXCLUDED.content, active = TRUE, updated_at = EXCLUDED.updated_at
+	`, id, content, at); err != nil {
+		return PolicyVersion{}, fmt.Errorf("failed to save policy: %w", err)
+	}
+	// The edited policy must also fit with the other active policies, e.g.
+	// not reuse their IDs, or the active set could no longer be loaded
+	snapshot, err := activeSnapshot(ctx, tx)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	if err := validateSnapshot(snapshot); err != nil {
+		return PolicyVersion{}, err
+	}
+
+	v, err := recordVersion(ctx, tx, author, fmt.Sprintf("update %s", id), at)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	return v, tx.Commit()
+}
+
+// restoreVersion makes the policies of a prior version the active ones and
+// records the rollback as a new version
+func (s *PolicyStore) restoreVersion(ctx context.Context, version int64, author string, at time.Time) (PolicyVersion, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	defer tx.Rollback()
+
+	snapshot, err := loadSnapshot(ctx, tx, version)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	if err := validateSnapshot(snapshot); err != nil {
+		return PolicyVersion{}, err
+	}
+	if err := replaceActivePolicies(ctx, tx, snapshot, at); err != nil {
+		return PolicyVersion{}, err
+	}
+
+	v, err := recordVersion(ctx, tx, author, fmt.Sprintf("rollback to version %d", version), at)
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	return v, tx.Commit()
+}
+
+// replaceActivePolicies makes the policies of snapshot the only active ones
+func replaceActivePolicies(ctx context.Context, tx *sql.Tx, snapshot map[string]string, at time.Time) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE policies SET active = FALSE, updated_at = $1 WHERE active
+	`, at); err != nil {
+		return fmt.Errorf("failed to deactivate policies: %w", err)
+	}
+	for id, content := range snapshot {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO policies (id, content, active, created_at, updated_at)
+			VALUES ($1, $2, TRUE, $3, $3)
+			ON CONFLICT (id) DO UPDATE SET content = EXCLUDED.content, active = TRUE, updated_at = EXCLUDED.updated_at
+		`, id, content, at); err != nil {
+			return fmt.Errorf("failed to restore policy %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// loadSnapshot returns the policies recorded in a version, keyed by ID
+func loadSnapshot(ctx context.Context, q rowQuerier, version int64) (map[string]string, error) {
+	var raw []byte
+	err := q.QueryRowContext(ctx, `
+		SELECT policies FROM policy_versions WHERE version = $1
+	`, version).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy version: %w", err)
+	}
+
+	var snapshot map[string]string
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode policy version %d: %w", version, err)
+	}
+	return snapshot, nil
+}
+
+// activeSnapshot returns the active policies, keyed by ID
+func activeSnapshot(ctx context.Context, q rowQuerier) (map[string]string, error) {
+	var raw []byte
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE(jsonb_object_agg(id, content), '{}'::jsonb)
+		FROM policies
+		WHERE active
+	`).Scan(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load active policies: %w", err)
+	}
+
+	var snapshot map[string]string
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode active policies: %w", err)
+	}
+	return snapshot, nil
+}
+
+// snapshotFiles orders the policies of a snapshot by ID
+func snapshotFiles(snapshot map[string]string) []policyFile {
+	files := make([]policyFile, 0, len(snapshot))
+	for id, content := range snapshot {
+		files = append(files, policyFile{name: id, content: []byte(content)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files
+}
+
+// validateSnapshot checks that a set of policies parses as a whole before
+// it is made active
+func validateSnapshot(snapshot map[string]string) error {
+	files := snapshotFiles(snapshot)
+	if len(files) == 0 {
+		return fmt.Errorf("%w: version has no policies", ErrInvalidPolicy)
+	}
+	if _, err := parsePolicyFiles(files); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	return nil
+}
+
+// versions lists all recorded versions, newest first
+func (s *PolicyStore) versions(ctx context.Context) ([]PolicyVersion, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT version, author, message, created_at
+		FROM policy_versions
+		ORDER BY version DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query policy versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []PolicyVersion{}
+	for rows.Next() {
+		var v PolicyVersion
+		if err := rows.Scan(&v.Version, &v.Author, &v.Message, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan policy version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// PolicyVersions lists the recorded policy versions, newest first
+func (a *Authorizer) PolicyVersions(ctx context.Context) ([]PolicyVersion, error) {
+	if a.policyStore == nil {
+		return nil, ErrNoPolicyStore
+	}
+	return a.policyStore.versions(ctx)
+}
+
+// UpdatePolicy stores new content for the policy with the given ID, records
+// a version attributed to author, and activates it. Content that does not
+// parse, alone or together with the other active policies, is rejected
+// with ErrInvalidPolicy and nothing is stored.
+func (a *Authorizer) UpdatePolicy(ctx context.Context, id, content, author string) (PolicyVersion, error) {
+	if a.policyStore == nil {
+		return PolicyVersion{}, ErrNoPolicyStore
+	}
+	if _, err := parsePolicyFiles([]policyFile{{name: id, content: []byte(content)}}); err != nil {
+		return PolicyVersion{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	v, err := a.policyStore.savePolicy(ctx, id, content, author, a.clock.Now())
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	if _, err := a.reload(ctx); err != nil {
+		return PolicyVersion{}, fmt.Errorf("failed to reload policies: %w", err)
+	}
+	return v, nil
+}
+
+// RollbackPolicies restores the policies of a prior version and swaps them
+// in. The rollback itself is recorded as a new version.
+func (a *Authorizer) RollbackPolicies(ctx context.Context, version int64, author string) (PolicyVersion, error) {
+	if a.policyStore == nil {
+		return PolicyVersion{}, ErrNoPolicyStore
+	}
+
+	v, err := a.policyStore.restoreVersion(ctx, version, author, a.clock.Now())
+	if err != nil {
+		return PolicyVersion{}, err
+	}
+	if _, err := a.reload(ctx); err != nil {
+		return PolicyVersion{}, fmt.Errorf("failed to reload policies: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,74 @@
+package cedar
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce coalesces bursts of file events (editors often write
+// files in several steps) into a single reload
+const reloadDebounce = 200 * time.Millisecond
+
+// Watch reloads policies whenever a .cedar file in the policy directory
+// changes. A reload that fails to parse keeps the previous policy set.
+// It blocks until ctx is cancelled.
+func (a *Authorizer) Watch(ctx context.Context) error {
+	if a.policyDir == "" {
+		return errors.New("no policy directory configured")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(a.policyDir); err != nil {
+		return err
+	}
+
+	var timer *time.Timer
+	var reload <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Ext(event.Name) != ".cedar" {
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(reloadDebounce)
+			reload = timer.C
+
+		case <-reload:
+			reload = nil
+			changed, err := a.reload(ctx)
+			if err != nil {
+				log.Printf("Policy reload failed, keeping previous policies: %v", err)
+				continue
+			}
+			if changed {
+				log.Printf("Reloaded policies from %s", a.policyDir)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Policy watcher error: %v", err)
+		}
+	}
+}
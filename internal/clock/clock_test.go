@@ -0,0 +1,31 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFake checks that a fake clock stands still until it is advanced or set
+func TestFake(t *testing.T) {
+	start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	if got := f.Now(); !got.Equal(start) {
+		t.Fatalf("Now() = %s, want %s", got, start)
+	}
+
+	f.Advance(90 * time.Minute)
+	if want := start.Add(90 * time.Minute); !f.Now().Equal(want) {
+		t.Errorf("after Advance, Now() = %s, want %s", f.Now(), want)
+	}
+
+	earlier := start.Add(-24 * time.Hour)
+	f.Set(earlier)
+	if !f.Now().Equal(earlier) {
+		t.Errorf("after Set, Now() = %s, want %s", f.Now(), earlier)
+	}
+
+	f.Advance(time.Second)
+	if want := earlier.Add(time.Second); !f.Now().Equal(want) {
+		t.Errorf("after Set and Advance, Now() = %s, want %s", f.Now(), want)
+	}
+}
@@ -11,6 +11,9 @@ type IPInfo struct {
 	IPAddress   string
 	IsPrivateIP bool
 	IsJapanIP   bool
+	// Classified is false when the address could not be parsed, so
+	// IsPrivateIP and IsJapanIP are unknown
+	Classified bool
 }
 
 // GetClientIP extracts the client IP address from the HTTP request
@@ -53,6 +56,7 @@ func ClassifyIP(ipAddr string) IPInfo {
 		IPAddress:   ipAddr,
 		IsPrivateIP: isPrivateIP(ip),
 		IsJapanIP:   isJapanIP(ip),
+		Classified:  true,
 	}
 }
 
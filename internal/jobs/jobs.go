@@ -0,0 +1,191 @@
+// Package jobs runs periodic maintenance jobs in the background. When
+// several instances of the server share a database, only the one holding a
+// Postgres advisory lock, the leader, runs them.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	mathrand "math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// leaderLockKey is the advisory lock the leader holds for as long as it
+	// runs the jobs, "cedarjob" in ASCII
+	leaderLockKey int64 = 0x63656461726a6f62
+	// jitter is the fraction of its interval by which each wait for a job
+	// is randomly shortened or lengthened, so that jobs started together
+	// drift apart
+	jitter = 0.1
+)
+
+var (
+	jobRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "background_job_runs_total",
+		Help: "Background job runs by job and result: success, failure, or skipped when another instance is the leader.",
+	}, []string{"job", "result"})
+
+	jobRunSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "background_job_duration_seconds",
+		Help:    "Time spent running background jobs by job.",
+		Buckets: prometheus.ExponentialBuckets(.01, 4, 10),
+	}, []string{"job"})
+
+	jobLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "background_job_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful run of each background job.",
+	}, []string{"job"})
+
+	jobLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "background_job_leader",
+		Help: "Whether this instance is the leader running the background jobs.",
+	})
+)
+
+// Job is a task run every Interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs, each on its own schedule, while this instance is
+// the leader. Leadership is taken before a run when no other instance
+// holds it, and kept on a dedicated connection until the scheduler stops
+// or the connection is lost.
+type Scheduler struct {
+	db   *sql.DB
+	jobs []Job
+
+	mu     sync.Mutex
+	leader *sql.Conn
+}
+
+// NewScheduler creates a scheduler with no jobs, electing its leader in db
+func NewScheduler(db *sql.DB) *Scheduler {
+	return &Scheduler{db: db}
+}
+
+// Add schedules run as the job name, every interval. Jobs must be added
+// before Run is called.
+func (s *Scheduler) Add(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.jobs = append(s.jobs, Job{Name: name, Interval: interval, Run: run})
+}
+
+// Run runs the jobs until ctx is cancelled, then waits for those running
+// to finish and gives up leadership
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.schedule(ctx, job)
+		}()
+	}
+	wg.Wait()
+	s.resign()
+}
+
+// schedule runs job after each of its intervals until ctx is cancelled
+func (s *Scheduler) schedule(ctx context.Context, job Job) {
+	timer := time.NewTimer(wait(job.Interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			s.run(ctx, job)
+			timer.Reset(wait(job.Interval))
+		}
+	}
+}
+
+// run runs job once if this instance is the leader, recording the outcome
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	if !s.lead(ctx) {
+		jobRunsTotal.WithLabelValues(job.Name, "skipped").Inc()
+		return
+	}
+
+	start := time.Now()
+	err := job.Run(ctx)
+	jobRunSeconds.WithLabelValues(job.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		jobRunsTotal.WithLabelValues(job.Name, "failure").Inc()
+		log.Printf("Job %s failed: %v", job.Name, err)
+		return
+	}
+	jobRunsTotal.WithLabelValues(job.Name, "success").Inc()
+	jobLastSuccess.WithLabelValues(job.Name).SetToCurrentTime()
+}
+
+// lead reports whether this instance is the leader, checking that the
+// connection holding the lock is still alive or else trying to take it
+func (s *Scheduler) lead(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leader != nil {
+		err := s.leader.PingContext(ctx)
+		if err == nil {
+			return true
+		}
+		log.Printf("Lost leadership of background jobs: %v", err)
+		s.leader.Close()
+		s.leader = nil
+		jobLeader.Set(0)
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		log.Printf("Failed to elect background job leader: %v", err)
+		return false
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockKey).Scan(&acquired); err != nil {
+		log.Printf("Failed to elect background job leader: %v", err)
+		conn.Close()
+		return false
+	}
+	if !acquired {
+		conn.Close()
+		return false
+	}
+	log.Println("Leading background jobs")
+	s.leader = conn
+	jobLeader.Set(1)
+	return true
+}
+
+// resign releases the leader lock if this instance holds it
+func (s *Scheduler) resign() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leader == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.leader.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, leaderLockKey); err != nil {
+		log.Printf("Failed to release background job leadership: %v", err)
+	}
+	s.leader.Close()
+	s.leader = nil
+	jobLeader.Set(0)
+}
+
+// wait returns interval lengthened or shortened by up to its jitter
+func wait(interval time.Duration) time.Duration {
+	spread := int64(float64(interval) * jitter)
+	return interval + time.Duration(mathrand.Int64N(2*spread+1)-spread)
+}
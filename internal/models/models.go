@@ -1,7 +1,11 @@
 package models
 
 import (
+	"bytes"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -12,8 +16,292 @@ type Document struct {
 	Content         string         `json:"content" db:"content"`
 	OwnerID         string         `json:"owner_id" db:"owner_id"`
 	DocumentGroupID sql.NullString `json:"document_group_id,omitempty" db:"document_group_id"`
+	Classification  string         `json:"classification" db:"classification"`
+	Tags            []string       `json:"tags" db:"tags"`
+	Metadata        Metadata       `json:"metadata" db:"metadata"`
 	CreatedAt       time.Time      `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at" db:"updated_at"`
+	// DeletedAt is set for documents in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// Metadata holds the custom fields of a document by key. Values are
+// strings, booleans, or integers, which are kept as json.Number.
+type Metadata map[string]any
+
+// UnmarshalJSON decodes metadata keeping numbers as json.Number
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return err
+	}
+	*m = values
+	return nil
+}
+
+// Scan reads metadata from a JSONB column
+func (m *Metadata) Scan(src any) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into metadata", src)
+	}
+	if err := m.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = Metadata{}
+	}
+	return nil
+}
+
+// Value writes metadata to a JSONB column
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// MetadataField declares a metadata key of the documents of a group
+type MetadataField struct {
+	// Type is string, integer, or boolean
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// MetadataSchema declares the metadata of the documents of a group by key
+type MetadataSchema map[string]MetadataField
+
+// Scan reads a metadata schema from a nullable JSONB column
+func (s *MetadataSchema) Scan(src any) error {
+	if src == nil {
+		*s = nil
+		return nil
+	}
+	data, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into metadata schema", src)
+	}
+	return json.Unmarshal(data, s)
+}
+
+// Value writes a metadata schema to a nullable JSONB column
+func (s MetadataSchema) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
+}
+
+// Attachment describes a file attached to a document
+type Attachment struct {
+	ID          string    `json:"id"`
+	DocumentID  string    `json:"document_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	UploadedBy  string    `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AttachmentsResponse represents the attachments of a document
+type AttachmentsResponse struct {
+	DocumentID  string       `json:"document_id"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// DocumentGrant gives a user read or write access to a document
+type DocumentGrant struct {
+	UserID string `json:"user_id"`
+	Access string `json:"access"`
+}
+
+// DocumentPermissions represents the users granted access to a document
+type DocumentPermissions struct {
+	DocumentID string          `json:"document_id"`
+	Grants     []DocumentGrant `json:"grants"`
+}
+
+// DocumentPermissionsInput represents input for replacing the users
+// granted access to a document
+type DocumentPermissionsInput struct {
+	Grants []DocumentGrant `json:"grants"`
+}
+
+// ShareLinkInput represents input for creating a public share link
+type ShareLinkInput struct {
+	// ExpiresIn is a Go duration such as "24h" and defaults to 24 hours
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// ShareLink is a public link that lets anyone holding its token read a
+// document on behalf of the user who created it
+type ShareLink struct {
+	ID         string     `json:"id"`
+	DocumentID string     `json:"document_id"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Token and URL are only shown when the link is created
+	Token string `json:"token,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// ShareLinksResponse represents the share links of a document
+type ShareLinksResponse struct {
+	DocumentID string      `json:"document_id"`
+	Links      []ShareLink `json:"links"`
+}
+
+// DocumentRetention is when a document expires. Expired documents are
+// moved to the trash and purged after a grace period.
+type DocumentRetention struct {
+	DocumentID string `json:"document_id"`
+	// ExpiresAt is the expiry set on the document itself
+	ExpiresAt *time.Time `json:"expires_at"`
+	// RetentionDays is the retention of the document's group
+	RetentionDays *int `json:"retention_days,omitempty"`
+	// ExpiryAt is when the document expires, by either of them
+	ExpiryAt *time.Time `json:"expiry_at,omitempty"`
+	// ExpiredAt and PurgeAt are set once the document has been moved to
+	// the trash for expiring
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"`
+}
+
+// DocumentRetentionInput represents input for setting the expiry of a
+// document; a null expires_at leaves it to the group's retention
+type DocumentRetentionInput struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ErasureInput represents input for erasing a user; without reassign_to,
+// their documents are deleted
+type ErasureInput struct {
+	ReassignTo string `json:"reassign_to"`
+}
+
+// ErasureReport represents what erasing a user removed
+type ErasureReport struct {
+	UserID       string    `json:"user_id"`
+	ErasedAt     time.Time `json:"erased_at"`
+	ReassignedTo string    `json:"reassigned_to,omitempty"`
+	// DeletedDocuments are the user's documents that were deleted, and
+	// ReassignedDocuments those moved to ReassignedTo
+	DeletedDocuments    []string `json:"deleted_documents"`
+	ReassignedDocuments []string `json:"reassigned_documents"`
+	// RetainedDocuments are under legal hold and kept under a pseudonym
+	RetainedDocuments []string `json:"retained_documents"`
+	// Deleted and Anonymized count the other rows deleted and the rows
+	// kept with the user replaced by the pseudonym, by table
+	Deleted    map[string]int64 `json:"deleted"`
+	Anonymized map[string]int64 `json:"anonymized"`
+}
+
+// LegalHold is whether a document is under legal hold, which keeps it
+// from being deleted or purged, and who placed or released it last
+type LegalHold struct {
+	DocumentID string     `json:"document_id"`
+	LegalHold  bool       `json:"legal_hold"`
+	SetBy      *string    `json:"set_by,omitempty"`
+	SetAt      *time.Time `json:"set_at,omitempty"`
+}
+
+// LegalHoldInput represents input for placing a document under legal hold
+// or releasing it
+type LegalHoldInput struct {
+	LegalHold *bool `json:"legal_hold"`
+}
+
+// RecentDocument is a document with when the caller last viewed it
+type RecentDocument struct {
+	Document Document  `json:"document"`
+	ViewedAt time.Time `json:"viewed_at"`
+}
+
+// RecentDocumentsResponse represents the documents the caller viewed
+// recently, last viewed first
+type RecentDocumentsResponse struct {
+	Documents []RecentDocument `json:"documents"`
+}
+
+// RenderedDocument is a document with its Markdown content rendered to
+// HTML
+type RenderedDocument struct {
+	DocumentID string    `json:"document_id"`
+	Title      string    `json:"title"`
+	HTML       string    `json:"html"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// DocumentLock is a user's check-out of a document. Until it expires, only
+// that user can update the document.
+type DocumentLock struct {
+	DocumentID string    `json:"document_id"`
+	LockedBy   string    `json:"locked_by"`
+	LockedAt   time.Time `json:"locked_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// DocumentVersion is a document as it was before an update replaced it
+type DocumentVersion struct {
+	DocumentID     string    `json:"document_id"`
+	Version        int       `json:"version"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content"`
+	Classification string    `json:"classification"`
+	Tags           []string  `json:"tags"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	ReplacedBy     string    `json:"replaced_by"`
+	ReplacedAt     time.Time `json:"replaced_at"`
+}
+
+// DocumentDiff represents the changes between two versions of a document
+type DocumentDiff struct {
+	DocumentID string `json:"document_id"`
+	From       int    `json:"from"`
+	To         int    `json:"to"`
+	// Title and Classification are set when they changed
+	Title          *ValueChange `json:"title,omitempty"`
+	Classification *ValueChange `json:"classification,omitempty"`
+	AddedTags      []string     `json:"added_tags"`
+	RemovedTags    []string     `json:"removed_tags"`
+	// Content holds the changed lines of the content with three lines of
+	// context around them
+	Content []DiffHunk `json:"content"`
+}
+
+// ValueChange represents a field that changed between versions
+type ValueChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DiffHunk represents a run of changed lines and their context. Lines are
+// numbered from 1.
+type DiffHunk struct {
+	FromLine  int        `json:"from_line"`
+	FromCount int        `json:"from_count"`
+	ToLine    int        `json:"to_line"`
+	ToCount   int        `json:"to_count"`
+	Lines     []DiffLine `json:"lines"`
+}
+
+// DiffLine represents a line of a DiffHunk
+type DiffLine struct {
+	// Op is equal, delete, or insert
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DocumentVersionsResponse represents the versions of a document, newest
+// first
+type DocumentVersionsResponse struct {
+	DocumentID string            `json:"document_id"`
+	Versions   []DocumentVersion `json:"versions"`
 }
 
 // UserGroup represents a user group in the system
@@ -23,11 +311,115 @@ type UserGroup struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+// User represents a recorded user. An empty role leaves the role to the
+// X-User-Role header.
+type User struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	Role             string    `json:"role"`
+	Groups           []string  `json:"groups"`
+	Department       string    `json:"department"`
+	ClearanceLevel   int64     `json:"clearance_level"`
+	EmploymentStatus string    `json:"employment_status"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// UserInput represents input for creating or replacing a user. Groups
+// replaces the user's group memberships.
+type UserInput struct {
+	Name             string   `json:"name"`
+	Role             string   `json:"role"`
+	Groups           []string `json:"groups"`
+	Department       string   `json:"department"`
+	ClearanceLevel   int64    `json:"clearance_level"`
+	EmploymentStatus string   `json:"employment_status,omitempty"`
+}
+
+// UsersResponse represents a list of users
+type UsersResponse struct {
+	Users []User `json:"users"`
+}
+
+// UserGroupInput represents input for creating or renaming a user group.
+// The ID is only read on create.
+type UserGroupInput struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// UserGroupsResponse represents a list of user groups
+type UserGroupsResponse struct {
+	UserGroups []UserGroup `json:"user_groups"`
+}
+
+// UserGroupMembersResponse represents the members of a user group
+type UserGroupMembersResponse struct {
+	UserGroupID string   `json:"user_group_id"`
+	Members     []string `json:"members"`
+}
+
 // DocumentGroup represents a document group in the system
 type DocumentGroup struct {
-	ID        string    `json:"id" db:"id"`
-	Name      string    `json:"name" db:"name"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID   string `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+	// RetentionDays is how long the documents of the group are kept after
+	// they are created, unless they have their own expiry
+	RetentionDays *int `json:"retention_days,omitempty" db:"retention_days"`
+	// MetadataSchema restricts the metadata of the documents of the group
+	// to the keys it declares
+	MetadataSchema MetadataSchema `json:"metadata_schema,omitempty" db:"metadata_schema"`
+	CreatedAt      time.Time      `json:"created_at" db:"created_at"`
+}
+
+// DocumentGroupInput represents input for creating or updating a document
+// group. The ID is only read on create.
+type DocumentGroupInput struct {
+	ID             string         `json:"id,omitempty"`
+	Name           string         `json:"name"`
+	RetentionDays  *int           `json:"retention_days,omitempty"`
+	MetadataSchema MetadataSchema `json:"metadata_schema,omitempty"`
+}
+
+// DocumentGroupsResponse represents a list of document groups
+type DocumentGroupsResponse struct {
+	DocumentGroups []DocumentGroup `json:"document_groups"`
+}
+
+// DocumentTemplate represents a template for the documents of a document
+// group
+type DocumentTemplate struct {
+	ID              string    `json:"id"`
+	DocumentGroupID string    `json:"document_group_id"`
+	Name            string    `json:"name"`
+	Title           string    `json:"title"`
+	Content         string    `json:"content"`
+	Classification  string    `json:"classification"`
+	Tags            []string  `json:"tags"`
+	CreatedBy       string    `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// DocumentTemplateInput represents input for creating or replacing a
+// document template
+type DocumentTemplateInput struct {
+	Name    string `json:"name"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// Classification defaults to internal
+	Classification string   `json:"classification,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+}
+
+// DocumentTemplatesResponse represents the templates of a document group
+type DocumentTemplatesResponse struct {
+	DocumentGroupID string             `json:"document_group_id"`
+	Templates       []DocumentTemplate `json:"templates"`
+}
+
+// DocumentGroupAssignment represents moving a document into a document group
+type DocumentGroupAssignment struct {
+	DocumentGroupID string `json:"document_group_id"`
 }
 
 // GroupAssociation represents the N:N relationship between document groups and user groups
@@ -42,12 +434,83 @@ type GroupAssociation struct {
 type DocumentInput struct {
 	Title   string `json:"title"`
 	Content string `json:"content"`
+	// Classification is public, internal, or confidential. It defaults to
+	// internal on create and is left unchanged on update when empty.
+	Classification string `json:"classification,omitempty"`
+	// Tags replaces the document's tags when present
+	Tags []string `json:"tags,omitempty"`
+	// Metadata replaces the document's metadata when present
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// DuplicateDocumentInput represents input for duplicating a document
+type DuplicateDocumentInput struct {
+	// Title is the title of the copy; it defaults to the original's
+	Title string `json:"title,omitempty"`
+	// IncludeTags copies the original's tags
+	IncludeTags bool `json:"include_tags,omitempty"`
+	// IncludeAttachments copies the original's attachments
+	IncludeAttachments bool `json:"include_attachments,omitempty"`
+}
+
+// BatchDocumentsInput represents input for creating documents in bulk
+type BatchDocumentsInput struct {
+	Documents []DocumentInput `json:"documents"`
+}
+
+// BatchDeleteInput represents input for deleting documents in bulk.
+// ETags holds the ETag of each document as last read, by ID, or "*".
+type BatchDeleteInput struct {
+	IDs   []string          `json:"ids"`
+	ETags map[string]string `json:"etags"`
+}
+
+// BatchGetInput represents input for fetching documents in bulk
+type BatchGetInput struct {
+	IDs []string `json:"ids"`
+}
+
+// BatchDocumentResult represents the outcome for a single document of a
+// bulk operation
+type BatchDocumentResult struct {
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Code and Message explain a 403, as in ErrorResponse
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Errors lists the invalid fields of a 422, as in ErrorResponse
+	Errors []FieldError `json:"errors,omitempty"`
+	// ETag is the current ETag of a document that has changed, with a 412
+	ETag string `json:"etag,omitempty"`
+	// Document is the created or fetched document
+	Document *Document `json:"document,omitempty"`
+}
+
+// BatchDocumentsResponse represents the multi-status response of a bulk
+// operation, with one result per document in request order
+type BatchDocumentsResponse struct {
+	Results []BatchDocumentResult `json:"results"`
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	Error string `json:"error"`
+	// Code is a stable, machine-readable reason for a 403, e.g. GEO_RESTRICTED
+	Code    string   `json:"code,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+	// Errors lists the invalid fields of a 422
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a field of a request body is invalid
+type FieldError struct {
+	// Field is the JSON name of the field, e.g. title or tags[2]
+	Field string `json:"field"`
+	// Code is a stable, machine-readable reason, e.g. REQUIRED or TOO_LONG
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 // HealthResponse represents a health check response
@@ -58,4 +521,398 @@ type HealthResponse struct {
 // DocumentsResponse represents a list of documents
 type DocumentsResponse struct {
 	Documents []Document `json:"documents"`
+	// NextCursor fetches the next page; it is empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Facets maps the requested facets to their most common values
+	Facets map[string][]FacetCount `json:"facets,omitempty"`
+}
+
+// FacetCount is the number of listed documents with a value of a facet,
+// or without one if Value is null
+type FacetCount struct {
+	Value *string `json:"value"`
+	Count int64   `json:"count"`
+}
+
+// DocumentStatsResponse represents document counts for reporting
+type DocumentStatsResponse struct {
+	Total           int64                `json:"total"`
+	ByOwner         []OwnerCount         `json:"by_owner"`
+	ByDocumentGroup []DocumentGroupCount `json:"by_document_group"`
+	// Interval is the length of each period of Created: day, week, or
+	// month
+	Interval string        `json:"interval"`
+	Created  []PeriodCount `json:"created"`
+}
+
+// OwnerCount is the number of documents a user owns
+type OwnerCount struct {
+	OwnerID string `json:"owner_id"`
+	Count   int64  `json:"count"`
+}
+
+// DocumentGroupCount is the number of documents in a document group, or
+// outside any group if DocumentGroupID is null
+type DocumentGroupCount struct {
+	DocumentGroupID *string `json:"document_group_id"`
+	Count           int64   `json:"count"`
+}
+
+// PeriodCount is the number of documents created in the period starting
+// at Start
+type PeriodCount struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// BatchAuthzEntry represents a single action/resource pair to check in a batch
+type BatchAuthzEntry struct {
+	Action     string `json:"action"`
+	ResourceID string `json:"resource_id"`
+}
+
+// BatchAuthzInput represents input for a batch authorization check
+type BatchAuthzInput struct {
+	Requests []BatchAuthzEntry `json:"requests"`
+}
+
+// BatchAuthzResult represents the outcome of a single batch entry
+type BatchAuthzResult struct {
+	Action     string   `json:"action"`
+	ResourceID string   `json:"resource_id"`
+	Status     int      `json:"status"`
+	Allowed    bool     `json:"allowed"`
+	Error      string   `json:"error,omitempty"`
+	Code       string   `json:"code,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	Reasons    []string `json:"reasons,omitempty"`
+}
+
+// BatchAuthzResponse represents the multi-status response of a batch check
+type BatchAuthzResponse struct {
+	Results []BatchAuthzResult `json:"results"`
+}
+
+// CapabilitiesResponse lists the actions the caller may perform on a
+// document
+type CapabilitiesResponse struct {
+	DocumentID string   `json:"document_id"`
+	Allowed    []string `json:"allowed"`
+	Denied     []string `json:"denied"`
+}
+
+// AuthzPrincipal identifies the user in an authorization check
+type AuthzPrincipal struct {
+	ID      string `json:"id"`
+	Role    string `json:"role"`
+	GroupID string `json:"group_id,omitempty"`
+}
+
+// AuthzResource identifies the document in an authorization check
+type AuthzResource struct {
+	ID string `json:"id"`
+}
+
+// AuthzCheckInput represents a standalone authorization request
+type AuthzCheckInput struct {
+	Principal AuthzPrincipal `json:"principal"`
+	Action    string         `json:"action"`
+	Resource  AuthzResource  `json:"resource"`
+	Context   map[string]any `json:"context,omitempty"`
+}
+
+// AuthzDiagnostics explains an authorization decision
+type AuthzDiagnostics struct {
+	Code           string   `json:"code,omitempty"`
+	Reason         string   `json:"reason,omitempty"`
+	Reasons        []string `json:"reasons"`
+	ForbidOverride bool     `json:"forbid_override"`
+	DecidingPolicy string   `json:"deciding_policy,omitempty"`
+	Overridden     []string `json:"overridden"`
+	Errors         []string `json:"errors"`
+	UnknownContext []string `json:"unknown_context"`
+}
+
+// AuthzCheckResponse represents the decision for a standalone request
+type AuthzCheckResponse struct {
+	Decision    string           `json:"decision"`
+	Allowed     bool             `json:"allowed"`
+	Diagnostics AuthzDiagnostics `json:"diagnostics"`
+}
+
+// ContextAttribute describes a context attribute an action accepts
+type ContextAttribute struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+}
+
+// ActionInfo describes an action requests can name
+type ActionInfo struct {
+	Name           string             `json:"name"`
+	Groups         []string           `json:"groups"`
+	PrincipalTypes []string           `json:"principal_types"`
+	ResourceTypes  []string           `json:"resource_types"`
+	Collection     bool               `json:"collection"`
+	Context        []ContextAttribute `json:"context"`
+}
+
+// ActionCatalogResponse represents the actions declared in the schema
+type ActionCatalogResponse struct {
+	Actions []ActionInfo `json:"actions"`
+}
+
+// SimulatedResource identifies the document in a simulation. Setting
+// OwnerID describes a hypothetical document, which need not exist, instead
+// of loading ID from the database.
+type SimulatedResource struct {
+	ID             string            `json:"id"`
+	OwnerID        string            `json:"owner_id,omitempty"`
+	GroupID        string            `json:"group_id,omitempty"`
+	Classification string            `json:"classification,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+// AuthzSimulateInput represents a hypothetical authorization request
+type AuthzSimulateInput struct {
+	Principal AuthzPrincipal    `json:"principal"`
+	Action    string            `json:"action"`
+	Resource  SimulatedResource `json:"resource"`
+	Context   map[string]any    `json:"context,omitempty"`
+}
+
+// FiredPolicy represents a policy that determined a simulated decision
+type FiredPolicy struct {
+	ID       string `json:"id"`
+	Effect   string `json:"effect"`
+	Position string `json:"position"`
+	Source   string `json:"source"`
+}
+
+// AuthzSimulateResponse represents the decision for a hypothetical request
+type AuthzSimulateResponse struct {
+	Decision    string           `json:"decision"`
+	Allowed     bool             `json:"allowed"`
+	Diagnostics AuthzDiagnostics `json:"diagnostics"`
+	Policies    []FiredPolicy    `json:"policies"`
+	Overridden  []FiredPolicy    `json:"overridden"`
+	Explanation string           `json:"explanation,omitempty"`
+}
+
+// AccessEntry represents what a principal may do with a document. An
+// empty user ID stands for any member of the group with the role.
+type AccessEntry struct {
+	UserID  string   `json:"user_id,omitempty"`
+	Role    string   `json:"role"`
+	GroupID string   `json:"group_id,omitempty"`
+	Actions []string `json:"actions"`
+}
+
+// AccessReviewResponse lists who may act on a document
+type AccessReviewResponse struct {
+	DocumentID string        `json:"document_id"`
+	Access     []AccessEntry `json:"access"`
+}
+
+// PolicyInput represents new content for a stored Cedar policy
+type PolicyInput struct {
+	Content string `json:"content"`
+}
+
+// PolicyVersion represents a recorded change to the stored policies
+type PolicyVersion struct {
+	Version   int64     `json:"version"`
+	Author    string    `json:"author"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PolicyVersionsResponse represents the policy version history
+type PolicyVersionsResponse struct {
+	Versions []PolicyVersion `json:"versions"`
+}
+
+// PolicyDiffInput selects two policy versions to compare. Without
+// requests, the most recent audit log entries are replayed.
+type PolicyDiffInput struct {
+	From       int64             `json:"from"`
+	To         int64             `json:"to"`
+	Requests   []AuthzCheckInput `json:"requests,omitempty"`
+	AuditLimit int               `json:"audit_limit,omitempty"`
+}
+
+// PolicyChange represents a stored policy that differs between versions
+type PolicyChange struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// DecisionFlip represents a replayed request the versions decide
+// differently
+type DecisionFlip struct {
+	Principal AuthzPrincipal     `json:"principal"`
+	Action    string             `json:"action"`
+	Resource  AuthzResource      `json:"resource"`
+	Before    AuthzCheckResponse `json:"before"`
+	After     AuthzCheckResponse `json:"after"`
+}
+
+// PolicyDiffResponse represents the impact of moving between two policy
+// versions
+type PolicyDiffResponse struct {
+	From     int64          `json:"from"`
+	To       int64          `json:"to"`
+	Changes  []PolicyChange `json:"changes"`
+	Replayed int            `json:"replayed"`
+	Skipped  int            `json:"skipped"`
+	Flips    []DecisionFlip `json:"flips"`
+}
+
+// EntityRef identifies a Cedar entity by type and ID
+type EntityRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// PolicyTemplateLink represents a policy template linked to a principal
+// and resource
+type PolicyTemplateLink struct {
+	TemplateID string    `json:"template_id"`
+	Principal  EntityRef `json:"principal"`
+	Resource   EntityRef `json:"resource"`
+}
+
+// PolicyExport represents the active policies, the schema they were
+// written against, and the policy templates and their links
+type PolicyExport struct {
+	Policies  map[string]string    `json:"policies"`
+	Schema    string               `json:"schema"`
+	Templates map[string]string    `json:"templates"`
+	Links     []PolicyTemplateLink `json:"links"`
+}
+
+// AuditRecord represents a logged authorization decision
+type AuditRecord struct {
+	Time            time.Time `json:"time"`
+	PrincipalID     string    `json:"principal_id"`
+	PrincipalRole   string    `json:"principal_role"`
+	PrincipalGroup  string    `json:"principal_group,omitempty"`
+	Action          string    `json:"action"`
+	ResourceID      string    `json:"resource_id"`
+	Allowed         bool      `json:"allowed"`
+	MatchedPolicies []string  `json:"matched_policies"`
+	IPAddress       string    `json:"ip_address"`
+	IsPrivateIP     bool      `json:"is_private_ip"`
+	IsJapanIP       bool      `json:"is_japan_ip"`
+	MFAVerified     bool      `json:"mfa_verified"`
+}
+
+// AuditResponse represents a page of audit records, newest first
+type AuditResponse struct {
+	Records []AuditRecord `json:"records"`
+}
+
+// ActivityEvent is something done to a document: "created", "viewed",
+// "edited", "shared", "deleted", or "restored"
+type ActivityEvent struct {
+	Type string `json:"type"`
+	// Action is the authorized action, absent for creation
+	Action   string    `json:"action,omitempty"`
+	UserID   string    `json:"user_id"`
+	UserRole string    `json:"user_role,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// DocumentActivity represents a page of the activity of a document,
+// newest first
+type DocumentActivity struct {
+	DocumentID string          `json:"document_id"`
+	Events     []ActivityEvent `json:"events"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// ShareInput represents a request to share a document with a user
+type ShareInput struct {
+	UserID string `json:"user_id"`
+	// Access is read or write and defaults to read
+	Access string `json:"access,omitempty"`
+}
+
+// DocumentShare represents a user's access to a document granted by a
+// template link
+type DocumentShare struct {
+	ID         string `json:"id"`
+	DocumentID string `json:"document_id"`
+	UserID     string `json:"user_id"`
+	Access     string `json:"access"`
+}
+
+// BreakGlassInput represents a request for an emergency override
+type BreakGlassInput struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"`
+	// Duration is a Go duration such as "30m"
+	Duration string `json:"duration"`
+}
+
+// BreakGlassResponse represents an issued break-glass token
+type BreakGlassResponse struct {
+	UserID    string    `json:"user_id"`
+	GrantedBy string    `json:"granted_by"`
+	Reason    string    `json:"reason"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Webhook is a subscription that receives the events named in Events as
+// signed POST requests to URL
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret signs the deliveries and is returned only when the webhook
+	// is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookInput represents a request to register a webhook
+type WebhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhooksResponse represents the registered webhooks
+type WebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookEvent is the body of a webhook delivery
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// DocumentEventData is the data of a document.created, document.updated,
+// or document.deleted event. Subscribers fetch the document itself, so
+// that its content is only seen by callers the policies allow.
+type DocumentEventData struct {
+	DocumentID string `json:"document_id"`
+	OwnerID    string `json:"owner_id"`
+}
+
+// AuthzDeniedEventData is the data of an authz.denied event
+type AuthzDeniedEventData struct {
+	PrincipalID   string   `json:"principal_id"`
+	PrincipalRole string   `json:"principal_role"`
+	Action        string   `json:"action"`
+	ResourceID    string   `json:"resource_id"`
+	Reasons       []string `json:"reasons"`
+	IPAddress     string   `json:"ip_address"`
 }
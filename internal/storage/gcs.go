@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metadataTokenURL is where the metadata server of Compute Engine, GKE,
+// and Cloud Run hands out access tokens for the attached service account
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// tokenExpiryMargin is how long before it expires a token is replaced
+const tokenExpiryMargin = time.Minute
+
+// GCS stores objects in a Google Cloud Storage bucket through the XML API,
+// authenticated as the service account the server runs as
+type GCS struct {
+	objectStore
+	bucket string
+	tokens *metadataTokenSource
+}
+
+// NewGCS creates a backend storing objects in bucket
+func NewGCS(bucket string) (*GCS, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("a Cloud Storage bucket is required")
+	}
+	g := &GCS{
+		bucket: bucket,
+		tokens: newMetadataTokenSource(),
+	}
+	g.objectStore = objectStore{
+		client:    &http.Client{Timeout: 5 * time.Minute},
+		url:       g.url,
+		authorize: g.authorize,
+	}
+	return g, nil
+}
+
+// url addresses the bucket in the path
+func (g *GCS) url(key string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", g.bucket, key)
+}
+
+// authorize adds a bearer token for the service account
+func (g *GCS) authorize(ctx context.Context, req *http.Request) error {
+	token, err := g.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// metadataTokenSource fetches access tokens from the metadata server and
+// reuses each until shortly before it expires
+type metadataTokenSource struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newMetadataTokenSource creates a token source for the default service
+// account
+func newMetadataTokenSource() *metadataTokenSource {
+	return &metadataTokenSource{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Token returns a valid access token
+func (t *metadataTokenSource) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch access token: %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode access token: %w", err)
+	}
+	t.token = body.AccessToken
+	t.expires = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return t.token, nil
+}
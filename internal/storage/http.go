@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodySize bounds how much of an error response is read into the
+// error
+const maxErrorBodySize = 1 << 10
+
+// objectStore implements Backend over the HTTP API of an object store,
+// which S3 and Cloud Storage's XML API share
+type objectStore struct {
+	client *http.Client
+	// url returns the URL of the object stored under a checked key
+	url func(key string) string
+	// authorize signs or otherwise authenticates a request; payload
+	// requests have their ContentLength set
+	authorize func(ctx context.Context, req *http.Request) error
+}
+
+// Put uploads the object with a single request
+func (s *objectStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	body := io.NopCloser(r)
+	if size == 0 {
+		body = http.NoBody
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads the object as a stream
+func (s *objectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete deletes the object
+func (s *objectStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// do sends an authorized request for key and returns the response if it
+// succeeded
+func (s *objectStore) do(ctx context.Context, method, key string, body io.ReadCloser, size int64) (*http.Response, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if err := s.authorize(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s %s: %w", strings.ToLower(method), key, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return nil, fmt.Errorf("failed to %s %s: %s: %s", strings.ToLower(method), key, resp.Status, strings.TrimSpace(string(msg)))
+}
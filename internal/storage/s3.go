@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// unsignedPayload stands in for the payload hash, so uploads can be
+// streamed without reading them twice. Requests are sent over HTTPS.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3 stores objects in an Amazon S3 bucket, or a bucket of a compatible
+// store such as MinIO
+type S3 struct {
+	objectStore
+	bucket   string
+	endpoint string
+	config   aws.Config
+}
+
+// S3Option configures an S3 backend
+type S3Option func(*S3)
+
+// WithS3Endpoint sends requests to an S3-compatible store at endpoint,
+// e.g. "http://minio:9000", addressing the bucket in the path
+func WithS3Endpoint(endpoint string) S3Option {
+	return func(s *S3) {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// NewS3 creates a backend storing objects in bucket, signing requests with
+// the credentials and region of cfg
+func NewS3(bucket string, cfg aws.Config, opts ...S3Option) (*S3, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("an S3 bucket is required")
+	}
+	s := &S3{bucket: bucket, config: cfg}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.endpoint == "" && cfg.Region == "" {
+		return nil, fmt.Errorf("an AWS region is required for S3")
+	}
+	s.objectStore = objectStore{
+		client:    &http.Client{Timeout: 5 * time.Minute},
+		url:       s.url,
+		authorize: s.sign,
+	}
+	return s, nil
+}
+
+// url addresses the bucket in the host name on S3 and in the path on
+// other endpoints
+func (s *S3) url(key string) string {
+	if s.endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.config.Region, key)
+}
+
+// sign adds AWS Signature Version 4 headers
+func (s *S3) sign(ctx context.Context, req *http.Request) error {
+	creds, err := s.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	region := s.config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	signer := v4.NewSigner(func(o *v4.SignerOptions) {
+		// S3 object keys are signed as they are sent
+		o.DisableURIPathEscaping = true
+	})
+	return signer.SignHTTP(ctx, creds, req, unsignedPayload, "s3", region, time.Now())
+}
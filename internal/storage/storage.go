@@ -0,0 +1,51 @@
+// Package storage keeps large binary content, such as attachments and
+// large document content, outside the database. The database only records
+// the key an object is stored under.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned for keys that hold no object
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidKey is returned for keys that cannot be stored
+var ErrInvalidKey = errors.New("invalid object key")
+
+// Backend stores objects by key. Keys are slash-separated paths of
+// letters, digits, '.', '_', and '-', e.g. "attachments/att-5f0c".
+type Backend interface {
+	// Put stores size bytes read from r under key, replacing any object
+	// stored there
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the object stored under key. The reader is also an
+	// io.Seeker if the backend supports seeking.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting a key that
+	// holds no object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// checkKey rejects keys that could escape the backend's root or need
+// escaping
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+		for _, c := range segment {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_' || c == '-') {
+				return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+			}
+		}
+	}
+	return nil
+}
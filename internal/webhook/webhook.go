@@ -0,0 +1,332 @@
+// Package webhook delivers document and authorization events to the
+// subscribers registered in the webhooks table.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ksakiyama/study-cedar/internal/cedar"
+	"github.com/ksakiyama/study-cedar/internal/clock"
+	"github.com/ksakiyama/study-cedar/internal/models"
+)
+
+// Event types
+const (
+	DocumentCreated = "document.created"
+	DocumentUpdated = "document.updated"
+	DocumentDeleted = "document.deleted"
+	AuthzDenied     = "authz.denied"
+)
+
+// Events are the event types webhooks can subscribe to
+var Events = []string{DocumentCreated, DocumentUpdated, DocumentDeleted, AuthzDenied}
+
+// Delivery headers
+const (
+	EventHeader     = "X-Webhook-Event"
+	IDHeader        = "X-Webhook-ID"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// defaultMaxAttempts is how often a delivery is tried before it is
+	// given up
+	defaultMaxAttempts = 5
+	// defaultBackoff is the wait before the first retry, doubled for
+	// each retry after it
+	defaultBackoff = time.Second
+	// maxBackoff bounds the wait between retries
+	maxBackoff = 5 * time.Minute
+)
+
+// Dispatcher delivers events to webhooks in the background. Each event is
+// POSTed as a models.WebhookEvent to every webhook subscribed to its type,
+// signed with the webhook's secret, and retried with exponential backoff
+// until the subscriber answers with a 2xx status.
+type Dispatcher struct {
+	db          *sql.DB
+	client      *http.Client
+	clock       clock.Clock
+	events      chan models.WebhookEvent
+	maxAttempts int
+	backoff     time.Duration
+	deliveries  sync.WaitGroup
+}
+
+// Option configures a Dispatcher
+type Option func(*Dispatcher)
+
+// WithClock sets the clock events are timestamped with
+func WithClock(clk clock.Clock) Option {
+	return func(d *Dispatcher) {
+		d.clock = clk
+	}
+}
+
+// WithHTTPClient sets the client deliveries are made with
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithRetries tries each delivery up to maxAttempts times, waiting backoff
+// before the first retry and twice as long before each one after it
+func WithRetries(maxAttempts int, backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.backoff = backoff
+	}
+}
+
+// NewDispatcher creates a dispatcher that buffers up to bufferSize events
+// waiting to be dispatched. Deliveries time out after 10 seconds.
+func NewDispatcher(db *sql.DB, bufferSize int, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		clock:       clock.Real{},
+		events:      make(chan models.WebhookEvent, bufferSize),
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Publish queues an event of eventType with data for delivery without
+// blocking
+func (d *Dispatcher) Publish(eventType string, data any) {
+	id, err := newEventID()
+	if err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+		return
+	}
+	event := models.WebhookEvent{
+		ID:        id,
+		Type:      eventType,
+		CreatedAt: d.clock.Now(),
+		Data:      data,
+	}
+	select {
+	case d.events <- event:
+	default:
+		log.Printf("Webhook buffer full, dropping %s event %s", event.Type, event.ID)
+	}
+}
+
+// Record publishes an authz.denied event for a denied decision, so the
+// dispatcher can be used as a cedar.AuditSink
+func (d *Dispatcher) Record(rec cedar.AuditRecord) {
+	if rec.Allowed {
+		return
+	}
+	reasons := rec.MatchedPolicies
+	if reasons == nil {
+		reasons = []string{}
+	}
+	d.Publish(AuthzDenied, models.AuthzDeniedEventData{
+		PrincipalID:   rec.PrincipalID,
+		PrincipalRole: rec.PrincipalRole,
+		Action:        rec.Action,
+		ResourceID:    rec.ResourceID,
+		Reasons:       reasons,
+		IPAddress:     rec.IPAddress,
+	})
+}
+
+// Run dispatches queued events until ctx is cancelled, then waits for
+// deliveries in progress to return. Deliveries waiting for a retry and
+// events still queued are dropped.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case event := <-d.events:
+			if err := d.dispatch(ctx, event); err != nil {
+				log.Printf("Failed to dispatch %s event %s: %v", event.Type, event.ID, err)
+			}
+		case <-ctx.Done():
+			if n := len(d.events); n > 0 {
+				log.Printf("Dropping %d undispatched webhook events", n)
+			}
+			d.deliveries.Wait()
+			return
+		}
+	}
+}
+
+// subscriber is a webhook an event is delivered to
+type subscriber struct {
+	id     string
+	url    string
+	secret string
+}
+
+// dispatch starts delivering event to every webhook subscribed to its type
+func (d *Dispatcher) dispatch(ctx context.Context, event models.WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, url, secret FROM webhooks WHERE $1 = ANY(events)
+	`, event.Type)
+	if err != nil {
+		return fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+	var subscribers []subscriber
+	for rows.Next() {
+		var s subscriber
+		if err := rows.Scan(&s.id, &s.url, &s.secret); err != nil {
+			return fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		subscribers = append(subscribers, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query webhooks: %w", err)
+	}
+
+	for _, s := range subscribers {
+		d.deliveries.Add(1)
+		go func() {
+			defer d.deliveries.Done()
+			d.deliver(ctx, s, event, body)
+		}()
+	}
+	return nil
+}
+
+// deliver POSTs body to s until it is accepted, the attempts run out, or
+// ctx is cancelled
+func (d *Dispatcher) deliver(ctx context.Context, s subscriber, event models.WebhookEvent, body []byte) {
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(ctx, s, event, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == d.maxAttempts {
+			log.Printf("Giving up delivering %s event %s to webhook %s after %d attempts: %v", event.Type, event.ID, s.id, attempt, err)
+			return
+		}
+
+		wait := d.retryAfter(attempt)
+		log.Printf("Delivering %s event %s to webhook %s failed, retrying in %s: %v", event.Type, event.ID, s.id, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// retryAfter returns the wait before the retry following attempt: the
+// backoff doubled for each earlier retry, up to maxBackoff, with up to a
+// fifth of it added at random so that retries to a recovering subscriber
+// are spread out
+func (d *Dispatcher) retryAfter(attempt int) time.Duration {
+	wait := d.backoff
+	for i := 1; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, maxBackoff)
+	return wait + time.Duration(mathrand.Int64N(int64(wait)/5+1))
+}
+
+// post makes one delivery attempt. It reports whether a failed attempt is
+// worth retrying: client errors other than 408 and 429 are not.
+func (d *Dispatcher) post(ctx context.Context, s subscriber, event models.WebhookEvent, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(d.clock.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(IDHeader, event.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(s.secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("subscriber answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("subscriber answered %s", resp.Status)
+	}
+}
+
+// Sign returns the signature of a delivery: "sha256=" followed by the
+// hex HMAC-SHA256, keyed with the webhook's secret, of the timestamp
+// header, a period, and the body. Subscribers compute the same to verify
+// a delivery, and reject old timestamps to prevent replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random secret for signing a webhook's deliveries
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newEventID returns a random event ID, which subscribers can use to
+// ignore deliveries they have already handled
+func newEventID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate event ID: %w", err)
+	}
+	return "evt-" + hex.EncodeToString(b), nil
+}
+
+// ValidEvents reports whether events is a non-empty list of known event
+// types
+func ValidEvents(events []string) bool {
+	if len(events) == 0 {
+		return false
+	}
+	for _, e := range events {
+		if !slices.Contains(Events, e) {
+			return false
+		}
+	}
+	return true
+}
@@ -5,10 +5,36 @@ CREATE TABLE IF NOT EXISTS user_groups (
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Create users table (attributes of users that are not taken from request headers;
+-- an empty role leaves the role to the X-User-Role header)
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(500) NOT NULL DEFAULT '',
+    role VARCHAR(50) NOT NULL DEFAULT '',
+    department VARCHAR(255) NOT NULL DEFAULT '',
+    clearance_level INTEGER NOT NULL DEFAULT 0,
+    employment_status VARCHAR(50) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create user_group_members table (users recorded as members of a user group)
+CREATE TABLE IF NOT EXISTS user_group_members (
+    user_group_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_group_id, user_id),
+    FOREIGN KEY (user_group_id) REFERENCES user_groups(id) ON DELETE CASCADE
+);
+
 -- Create document_groups table
 CREATE TABLE IF NOT EXISTS document_groups (
     id VARCHAR(255) PRIMARY KEY,
     name VARCHAR(500) NOT NULL,
+    -- Days the documents of the group are kept after they are created
+    retention_days INTEGER,
+    -- The metadata keys the documents of the group may have, with their
+    -- types and whether they are required
+    metadata_schema JSONB,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -17,13 +43,154 @@ CREATE TABLE IF NOT EXISTS documents (
     id VARCHAR(255) PRIMARY KEY,
     title VARCHAR(500) NOT NULL,
     content TEXT NOT NULL,
+    -- Set when the content is kept in object storage; content is then empty
+    content_key VARCHAR(500),
+    content_size BIGINT,
     owner_id VARCHAR(255) NOT NULL,
     document_group_id VARCHAR(255),
+    classification VARCHAR(50) NOT NULL DEFAULT 'internal',
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    -- Custom fields: an object of strings, integers, and booleans
+    metadata JSONB NOT NULL DEFAULT '{}',
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    -- When the document expires, instead of its group's retention
+    expires_at TIMESTAMP,
+    -- Set when the document was moved to the trash for expiring
+    expired_at TIMESTAMP,
+    -- A document under legal hold is neither deleted nor purged
+    legal_hold BOOLEAN NOT NULL DEFAULT FALSE,
+    legal_hold_by VARCHAR(255),
+    legal_hold_at TIMESTAMP,
     FOREIGN KEY (document_group_id) REFERENCES document_groups(id)
 );
 
+-- Create document_versions table (a document as it was before each update)
+CREATE TABLE IF NOT EXISTS document_versions (
+    document_id VARCHAR(255) NOT NULL,
+    version INTEGER NOT NULL,
+    title VARCHAR(500) NOT NULL,
+    content TEXT NOT NULL,
+    content_key VARCHAR(500),
+    content_size BIGINT,
+    classification VARCHAR(50) NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP NOT NULL,
+    replaced_by VARCHAR(255) NOT NULL,
+    replaced_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (document_id, version),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Create document_grants table (users granted read or write access to a document)
+CREATE TABLE IF NOT EXISTS document_grants (
+    document_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    access VARCHAR(10) NOT NULL CHECK (access IN ('read', 'write')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (document_id, user_id),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Create attachments table (metadata of files stored outside the database)
+CREATE TABLE IF NOT EXISTS attachments (
+    id VARCHAR(255) PRIMARY KEY,
+    document_id VARCHAR(255) NOT NULL,
+    filename VARCHAR(500) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    uploaded_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Create idempotency_keys table (responses to document creation, replayed to retries with the same key)
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    response JSONB,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+-- Create webhooks table (subscribers to document and authorization events)
+CREATE TABLE IF NOT EXISTS webhooks (
+    id VARCHAR(255) PRIMARY KEY,
+    url TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create document_locks table (check-outs of documents, expired once expires_at has passed)
+CREATE TABLE IF NOT EXISTS document_locks (
+    document_id VARCHAR(255) PRIMARY KEY,
+    locked_by VARCHAR(255) NOT NULL,
+    locked_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Create share_links table (public links to documents, acting for the user who created them, until they expire or are revoked)
+CREATE TABLE IF NOT EXISTS share_links (
+    id VARCHAR(255) PRIMARY KEY,
+    document_id VARCHAR(255) NOT NULL,
+    created_by VARCHAR(255) NOT NULL,
+    created_role VARCHAR(50) NOT NULL,
+    created_group VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Create document_stars table (documents each user has starred)
+CREATE TABLE IF NOT EXISTS document_stars (
+    user_id VARCHAR(255) NOT NULL,
+    document_id VARCHAR(255) NOT NULL,
+    starred_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, document_id),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Create recent_views table (the documents each user viewed last, capped per user)
+CREATE TABLE IF NOT EXISTS recent_views (
+    user_id VARCHAR(255) NOT NULL,
+    document_id VARCHAR(255) NOT NULL,
+    viewed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, document_id),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Create retention_audit table (documents moved to the trash or purged because they expired)
+CREATE TABLE IF NOT EXISTS retention_audit (
+    id BIGSERIAL PRIMARY KEY,
+    document_id VARCHAR(255) NOT NULL,
+    title VARCHAR(500) NOT NULL,
+    owner_id VARCHAR(255) NOT NULL,
+    document_group_id VARCHAR(255),
+    action VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Create document_templates table (pre-filled documents, each for the documents of one document group)
+CREATE TABLE IF NOT EXISTS document_templates (
+    id VARCHAR(255) PRIMARY KEY,
+    document_group_id VARCHAR(255) NOT NULL,
+    name VARCHAR(500) NOT NULL,
+    title VARCHAR(500) NOT NULL,
+    content TEXT NOT NULL,
+    classification VARCHAR(50) NOT NULL DEFAULT 'internal',
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (document_group_id) REFERENCES document_groups(id) ON DELETE CASCADE
+);
+
 -- Create group_associations table (N:N relationship between document_groups and user_groups)
 CREATE TABLE IF NOT EXISTS group_associations (
     id SERIAL PRIMARY KEY,
@@ -35,11 +202,155 @@ CREATE TABLE IF NOT EXISTS group_associations (
     UNIQUE(document_group_id, user_group_id)
 );
 
+-- Create policies table (Cedar policies loaded when POLICY_SOURCE=db)
+CREATE TABLE IF NOT EXISTS policies (
+    id VARCHAR(255) PRIMARY KEY,
+    content TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create policy_versions table (snapshot of the active policies after each change)
+CREATE TABLE IF NOT EXISTS policy_versions (
+    version SERIAL PRIMARY KEY,
+    author VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL,
+    policies JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create policy_templates table (Cedar policies with ?principal / ?resource slots)
+CREATE TABLE IF NOT EXISTS policy_templates (
+    id VARCHAR(255) PRIMARY KEY,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create policy_template_links table (templates instantiated for a principal and resource)
+CREATE TABLE IF NOT EXISTS policy_template_links (
+    id SERIAL PRIMARY KEY,
+    template_id VARCHAR(255) NOT NULL,
+    principal_type VARCHAR(255) NOT NULL,
+    principal_id VARCHAR(255) NOT NULL,
+    resource_type VARCHAR(255) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (template_id) REFERENCES policy_templates(id) ON DELETE CASCADE,
+    UNIQUE(template_id, principal_type, principal_id, resource_type, resource_id)
+);
+
+-- Create authz_audit table (every authorization decision, written asynchronously)
+CREATE TABLE IF NOT EXISTS authz_audit (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    principal_id VARCHAR(255) NOT NULL,
+    principal_role VARCHAR(255) NOT NULL,
+    principal_group VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(255) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    matched_policies TEXT[] NOT NULL DEFAULT '{}',
+    ip_address VARCHAR(64) NOT NULL,
+    is_private_ip BOOLEAN NOT NULL,
+    is_japan_ip BOOLEAN NOT NULL,
+    mfa_verified BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Create break_glass_grants table (emergency overrides, checked until they expire)
+CREATE TABLE IF NOT EXISTS break_glass_grants (
+    id SERIAL PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    user_id VARCHAR(255) NOT NULL,
+    granted_by VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create break_glass_audit table (every decision made with a break-glass token)
+CREATE TABLE IF NOT EXISTS break_glass_audit (LIKE authz_audit INCLUDING ALL);
+
+-- Notify the entity cache when group associations change. The payload is
+-- the affected document group, or empty when the table was truncated.
+CREATE OR REPLACE FUNCTION notify_group_associations_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'TRUNCATE' THEN
+        PERFORM pg_notify('group_associations_changed', '');
+        RETURN NULL;
+    END IF;
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('group_associations_changed', OLD.document_group_id);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('group_associations_changed', NEW.document_group_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS group_associations_changed ON group_associations;
+CREATE TRIGGER group_associations_changed
+    AFTER INSERT OR UPDATE OR DELETE ON group_associations
+    FOR EACH ROW EXECUTE FUNCTION notify_group_associations_changed();
+
+DROP TRIGGER IF EXISTS group_associations_truncated ON group_associations;
+CREATE TRIGGER group_associations_truncated
+    AFTER TRUNCATE ON group_associations
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_group_associations_changed();
+
+-- Notify document event streams when documents change. The payload is the
+-- change as JSON; moving a document to the trash is a deletion and
+-- restoring it a creation, and changes in the trash are not notified.
+CREATE OR REPLACE FUNCTION notify_documents_changed() RETURNS trigger AS $$
+DECLARE
+    change TEXT;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        change := 'created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        change := 'deleted';
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        change := 'created';
+    ELSIF NEW.deleted_at IS NULL THEN
+        change := 'updated';
+    ELSE
+        RETURN NULL;
+    END IF;
+    PERFORM pg_notify('documents_changed', json_build_object(
+        'type', 'document.' || change,
+        'document_id', NEW.id,
+        'owner_id', NEW.owner_id
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS documents_changed ON documents;
+CREATE TRIGGER documents_changed
+    AFTER INSERT OR UPDATE ON documents
+    FOR EACH ROW EXECUTE FUNCTION notify_documents_changed();
+
 -- Create indexes for efficient queries
 CREATE INDEX IF NOT EXISTS idx_documents_owner_id ON documents(owner_id);
 CREATE INDEX IF NOT EXISTS idx_documents_group_id ON documents(document_group_id);
+CREATE INDEX IF NOT EXISTS idx_documents_created_at_id ON documents(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_documents_updated_at_id ON documents(updated_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_documents_deleted_at ON documents(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_metadata ON documents USING GIN (metadata);
+CREATE INDEX IF NOT EXISTS idx_attachments_document_id ON attachments(document_id);
+CREATE INDEX IF NOT EXISTS idx_user_group_members_user ON user_group_members(user_id);
 CREATE INDEX IF NOT EXISTS idx_group_associations_doc_group ON group_associations(document_group_id);
 CREATE INDEX IF NOT EXISTS idx_group_associations_user_group ON group_associations(user_group_id);
+CREATE INDEX IF NOT EXISTS idx_authz_audit_created_at ON authz_audit(created_at);
+CREATE INDEX IF NOT EXISTS idx_authz_audit_principal ON authz_audit(principal_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_authz_audit_resource ON authz_audit(resource_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_document_templates_group ON document_templates(document_group_id);
+CREATE INDEX IF NOT EXISTS idx_share_links_document ON share_links(document_id);
+CREATE INDEX IF NOT EXISTS idx_share_links_ended ON share_links((LEAST(expires_at, revoked_at)));
+CREATE INDEX IF NOT EXISTS idx_document_stars_document ON document_stars(document_id);
+CREATE INDEX IF NOT EXISTS idx_recent_views_document ON recent_views(document_id);
+CREATE INDEX IF NOT EXISTS idx_retention_audit_document ON retention_audit(document_id, created_at);
 
 -- Insert sample user groups
 INSERT INTO user_groups (id, name, created_at) VALUES
@@ -48,6 +359,23 @@ INSERT INTO user_groups (id, name, created_at) VALUES
     ('user-group-management', 'Management', CURRENT_TIMESTAMP)
 ON CONFLICT (id) DO NOTHING;
 
+-- Insert sample users
+INSERT INTO users (id, name, role, department, clearance_level, employment_status, created_at) VALUES
+    ('user-1', 'Alice', '', 'engineering', 2, 'active', CURRENT_TIMESTAMP),
+    ('user-2', 'Bob', '', 'sales', 1, 'active', CURRENT_TIMESTAMP),
+    ('user-3', 'Carol', '', 'management', 3, 'active', CURRENT_TIMESTAMP),
+    ('user-admin', 'Administrator', 'admin', 'it', 4, 'active', CURRENT_TIMESTAMP),
+    ('user-4', 'Dave', 'group_admin', 'sales', 3, 'active', CURRENT_TIMESTAMP)
+ON CONFLICT (id) DO NOTHING;
+
+-- Insert sample user group members
+INSERT INTO user_group_members (user_group_id, user_id, created_at) VALUES
+    ('user-group-engineering', 'user-1', CURRENT_TIMESTAMP),
+    ('user-group-sales', 'user-2', CURRENT_TIMESTAMP),
+    ('user-group-management', 'user-3', CURRENT_TIMESTAMP),
+    ('user-group-sales', 'user-4', CURRENT_TIMESTAMP)
+ON CONFLICT (user_group_id, user_id) DO NOTHING;
+
 -- Insert sample document groups
 INSERT INTO document_groups (id, name, created_at) VALUES
     ('doc-group-technical', 'Technical Documentation', CURRENT_TIMESTAMP),
@@ -55,13 +383,19 @@ INSERT INTO document_groups (id, name, created_at) VALUES
     ('doc-group-internal', 'Internal Documents', CURRENT_TIMESTAMP)
 ON CONFLICT (id) DO NOTHING;
 
+-- Insert policy templates for sharing a single document with a single user
+INSERT INTO policy_templates (id, content, created_at) VALUES
+    ('share-read', 'permit(principal == ?principal, action == DocumentApp::Action::"GetDocument", resource == ?resource);', CURRENT_TIMESTAMP),
+    ('share-write', 'permit(principal == ?principal, action in [DocumentApp::Action::"GetDocument", DocumentApp::Action::"UpdateDocument"], resource == ?resource);', CURRENT_TIMESTAMP)
+ON CONFLICT (id) DO NOTHING;
+
 -- Insert sample documents
-INSERT INTO documents (id, title, content, owner_id, document_group_id, created_at, updated_at) VALUES
-    ('doc-1', 'Technical Specification', 'This is a technical specification document created by user-1', 'user-1', 'doc-group-technical', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
-    ('doc-2', 'Sales Proposal', 'This is a sales proposal document created by user-2', 'user-2', 'doc-group-sales', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
-    ('doc-3', 'Internal Memo', 'This is an internal memo created by user-1', 'user-1', 'doc-group-internal', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
-    ('doc-4', 'API Documentation', 'API documentation for engineers', 'user-1', 'doc-group-technical', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
-    ('doc-5', 'Quarterly Report', 'Management quarterly report', 'user-3', 'doc-group-internal', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+INSERT INTO documents (id, title, content, owner_id, document_group_id, classification, tags, created_at, updated_at) VALUES
+    ('doc-1', 'Technical Specification', 'This is a technical specification document created by user-1', 'user-1', 'doc-group-technical', 'internal', '{engineering}', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+    ('doc-2', 'Sales Proposal', 'This is a sales proposal document created by user-2', 'user-2', 'doc-group-sales', 'internal', '{sales}', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+    ('doc-3', 'Internal Memo', 'This is an internal memo created by user-1', 'user-1', 'doc-group-internal', 'internal', '{}', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+    ('doc-4', 'API Documentation', 'API documentation for engineers', 'user-1', 'doc-group-technical', 'public', '{engineering,api}', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+    ('doc-5', 'Quarterly Report', 'Management quarterly report', 'user-3', 'doc-group-internal', 'confidential', '{finance}', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 ON CONFLICT (id) DO NOTHING;
 
 -- Insert sample group associations